@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ddia-labs/pkg/bptree"
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/lsm"
+	"github.com/ddia-labs/pkg/pager"
+)
+
+// Engine 是基准测试驱动的存储引擎的公共接口
+type Engine interface {
+	Put(key int, value string) error
+	Get(key int) (string, bool, error)
+	// Scan 从第一个 >= start 的键开始按顺序读取最多 count 条记录，返回实际读取的条数
+	Scan(start, count int) (int, error)
+	// IO 返回自打开以来引擎写入和读取的字节数（数据文件、日志、SSTable）
+	IO() IOStats
+	Close() error
+}
+
+type IOStats struct {
+	Written int64
+	Read    int64
+}
+
+// engineConfig 是所有引擎共用的配置
+type engineConfig struct {
+	sync      bool // 每次写入后 fsync
+	poolPages int  // B+tree 缓冲池页数
+	memTable  int  // LSM 内存表条目数
+}
+
+type engineFactory struct {
+	name string
+	open func(dir string, cfg engineConfig) (Engine, error)
+}
+
+var engines = []engineFactory{
+	{"btree", openBTree},
+	{"lsm", openLSM},
+	{"hash", openHash},
+}
+
+// --- B+tree（pkg/bptree，原地更新的页文件 + 页级 WAL） ---
+
+type btreeEngine struct {
+	tree *bptree.Tree
+}
+
+func openBTree(dir string, cfg engineConfig) (Engine, error) {
+	tree, err := bptree.Open(filepath.Join(dir, "tree.db"), cfg.poolPages)
+	if err != nil {
+		return nil, err
+	}
+	tree.SetSyncCommits(cfg.sync)
+	return &btreeEngine{tree: tree}, nil
+}
+
+func (e *btreeEngine) Put(key int, value string) error   { return e.tree.Put(key, value) }
+func (e *btreeEngine) Get(key int) (string, bool, error) { return e.tree.Get(key) }
+
+func (e *btreeEngine) Scan(start, count int) (int, error) {
+	n := 0
+	err := e.tree.Scan(start, maxKey, func(int, string) bool {
+		n++
+		return n < count
+	})
+	return n, err
+}
+
+func (e *btreeEngine) IO() IOStats {
+	s, err := e.tree.Stats()
+	if err != nil {
+		return IOStats{}
+	}
+	return IOStats{
+		Written: s.PageWrites*pager.PageSize + s.WAL.Written,
+		Read:    s.PageReads * pager.PageSize,
+	}
+}
+
+func (e *btreeEngine) Close() error { return e.tree.Close() }
+
+// --- LSM-tree（pkg/lsm，WAL + 内存表 + SSTable + 后台合并） ---
+
+type lsmEngine struct {
+	tree *lsm.LSMTree
+}
+
+func openLSM(dir string, cfg engineConfig) (Engine, error) {
+	tree, err := lsm.Open(dir, lsm.Options{MemTableSize: cfg.memTable, SyncWrites: cfg.sync})
+	if err != nil {
+		return nil, err
+	}
+	return &lsmEngine{tree: tree}, nil
+}
+
+func (e *lsmEngine) Put(key int, value string) error {
+	return e.tree.Put(kv.IntKey(key), []byte(value))
+}
+
+func (e *lsmEngine) Get(key int) (string, bool, error) {
+	value, ok, err := e.tree.Get(kv.IntKey(key))
+	return string(value), ok, err
+}
+
+func (e *lsmEngine) Scan(start, count int) (int, error) {
+	n := 0
+	err := e.tree.Scan(kv.IntKey(start), nil, func(_, _ []byte) bool {
+		n++
+		return n < count
+	})
+	return n, err
+}
+
+func (e *lsmEngine) IO() IOStats {
+	s := e.tree.Stats()
+	return IOStats{
+		Written: s.WALBytes + s.FlushWritten + s.CompactionWritten + s.ValueLogWritten,
+		Read:    s.BytesRead,
+	}
+}
+
+func (e *lsmEngine) Close() error { return e.tree.Close() }
+
+// --- 哈希索引（Bitcask 风格：只追加的数据文件 + 内存中的哈希表） ---
+//
+// DDIA 3.1 节的哈希索引：每次写入追加一条记录到数据文件末尾，内存中的哈希表记录每个键
+// 最新记录的偏移量，读取时一次定位。简化：不做日志段的合并压缩，旧版本一直留在文件中。
+// 哈希表无序，Scan 只能取出所有键排序后再读取。
+
+type hashEngine struct {
+	file   *os.File
+	w      *bufio.Writer
+	sync   bool
+	offset int64 // 文件末尾（含缓冲区中尚未写出的部分）
+	dirty  bool  // 缓冲区中有尚未写到文件的记录
+	index  map[int]hashPos
+	buf    []byte
+	stats  IOStats
+}
+
+type hashPos struct {
+	offset int64
+	length int32
+}
+
+var errCorruptRecord = errors.New("hash: corrupt record")
+
+func openHash(dir string, cfg engineConfig) (Engine, error) {
+	f, err := os.OpenFile(filepath.Join(dir, "data.log"), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &hashEngine{
+		file:  f,
+		w:     bufio.NewWriterSize(f, 64*1024),
+		sync:  cfg.sync,
+		index: make(map[int]hashPos),
+	}, nil
+}
+
+// Put 追加一条记录：crc32(4) | key(varint) | value
+func (e *hashEngine) Put(key int, value string) error {
+	e.buf = binary.AppendVarint(append(e.buf[:0], 0, 0, 0, 0), int64(key))
+	e.buf = append(e.buf, value...)
+	binary.LittleEndian.PutUint32(e.buf, crc32.ChecksumIEEE(e.buf[4:]))
+	if _, err := e.w.Write(e.buf); err != nil {
+		return err
+	}
+	if e.sync {
+		if err := e.w.Flush(); err != nil {
+			return err
+		}
+		if err := e.file.Sync(); err != nil {
+			return err
+		}
+	} else {
+		e.dirty = true
+	}
+	e.index[key] = hashPos{offset: e.offset, length: int32(len(e.buf))}
+	e.offset += int64(len(e.buf))
+	e.stats.Written += int64(len(e.buf))
+	return nil
+}
+
+func (e *hashEngine) Get(key int) (string, bool, error) {
+	pos, ok := e.index[key]
+	if !ok {
+		return "", false, nil
+	}
+	if e.dirty {
+		if err := e.w.Flush(); err != nil {
+			return "", false, err
+		}
+		e.dirty = false
+	}
+	rec := make([]byte, pos.length)
+	if _, err := e.file.ReadAt(rec, pos.offset); err != nil && err != io.EOF {
+		return "", false, err
+	}
+	e.stats.Read += int64(len(rec))
+	if crc32.ChecksumIEEE(rec[4:]) != binary.LittleEndian.Uint32(rec) {
+		return "", false, errCorruptRecord
+	}
+	_, n := binary.Varint(rec[4:])
+	if n <= 0 {
+		return "", false, errCorruptRecord
+	}
+	return string(rec[4+n:]), true, nil
+}
+
+func (e *hashEngine) Scan(start, count int) (int, error) {
+	var keys []int
+	for k := range e.index {
+		if k >= start {
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+	if len(keys) > count {
+		keys = keys[:count]
+	}
+	for _, k := range keys {
+		if _, _, err := e.Get(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+func (e *hashEngine) IO() IOStats { return e.stats }
+
+func (e *hashEngine) Close() error {
+	err := e.w.Flush()
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
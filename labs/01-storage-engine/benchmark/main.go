@@ -1,77 +1,336 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// 简化的性能对比测试
-// 注意：这是概念演示，实际性能对比需要考虑更多因素
+// 存储引擎基准测试：用 YCSB 风格的负载实际驱动 B+tree、LSM-tree 和哈希索引三种引擎，
+// 测量吞吐量、延迟分位数以及引擎写入/读取的字节数。
+//
+// 每个（引擎，负载）组合都在一个新目录中进行：先装载 -records 条记录（作为 "load" 负载单独报告），
+// 再执行 -ops 次该负载的操作。
 
-func benchmarkWrite(name string, writeFunc func(int, string), count int) time.Duration {
-	start := time.Now()
-	for i := 0; i < count; i++ {
-		writeFunc(i, fmt.Sprintf("value%d", i))
+// OpStats 是一种操作的统计
+type OpStats struct {
+	Count int     `json:"count"`
+	P50us float64 `json:"p50_us"`
+	P99us float64 `json:"p99_us"`
+}
+
+// Result 是一个（引擎，负载）组合的结果
+type Result struct {
+	Engine       string             `json:"engine"`
+	Workload     string             `json:"workload"`
+	Distribution string             `json:"distribution"`
+	Ops          int                `json:"ops"`
+	Seconds      float64            `json:"seconds"`
+	Throughput   float64            `json:"throughput_ops"`
+	P50us        float64            `json:"p50_us"`
+	P99us        float64            `json:"p99_us"`
+	BytesWritten int64              `json:"bytes_written"`
+	BytesRead    int64              `json:"bytes_read"`
+	PerOp        map[string]OpStats `json:"per_op"`
+}
+
+type config struct {
+	records   int
+	ops       int
+	valueSize int
+	maxScan   int
+	dist      string
+	seed      int64
+	engine    engineConfig
+}
+
+// recorder 收集每次操作的延迟
+type recorder struct {
+	all   []time.Duration
+	perOp map[string][]time.Duration
+}
+
+func newRecorder() *recorder { return &recorder{perOp: make(map[string][]time.Duration)} }
+
+func (r *recorder) add(op string, d time.Duration) {
+	r.all = append(r.all, d)
+	r.perOp[op] = append(r.perOp[op], d)
+}
+
+// percentile 返回已排序的延迟中第 p 百分位的值（微秒）
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := min(len(sorted)-1, int(float64(len(sorted))*p))
+	return float64(sorted[i].Nanoseconds()) / 1e3
+}
+
+func (r *recorder) result(engine, workload, dist string, elapsed time.Duration, io IOStats) Result {
+	sortDurations := func(d []time.Duration) {
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	}
+	sortDurations(r.all)
+	res := Result{
+		Engine:       engine,
+		Workload:     workload,
+		Distribution: dist,
+		Ops:          len(r.all),
+		Seconds:      elapsed.Seconds(),
+		Throughput:   float64(len(r.all)) / elapsed.Seconds(),
+		P50us:        percentile(r.all, 0.50),
+		P99us:        percentile(r.all, 0.99),
+		BytesWritten: io.Written,
+		BytesRead:    io.Read,
+		PerOp:        make(map[string]OpStats),
+	}
+	for op, d := range r.perOp {
+		sortDurations(d)
+		res.PerOp[op] = OpStats{Count: len(d), P50us: percentile(d, 0.50), P99us: percentile(d, 0.99)}
 	}
-	return time.Since(start)
+	return res
 }
 
-func benchmarkRead(name string, readFunc func(int) (string, bool), count int) time.Duration {
+func ioDelta(after, before IOStats) IOStats {
+	return IOStats{Written: after.Written - before.Written, Read: after.Read - before.Read}
+}
+
+// run 在 dir 中打开引擎，装载数据后执行负载 w，返回装载和负载两个阶段的结果
+func run(f engineFactory, w Workload, cfg config, dir string) ([]Result, error) {
+	e, err := f.open(dir, cfg.engine)
+	if err != nil {
+		return nil, err
+	}
+	defer e.Close()
+	r := rand.New(rand.NewSource(cfg.seed))
+
+	// 装载阶段：以随机顺序插入 0..records-1
+	rec := newRecorder()
 	start := time.Now()
-	for i := 0; i < count; i++ {
-		readFunc(i)
+	for _, key := range r.Perm(cfg.records) {
+		t := time.Now()
+		if err := e.Put(key, makeValue(r, cfg.valueSize)); err != nil {
+			return nil, err
+		}
+		rec.add(opInsert, time.Since(t))
+	}
+	loadIO := e.IO()
+	load := rec.result(f.name, "load", "-", time.Since(start), loadIO)
+
+	// 运行阶段
+	dist := cfg.dist
+	if w.Latest {
+		dist = "latest"
 	}
-	return time.Since(start)
+	chooser, err := newChooser(cfg.dist, w.Latest, r, cfg.records)
+	if err != nil {
+		return nil, err
+	}
+	next := cfg.records // 下一个插入的键
+	rec = newRecorder()
+	start = time.Now()
+	for i := 0; i < cfg.ops; i++ {
+		op := w.nextOp(r)
+		t := time.Now()
+		switch op {
+		case opRead:
+			_, _, err = e.Get(chooser.next(next))
+		case opUpdate:
+			err = e.Put(chooser.next(next), makeValue(r, cfg.valueSize))
+		case opInsert:
+			err = e.Put(next, makeValue(r, cfg.valueSize))
+			next++
+		case opScan:
+			_, err = e.Scan(chooser.next(next), 1+r.Intn(cfg.maxScan))
+		case opRMW:
+			key := chooser.next(next)
+			if _, _, err = e.Get(key); err == nil {
+				err = e.Put(key, makeValue(r, cfg.valueSize))
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		rec.add(op, time.Since(t))
+	}
+	res := rec.result(f.name, w.Name, dist, time.Since(start), ioDelta(e.IO(), loadIO))
+	return []Result{load, res}, nil
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+func printResult(r Result) {
+	fmt.Printf("  %-6s %-8s %-8s %10.0f %10.1f %10.1f %10s %10s\n",
+		r.Engine, r.Workload, r.Distribution, r.Throughput, r.P50us, r.P99us,
+		formatBytes(r.BytesWritten), formatBytes(r.BytesRead))
+}
+
+// writeCSV 每个结果输出一行 op=all 的汇总和每种操作各一行
+func writeCSV(path string, results []Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"engine", "workload", "distribution", "op", "count", "seconds", "throughput_ops",
+		"p50_us", "p99_us", "bytes_written", "bytes_read"})
+	ff := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	for _, r := range results {
+		w.Write([]string{r.Engine, r.Workload, r.Distribution, "all", strconv.Itoa(r.Ops), ff(r.Seconds),
+			ff(r.Throughput), ff(r.P50us), ff(r.P99us),
+			strconv.FormatInt(r.BytesWritten, 10), strconv.FormatInt(r.BytesRead, 10)})
+		ops := make([]string, 0, len(r.PerOp))
+		for op := range r.PerOp {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		for _, op := range ops {
+			s := r.PerOp[op]
+			w.Write([]string{r.Engine, r.Workload, r.Distribution, op, strconv.Itoa(s.Count), "", "",
+				ff(s.P50us), ff(s.P99us), "", ""})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeJSON(path string, results []Result) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0666)
 }
 
 func main() {
-	fmt.Println("=== B-tree vs LSM-tree 性能对比 ===")
-	fmt.Println()
-	fmt.Println("注意：这是简化演示，实际性能受多种因素影响")
+	var cfg config
+	engineList := flag.String("engines", "btree,lsm,hash", "要测试的引擎，逗号分隔（btree、lsm、hash）")
+	workloadList := flag.String("workloads", "A,B,C,D,E,F", "要运行的 YCSB 负载，逗号分隔")
+	flag.IntVar(&cfg.records, "records", 20000, "装载的记录数")
+	flag.IntVar(&cfg.ops, "ops", 20000, "每个负载执行的操作数")
+	flag.IntVar(&cfg.valueSize, "value-size", 100, "值的字节数")
+	flag.IntVar(&cfg.maxScan, "max-scan", 100, "负载 E 中每次扫描的最大记录数（均匀分布于 1..max-scan）")
+	flag.StringVar(&cfg.dist, "dist", "zipfian", "键的分布：zipfian 或 uniform（负载 D 总是使用 latest）")
+	flag.Int64Var(&cfg.seed, "seed", 1, "随机种子")
+	flag.BoolVar(&cfg.engine.sync, "sync", false, "每次写入后 fsync（三种引擎一致）")
+	flag.IntVar(&cfg.engine.poolPages, "pool", 1024, "B+tree 缓冲池页数（每页 4KB）")
+	flag.IntVar(&cfg.engine.memTable, "memtable", 4096, "LSM 内存表条目数")
+	dir := flag.String("dir", "", "数据目录（默认使用临时目录，结束后删除）")
+	csvPath := flag.String("csv", "", "把结果写入 CSV 文件")
+	jsonPath := flag.String("json", "", "把结果写入 JSON 文件")
+	flag.Parse()
+
+	var selected []engineFactory
+	for _, name := range strings.Split(*engineList, ",") {
+		found := false
+		for _, f := range engines {
+			if f.name == strings.TrimSpace(name) {
+				selected = append(selected, f)
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "未知引擎 %q\n", name)
+			os.Exit(2)
+		}
+	}
+	var wls []Workload
+	for _, name := range strings.Split(*workloadList, ",") {
+		w, ok := findWorkload(strings.TrimSpace(name))
+		if !ok {
+			fmt.Fprintf(os.Stderr, "未知负载 %q\n", name)
+			os.Exit(2)
+		}
+		wls = append(wls, w)
+	}
+
+	root := *dir
+	if root == "" {
+		tmp, err := os.MkdirTemp("", "storage-bench")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(tmp)
+		root = tmp
+	}
+
+	fmt.Println("=== 存储引擎基准测试（YCSB 风格负载） ===")
+	fmt.Printf("记录数 %d，每个负载 %d 次操作，值 %d 字节，键分布 %s，fsync=%v\n\n",
+		cfg.records, cfg.ops, cfg.valueSize, cfg.dist, cfg.engine.sync)
+	for _, w := range wls {
+		fmt.Printf("  负载 %s: %s\n", w.Name, w.Description)
+	}
 	fmt.Println()
+	fmt.Printf("  %-6s %-8s %-8s %10s %10s %10s %10s %10s\n",
+		"engine", "workload", "dist", "ops/s", "p50(us)", "p99(us)", "written", "read")
+
+	var results []Result
+	for _, w := range wls {
+		for _, f := range selected {
+			d := filepath.Join(root, f.name+"-"+w.Name)
+			if err := os.RemoveAll(d); err == nil {
+				err = os.MkdirAll(d, 0755)
+			}
+			rs, err := run(f, w, cfg, d)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s/%s: %v\n", f.name, w.Name, err)
+				os.Exit(1)
+			}
+			if *dir == "" {
+				os.RemoveAll(d)
+			}
+			// 装载阶段对每个负载都一样，只在第一个负载时输出
+			if w.Name == wls[0].Name {
+				printResult(rs[0])
+				results = append(results, rs[0])
+			}
+			printResult(rs[1])
+			results = append(results, rs[1])
+		}
+	}
+
+	if *csvPath != "" {
+		if err := writeCSV(*csvPath, results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("\n结果已写入 %s\n", *csvPath)
+	}
+	if *jsonPath != "" {
+		if err := writeJSON(*jsonPath, results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("结果已写入 %s\n", *jsonPath)
+	}
 
-	// 这里只是演示框架，实际需要导入btree和lsm的实现
-	// 为了演示，我们使用模拟数据
-
-	writeCount := 1000
-	readCount := 1000
-
-	fmt.Println("写入性能对比：")
-	fmt.Printf("  写入 %d 条记录\n", writeCount)
-
-	// 模拟B-tree写入（随机I/O，较慢）
-	btreeWriteTime := time.Duration(writeCount*100) * time.Microsecond
-	fmt.Printf("  B-tree:   %v (模拟：随机I/O，较慢)\n", btreeWriteTime)
-
-	// 模拟LSM-tree写入（顺序I/O，较快）
-	lsmWriteTime := time.Duration(writeCount*20) * time.Microsecond
-	fmt.Printf("  LSM-tree: %v (模拟：顺序I/O，较快)\n", lsmWriteTime)
-	fmt.Printf("  LSM-tree写入速度约为B-tree的 %.1f 倍\n", float64(btreeWriteTime)/float64(lsmWriteTime))
-
-	fmt.Println("\n读取性能对比：")
-	fmt.Printf("  读取 %d 条记录\n", readCount)
-
-	// 模拟B-tree读取（直接定位，较快）
-	btreeReadTime := time.Duration(readCount*10) * time.Microsecond
-	fmt.Printf("  B-tree:   %v (模拟：直接定位，较快)\n", btreeReadTime)
-
-	// 模拟LSM-tree读取（可能需要查多个SSTable，较慢）
-	lsmReadTime := time.Duration(readCount*50) * time.Microsecond
-	fmt.Printf("  LSM-tree: %v (模拟：可能查多个SSTable，较慢)\n", lsmReadTime)
-	fmt.Printf("  B-tree读取速度约为LSM-tree的 %.1f 倍\n", float64(lsmReadTime)/float64(btreeReadTime))
-
-	fmt.Println("\n=== 性能总结 ===")
-	fmt.Println("B-tree:")
-	fmt.Println("  ✓ 读取性能优秀（稳定，可预测）")
-	fmt.Println("  ✗ 写入性能一般（随机I/O）")
-	fmt.Println("  ✓ 适合OLTP系统，读多写少")
-	fmt.Println("\nLSM-tree:")
-	fmt.Println("  ✗ 读取性能一般（可能需要查多个层级）")
-	fmt.Println("  ✓ 写入性能优秀（顺序I/O，高吞吐）")
-	fmt.Println("  ✓ 适合写密集型应用，时序数据")
-	fmt.Println("\n选择建议：")
-	fmt.Println("- 读多写少 -> B-tree")
-	fmt.Println("- 写多读少 -> LSM-tree")
-	fmt.Println("- 需要范围查询 -> B-tree")
-	fmt.Println("- 高写入吞吐量 -> LSM-tree")
+	fmt.Println("\n=== 如何阅读结果 ===")
+	fmt.Println("- load：随机顺序插入。LSM 和哈希索引只追加写，B+tree 每次写入都要修改页并写 WAL")
+	fmt.Println("- written/read：引擎实际写入/读取的字节数（含 WAL、刷盘、合并和页回写），与用户数据量之比即写放大/读放大")
+	fmt.Println("- C（只读）：哈希索引一次定位；B+tree 热页在缓冲池中；LSM 需要逐层查找（布隆过滤器减少了无效读取）")
+	fmt.Println("- E（范围扫描）：B+tree 和 LSM 按顺序读取；哈希索引无序，只能取出所有键排序，代价随数据量增长")
+	fmt.Println("- zipfian 分布下热门键集中，缓存命中率高；换成 -dist uniform 可以观察到读取延迟的变化")
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+const maxKey = math.MaxInt
+
+// 操作类型
+const (
+	opRead   = "read"
+	opUpdate = "update"
+	opInsert = "insert"
+	opScan   = "scan"
+	opRMW    = "rmw" // read-modify-write：先读再写回
+)
+
+// Workload 是一个 YCSB 风格的负载：各种操作的比例和选择键的分布
+type Workload struct {
+	Name        string
+	Description string
+	Read        float64
+	Update      float64
+	Insert      float64
+	Scan        float64
+	RMW         float64
+	// Latest 为 true 时读取偏向最近插入的键（YCSB 的 latest 分布），忽略 -dist
+	Latest bool
+}
+
+// workloads 对应 YCSB 的核心负载 A–F
+var workloads = []Workload{
+	{Name: "A", Description: "更新密集：50% 读，50% 更新", Read: 0.5, Update: 0.5},
+	{Name: "B", Description: "读为主：95% 读，5% 更新", Read: 0.95, Update: 0.05},
+	{Name: "C", Description: "只读：100% 读", Read: 1},
+	{Name: "D", Description: "读最新：95% 读（偏向新插入的键），5% 插入", Read: 0.95, Insert: 0.05, Latest: true},
+	{Name: "E", Description: "短范围扫描：95% 扫描，5% 插入", Scan: 0.95, Insert: 0.05},
+	{Name: "F", Description: "读-改-写：50% 读，50% 读-改-写", Read: 0.5, RMW: 0.5},
+}
+
+func findWorkload(name string) (Workload, bool) {
+	for _, w := range workloads {
+		if strings.EqualFold(w.Name, name) {
+			return w, true
+		}
+	}
+	return Workload{}, false
+}
+
+// nextOp 按比例随机选择一种操作
+func (w Workload) nextOp(r *rand.Rand) string {
+	x := r.Float64()
+	for _, c := range []struct {
+		p  float64
+		op string
+	}{{w.Read, opRead}, {w.Update, opUpdate}, {w.Insert, opInsert}, {w.Scan, opScan}, {w.RMW, opRMW}} {
+		if x < c.p {
+			return c.op
+		}
+		x -= c.p
+	}
+	return opRead
+}
+
+// keyChooser 从 [0, n) 中选择一个已存在的键
+type keyChooser interface {
+	next(n int) int
+}
+
+type uniformChooser struct{ r *rand.Rand }
+
+func (u uniformChooser) next(n int) int { return u.r.Intn(n) }
+
+// zipfian 按 Zipf 分布生成 [0, items) 中的排名，排名 0 最热门。
+// 算法来自 Gray 等人的 "Quickly Generating Billion-Record Synthetic Databases"，与 YCSB 相同，
+// theta 默认 0.99：最热门的 1% 的键大约承担一半的访问。
+type zipfian struct {
+	r                        *rand.Rand
+	items                    int
+	theta, alpha, zetaN, eta float64
+	halfPowTheta             float64
+}
+
+func newZipfian(r *rand.Rand, items int, theta float64) *zipfian {
+	zeta := func(n int) float64 {
+		sum := 0.0
+		for i := 1; i <= n; i++ {
+			sum += 1 / math.Pow(float64(i), theta)
+		}
+		return sum
+	}
+	z := &zipfian{r: r, items: items, theta: theta}
+	z.zetaN = zeta(items)
+	z.alpha = 1 / (1 - theta)
+	z.eta = (1 - math.Pow(2/float64(items), 1-theta)) / (1 - zeta(2)/z.zetaN)
+	z.halfPowTheta = 1 + math.Pow(0.5, theta)
+	return z
+}
+
+func (z *zipfian) rank() int {
+	u := z.r.Float64()
+	uz := u * z.zetaN
+	if uz < 1 {
+		return 0
+	}
+	if uz < z.halfPowTheta {
+		return 1
+	}
+	return min(z.items-1, int(float64(z.items)*math.Pow(z.eta*u-z.eta+1, z.alpha)))
+}
+
+// scrambledZipfian 把 Zipf 排名打散到整个键空间，热门键不会集中在键的一端
+type scrambledZipfian struct{ z *zipfian }
+
+func (s scrambledZipfian) next(n int) int {
+	h := uint64(s.z.rank())*0x9e3779b97f4a7c15 + 0x7f4a7c15
+	h ^= h >> 29
+	return int(h % uint64(n))
+}
+
+// latestChooser 让最近插入的键最热门：n-1 是排名 0
+type latestChooser struct{ z *zipfian }
+
+func (l latestChooser) next(n int) int { return max(0, n-1-l.z.rank()) }
+
+func newChooser(dist string, latest bool, r *rand.Rand, records int) (keyChooser, error) {
+	if latest {
+		return latestChooser{newZipfian(r, records, 0.99)}, nil
+	}
+	switch dist {
+	case "zipfian":
+		return scrambledZipfian{newZipfian(r, records, 0.99)}, nil
+	case "uniform":
+		return uniformChooser{r}, nil
+	}
+	return nil, fmt.Errorf("unknown distribution %q", dist)
+}
+
+// makeValue 生成长度为 size 的随机值（随机内容，压缩和去重都占不到便宜）
+func makeValue(r *rand.Rand, size int) string {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = 'a' + byte(r.Intn(26))
+	}
+	return string(b)
+}
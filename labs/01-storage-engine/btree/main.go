@@ -2,133 +2,28 @@ package main
 
 import (
 	"fmt"
-)
-
-// B-tree节点
-type BTreeNode struct {
-	keys     []int
-	values   []string
-	children []*BTreeNode
-	isLeaf   bool
-}
+	"strings"
 
-// B-tree实现（简化版，仅用于演示）
-type BTree struct {
-	root  *BTreeNode
-	order int // B-tree的阶数（每个节点最多order-1个键）
-}
+	"github.com/ddia-labs/pkg/btree"
+	"github.com/ddia-labs/pkg/kv"
+)
 
-func NewBTree(order int) *BTree {
-	return &BTree{
-		root: &BTreeNode{
-			keys:   make([]int, 0),
-			values: make([]string, 0),
-			isLeaf: true,
-		},
-		order: order,
+func must(err error) {
+	if err != nil {
+		panic(err)
 	}
 }
 
-// 查找操作
-func (bt *BTree) Get(key int) (string, bool) {
-	return bt.search(bt.root, key)
-}
-
-func (bt *BTree) search(node *BTreeNode, key int) (string, bool) {
-	if node == nil {
-		return "", false
-	}
-
-	// 在节点中查找key的位置
-	i := 0
-	for i < len(node.keys) && key > node.keys[i] {
-		i++
-	}
-
-	// 如果找到key
-	if i < len(node.keys) && key == node.keys[i] {
-		if node.isLeaf {
-			return node.values[i], true
-		}
-		// 非叶子节点，继续向下查找
+// mustCheck 在每次操作后校验 B-tree 的结构不变式
+func mustCheck(bt *btree.BTree) {
+	if err := bt.CheckInvariants(); err != nil {
+		panic(err)
 	}
-
-	// 如果是叶子节点但没找到，返回false
-	if node.isLeaf {
-		return "", false
-	}
-
-	// 递归查找子节点
-	return bt.search(node.children[i], key)
 }
 
-// 插入操作（简化版，仅支持叶子节点插入）
-func (bt *BTree) Put(key int, value string) {
-	bt.insert(bt.root, key, value)
-}
-
-func (bt *BTree) insert(node *BTreeNode, key int, value string) {
-	if node.isLeaf {
-		// 在叶子节点中插入
-		pos := 0
-		for pos < len(node.keys) && key > node.keys[pos] {
-			pos++
-		}
-
-		// 如果key已存在，更新value
-		if pos < len(node.keys) && node.keys[pos] == key {
-			node.values[pos] = value
-			return
-		}
-
-		// 插入新key-value
-		node.keys = append(node.keys[:pos], append([]int{key}, node.keys[pos:]...)...)
-		node.values = append(node.values[:pos], append([]string{value}, node.values[pos:]...)...)
-		return
-	}
-
-	// 非叶子节点，找到合适的子节点
-	pos := 0
-	for pos < len(node.keys) && key > node.keys[pos] {
-		pos++
-	}
-	bt.insert(node.children[pos], key, value)
-}
-
-// 范围查询
-func (bt *BTree) RangeScan(start, end int) []string {
-	var result []string
-	bt.rangeScan(bt.root, start, end, &result)
-	return result
-}
-
-func (bt *BTree) rangeScan(node *BTreeNode, start, end int, result *[]string) {
-	if node == nil {
-		return
-	}
-
-	if node.isLeaf {
-		// 在叶子节点中查找范围内的key
-		for i, key := range node.keys {
-			if key >= start && key <= end {
-				*result = append(*result, node.values[i])
-			}
-		}
-		return
-	}
-
-	// 非叶子节点，递归查找
-	for i, key := range node.keys {
-		if key >= start {
-			bt.rangeScan(node.children[i], start, end, result)
-		}
-		if key > end {
-			break
-		}
-	}
-	// 检查最后一个子节点
-	if len(node.keys) > 0 && node.keys[len(node.keys)-1] < end {
-		bt.rangeScan(node.children[len(node.children)-1], start, end, result)
+func printTree(bt *btree.BTree) {
+	for i, line := range strings.Split(bt.String(), "\n") {
+		fmt.Printf("  第%d层: %s\n", i+1, line)
 	}
 }
 
@@ -142,23 +37,30 @@ func main() {
 	fmt.Println("4. 写入时可能需要更新多个节点（随机I/O）")
 	fmt.Println()
 
-	bt := NewBTree(4)
+	bt := btree.New(4) // 每个节点最多3个键、4个子节点
 
 	// 插入数据
 	fmt.Println("插入数据：")
-	keys := []int{10, 20, 5, 15, 25, 30, 8, 12}
-	values := []string{"val10", "val20", "val5", "val15", "val25", "val30", "val8", "val12"}
-
-	for i, key := range keys {
-		bt.Put(key, values[i])
-		fmt.Printf("  插入 key=%d, value=%s\n", key, values[i])
+	keys := []int{10, 20, 5, 15, 25, 30, 8, 12, 18, 22, 28, 35, 40, 1, 3}
+	for _, key := range keys {
+		height := bt.Height()
+		must(bt.Put(kv.IntKey(key), []byte(fmt.Sprintf("val%d", key))))
+		mustCheck(bt)
+		note := ""
+		if bt.Height() > height {
+			note = fmt.Sprintf(" -> 根节点分裂，树高增长为 %d", bt.Height())
+		}
+		fmt.Printf("  插入 key=%d%s\n", key, note)
 	}
+	fmt.Println("\n插入后的树结构（节点满时分裂，中间键提升到父节点）：")
+	printTree(bt)
 
 	// 查找数据
 	fmt.Println("\n查找数据：")
 	testKeys := []int{10, 15, 100}
 	for _, key := range testKeys {
-		value, found := bt.Get(key)
+		value, found, err := bt.Get(kv.IntKey(key))
+		must(err)
 		if found {
 			fmt.Printf("  key=%d -> value=%s\n", key, value)
 		} else {
@@ -168,10 +70,37 @@ func main() {
 
 	// 范围查询
 	fmt.Println("\n范围查询 [10, 25]：")
-	results := bt.RangeScan(10, 25)
-	for _, value := range results {
+	must(bt.Scan(kv.IntKey(10), kv.IntKey(26), func(key, value []byte) bool {
 		fmt.Printf("  %s\n", value)
+		return true
+	}))
+
+	// 删除数据
+	fmt.Println("\n删除数据（节点下溢时向兄弟借键或与兄弟合并）：")
+	for _, key := range []int{20, 1, 3, 5, 8, 10, 12, 15} {
+		must(bt.Delete(kv.IntKey(key)))
+		mustCheck(bt)
+		fmt.Printf("  删除 key=%d，树高 %d，剩余 %d 个键\n", key, bt.Height(), bt.Len())
+	}
+	fmt.Println("\n删除后的树结构：")
+	printTree(bt)
+
+	// 复合键：(用户, 时间戳) 按保持顺序的编码拼成一个字节串，同一用户的记录在树中相邻
+	fmt.Println("\n复合键 (用户, 时间戳)，前缀扫描 bob 的记录：")
+	events := btree.New(4)
+	for i, user := range []string{"bob", "alice", "bob", "carol", "bob", "alice"} {
+		key := kv.AppendInt64(kv.AppendString(nil, user), int64(100-i*10))
+		must(events.Put(key, []byte(fmt.Sprintf("%s 的第 %d 个事件", user, i))))
 	}
+	prefix := kv.AppendString(nil, "bob")
+	must(events.Scan(prefix, kv.PrefixEnd(prefix), func(key, value []byte) bool {
+		_, rest, err := kv.DecodeBytes(key)
+		must(err)
+		ts, _, err := kv.DecodeInt64(rest)
+		must(err)
+		fmt.Printf("  ts=%d -> %s\n", ts, value)
+		return true
+	}))
 
 	fmt.Println("\n=== B-tree 权衡分析 ===")
 	fmt.Println("优势：")
@@ -0,0 +1,278 @@
+// crashtest 是磁盘 B+tree 的崩溃恢复测试驱动：
+// 反复启动一个子进程执行随机的 Put/Delete 负载，并在分裂进行到一半时（或随机时刻）
+// 用 SIGKILL 杀掉它，然后重新打开树（重放 WAL）并检查
+//  1. CheckInvariants 通过：键有序、叶子深度一致、兄弟指针正确、没有悬空或泄漏的页；
+//  2. 树的内容等于所有已确认操作生效后的状态（被杀时正在执行的那条操作可以生效也可以不生效）。
+//
+// 子进程关闭了每次提交的 fsync：进程被杀时已写入的数据仍在操作系统的页缓存中，
+// 这里测试的是“写到一半”的撕裂状态，而不是断电。
+//
+// 用法：go run ./crashtest -seed 1 -rounds 100
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ddia-labs/pkg/bptree"
+)
+
+const keySpace = 4000
+
+// op 是负载中的第 i 条操作，由种子和下标唯一确定，父子进程各自生成同样的序列
+type op struct {
+	del   bool
+	key   int
+	value string
+}
+
+func opAt(seed int64, i int) op {
+	r := rand.New(rand.NewSource(seed*1_000_003 + int64(i)))
+	o := op{key: r.Intn(keySpace), del: r.Intn(4) == 0}
+	if !o.del {
+		// 值里带上操作编号，读到旧值或别的操作的值都能被发现
+		o.value = fmt.Sprintf("op%d-", i) + strings.Repeat("v", r.Intn(400))
+	}
+	return o
+}
+
+func (o op) apply(m map[int]string) {
+	if o.del {
+		delete(m, o.key)
+	} else {
+		m[o.key] = o.value
+	}
+}
+
+// runChild 在子进程中执行操作 [start, start+n)，每完成一条就向 stdout 报告它的下标。
+// killSplit > 0 时在第 killSplit 次分裂的中途杀死自己。
+func runChild(path string, seed int64, start, n, killSplit int, checkpoint int64) {
+	t, err := bptree.Open(path, bptree.MinPoolPages)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open:", err)
+		os.Exit(2)
+	}
+	t.SetSyncCommits(false)
+	t.SetCheckpointBytes(checkpoint)
+	splits := 0
+	t.SetSplitHook(func() {
+		splits++
+		if splits == killSplit {
+			syscall.Kill(os.Getpid(), syscall.SIGKILL)
+		}
+	})
+
+	for i := start; i < start+n; i++ {
+		o := opAt(seed, i)
+		if o.del {
+			_, err = t.Delete(o.key)
+		} else {
+			err = t.Put(o.key, o.value)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "op:", err)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stdout, i)
+	}
+	if err := t.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "close:", err)
+		os.Exit(2)
+	}
+}
+
+type harness struct {
+	rng  *rand.Rand
+	seed int64
+	path string
+	ops  int
+
+	model map[int]string // 已确认（以及被判定为已生效）的操作之后的状态
+	next  int            // 下一条要执行的操作
+
+	kills, splitKills int
+	recovered         int64
+	discarded         int64
+}
+
+// round 启动一次子进程、等它被杀死或正常结束，然后校验恢复后的树
+func (h *harness) round() error {
+	killSplit := 0
+	if h.rng.Intn(3) != 0 {
+		killSplit = 1 + h.rng.Intn(10)
+	}
+	// 检查点间隔也随机选择，让崩溃落在检查点前后的不同位置
+	checkpoint := []int64{16 << 10, 256 << 10, 4 << 20}[h.rng.Intn(3)]
+	cmd := exec.Command(os.Args[0], "-child",
+		"-path", h.path,
+		"-seed", strconv.FormatInt(h.seed, 10),
+		"-start", strconv.Itoa(h.next),
+		"-ops", strconv.Itoa(h.ops),
+		"-kill-split", strconv.Itoa(killSplit),
+		"-checkpoint", strconv.FormatInt(checkpoint, 10))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if killSplit == 0 {
+		// 不在分裂处自杀的轮次，由父进程在随机时刻杀死子进程
+		timer := time.AfterFunc(time.Duration(h.rng.Intn(30000))*time.Microsecond, func() {
+			cmd.Process.Kill()
+		})
+		defer timer.Stop()
+	}
+
+	acked := h.next - 1
+	sc := bufio.NewScanner(out)
+	for sc.Scan() {
+		if acked, err = strconv.Atoi(sc.Text()); err != nil {
+			return err
+		}
+	}
+	err = cmd.Wait()
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		h.kills++
+		if killSplit > 0 {
+			h.splitKills++
+		}
+	} else if err != nil {
+		return fmt.Errorf("child failed: %v", err)
+	}
+
+	// 已确认的操作必须生效；被杀时正在执行的那一条可能生效也可能没有
+	for i := h.next; i <= acked; i++ {
+		opAt(h.seed, i).apply(h.model)
+	}
+	h.next = acked + 1
+	return h.verify()
+}
+
+func (h *harness) verify() error {
+	t, err := bptree.Open(h.path, bptree.MinPoolPages)
+	if err != nil {
+		return fmt.Errorf("reopen: %v", err)
+	}
+	defer t.Close()
+	st, err := t.Stats()
+	if err != nil {
+		return err
+	}
+	h.recovered += st.WAL.Recovered
+	h.discarded += st.WAL.Discarded
+
+	if err := t.CheckInvariants(); err != nil {
+		return fmt.Errorf("invariant violated after recovery: %v", err)
+	}
+	got := make(map[int]string)
+	if err := t.Scan(-1, keySpace, func(k int, v string) bool {
+		got[k] = v
+		return true
+	}); err != nil {
+		return err
+	}
+
+	if equal(got, h.model) {
+		return nil
+	}
+	inFlight := opAt(h.seed, h.next)
+	withInFlight := make(map[int]string, len(h.model)+1)
+	for k, v := range h.model {
+		withInFlight[k] = v
+	}
+	inFlight.apply(withInFlight)
+	if equal(got, withInFlight) {
+		h.model = withInFlight
+		h.next++
+		return nil
+	}
+	return fmt.Errorf("recovered state matches neither op %d applied nor unapplied: %s", h.next, diff(got, h.model))
+}
+
+func equal(a, b map[int]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func diff(got, want map[int]string) string {
+	var keys []int
+	for k := range want {
+		if got[k] != want[k] {
+			keys = append(keys, k)
+		}
+	}
+	for k := range got {
+		if _, ok := want[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+	if len(keys) > 5 {
+		keys = keys[:5]
+	}
+	var b strings.Builder
+	for _, k := range keys {
+		g, w := got[k], want[k]
+		fmt.Fprintf(&b, "key %d: got %.12q want %.12q; ", k, g, w)
+	}
+	return fmt.Sprintf("%d keys vs %d expected; %s", len(got), len(want), b.String())
+}
+
+func main() {
+	child := flag.Bool("child", false, "内部使用：以子进程模式运行负载")
+	path := flag.String("path", "", "内部使用：树文件路径")
+	start := flag.Int("start", 0, "内部使用：第一条操作的下标")
+	killSplit := flag.Int("kill-split", 0, "内部使用：在第 n 次分裂中途自杀")
+	checkpoint := flag.Int64("checkpoint", 0, "内部使用：WAL 检查点阈值（字节）")
+	seed := flag.Int64("seed", 1, "随机种子")
+	rounds := flag.Int("rounds", 50, "崩溃轮数")
+	ops := flag.Int("ops", 3000, "每轮子进程最多执行的操作数")
+	flag.Parse()
+
+	if *child {
+		runChild(*path, *seed, *start, *ops, *killSplit, *checkpoint)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "bptree-crash")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := &harness{
+		rng:   rand.New(rand.NewSource(*seed)),
+		seed:  *seed,
+		path:  filepath.Join(dir, "tree.db"),
+		ops:   *ops,
+		model: make(map[int]string),
+	}
+	for r := 0; r < *rounds; r++ {
+		if err := h.round(); err != nil {
+			fmt.Printf("FAIL seed=%d round=%d: %v\n", *seed, r, err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("PASS seed=%d rounds=%d ops=%d kills=%d (mid-split=%d) keys=%d recovered_txns=%d discarded_bytes=%d\n",
+		*seed, *rounds, h.next, h.kills, h.splitKills, len(h.model), h.recovered, h.discarded)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ddia-labs/pkg/bptree"
+)
+
+const numKeys = 20000
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func printStats(label string, t *bptree.Tree) {
+	s, err := t.Stats()
+	must(err)
+	fmt.Printf("  %-12s 高度=%d 页数=%d 空闲页=%d 读页=%d 写页=%d 命中=%d 未命中=%d 淘汰=%d\n",
+		label, s.Height, s.Pages, s.FreePages, s.PageReads, s.PageWrites, s.Hits, s.Misses, s.Evictions)
+}
+
+// run 向一棵缓冲池大小为 poolPages 的树随机写入并随机读取，对比页 I/O
+func run(dir string, poolPages int) {
+	path := filepath.Join(dir, fmt.Sprintf("pool%d.db", poolPages))
+	t, err := bptree.Open(path, poolPages)
+	must(err)
+	defer t.Close()
+
+	r := rand.New(rand.NewSource(1))
+	for _, k := range r.Perm(numKeys) {
+		must(t.Put(k, fmt.Sprintf("value-%06d", k)))
+	}
+	for i := 0; i < numKeys; i++ {
+		k := r.Intn(numKeys)
+		v, ok, err := t.Get(k)
+		must(err)
+		if !ok || v != fmt.Sprintf("value-%06d", k) {
+			panic(fmt.Sprintf("key %d: got %q", k, v))
+		}
+	}
+	printStats(fmt.Sprintf("池=%d页", poolPages), t)
+}
+
+func main() {
+	fmt.Println("=== 磁盘 B+tree + 缓冲池演示 ===")
+	fmt.Println()
+	fmt.Println("B+tree特点：")
+	fmt.Println("1. 每个节点是页文件中的一个 4KB 页，通过缓冲池读写")
+	fmt.Println("2. 键值只存放在叶子节点，叶子之间用兄弟指针串成链表")
+	fmt.Println("3. 范围扫描只需下降一次，之后顺着叶子链表顺序读取")
+	fmt.Println()
+
+	dir, err := os.MkdirTemp("", "disk-btree")
+	must(err)
+	defer os.RemoveAll(dir)
+
+	// 1. 缓冲池大小对 I/O 的影响
+	fmt.Printf("1. 随机写入并随机读取 %d 个键，对比不同缓冲池大小：\n", numKeys)
+	run(dir, 16)
+	run(dir, 1024)
+	fmt.Println("  → 缓冲池放不下整棵树时，每次下降都会淘汰页并产生真实的磁盘读写")
+	fmt.Println()
+
+	// 2. 范围扫描与持久化
+	path := filepath.Join(dir, "tree.db")
+	t, err := bptree.Open(path, 64)
+	must(err)
+	for k := 0; k < 1000; k++ {
+		must(t.Put(k, strings.Repeat("x", 40)+fmt.Sprint(k)))
+	}
+	must(t.Close())
+
+	fmt.Println("2. 关闭后重新打开，数据仍在磁盘上：")
+	t, err = bptree.Open(path, 64)
+	must(err)
+	printStats("重新打开", t)
+	fmt.Print("  Scan(500, 505): ")
+	must(t.Scan(500, 505, func(k int, v string) bool {
+		fmt.Printf("%d ", k)
+		return true
+	}))
+	fmt.Println()
+	printStats("扫描后", t)
+	fmt.Println()
+
+	// 3. 删除后空叶子被回收到空闲链表，后续插入复用这些页
+	fmt.Println("3. 删除 [0, 900) 后再插入新键：")
+	for k := 0; k < 900; k++ {
+		ok, err := t.Delete(k)
+		must(err)
+		if !ok {
+			panic(fmt.Sprintf("key %d missing", k))
+		}
+	}
+	printStats("删除后", t)
+	for k := 2000; k < 2900; k++ {
+		must(t.Put(k, strings.Repeat("y", 40)))
+	}
+	printStats("再插入后", t)
+	fmt.Println("  → 空闲页被优先复用，页文件没有继续增长")
+
+	count := 0
+	must(t.Scan(0, 1<<31, func(int, string) bool { count++; return true }))
+	fmt.Printf("  剩余键数: %d\n", count)
+	must(t.Close())
+}
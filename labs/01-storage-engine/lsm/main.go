@@ -2,125 +2,36 @@ package main
 
 import (
 	"fmt"
-	"sort"
-)
-
-// LSM-tree的MemTable（内存表）
-type MemTable struct {
-	data map[int]string
-}
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
-func NewMemTable() *MemTable {
-	return &MemTable{
-		data: make(map[int]string),
-	}
-}
-
-func (mt *MemTable) Put(key int, value string) {
-	mt.data[key] = value
-}
-
-func (mt *MemTable) Get(key int) (string, bool) {
-	value, ok := mt.data[key]
-	return value, ok
-}
-
-func (mt *MemTable) Size() int {
-	return len(mt.data)
-}
-
-// SSTable（Sorted String Table）- 磁盘上的有序表
-type SSTable struct {
-	entries []Entry
-}
-
-type Entry struct {
-	Key   int
-	Value string
-}
-
-func NewSSTable(entries []Entry) *SSTable {
-	// 确保有序
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Key < entries[j].Key
-	})
-	return &SSTable{entries: entries}
-}
-
-func (sst *SSTable) Get(key int) (string, bool) {
-	// 二分查找
-	left, right := 0, len(sst.entries)-1
-	for left <= right {
-		mid := (left + right) / 2
-		if sst.entries[mid].Key == key {
-			return sst.entries[mid].Value, true
-		} else if sst.entries[mid].Key < key {
-			left = mid + 1
-		} else {
-			right = mid - 1
-		}
-	}
-	return "", false
-}
-
-// LSM-tree实现（简化版）
-type LSMTree struct {
-	memTable  *MemTable
-	sstables  []*SSTable // 多个层级的SSTable
-	threshold int        // MemTable刷新阈值
-}
-
-func NewLSMTree(threshold int) *LSMTree {
-	return &LSMTree{
-		memTable:  NewMemTable(),
-		sstables:  make([]*SSTable, 0),
-		threshold: threshold,
-	}
-}
-
-// 写入操作（追加写入）
-func (lsm *LSMTree) Put(key int, value string) {
-	lsm.memTable.Put(key, value)
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/lsm"
+)
 
-	// 如果MemTable达到阈值，刷新到磁盘（这里简化为创建SSTable）
-	if lsm.memTable.Size() >= lsm.threshold {
-		lsm.flushMemTable()
+func must(err error) {
+	if err != nil {
+		panic(err)
 	}
 }
 
-func (lsm *LSMTree) flushMemTable() {
-	fmt.Println("  [LSM] MemTable达到阈值，刷新到SSTable...")
-
-	// 将MemTable转换为SSTable
-	entries := make([]Entry, 0, lsm.memTable.Size())
-	for key, value := range lsm.memTable.data {
-		entries = append(entries, Entry{Key: key, Value: value})
+func printTables(tree *lsm.LSMTree) {
+	for _, t := range tree.Tables() {
+		fmt.Printf("  %s: %d 条, 键范围 [%s, %s], %d 个数据块, %d 字节\n",
+			filepath.Base(t.File), t.Entries, kv.FormatKey(t.MinKey), kv.FormatKey(t.MaxKey), t.Blocks, t.Size)
 	}
-
-	sstable := NewSSTable(entries)
-	lsm.sstables = append(lsm.sstables, sstable)
-
-	// 清空MemTable
-	lsm.memTable = NewMemTable()
-
-	fmt.Printf("  [LSM] 创建SSTable，包含 %d 个条目\n", len(entries))
 }
 
-// 读取操作（需要检查MemTable和所有SSTable）
-func (lsm *LSMTree) Get(key int) (string, bool) {
-	// 先检查MemTable
-	if value, ok := lsm.memTable.Get(key); ok {
-		return value, true
+// put 写入一条记录，内存表写满被切换时打印提示
+func put(tree *lsm.LSMTree, key int, value string) {
+	must(tree.Put(kv.IntKey(key), []byte(value)))
+	fmt.Printf("  插入 key=%d, value=%s\n", key, value)
+	if tree.Stats().MemTableEntries == 0 {
+		fmt.Println("  [LSM] MemTable达到阈值，转为不可变内存表，由后台协程刷成 SSTable")
 	}
-
-	// 从最新的SSTable开始查找（LSM-tree通常从新到旧查找）
-	for i := len(lsm.sstables) - 1; i >= 0; i-- {
-		if value, ok := lsm.sstables[i].Get(key); ok {
-			return value, true
-		}
-	}
-
-	return "", false
 }
 
 func main() {
@@ -133,29 +44,243 @@ func main() {
 	fmt.Println("4. 定期合并（Compaction）减少读取开销")
 	fmt.Println()
 
-	lsm := NewLSMTree(5) // 阈值设为5
+	dir, err := os.MkdirTemp("", "lsm-demo")
+	must(err)
+	defer os.RemoveAll(dir)
+
+	// 阈值设为5；数据块很小，让每个 SSTable 都有多个块
+	opts := lsm.Options{MemTableSize: 5, BlockSize: 16}
+	tree, err := lsm.Open(dir, opts)
+	must(err)
 
 	// 插入数据
 	fmt.Println("插入数据：")
 	keys := []int{10, 20, 5, 15, 25, 30, 8, 12, 18, 22}
-	values := []string{"val10", "val20", "val5", "val15", "val25", "val30", "val8", "val12", "val18", "val22"}
-
-	for i, key := range keys {
-		lsm.Put(key, values[i])
-		fmt.Printf("  插入 key=%d, value=%s\n", key, values[i])
+	for _, key := range keys {
+		put(tree, key, fmt.Sprintf("val%d", key))
 	}
 
+	must(tree.Flush()) // 等待后台刷盘完成
+	fmt.Println("\n磁盘上的 SSTable 文件（数据块 | 稀疏索引 | 页脚）：")
+	printTables(tree)
+
 	// 查找数据
-	fmt.Println("\n查找数据：")
+	fmt.Println("\n查找数据（稀疏索引只定位一个数据块）：")
 	testKeys := []int{10, 15, 25, 100}
 	for _, key := range testKeys {
-		value, found := lsm.Get(key)
+		before := tree.Stats().BlockReads
+		value, found, err := tree.Get(kv.IntKey(key))
+		must(err)
+		reads := tree.Stats().BlockReads - before
 		if found {
-			fmt.Printf("  key=%d -> value=%s\n", key, value)
+			fmt.Printf("  key=%d -> value=%s（读取 %d 个数据块）\n", key, value, reads)
 		} else {
-			fmt.Printf("  key=%d -> 未找到\n", key)
+			fmt.Printf("  key=%d -> 未找到（读取 %d 个数据块）\n", key, reads)
+		}
+	}
+
+	// 重新打开：SSTable 从磁盘加载
+	must(tree.Close())
+	tree, err = lsm.Open(dir, opts)
+	must(err)
+	value, _, err := tree.Get(kv.IntKey(18))
+	must(err)
+	fmt.Printf("\n重新打开后加载了 %d 个 SSTable，key=18 -> %s\n", tree.Stats().Tables, value)
+	must(tree.Close())
+
+	// 模拟崩溃：写入后不调用 Close，内存表中的数据只存在于 WAL 中
+	tree, err = lsm.Open(dir, opts)
+	must(err)
+	for _, key := range []int{40, 41, 42} {
+		must(tree.Put(kv.IntKey(key), []byte(fmt.Sprintf("val%d", key))))
+	}
+	logs, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	fmt.Printf("\n模拟崩溃：写入 key=40,41,42 后不关闭（内存表 %d 条，WAL 文件 %d 个）\n",
+		tree.Stats().MemTableEntries, len(logs))
+	tree, err = lsm.Open(dir, opts)
+	must(err)
+	value, _, err = tree.Get(kv.IntKey(41))
+	must(err)
+	fmt.Printf("  重新打开：从 WAL 重放 %d 条记录，key=41 -> %s\n", tree.Stats().Recovered, value)
+	must(tree.Close())
+
+	// 删除：写入删除标记，遮蔽 SSTable 中更旧的版本
+	tree, err = lsm.Open(dir, opts)
+	must(err)
+	fmt.Println("\n删除与范围扫描：")
+	must(tree.Delete(kv.IntKey(15)))
+	must(tree.Delete(kv.IntKey(22)))
+	must(tree.Put(kv.IntKey(20), []byte("val20-new")))
+	_, found, err := tree.Get(kv.IntKey(15))
+	must(err)
+	fmt.Printf("  删除 key=15、22，更新 key=20；key=15 仍在旧 SSTable 中，但被删除标记遮蔽：found=%v\n", found)
+	fmt.Print("  Scan([8, 26)):")
+	must(tree.Scan(kv.IntKey(8), kv.IntKey(26), func(key, value []byte) bool {
+		fmt.Printf(" %s=%s", kv.FormatKey(key), value)
+		return true
+	}))
+	fmt.Println()
+	must(tree.Close())
+
+	// 数据块压缩
+	fmt.Println("\n数据块压缩（值中有大量重复内容时效果明显）：")
+	for _, compression := range []bool{false, true} {
+		d := filepath.Join(dir, fmt.Sprintf("compress-%v", compression))
+		t, err := lsm.Open(d, lsm.Options{MemTableSize: 1000, Compression: compression})
+		must(err)
+		for i := 0; i < 1000; i++ {
+			must(t.Put(kv.IntKey(i), []byte("user:"+strings.Repeat("abc", 20)+fmt.Sprint(i))))
+		}
+		must(t.Flush())
+		fmt.Printf("  压缩=%-5v SSTable 大小: %d 字节\n", compression, t.Stats().TableBytes)
+		must(t.Close())
+	}
+
+	// 并发写入：多个协程同时写入，写满的内存表在后台刷盘；L0 堆积时写入被放慢或暂停
+	fmt.Println("\n并发写入（8 个协程各写 5000 条，同时有 8 个协程读取）：")
+	{
+		d := filepath.Join(dir, "concurrent")
+		t, err := lsm.Open(d, lsm.Options{MemTableSize: 500, L0SlowdownTrigger: 6, L0StopTrigger: 8})
+		must(err)
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(2)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 5000; i++ {
+					must(t.Put(kv.IntKey(i*8+w), []byte(fmt.Sprintf("val%d", i*8+w))))
+				}
+			}(w)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 5000; i++ {
+					_, _, err := t.Get(kv.IntKey(i*8 + w))
+					must(err)
+				}
+			}(w)
+		}
+		wg.Wait()
+		must(t.Flush())
+		s := t.Stats()
+		fmt.Printf("  刷盘 %d 次，合并 %d 次，每层表数 %v\n", s.Flushes, s.Compactions, s.Levels)
+		fmt.Printf("  写入暂停 %d 次（共 %v），被放慢 %d 次\n", s.WriteStalls, s.StallTime.Round(time.Millisecond), s.Slowdowns)
+		value, _, err := t.Get(kv.IntKey(39999))
+		must(err)
+		fmt.Printf("  key=39999 -> %s\n", value)
+		must(t.Close())
+	}
+
+	// 布隆过滤器：查找不存在的键时，不用读数据块就能排除大部分 SSTable
+	fmt.Println("\n布隆过滤器（20 个互相重叠的 SSTable，查找 10000 个不存在的键）：")
+	fmt.Println("  位/键  过滤器大小  读取的表  过滤器排除  误判率")
+	for _, bits := range []int{-1, 2, 5, 10} {
+		d := filepath.Join(dir, fmt.Sprintf("bloom-%d", bits))
+		t, err := lsm.Open(d, lsm.Options{MemTableSize: 500, BloomBitsPerKey: bits, Compaction: lsm.CompactionNone})
+		must(err)
+		for i := 0; i < 10000; i++ {
+			key := (i * 7919) % 10000 * 2 // 只写偶数键
+			must(t.Put(kv.IntKey(key), []byte(fmt.Sprintf("val%d", key))))
+		}
+		for key := 1; key < 20000; key += 2 {
+			_, found, err := t.Get(kv.IntKey(key))
+			must(err)
+			if found {
+				panic("found a key that was never written")
+			}
+		}
+		s := t.Stats()
+		label := fmt.Sprintf("%-6d", bits)
+		if bits < 0 {
+			label = "关闭  " // 中文占两列
+		}
+		fmt.Printf("  %s %8d 字节 %8d %10d %7.2f%%\n",
+			label, t.Tables()[0].FilterBytes, s.TableProbes, s.BloomSkips, s.FalsePositiveRate()*100)
+		must(t.Close())
+	}
+	t, err := lsm.Open(filepath.Join(dir, "bloom-10"), lsm.Options{Compaction: lsm.CompactionNone})
+	must(err)
+	_, _, err = t.Get(kv.IntKey(50000))
+	must(err)
+	fmt.Printf("  key=50000 大于所有表的最大键：被最小/最大键（fence pointer）排除 %d 个表，读取 %d 个\n",
+		t.Stats().FenceSkips, t.Stats().TableProbes)
+	must(t.Close())
+
+	// 合并策略：同样的覆盖写负载，比较三种策略的层结构和放大系数
+	fmt.Println("\n合并策略对比（40000 次写入，20000 个键，每个键平均覆盖一次）：")
+	fmt.Println("  策略         每层表数              合并数   写放大   读放大 空间放大")
+	for _, strategy := range []lsm.CompactionStrategy{lsm.CompactionLeveled, lsm.CompactionSizeTiered, lsm.CompactionNone} {
+		d := filepath.Join(dir, "compaction-"+strategy.String())
+		t, err := lsm.Open(d, lsm.Options{
+			MemTableSize:   200,
+			Compaction:     strategy,
+			BaseLevelBytes: 16 << 10,
+			TargetFileSize: 8 << 10,
+			LevelSizeRatio: 4,
+		})
+		must(err)
+		for i := 0; i < 40000; i++ {
+			key := (i * 7919) % 20000
+			must(t.Put(kv.IntKey(key), []byte(fmt.Sprintf("val%d-%d", key, i))))
+		}
+		must(t.Compact()) // 等待后台合并完成
+		amp, err := t.Amplification()
+		must(err)
+		s := t.Stats()
+		fmt.Printf("  %-12s %-20s %6d %8.2f %8.0f %8.2f\n",
+			strategy, fmt.Sprint(s.Levels), s.Compactions, amp.Write, amp.Read, amp.Space)
+		must(t.Close())
+	}
+	fmt.Println("  leveled：每层互不重叠，读放大和空间放大最小，但数据逐层重写，写放大最大")
+	fmt.Println("  size-tiered：只合并大小相近的段，写放大较小，但同一个键可能存在于多个段中")
+	fmt.Println("  none：从不合并，写放大为 1，读放大和空间放大随写入量线性增长")
+
+	// 键值分离：同样的 1KB 值覆盖写负载，值放在 SSTable 中 vs 放在值日志中
+	fmt.Println("\n键值分离（WiscKey，10000 次写入 1KB 的值，2000 个键）：")
+	fmt.Println("  方式               写放大    合并写出  值日志写入  空间放大")
+	for _, threshold := range []int{0, 256} {
+		name := "值在 SSTable 中 " // 中文占两列，手工补齐到 16 列
+		if threshold > 0 {
+			name = "值在值日志中    "
+		}
+		t, err := lsm.Open(filepath.Join(dir, fmt.Sprintf("vlog-%d", threshold)), lsm.Options{
+			MemTableSize:     200,
+			BaseLevelBytes:   256 << 10,
+			TargetFileSize:   128 << 10,
+			ValueThreshold:   threshold,
+			ValueLogFileSize: 1 << 20,
+		})
+		must(err)
+		for i := 0; i < 10000; i++ {
+			key := (i * 7919) % 2000
+			must(t.Put(kv.IntKey(key), []byte(strings.Repeat(string(rune('a'+i%26)), 1024))))
+		}
+		must(t.Compact())
+		amp, err := t.Amplification()
+		must(err)
+		s := t.Stats()
+		fmt.Printf("  %s %8.2f %9dKB %9dKB %9.2f\n",
+			name, amp.Write, s.CompactionWritten>>10, s.ValueLogWritten>>10, amp.Space)
+		if threshold == 0 {
+			must(t.Close())
+			continue
+		}
+
+		// 每个键被覆盖了 5 次，旧段中大部分值已经失效；逐段回收，只搬动仍然有效的值
+		segments := s.ValueLogFiles - 1
+		for i := 0; i < segments; i++ {
+			gs, err := t.GCValueLog()
+			must(err)
+			fmt.Printf("  回收 %s：%d 条记录中 %d 条仍有效被搬走，释放 %dKB\n",
+				gs.Segment, gs.Records, gs.Relocated, gs.Reclaimed>>10)
 		}
+		amp, err = t.Amplification()
+		must(err)
+		fmt.Printf("  回收后值日志 %dKB（%d 个段），空间放大 %.2f\n",
+			t.Stats().ValueLogBytes>>10, t.Stats().ValueLogFiles, amp.Space)
+		must(t.Close())
 	}
+	fmt.Println("  合并只搬动键和几字节的指针，写放大接近 1；代价是每次读取多一次值日志的随机读，")
+	fmt.Println("  范围扫描变成许多次随机读，被覆盖的值要靠值日志回收（重新读写有效数据）来释放空间")
 
 	fmt.Println("\n=== LSM-tree 权衡分析 ===")
 	fmt.Println("优势：")
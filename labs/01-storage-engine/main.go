@@ -17,6 +17,11 @@ func main() {
 			description: "演示B-tree的存储和检索机制",
 			path:        "btree",
 		},
+		{
+			name:        "磁盘B+tree",
+			description: "演示页文件、缓冲池和叶子兄弟指针上的范围扫描",
+			path:        "disk-btree",
+		},
 		{
 			name:        "LSM-tree存储引擎",
 			description: "演示LSM-tree的追加写入和合并机制",
@@ -24,7 +29,7 @@ func main() {
 		},
 		{
 			name:        "性能对比",
-			description: "对比B-tree和LSM-tree的性能特征",
+			description: "用YCSB风格负载实测B+tree、LSM-tree和哈希索引的吞吐、延迟和I/O",
 			path:        "benchmark",
 		},
 	}
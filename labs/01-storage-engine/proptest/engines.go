@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/ddia-labs/pkg/bptree"
+	"github.com/ddia-labs/pkg/btree"
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/kvcheck"
+	"github.com/ddia-labs/pkg/lsm"
+)
+
+// target 是一个被测引擎及其配置
+type target struct {
+	name string
+	cfg  kvcheck.Config
+	open kvcheck.Factory
+}
+
+func reverse(a, b []byte) int { return -bytes.Compare(a, b) }
+
+func targets(dir string) []target {
+	btreeTarget := func(name string, order int, cmp kv.Comparator) target {
+		return target{
+			name: name,
+			cfg:  kvcheck.Config{Comparator: cmp, Keys: 60},
+			open: func() (kv.KV, error) { return btree.NewWithComparator(order, cmp), nil },
+		}
+	}
+	lsmTarget := func(name string, opts lsm.Options, gc bool) target {
+		return target{
+			name: name,
+			cfg:  kvcheck.Config{Comparator: opts.Comparator, Keys: 40, MaxValue: 40, Reopen: true},
+			open: func() (kv.KV, error) { return openLSM(dir, opts, gc) },
+		}
+	}
+	// 很小的内存表和数据块：几次写入就刷盘，一个表有多个块，很快触发合并
+	small := lsm.Options{MemTableSize: 4, BlockSize: 64, L0CompactionTrigger: 2, BaseLevelBytes: 512, TargetFileSize: 256}
+	tiered := small
+	tiered.Compaction, tiered.TierMinMerge = lsm.CompactionSizeTiered, 2
+	noCompaction := small
+	noCompaction.Compaction = lsm.CompactionNone
+	compressed := small
+	compressed.Compression, compressed.BloomBitsPerKey = true, -1
+	vlog := small
+	vlog.ValueThreshold, vlog.ValueLogFileSize = 8, 256
+	reversed := small
+	reversed.Comparator = reverse
+
+	return []target{
+		btreeTarget("btree/order3", 3, kv.Bytewise),
+		btreeTarget("btree/order4", 4, kv.Bytewise),
+		btreeTarget("btree/order7-reverse", 7, reverse),
+		lsmTarget("lsm/leveled", small, false),
+		lsmTarget("lsm/size-tiered", tiered, false),
+		lsmTarget("lsm/no-compaction", noCompaction, false),
+		lsmTarget("lsm/compressed-nobloom", compressed, false),
+		lsmTarget("lsm/value-log", vlog, true),
+		lsmTarget("lsm/reverse", reversed, false),
+		{
+			name: "bptree",
+			// 只支持整数键；值接近 MaxValueSize，几个键就能填满一页，触发分裂和回收
+			cfg: kvcheck.Config{
+				Keys: 80, MaxValue: bptree.MaxValueSize, Reopen: true,
+				NewKey: func(r *rand.Rand) []byte { return kv.IntKey(r.Intn(400) - 200) },
+			},
+			open: func() (kv.KV, error) { return openBPTree(dir) },
+		},
+	}
+}
+
+// --- LSM-tree ---
+
+type lsmEngine struct {
+	*lsm.LSMTree
+	dir  string
+	opts lsm.Options
+	gc   bool // 重启前先回收一个值日志段
+}
+
+func openLSM(parent string, opts lsm.Options, gc bool) (*lsmEngine, error) {
+	dir, err := os.MkdirTemp(parent, "lsm")
+	if err != nil {
+		return nil, err
+	}
+	tree, err := lsm.Open(dir, opts)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &lsmEngine{LSMTree: tree, dir: dir, opts: opts, gc: gc}, nil
+}
+
+func (e *lsmEngine) Reopen() error {
+	if e.gc {
+		if _, err := e.GCValueLog(); err != nil && !errors.Is(err, lsm.ErrNoValueLogGarbage) {
+			return err
+		}
+	}
+	if err := e.LSMTree.Close(); err != nil {
+		return err
+	}
+	tree, err := lsm.Open(e.dir, e.opts)
+	if err != nil {
+		return err
+	}
+	e.LSMTree = tree
+	return nil
+}
+
+func (e *lsmEngine) Close() error {
+	defer os.RemoveAll(e.dir)
+	return e.LSMTree.Close()
+}
+
+// --- 磁盘 B+tree：int 键、string 值，这里把 IntKey 编码的键转换过去 ---
+
+type bptreeEngine struct {
+	tree *bptree.Tree
+	dir  string
+}
+
+func openBPTree(parent string) (*bptreeEngine, error) {
+	dir, err := os.MkdirTemp(parent, "bptree")
+	if err != nil {
+		return nil, err
+	}
+	tree, err := bptree.Open(filepath.Join(dir, "tree.db"), bptree.MinPoolPages)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	tree.SetSyncCommits(false)
+	return &bptreeEngine{tree: tree, dir: dir}, nil
+}
+
+func (e *bptreeEngine) Get(key []byte) ([]byte, bool, error) {
+	k, err := kv.KeyInt(key)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok, err := e.tree.Get(k)
+	if !ok {
+		return nil, ok, err
+	}
+	return []byte(value), ok, err
+}
+
+func (e *bptreeEngine) Put(key, value []byte) error {
+	k, err := kv.KeyInt(key)
+	if err != nil {
+		return err
+	}
+	return e.tree.Put(k, string(value))
+}
+
+func (e *bptreeEngine) Delete(key []byte) error {
+	k, err := kv.KeyInt(key)
+	if err != nil {
+		return err
+	}
+	_, err = e.tree.Delete(k)
+	return err
+}
+
+// Scan 把 [start, end) 转换成 bptree 的闭区间 [start, end-1]
+func (e *bptreeEngine) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	lo, hi := math.MinInt, math.MaxInt
+	var err error
+	if start != nil {
+		if lo, err = kv.KeyInt(start); err != nil {
+			return err
+		}
+	}
+	if end != nil {
+		if hi, err = kv.KeyInt(end); err != nil {
+			return err
+		}
+		if hi == math.MinInt {
+			return nil
+		}
+		hi--
+	}
+	return e.tree.Scan(lo, hi, func(k int, v string) bool { return fn(kv.IntKey(k), []byte(v)) })
+}
+
+func (e *bptreeEngine) CheckInvariants() error { return e.tree.CheckInvariants() }
+
+func (e *bptreeEngine) Reopen() error {
+	if err := e.tree.Close(); err != nil {
+		return err
+	}
+	tree, err := bptree.Open(filepath.Join(e.dir, "tree.db"), bptree.MinPoolPages)
+	if err != nil {
+		return err
+	}
+	tree.SetSyncCommits(false)
+	e.tree = tree
+	return nil
+}
+
+func (e *bptreeEngine) Close() error {
+	defer os.RemoveAll(e.dir)
+	return e.tree.Close()
+}
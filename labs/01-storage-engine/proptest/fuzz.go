@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ddia-labs/pkg/bptree"
+	"github.com/ddia-labs/pkg/exthash"
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/lsm"
+)
+
+// 磁盘格式的变异测试：先用引擎生成一个合法的数据目录作为种子，
+// 每个用例复制一份、随机破坏其中一个文件，然后打开引擎读遍所有数据、再写几条并关闭。
+// 打开或读取返回错误是正确的行为；panic 或卡住不动则是缺陷。
+//
+// 后台协程中的 panic 无法被捕获，会直接终止进程；这时用例目录不会被删除，
+// 可以按开头打印的路径找到导致崩溃的文件。
+
+// format 是一种磁盘格式的种子生成器和读取器
+type format struct {
+	name string
+	seed func(dir string) error // 在 dir 中生成合法的数据
+	load func(dir string) error // 打开并读遍 dir 中的数据
+}
+
+var lsmFuzzOpts = lsm.Options{
+	MemTableSize: 64, BlockSize: 256, Compression: true,
+	ValueThreshold: 48, ValueLogFileSize: 4 << 10,
+}
+
+var formats = []format{
+	{
+		name: "lsm",
+		// 生成多层 SSTable、值日志段、MANIFEST，以及一个还没有刷盘的 WAL
+		seed: func(dir string) error {
+			tree, err := lsm.Open(dir, lsmFuzzOpts)
+			if err != nil {
+				return err
+			}
+			defer tree.Close()
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 600; i++ {
+				key := kv.IntKey(r.Intn(300))
+				if r.Intn(5) == 0 {
+					err = tree.Delete(key)
+				} else {
+					err = tree.Put(key, []byte(strings.Repeat(fmt.Sprint(i), 1+r.Intn(20))))
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if err := tree.Flush(); err != nil {
+				return err
+			}
+			if err := tree.Compact(); err != nil {
+				return err
+			}
+			for i := 0; i < 20; i++ {
+				if err := tree.Put(kv.IntKey(1000+i), []byte(strings.Repeat("w", 10*i))); err != nil {
+					return err
+				}
+			}
+			// 关闭会把内存表刷成 SSTable，所以在关闭前把目录复制出来
+			return copyDir(dir, dir+".seed")
+		},
+		load: func(dir string) error {
+			tree, err := lsm.Open(dir, lsmFuzzOpts)
+			if err != nil {
+				return err
+			}
+			err = tree.Scan(nil, nil, func(key, value []byte) bool { return true })
+			for i := 0; i < 300 && err == nil; i += 7 {
+				_, _, err = tree.Get(kv.IntKey(i))
+			}
+			for i := 0; i < 100 && err == nil; i++ {
+				err = tree.Put(kv.IntKey(i), []byte(strings.Repeat("n", 60)))
+			}
+			if cerr := tree.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		},
+	},
+	{
+		name: "bptree",
+		// 生成检查点之后的页文件，以及一个包含若干已提交事务的 WAL
+		seed: func(dir string) error {
+			path := filepath.Join(dir, "tree.db")
+			tree, err := bptree.Open(path, bptree.MinPoolPages)
+			if err != nil {
+				return err
+			}
+			defer tree.Close()
+			tree.SetSyncCommits(false)
+			tree.SetCheckpointBytes(1 << 30)
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 400; i++ {
+				if err := tree.Put(r.Intn(1000), strings.Repeat("v", r.Intn(200))); err != nil {
+					return err
+				}
+			}
+			if err := tree.Flush(); err != nil {
+				return err
+			}
+			for i := 0; i < 100; i++ {
+				if i%3 == 0 {
+					_, err = tree.Delete(r.Intn(1000))
+				} else {
+					err = tree.Put(r.Intn(1000), strings.Repeat("w", r.Intn(200)))
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return copyDir(dir, dir+".seed")
+		},
+		load: func(dir string) error {
+			tree, err := bptree.Open(filepath.Join(dir, "tree.db"), bptree.MinPoolPages)
+			if err != nil {
+				return err
+			}
+			tree.SetSyncCommits(false)
+			err = tree.CheckInvariants()
+			if err == nil {
+				err = tree.Scan(-1, 1000, func(int, string) bool { return true })
+			}
+			for i := 0; i < 200 && err == nil; i++ {
+				err = tree.Put(i*5, strings.Repeat("n", 100))
+			}
+			if cerr := tree.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		},
+	},
+	{
+		name: "exthash",
+		// 生成检查点之后的哈希索引页文件（多个桶、目录翻倍过），以及包含分裂和合并的已提交事务的 WAL
+		seed: func(dir string) error {
+			path := filepath.Join(dir, "hash.db")
+			ix, err := exthash.Open(path, exthash.MinPoolPages)
+			if err != nil {
+				return err
+			}
+			defer ix.Close()
+			ix.SetSyncCommits(false)
+			ix.SetCheckpointBytes(1 << 30)
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 400; i++ {
+				if err := ix.Put(kv.IntKey(r.Intn(1000)), []byte(strings.Repeat("v", r.Intn(200)))); err != nil {
+					return err
+				}
+			}
+			if err := ix.Flush(); err != nil {
+				return err
+			}
+			for i := 0; i < 300; i++ {
+				if i%2 == 0 {
+					err = ix.Delete(kv.IntKey(r.Intn(1000)))
+				} else {
+					err = ix.Put(kv.IntKey(r.Intn(1000)), []byte(strings.Repeat("w", r.Intn(200))))
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return copyDir(dir, dir+".seed")
+		},
+		load: func(dir string) error {
+			ix, err := exthash.Open(filepath.Join(dir, "hash.db"), exthash.MinPoolPages)
+			if err != nil {
+				return err
+			}
+			ix.SetSyncCommits(false)
+			err = ix.CheckInvariants()
+			if err == nil {
+				err = ix.Scan(nil, nil, func(k, v []byte) bool { return true })
+			}
+			for i := 0; i < 200 && err == nil; i++ {
+				err = ix.Put(kv.IntKey(i*5), []byte(strings.Repeat("n", 100)))
+			}
+			for i := 0; i < 100 && err == nil; i++ {
+				err = ix.Delete(kv.IntKey(i * 7))
+			}
+			if cerr := ix.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		},
+	},
+}
+
+// fuzzResult 统计用例的结局
+type fuzzResult struct {
+	cases, rejected, loaded int
+	byFile                  map[string]int
+}
+
+// fuzzFormat 对格式 f 执行 n 个变异用例，发现 panic 或超时时返回描述它的错误
+func fuzzFormat(f format, workDir string, seed int64, n int, timeout time.Duration) (fuzzResult, error) {
+	res := fuzzResult{byFile: make(map[string]int)}
+	base := filepath.Join(workDir, f.name)
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return res, err
+	}
+	if err := f.seed(base); err != nil {
+		return res, fmt.Errorf("seed: %v", err)
+	}
+	files, err := listFiles(base + ".seed")
+	if err != nil {
+		return res, err
+	}
+
+	r := rand.New(rand.NewSource(seed))
+	caseDir := filepath.Join(workDir, f.name+".case")
+	for i := 0; i < n; i++ {
+		os.RemoveAll(caseDir)
+		if err := copyDir(base+".seed", caseDir); err != nil {
+			return res, err
+		}
+		name := files[r.Intn(len(files))]
+		path := filepath.Join(caseDir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return res, err
+		}
+		mutated, desc := mutate(r, data)
+		if err := os.WriteFile(path, mutated, 0666); err != nil {
+			return res, err
+		}
+		res.cases++
+		res.byFile[fileKind(name)]++
+
+		type outcome struct {
+			err   error
+			panic string
+		}
+		done := make(chan outcome, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					done <- outcome{panic: fmt.Sprintf("%v\n%s", p, debug.Stack())}
+				}
+			}()
+			done <- outcome{err: f.load(caseDir)}
+		}()
+		select {
+		case o := <-done:
+			if o.panic != "" {
+				return res, fmt.Errorf("case %d: %s (%s): panic: %s\n  corrupted copy kept in %s", i, name, desc, o.panic, keep(caseDir, i))
+			}
+			if o.err != nil {
+				res.rejected++
+			} else {
+				res.loaded++
+			}
+		case <-time.After(timeout):
+			return res, fmt.Errorf("case %d: %s (%s): no progress after %v\n  corrupted copy kept in %s", i, name, desc, timeout, keep(caseDir, i))
+		}
+	}
+	os.RemoveAll(caseDir)
+	return res, nil
+}
+
+// mutate 对 data 做 1~3 次随机破坏，返回结果和描述
+func mutate(r *rand.Rand, data []byte) ([]byte, string) {
+	data = append([]byte{}, data...)
+	var desc []string
+	for k := 1 + r.Intn(3); k > 0; k-- {
+		if len(data) == 0 {
+			data = append(data, randomBytes(r, 1+r.Intn(16))...)
+			desc = append(desc, "fill empty file")
+			continue
+		}
+		at := r.Intn(len(data))
+		switch r.Intn(6) {
+		case 0:
+			data[at] ^= 1 << r.Intn(8)
+			desc = append(desc, fmt.Sprintf("flip bit at %d", at))
+		case 1:
+			v := []byte{0x00, 0x01, 0x7F, 0x80, 0xFF}[r.Intn(5)]
+			data[at] = v
+			desc = append(desc, fmt.Sprintf("set byte %d to %#x", at, v))
+		case 2:
+			// 长度、偏移量之类的字段被改成极端值
+			v := []uint32{0, 1, 0x7FFFFFFF, 0xFFFFFFFF, uint32(len(data)), uint32(len(data) + 1)}[r.Intn(6)]
+			if at+4 > len(data) {
+				at = len(data) - min(4, len(data))
+			}
+			var buf [4]byte
+			binary.LittleEndian.PutUint32(buf[:], v)
+			copy(data[at:], buf[:])
+			desc = append(desc, fmt.Sprintf("write uint32 %#x at %d", v, at))
+		case 3:
+			data = data[:at]
+			desc = append(desc, fmt.Sprintf("truncate to %d", at))
+		case 4:
+			n := 1 + r.Intn(64)
+			data = append(data[:at:at], append(randomBytes(r, n), data[at:]...)...)
+			desc = append(desc, fmt.Sprintf("insert %d random bytes at %d", n, at))
+		case 5:
+			end := min(len(data), at+1+r.Intn(64))
+			data = append(data[:at], data[end:]...)
+			desc = append(desc, fmt.Sprintf("delete bytes [%d, %d)", at, end))
+		}
+	}
+	return data, strings.Join(desc, ", ")
+}
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
+
+// fileKind 把文件名归类（000012.sst -> .sst），用于统计
+func fileKind(name string) string {
+	if ext := filepath.Ext(name); ext != "" {
+		return ext
+	}
+	return name
+}
+
+// keep 把出错的用例目录改名保留下来
+func keep(caseDir string, i int) string {
+	dst := fmt.Sprintf("%s-%d", caseDir, i)
+	if err := os.Rename(caseDir, dst); err != nil {
+		return caseDir
+	}
+	return dst
+}
+
+func listFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func copyDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	names, err := listFiles(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := copyFile(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
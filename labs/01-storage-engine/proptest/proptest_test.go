@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ddia-labs/pkg/kvcheck"
+)
+
+// TestModel 对每个引擎跑几个基于模型的随机序列，完整的测试见 go run ./proptest
+func TestModel(t *testing.T) {
+	seeds := 5
+	if testing.Short() {
+		seeds = 2
+	}
+	for _, tg := range targets(t.TempDir()) {
+		tg := tg
+		t.Run(tg.name, func(t *testing.T) {
+			if failure := kvcheck.CheckSeeds(tg.cfg, tg.open, 1, seeds); failure != nil {
+				t.Fatal(failure)
+			}
+		})
+	}
+}
+
+// fuzzTarget 用 name 格式的合法数据目录做语料：每个输入替换目录中的一个文件，然后打开引擎读遍数据、
+// 再写几条并关闭。返回错误是正确的行为，panic 会让用例失败。
+// 种子语料是每个文件的原始内容加上几个用 mutate 破坏过的版本，所以不带 -fuzz 的 go test 也会检查它们
+func fuzzTarget(f *testing.F, name string) {
+	var fm format
+	for _, x := range formats {
+		if x.name == name {
+			fm = x
+		}
+	}
+	base := filepath.Join(f.TempDir(), name)
+	if err := os.MkdirAll(base, 0755); err != nil {
+		f.Fatal(err)
+	}
+	if err := fm.seed(base); err != nil {
+		f.Fatalf("seed: %v", err)
+	}
+	files, err := listFiles(base + ".seed")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i, file := range files {
+		data, err := os.ReadFile(filepath.Join(base+".seed", file))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(i), data)
+		for k := 0; k < 3; k++ {
+			mutated, _ := mutate(r, data)
+			f.Add(uint8(i), mutated)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, file uint8, data []byte) {
+		dir := t.TempDir()
+		if err := copyDir(base+".seed", dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, files[int(file)%len(files)]), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		fm.load(dir) // 只要求不 panic
+	})
+}
+
+// FuzzLSM 破坏 SSTable、WAL、值日志段或 MANIFEST
+func FuzzLSM(f *testing.F) { fuzzTarget(f, "lsm") }
+
+// FuzzBPTree 破坏 B+tree 的页文件或页级 WAL
+func FuzzBPTree(f *testing.F) { fuzzTarget(f, "bptree") }
+
+// FuzzExtHash 破坏可扩展哈希的页文件或页级 WAL
+func FuzzExtHash(f *testing.F) { fuzzTarget(f, "exthash") }
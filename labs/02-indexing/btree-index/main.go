@@ -1,185 +1,129 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-)
-
-// B-tree索引节点（简化版）
-type BTreeIndexNode struct {
-	keys     []int
-	values   []string
-	children []*BTreeIndexNode
-	isLeaf   bool
-}
+	"os"
+	"strings"
 
-// B-tree索引实现
-type BTreeIndex struct {
-	root *BTreeIndexNode
-}
+	"github.com/ddia-labs/pkg/btreeindex"
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/kvcheck"
+)
 
-func NewBTreeIndex() *BTreeIndex {
-	return &BTreeIndex{
-		root: &BTreeIndexNode{
-			keys:   make([]int, 0),
-			values: make([]string, 0),
-			isLeaf: true,
-		},
+func must(err error) {
+	if err != nil {
+		panic(err)
 	}
 }
 
-// 查找
-func (bti *BTreeIndex) Get(key int) (string, bool) {
-	return bti.search(bti.root, key)
-}
-
-func (bti *BTreeIndex) search(node *BTreeIndexNode, key int) (string, bool) {
-	if node == nil {
-		return "", false
-	}
-
-	// 在节点中查找key的位置
-	i := 0
-	for i < len(node.keys) && key > node.keys[i] {
-		i++
-	}
-
-	// 如果找到key
-	if i < len(node.keys) && key == node.keys[i] {
-		if node.isLeaf {
-			return node.values[i], true
+// reverseScan 用 ScanReverse 实现 Scan：反向读出整个范围再倒过来交给 fn，让 kvcheck 也检查反向遍历
+type reverseScan struct{ *btreeindex.BTreeIndex }
+
+func (r reverseScan) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	var keys, values [][]byte
+	must(r.ScanReverse(start, end, func(key, value []byte) bool {
+		keys, values = append(keys, key), append(values, value)
+		return true
+	}))
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !fn(keys[i], values[i]) {
+			break
 		}
 	}
-
-	// 如果是叶子节点但没找到，返回false
-	if node.isLeaf {
-		return "", false
-	}
-
-	// 递归查找子节点
-	return bti.search(node.children[i], key)
-}
-
-// 插入
-func (bti *BTreeIndex) Put(key int, value string) {
-	bti.insert(bti.root, key, value)
+	return nil
 }
 
-func (bti *BTreeIndex) insert(node *BTreeIndexNode, key int, value string) {
-	if node.isLeaf {
-		// 在叶子节点中插入
-		pos := 0
-		for pos < len(node.keys) && key > node.keys[pos] {
-			pos++
-		}
+// pagedScan 用每页 3 条的 ScanPage 实现 Scan，让 kvcheck 也检查分页令牌
+type pagedScan struct{ *btreeindex.BTreeIndex }
 
-		// 如果key已存在，更新value
-		if pos < len(node.keys) && node.keys[pos] == key {
-			node.values[pos] = value
-			return
+func (p pagedScan) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	opts := btreeindex.ScanOptions{Start: start, End: end, Limit: 3}
+	for {
+		page, err := p.ScanPage(opts)
+		if err != nil {
+			return err
 		}
-
-		// 插入新key-value
-		node.keys = append(node.keys[:pos], append([]int{key}, node.keys[pos:]...)...)
-		node.values = append(node.values[:pos], append([]string{value}, node.values[pos:]...)...)
-		return
-	}
-
-	// 非叶子节点，找到合适的子节点
-	pos := 0
-	for pos < len(node.keys) && key > node.keys[pos] {
-		pos++
-	}
-	bti.insert(node.children[pos], key, value)
-}
-
-// 范围查询
-func (bti *BTreeIndex) RangeScan(start, end int) []string {
-	var result []string
-	bti.rangeScan(bti.root, start, end, &result)
-	return result
-}
-
-func (bti *BTreeIndex) rangeScan(node *BTreeIndexNode, start, end int, result *[]string) {
-	if node == nil {
-		return
-	}
-
-	if node.isLeaf {
-		// 在叶子节点中查找范围内的key
-		for i, key := range node.keys {
-			if key >= start && key <= end {
-				*result = append(*result, node.values[i])
+		for i, key := range page.Keys {
+			if !fn(key, page.Values[i]) {
+				return nil
 			}
 		}
-		return
+		if page.NextPageToken == "" {
+			return nil
+		}
+		opts.PageToken = page.NextPageToken
 	}
+}
 
-	// 非叶子节点，递归查找
-	for i, key := range node.keys {
-		if key >= start {
-			bti.rangeScan(node.children[i], start, end, result)
-		}
-		if key > end {
-			break
+// runCheck 用 pkg/kvcheck 做基于模型的随机测试：随机的 Put/Get/Delete/Scan 序列与 map 模型比较。
+// 阶数为 4 时几个键就会分裂出多层，每一步之后都校验树的不变式；Scan 分别用正向游标、反向游标和分页实现
+func runCheck(seed int64, seeds int) {
+	targets := []struct {
+		name    string
+		factory kvcheck.Factory
+	}{
+		{"default", func() (kv.KV, error) { return btreeindex.NewBTreeIndex(), nil }},
+		{"order4", func() (kv.KV, error) { return btreeindex.NewBTreeIndexWithOrder(4, kv.Bytewise), nil }},
+		{"order4/reverse-scan", func() (kv.KV, error) { return reverseScan{btreeindex.NewBTreeIndexWithOrder(4, kv.Bytewise)}, nil }},
+		{"order5/paged-scan", func() (kv.KV, error) { return pagedScan{btreeindex.NewBTreeIndexWithOrder(5, kv.Bytewise)}, nil }},
+	}
+	failed := false
+	for _, t := range targets {
+		if failure := kvcheck.CheckSeeds(kvcheck.Config{}, t.factory, seed, seeds); failure != nil {
+			failed = true
+			fmt.Printf("FAIL %s %s", t.name, failure)
+			continue
 		}
+		fmt.Printf("PASS %-20s seeds=%d\n", t.name, seeds)
 	}
-	// 检查最后一个子节点
-	if len(node.keys) > 0 && node.keys[len(node.keys)-1] < end {
-		bti.rangeScan(node.children[len(node.children)-1], start, end, result)
+	if failed {
+		os.Exit(1)
 	}
 }
 
-// 有序遍历
-func (bti *BTreeIndex) InOrderTraversal() []string {
-	var result []string
-	bti.inOrder(bti.root, &result)
-	return result
-}
-
-func (bti *BTreeIndex) inOrder(node *BTreeIndexNode, result *[]string) {
-	if node == nil {
-		return
-	}
-
-	if node.isLeaf {
-		*result = append(*result, node.values...)
+func main() {
+	check := flag.Bool("check", false, "执行基于模型的随机测试，而不是演示")
+	seed := flag.Int64("seed", 1, "随机测试的第一个种子")
+	seeds := flag.Int("seeds", 200, "随机测试的序列数")
+	flag.Parse()
+	if *check {
+		runCheck(*seed, *seeds)
 		return
 	}
 
-	// 遍历所有子节点和键
-	for i := 0; i < len(node.children); i++ {
-		bti.inOrder(node.children[i], result)
-		if i < len(node.keys) {
-			// 非叶子节点的值通常不存储实际数据
-		}
-	}
-}
-
-func main() {
 	fmt.Println("=== B-tree索引演示 ===\n")
 	fmt.Println("B-tree索引特点：")
 	fmt.Println("1. O(log n)查找时间复杂度")
 	fmt.Println("2. 支持范围查询")
 	fmt.Println("3. 数据有序存储")
-	fmt.Println("4. 支持有序遍历\n")
+	fmt.Println("4. 支持有序遍历")
+	fmt.Println("5. 游标可以正向、反向流式遍历，支持分页\n")
 
-	index := NewBTreeIndex()
+	// 阶数为 4：每个叶子最多 3 个键，插入几个键就能看到分裂出的多层结构
+	index := btreeindex.NewBTreeIndexWithOrder(4, kv.Bytewise)
 
 	// 插入数据
-	fmt.Println("插入数据：")
+	fmt.Println("插入数据（阶数 4，每个节点最多 3 个键）：")
 	keys := []int{10, 20, 5, 15, 25, 30, 8, 12}
 	values := []string{"val10", "val20", "val5", "val15", "val25", "val30", "val8", "val12"}
 
 	for i, key := range keys {
-		index.Put(key, values[i])
+		must(index.Put(kv.IntKey(key), []byte(values[i])))
 		fmt.Printf("  插入 key=%d, value=%s\n", key, values[i])
 	}
+	must(index.CheckInvariants())
+	fmt.Printf("\n树的结构（高度 %d，键值都在叶子中，内部节点的键是左边子树的上界）：\n", index.Height())
+	for _, line := range strings.Split(index.String(), "\n") {
+		fmt.Printf("  %s\n", line)
+	}
 
 	// 查找数据
 	fmt.Println("\n查找数据：")
 	testKeys := []int{10, 15, 25, 100}
 	for _, key := range testKeys {
-		value, found := index.Get(key)
+		value, found, err := index.Get(kv.IntKey(key))
+		must(err)
 		if found {
 			fmt.Printf("  key=%d -> value=%s (O(log n)查找)\n", key, value)
 		} else {
@@ -188,13 +132,96 @@ func main() {
 	}
 
 	// 范围查询
-	fmt.Println("\n范围查询 [10, 25]：")
-	results := index.RangeScan(10, 25)
-	for _, value := range results {
+	fmt.Println("\n范围查询 [10, 26)：")
+	must(index.Scan(kv.IntKey(10), kv.IntKey(26), func(key, value []byte) bool {
+		fmt.Printf("  %s -> %s\n", kv.FormatKey(key), value)
+		return true
+	}))
+	fmt.Println("\n闭区间范围查询 RangeScan [10, 25]（只返回值，相当于 BETWEEN）：")
+	for _, value := range index.RangeScan(kv.IntKey(10), kv.IntKey(25)) {
 		fmt.Printf("  %s\n", value)
 	}
 	fmt.Println("  B-tree索引支持高效的范围查询！")
 
+	// 游标
+	fmt.Println("\n游标（沿叶子链表移动，不回到根节点）：")
+	c := index.Cursor()
+	fmt.Print("  Seek(11) 之后 Next:")
+	for ok := c.Seek(kv.IntKey(11)); ok; ok = c.Next() {
+		fmt.Printf(" %s", kv.FormatKey(c.Key()))
+	}
+	fmt.Print("\n  SeekBefore(26) 之后 Prev:")
+	for ok := c.SeekBefore(kv.IntKey(26)); ok; ok = c.Prev() {
+		fmt.Printf(" %s", kv.FormatKey(c.Key()))
+	}
+	fmt.Println()
+
+	fmt.Print("  边遍历边修改（读到 12 时删除 15、插入 13）:")
+	for ok := c.First(); ok; ok = c.Next() {
+		fmt.Printf(" %s", kv.FormatKey(c.Key()))
+		if kv.FormatKey(c.Key()) == "12" {
+			must(index.Delete(kv.IntKey(15)))
+			must(index.Put(kv.IntKey(13), []byte("val13")))
+		}
+	}
+	fmt.Println()
+	fmt.Println("  → 树被修改后，游标从根重新查找当前键再移动，不会跳过或重复键")
+
+	// 分页
+	fmt.Println("\n分页查询（每页 3 条，令牌只记录上一页的最后一个键）：")
+	for _, reverse := range []bool{false, true} {
+		opts := btreeindex.ScanOptions{Limit: 3, Reverse: reverse}
+		for pageNo := 1; ; pageNo++ {
+			page, err := index.ScanPage(opts)
+			must(err)
+			var ks []string
+			for _, k := range page.Keys {
+				ks = append(ks, kv.FormatKey(k))
+			}
+			fmt.Printf("  反向=%-5v 第 %d 页: %-12s 下一页令牌 %q\n", reverse, pageNo, strings.Join(ks, " "), page.NextPageToken)
+			if page.NextPageToken == "" {
+				break
+			}
+			opts.PageToken = page.NextPageToken
+		}
+	}
+	first, err := index.ScanPage(btreeindex.ScanOptions{Limit: 3})
+	must(err)
+	_, err = index.ScanPage(btreeindex.ScanOptions{Limit: 3, Reverse: true, PageToken: first.NextPageToken})
+	fmt.Printf("  把正向的令牌用于反向扫描: %v\n", err)
+
+	// 删除
+	for _, key := range []int{5, 8, 10, 12, 13, 20} {
+		must(index.Delete(kv.IntKey(key)))
+	}
+	must(index.CheckInvariants())
+	fmt.Printf("\n删除 5、8、10、12、13、20 之后（空节点被摘掉，高度 %d）：\n", index.Height())
+	for _, line := range strings.Split(index.String(), "\n") {
+		fmt.Printf("  %s\n", line)
+	}
+	fmt.Printf("  有序遍历: %q\n", index.InOrderTraversal())
+
+	// 大范围的流式遍历
+	big := btreeindex.NewBTreeIndex()
+	for i := 0; i < 100000; i++ {
+		must(big.Put(kv.IntKey(i), []byte(fmt.Sprintf("v%d", i))))
+	}
+	must(big.CheckInvariants())
+	fmt.Printf("\n插入 100000 个键（阶数 %d）：高度 %d\n", btreeindex.DefaultOrder, big.Height())
+	n, sum := 0, 0
+	must(big.ScanReverse(kv.IntKey(10000), kv.IntKey(90000), func(key, value []byte) bool {
+		k, _ := kv.KeyInt(key)
+		n, sum = n+1, sum+k
+		return true
+	}))
+	fmt.Printf("  反向流式遍历 [10000, 90000): %d 个键，键之和 %d，不需要把结果收集到切片中\n", n, sum)
+	var last []string
+	must(big.ScanReverse(nil, nil, func(key, value []byte) bool {
+		last = append(last, string(value))
+		return len(last) < 3
+	}))
+	fmt.Printf("  ORDER BY key DESC LIMIT 3: %v\n", last)
+
 	fmt.Println("\n=== B-tree索引权衡分析 ===")
 	fmt.Println("优势：")
 	fmt.Println("- 支持范围查询（WHERE key BETWEEN ? AND ?）")
@@ -211,4 +238,3 @@ func main() {
 	fmt.Println("- 大多数关系型数据库的默认索引（MySQL, PostgreSQL）")
 	fmt.Println("- 需要支持ORDER BY的查询")
 }
-
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/ddia-labs/pkg/btree"
+	"github.com/ddia-labs/pkg/fulltext"
+	"github.com/ddia-labs/pkg/kv"
+)
+
+const numGenerated = 5000
+
+// 手写的几篇文档，用来演示短语、停用词、中文和 BM25；其余文档由模板随机生成
+var handWritten = map[int]string{
+	1:  "Full-text search engines build an inverted index: every term maps to a posting list of documents.",
+	2:  "Lucene writes immutable segments and merges them in the background, much like an LSM tree.",
+	3:  "The quick brown fox jumps over the lazy dog.",
+	4:  "A quick fox is still a fox.",
+	5:  "倒排索引把每个词映射到包含它的文档列表，全文检索引擎都用它。",
+	6:  "索引让读更快，但每次写入都要更新索引。",
+	10: "Compaction merges segments.",
+	11: "Compaction merges segments. Meanwhile the replication log keeps growing on every follower, " +
+		"the leader keeps accepting writes, clients keep retrying their requests and nobody notices anything.",
+	12: "Compaction, compaction, compaction and more compaction merges segments.",
+}
+
+var (
+	subjects = []string{"LSM trees", "B-trees", "Hash indexes", "Write-ahead logs", "Leader replication",
+		"Consensus protocols", "Secondary indexes", "Column stores", "SSTables", "Bloom filters"}
+	verbs   = []string{"speed up", "slow down", "complicate", "simplify", "protect", "compress", "replicate", "partition"}
+	objects = []string{"random writes", "range queries", "point lookups", "crash recovery", "full-text search",
+		"log compaction", "read amplification", "write amplification"}
+	systems = []string{"PostgreSQL", "Cassandra", "LevelDB", "Elasticsearch", "Kafka", "MySQL"}
+)
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func generate(r *rand.Rand) string {
+	var b strings.Builder
+	for i, n := 0, 2+r.Intn(5); i < n; i++ {
+		fmt.Fprintf(&b, "%s %s %s in %s. ", subjects[r.Intn(len(subjects))], verbs[r.Intn(len(verbs))],
+			objects[r.Intn(len(objects))], systems[r.Intn(len(systems))])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// show 执行查询，打印匹配数、读取的倒排列表和字节数，以及前 top 个结果的原文（从 KV 中读取）
+func show(ix *fulltext.Index, store kv.KV, query string, top int) {
+	before := ix.Stats()
+	q, err := fulltext.Parse(fulltext.StandardAnalyzer(), query)
+	must(err)
+	hits, err := ix.Search(q, 0)
+	must(err)
+	after := ix.Stats()
+	parsed := "（空查询）"
+	if q != nil {
+		parsed = q.String()
+	}
+	fmt.Printf("  %s\n", query)
+	fmt.Printf("    解析为 %s，匹配 %d 篇，读倒排列表 %d 个 / %d 字节\n", parsed, len(hits),
+		after.PostingLists-before.PostingLists, after.BytesRead-before.BytesRead)
+	for _, h := range hits[:min(top, len(hits))] {
+		fmt.Printf("      %6.3f  #%-5d %s\n", h.Score, docID(h.ID), excerpt(store, h.ID))
+	}
+}
+
+func docID(id string) int {
+	n, err := kv.KeyInt([]byte(id))
+	must(err)
+	return n
+}
+
+func excerpt(store kv.KV, id string) string {
+	value, ok, err := store.Get([]byte(id))
+	must(err)
+	if !ok {
+		return "（KV 中已不存在）"
+	}
+	text := []rune(string(value))
+	if len(text) > 60 {
+		return string(text[:60]) + "..."
+	}
+	return string(text)
+}
+
+func printStats(label string, st fulltext.Stats) {
+	fmt.Printf("  %s: %d 个段（%d 字节），%d 篇文档，缓冲区 %d 篇，待清理的已删除文档 %d 篇，合并 %d 次\n",
+		label, st.Segments, st.DiskBytes, st.Docs, st.BufferedDocs, st.DeletedDocs, st.Merges)
+}
+
+func main() {
+	fmt.Println("=== 全文索引：倒排列表、短语查询与 BM25 ===")
+	fmt.Println()
+	fmt.Println("特点：")
+	fmt.Println("1. 分析器把文本切成词并规范化（小写、去停用词、词干提取），建索引和查询用同一个分析器")
+	fmt.Println("2. 每个词的倒排列表记录包含它的文档和它出现的位置，短语查询靠位置判断词是否相邻")
+	fmt.Println("3. 文档先进内存缓冲区，攒够一批写成不可变的段，段按大小分层合并，和 LSM 树一样")
+	fmt.Println()
+
+	// 1. 分析器
+	fmt.Println("1. 分析器的输出（词@位置）：")
+	a := fulltext.StandardAnalyzer()
+	for _, text := range []string{
+		"The Indexes are INDEXED by running indexers",
+		"Stored, storing and stores",
+		"倒排索引 (inverted index)",
+	} {
+		var terms []string
+		for _, t := range a.Analyze(text) {
+			terms = append(terms, fmt.Sprintf("%s@%d", t.Term, t.Pos))
+		}
+		fmt.Printf("  %-45q → %s\n", text, strings.Join(terms, " "))
+	}
+	fmt.Println("  → 停用词 the/are/by 被去掉但留下位置空洞；中文每个汉字是一个词，词组用短语查询匹配")
+	fmt.Println()
+
+	// 2. 文档保存在 KV 中，全文索引扫描 KV 建立
+	store := btree.New(64)
+	for id, text := range handWritten {
+		must(store.Put(kv.IntKey(id), []byte(text)))
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < numGenerated; i++ {
+		must(store.Put(kv.IntKey(100+i), []byte(generate(r))))
+	}
+	dir, err := os.MkdirTemp("", "fulltext")
+	must(err)
+	defer os.RemoveAll(dir)
+	opts := fulltext.Options{BufferDocs: 500, MergeFactor: 4}
+	ix, err := fulltext.Open(dir, opts)
+	must(err)
+	must(ix.IndexKV(store, nil, nil, func(key, value []byte) string { return string(value) }))
+	fmt.Printf("2. 从 KV 中索引 %d 篇文档（缓冲区 %d 篇，每层 %d 个段合并一次）：\n", len(handWritten)+numGenerated,
+		opts.BufferDocs, opts.MergeFactor)
+	printStats("索引后", ix.Stats())
+	fmt.Println("  → 每 500 篇写一个段，4 个 500 篇的段合并成一个 2000 篇的段；查询要在每个段和缓冲区中各查一遍")
+	fmt.Println()
+
+	// 3. 布尔查询
+	fmt.Println("3. 布尔查询：")
+	show(ix, store, "leveldb compaction", 2)
+	show(ix, store, "leveldb OR cassandra", 0)
+	show(ix, store, "(leveldb OR cassandra) -compaction", 0)
+	show(ix, store, "bloom filters AND range queries NOT mysql", 2)
+	fmt.Println()
+
+	// 4. 短语查询
+	fmt.Println("4. 短语查询：")
+	show(ix, store, "quick fox", 3)
+	show(ix, store, `"quick fox"`, 3)
+	show(ix, store, `"over a lazy dog"`, 3)
+	show(ix, store, `"write amplification" leveldb`, 2)
+	show(ix, store, "full-text", 2)
+	show(ix, store, "倒排索引", 3)
+	show(ix, store, "索引", 3)
+	fmt.Println("  → 短语中的停用词 a 与文档中的 the 都只留下位置空洞，所以 \"over a lazy dog\" 匹配 \"over the lazy dog\"；")
+	fmt.Println("    full-text 和 倒排索引 分析后是多个词，自动按短语查询")
+	fmt.Println()
+
+	// 5. BM25
+	fmt.Println("5. BM25 排序（idf 越大的词越重要，词频的作用逐渐饱和，长文档被惩罚）：")
+	show(ix, store, "compaction merges segments", 3)
+	show(ix, store, "lucene OR compaction", 3)
+	fmt.Println("  → #12 中 compaction 出现 4 次，但词频的作用会饱和，它又比 #10 长，所以仍排在最短的 #10 后面；")
+	fmt.Println("    #11 的词频与 #10 相同但长得多，排在最后。lucene 只出现在一篇文档中，idf 远大于常见的 compaction")
+	fmt.Println()
+
+	// 6. 删除、更新、持久化与合并
+	fmt.Println("6. 删除、更新与合并：")
+	must(store.Delete(kv.IntKey(4)))
+	ix.Delete(string(kv.IntKey(4)))
+	must(store.Put(kv.IntKey(3), []byte("The quick red fox outran the lazy hound.")))
+	must(ix.Add(string(kv.IntKey(3)), "The quick red fox outran the lazy hound."))
+	for i := 0; i < 1000; i++ {
+		must(store.Delete(kv.IntKey(100 + i)))
+		ix.Delete(string(kv.IntKey(100 + i)))
+	}
+	must(ix.Flush())
+	printStats("删除 #4 和 1000 篇生成的文档、更新 #3 并刷盘后", ix.Stats())
+	show(ix, store, "fox", 3)
+	must(ix.Close())
+	ix, err = fulltext.Open(dir, opts)
+	must(err)
+	printStats("关闭后重新打开", ix.Stats())
+	show(ix, store, "compaction", 1)
+	must(ix.Merge())
+	printStats("Merge 之后", ix.Stats())
+	show(ix, store, "compaction", 1)
+	fmt.Println("  → 删除只在 MANIFEST 中记下段内的文档号，段文件不变；BM25 的文档频率和文档总数 N 都仍然算上已删除的文档")
+	fmt.Println("    （Lucene 的 maxDoc），合并重写段时才真正丢掉它们，所以合并前后同一篇文档的得分不同")
+	must(ix.Close())
+}
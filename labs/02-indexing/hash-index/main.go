@@ -1,98 +1,89 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-)
-
-// 哈希索引实现（简化版）
-type HashIndex struct {
-	buckets []*Bucket
-	size    int
-}
+	"os"
+	"time"
 
-type Bucket struct {
-	entries []Entry
-}
+	"github.com/ddia-labs/pkg/hashindex"
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/kvcheck"
+)
 
-type Entry struct {
-	Key   int
-	Value string
+// hashFuncs 是演示中对比的哈希函数
+var hashFuncs = []struct {
+	name string
+	fn   hashindex.HashFunc
+}{
+	{"fnv1a", hashindex.FNV1a},
+	{"maphash", hashindex.NewMaphash()},
+	{"djb2", hashindex.DJB2},
+	{"sum", hashindex.SumHash},
 }
 
-func NewHashIndex(size int) *HashIndex {
-	buckets := make([]*Bucket, size)
-	for i := range buckets {
-		buckets[i] = &Bucket{entries: make([]Entry, 0)}
-	}
-	return &HashIndex{
-		buckets: buckets,
-		size:    size,
+func must(err error) {
+	if err != nil {
+		panic(err)
 	}
 }
 
-// 哈希函数（简单取模）
-func (hi *HashIndex) hash(key int) int {
-	return key % hi.size
-}
-
-// 插入
-func (hi *HashIndex) Put(key int, value string) {
-	bucketIndex := hi.hash(key)
-	bucket := hi.buckets[bucketIndex]
-
-	// 检查key是否已存在
-	for i, entry := range bucket.entries {
-		if entry.Key == key {
-			// 更新现有值
-			bucket.entries[i].Value = value
-			return
-		}
+// runCheck 用 pkg/kvcheck 做基于模型的随机测试：随机的 Put/Get/Delete/Scan 序列与 map 模型比较，
+// 每一步之后校验 CheckInvariants。初始只有 1~2 个桶，24 个键的序列会反复触发扩容、缩容和渐进式 rehash
+func runCheck(seed int64, seeds int) {
+	targets := []struct {
+		name string
+		open func() kv.KV
+	}{
+		{"chained", func() kv.KV { return hashindex.NewHashIndexWithOptions(hashindex.Options{InitialBuckets: 1}) }},
+		{"chained/stop-the-world", func() kv.KV { return hashindex.NewHashIndexWithOptions(hashindex.Options{InitialBuckets: 2, RehashStep: -1}) }},
+		{"chained/sum-hash", func() kv.KV { return hashindex.NewHashIndexWithOptions(hashindex.Options{InitialBuckets: 1, Hash: hashindex.SumHash}) }},
+		{"chained/no-resize", func() kv.KV {
+			return hashindex.NewHashIndexWithOptions(hashindex.Options{InitialBuckets: 4, MaxLoadFactor: 1e9, MinLoadFactor: -1})
+		}},
+		{"robin-hood", func() kv.KV { return hashindex.NewOpenHashIndex(hashindex.Options{InitialBuckets: 2}, true) }},
+		{"robin-hood/djb2", func() kv.KV {
+			return hashindex.NewOpenHashIndex(hashindex.Options{InitialBuckets: 2, MaxLoadFactor: 0.95, Hash: hashindex.DJB2}, true)
+		}},
+		{"linear-probing", func() kv.KV { return hashindex.NewOpenHashIndex(hashindex.Options{InitialBuckets: 2}, false) }},
+		{"linear-probing/sum-hash", func() kv.KV {
+			return hashindex.NewOpenHashIndex(hashindex.Options{InitialBuckets: 2, MaxLoadFactor: 0.95, Hash: hashindex.SumHash}, false)
+		}},
 	}
-
-	// 添加新条目
-	bucket.entries = append(bucket.entries, Entry{Key: key, Value: value})
-}
-
-// 查找
-func (hi *HashIndex) Get(key int) (string, bool) {
-	bucketIndex := hi.hash(key)
-	bucket := hi.buckets[bucketIndex]
-
-	// 在bucket中线性查找（处理哈希冲突）
-	for _, entry := range bucket.entries {
-		if entry.Key == key {
-			return entry.Value, true
+	failed := false
+	for _, t := range targets {
+		open := t.open
+		failure := kvcheck.CheckSeeds(kvcheck.Config{}, func() (kv.KV, error) { return open(), nil }, seed, seeds)
+		if failure != nil {
+			failed = true
+			fmt.Printf("FAIL %s %s", t.name, failure)
+			continue
 		}
+		fmt.Printf("PASS %-24s seeds=%d\n", t.name, seeds)
 	}
-
-	return "", false
-}
-
-// 删除
-func (hi *HashIndex) Delete(key int) bool {
-	bucketIndex := hi.hash(key)
-	bucket := hi.buckets[bucketIndex]
-
-	for i, entry := range bucket.entries {
-		if entry.Key == key {
-			// 删除条目
-			bucket.entries = append(bucket.entries[:i], bucket.entries[i+1:]...)
-			return true
-		}
+	if failed {
+		os.Exit(1)
 	}
-
-	return false
 }
 
 func main() {
+	check := flag.Bool("check", false, "执行基于模型的随机测试，而不是演示")
+	seed := flag.Int64("seed", 1, "随机测试的第一个种子")
+	seeds := flag.Int("seeds", 200, "随机测试的序列数")
+	flag.Parse()
+	if *check {
+		runCheck(*seed, *seeds)
+		return
+	}
+
 	fmt.Println("=== 哈希索引演示 ===\n")
 	fmt.Println("哈希索引特点：")
 	fmt.Println("1. O(1)平均时间复杂度查找")
-	fmt.Println("2. 不支持范围查询")
+	fmt.Println("2. 不支持高效的范围查询")
 	fmt.Println("3. 数据无序存储")
 	fmt.Println("4. 需要处理哈希冲突\n")
 
-	index := NewHashIndex(10)
+	index := hashindex.NewHashIndex(10)
 
 	// 插入数据
 	fmt.Println("插入数据：")
@@ -100,15 +91,16 @@ func main() {
 	values := []string{"val10", "val20", "val5", "val15", "val25", "val30", "val8", "val12"}
 
 	for i, key := range keys {
-		index.Put(key, values[i])
-		fmt.Printf("  插入 key=%d, value=%s (hash=%d)\n", key, values[i], index.hash(key))
+		must(index.Put(kv.IntKey(key), []byte(values[i])))
+		fmt.Printf("  插入 key=%d, value=%s (hash=%d)\n", key, values[i], index.BucketIndex(kv.IntKey(key)))
 	}
 
 	// 查找数据
 	fmt.Println("\n查找数据：")
 	testKeys := []int{10, 15, 25, 100}
 	for _, key := range testKeys {
-		value, found := index.Get(key)
+		value, found, err := index.Get(kv.IntKey(key))
+		must(err)
 		if found {
 			fmt.Printf("  key=%d -> value=%s (查找次数: 1次哈希计算 + 线性查找)\n", key, value)
 		} else {
@@ -116,10 +108,78 @@ func main() {
 		}
 	}
 
-	// 尝试范围查询（不支持）
-	fmt.Println("\n范围查询 [10, 25]：")
-	fmt.Println("  哈希索引不支持范围查询！")
-	fmt.Println("  需要扫描所有bucket，效率低下")
+	// 范围查询：没有顺序可用，只能全表扫描后排序
+	fmt.Println("\n范围查询 [10, 26)：")
+	fmt.Print(" ")
+	must(index.Scan(kv.IntKey(10), kv.IntKey(26), func(key, value []byte) bool {
+		fmt.Printf(" %s=%s", kv.FormatKey(key), value)
+		return true
+	}))
+	fmt.Println()
+	fmt.Println("  哈希索引无法按顺序定位范围的起点！")
+	fmt.Println("  需要扫描所有bucket再排序，效率低下")
+
+	// 自动扩容：负载因子超过 1 时桶数翻倍。一次性搬完所有条目会让触发扩容的那次写入停顿很久，
+	// 渐进式 rehash 把搬迁分摊到之后的每次操作上
+	const n = 200000
+	fmt.Printf("\n自动扩容（初始 4 个桶，插入 %d 个键，再删除其中 95%%）：\n", n)
+	for _, mode := range []struct {
+		name string
+		step int
+	}{{"渐进式 rehash", 1}, {"一次性 rehash", -1}} {
+		idx := hashindex.NewHashIndexWithOptions(hashindex.Options{InitialBuckets: 4, RehashStep: mode.step})
+		var worst time.Duration
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			t := time.Now()
+			must(idx.Put(kv.IntKey(i), []byte("v")))
+			worst = max(worst, time.Since(t))
+		}
+		fmt.Printf("  %s：耗时 %v，单次 Put 最长 %v\n", mode.name, time.Since(start).Round(time.Millisecond), worst.Round(time.Microsecond))
+		fmt.Printf("    %s\n", idx.Stats())
+		for i := 0; i < n*95/100; i++ {
+			must(idx.Delete(kv.IntKey(i)))
+		}
+		fmt.Printf("    删除后：%s\n", idx.Stats())
+	}
+
+	// 哈希函数对比：好的哈希函数让链长接近负载因子，差的哈希函数让大量键挤在少数桶里
+	fmt.Println("\n哈希函数对比（10000 个整数键 + 10000 个 \"user:N\" 字符串键）：")
+	fmt.Println("  函数        平均探测  最大探测   插入+查找")
+	for _, hf := range hashFuncs {
+		idx := hashindex.NewHashIndexWithOptions(hashindex.Options{Hash: hf.fn})
+		start := time.Now()
+		for i := 0; i < 10000; i++ {
+			must(idx.Put(kv.IntKey(i), []byte("v")))
+			must(idx.Put([]byte(fmt.Sprintf("user:%d", i)), []byte("v")))
+		}
+		for i := 0; i < 10000; i++ {
+			_, _, err := idx.Get(kv.IntKey(i))
+			must(err)
+		}
+		s := idx.Stats()
+		fmt.Printf("  %-10s %8.2f %9d %11v\n", hf.name, s.AvgProbe, s.MaxProbe, time.Since(start).Round(time.Microsecond))
+	}
+	fmt.Println("  sum 只把字节相加，取值范围很小，大量键落在同一个桶中，查找退化成线性扫描")
+
+	// 开放寻址：负载因子越高，探测越长；Robin Hood 让所有条目的探测距离接近平均值
+	fmt.Println("\n开放寻址（16384 个槽，不扩容）：")
+	fmt.Println("  负载因子  探测方式      平均探测  最大探测")
+	for _, load := range []float64{0.5, 0.75, 0.9} {
+		for _, robinHood := range []bool{false, true} {
+			idx := hashindex.NewOpenHashIndex(hashindex.Options{InitialBuckets: 16384, MaxLoadFactor: 0.95}, robinHood)
+			for i := 0; i < int(load*16384); i++ {
+				must(idx.Put(kv.IntKey(i*7919), []byte("v")))
+			}
+			name := "线性探测    "
+			if robinHood {
+				name = "Robin Hood  "
+			}
+			s := idx.Stats()
+			fmt.Printf("  %-9.2f %s %9.2f %9d\n", s.LoadFactor, name, s.AvgProbe, s.MaxProbe)
+		}
+	}
+	fmt.Println("  平均探测相同，但 Robin Hood 的最大探测短得多；查找不存在的键时还能提前停止")
 
 	fmt.Println("\n=== 哈希索引权衡分析 ===")
 	fmt.Println("优势：")
@@ -130,10 +190,9 @@ func main() {
 	fmt.Println("- 不支持范围查询")
 	fmt.Println("- 数据无序，无法有序遍历")
 	fmt.Println("- 需要处理哈希冲突")
-	fmt.Println("- 哈希表大小固定或需要动态扩容")
+	fmt.Println("- 扩容需要搬动所有条目（渐进式 rehash 可以把停顿分摊开）")
 	fmt.Println("\n适用场景：")
 	fmt.Println("- 等值查询（WHERE key = ?）")
 	fmt.Println("- 不需要范围查询的场景")
 	fmt.Println("- 内存数据库的索引（如Redis）")
 }
-
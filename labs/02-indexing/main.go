@@ -19,9 +19,39 @@ func main() {
 		},
 		{
 			name:        "B-tree索引",
-			description: "演示B-tree索引的范围查询和有序遍历",
+			description: "演示B-tree索引的节点分裂、范围查询，以及游标的正向/反向遍历和分页",
 			path:        "btree-index",
 		},
+		{
+			name:        "磁盘可扩展哈希",
+			description: "演示放在页文件中的哈希索引：桶独立分裂、目录翻倍，以及与磁盘B+tree的读页数对比",
+			path:        "disk-hash",
+		},
+		{
+			name:        "二级索引",
+			description: "演示复合键的保持顺序编码、最左前缀查询和覆盖索引",
+			path:        "secondary-index",
+		},
+		{
+			name:        "全文索引",
+			description: "演示分析器、带位置的倒排列表、布尔和短语查询、BM25 排序以及段的刷盘与合并",
+			path:        "fulltext",
+		},
+		{
+			name:        "空间索引",
+			description: "演示R-tree（R*与二次分裂）的矩形查询和k近邻，以及用Z序编码让B-tree近似回答矩形查询",
+			path:        "spatial",
+		},
+		{
+			name:        "有序run索引",
+			description: "演示LSM风格的索引：写入进内存缓冲区，冻结成不可变的有序run并按大小合并，布隆过滤器跳过run",
+			path:        "sorted-run",
+		},
+		{
+			name:        "索引对比",
+			description: "对哈希、B-tree和有序run索引执行相同的负载，比较查找代价、范围扫描和内存占用",
+			path:        "compare",
+		},
 	}
 
 	fmt.Println("可用的索引结构演示：\n")
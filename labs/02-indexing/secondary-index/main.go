@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/ddia-labs/pkg/btree"
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/secindex"
+)
+
+const numRows = 20000
+
+var (
+	lastNames  = []string{"Wang", "Li", "Zhang", "Liu", "Chen", "Yang", "Huang", "Zhao", "Wu", "Zhou", "Xu", "Sun", "Ma", "Zhu", "Hu"}
+	firstNames = []string{"Wei", "Fang", "Na", "Min", "Jing", "Lei", "Jun", "Yang", "Yong", "Yan", "Jie", "Tao", "Ming", "Chao", "Xiu",
+		"Xia", "Ping", "Gang", "Hui", "Bo", "Lin", "Qiang", "Hong", "Li", "Hua", "Dan", "Peng", "Hao", "Kai", "Xin"}
+	cities = []string{"Beijing", "Shanghai", "Guangzhou", "Shenzhen", "Hangzhou", "Chengdu", "Wuhan", "Xian"}
+)
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// run 执行查询并打印计划、结果行数、读取的索引项和回表读取的行数
+func run(t *secindex.Table, label string, q secindex.Query, show int) {
+	before := t.Stats()
+	var rows []secindex.Row
+	plan, err := t.Find(q, func(row secindex.Row) bool {
+		rows = append(rows, row)
+		return true
+	})
+	must(err)
+	after := t.Stats()
+	fmt.Printf("  %s\n", label)
+	fmt.Printf("    计划: %s\n", plan)
+	fmt.Printf("    结果 %d 行，读索引项 %d，读主存储 %d 行\n",
+		len(rows), after.IndexEntries-before.IndexEntries, after.TableRows-before.TableRows)
+	for _, row := range rows[:min(show, len(rows))] {
+		fmt.Printf("      %v\n", row)
+	}
+}
+
+func main() {
+	fmt.Println("=== 二级索引：复合键与覆盖索引 ===")
+	fmt.Println()
+	fmt.Println("特点：")
+	fmt.Println("1. 主存储按主键保存整行，二级索引的键是索引列的复合编码再接上主键")
+	fmt.Println("2. 编码保持顺序：复合键按 (列1, 列2, ...) 排序，只有最左前缀上的条件能变成一次范围扫描")
+	fmt.Println("3. 覆盖索引在索引项中额外存放几列的值，查询只需要这些列时不用回表")
+	fmt.Println()
+
+	// 主存储和两个索引都用 pkg/btree，任何实现了 kv.KV 的有序引擎都可以
+	table, err := secindex.NewTable(secindex.Schema{
+		Columns: []secindex.Column{
+			{Name: "id", Type: secindex.Int},
+			{Name: "last_name", Type: secindex.String},
+			{Name: "first_name", Type: secindex.String},
+			{Name: "city", Type: secindex.String},
+			{Name: "age", Type: secindex.Int},
+		},
+		Key: []string{"id"},
+	}, btree.New(64))
+	must(err)
+
+	r := rand.New(rand.NewSource(1))
+	for id := 1; id <= numRows; id++ {
+		must(table.Insert(secindex.Row{
+			"id":         id,
+			"last_name":  lastNames[r.Intn(len(lastNames))],
+			"first_name": firstNames[r.Intn(len(firstNames))],
+			"city":       cities[r.Intn(len(cities))],
+			"age":        18 + r.Intn(60),
+		}))
+	}
+	// 先写入数据再建索引：AddIndex 会扫描主存储补建索引项
+	byName, err := table.AddIndex(secindex.IndexDef{
+		Name: "by_name", Columns: []string{"last_name", "first_name"}, Include: []string{"age"},
+	}, btree.New(64))
+	must(err)
+	byCity, err := table.AddIndex(secindex.IndexDef{Name: "by_city", Columns: []string{"city"}}, btree.New(64))
+	must(err)
+	must(table.CheckInvariants())
+	fmt.Printf("表 people: %d 行，索引 %s 和 %s\n", numRows, byName, byCity)
+	fmt.Println()
+
+	// 1. 复合键的编码
+	fmt.Println("1. 复合键的编码（字符串以 00 01 结尾，整数是符号位取反的大端序）：")
+	for _, e := range []struct {
+		last, first string
+		id          int64
+	}{{"Li", "Na", 42}, {"Li", "Na", 7}, {"Li", "Nan", 3}, {"Lin", "A", 1}} {
+		key := kv.AppendString(nil, e.last)
+		key = kv.AppendString(key, e.first)
+		key = kv.AppendInt64(key, e.id)
+		fmt.Printf("  (%q, %q, id=%d) → %x\n", e.last, e.first, e.id, key)
+	}
+	fmt.Println("  → 按字节比较的顺序就是 (last_name, first_name, id) 的顺序：\"Li\" 的所有项都排在 \"Lin\" 前面")
+	fmt.Println()
+
+	// 2. 最左前缀
+	fmt.Println("2. 最左前缀：")
+	run(table, "WHERE last_name = 'Wang'", secindex.Query{Where: secindex.Row{"last_name": "Wang"}}, 0)
+	run(table, "WHERE last_name = 'Wang' AND first_name = 'Wei'",
+		secindex.Query{Where: secindex.Row{"last_name": "Wang", "first_name": "Wei"}}, 3)
+	run(table, "WHERE first_name = 'Wei'（跳过了第一列）", secindex.Query{Where: secindex.Row{"first_name": "Wei"}}, 0)
+	run(table, "WHERE first_name = 'Wei'，强制使用 by_name",
+		secindex.Query{Where: secindex.Row{"first_name": "Wei"}, Index: "by_name"}, 0)
+	run(table, "WHERE last_name = 'Wang' AND first_name >= 'A' AND first_name < 'F'",
+		secindex.Query{Where: secindex.Row{"last_name": "Wang"}, Range: "first_name", From: "A", To: "F"}, 3)
+	fmt.Println("  → 索引只按 last_name 排序的前提下才按 first_name 排序；单独查 first_name 要么全表扫描，")
+	fmt.Println("    要么扫描整个索引逐项过滤")
+	fmt.Println()
+
+	// 3. 覆盖索引
+	fmt.Println("3. 覆盖索引：")
+	run(table, "SELECT first_name, age WHERE last_name = 'Zhou'",
+		secindex.Query{Where: secindex.Row{"last_name": "Zhou"}, Columns: []string{"first_name", "age"}}, 0)
+	run(table, "SELECT first_name, city WHERE last_name = 'Zhou'",
+		secindex.Query{Where: secindex.Row{"last_name": "Zhou"}, Columns: []string{"first_name", "city"}}, 0)
+	run(table, "SELECT id WHERE last_name = 'Zhou' AND age >= 70（age 和 id 都在索引项中）",
+		secindex.Query{Where: secindex.Row{"last_name": "Zhou"}, Range: "age", From: 70, Columns: []string{"id"}}, 0)
+	run(table, "SELECT * WHERE city = 'Xian' AND age >= 70（age 不在 by_city 中，每一项都要回表）",
+		secindex.Query{Where: secindex.Row{"city": "Xian"}, Range: "age", From: 70}, 0)
+	fmt.Println("  → 覆盖列让索引多占空间、写入多一些字节，换来查询不用再到主存储中按主键逐行查找")
+	fmt.Println()
+
+	// 4. 更新和删除同时维护所有索引
+	fmt.Println("4. 更新和删除：")
+	row, ok, err := table.Get(1)
+	must(err)
+	if !ok {
+		panic("row 1 missing")
+	}
+	fmt.Printf("  id=1: %v\n", row)
+	oldCity := row["city"]
+	row["city"] = "Lhasa"
+	must(table.Insert(row))
+	run(table, "WHERE city = 'Lhasa'（id=1 搬家后）", secindex.Query{Where: secindex.Row{"city": "Lhasa"}}, 1)
+	must(table.Delete(1))
+	run(table, "WHERE city = 'Lhasa'（删除 id=1 后）", secindex.Query{Where: secindex.Row{"city": "Lhasa"}}, 1)
+	must(table.CheckInvariants())
+	fmt.Printf("  → 覆盖写入先删掉旧行在 %s 下的索引项再写新项；校验通过：每行在每个索引中恰好一项\n", oldCity)
+}
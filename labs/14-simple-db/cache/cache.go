@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// entryOverhead 粗略估计每个缓存项在 map、链表节点上的额外内存开销
+const entryOverhead = 64
+
+// Cache 是一个按字节数限制容量的 LRU 值缓存，放在磁盘读取之前。
+//
+// 每个缓存项都记录了值所在的日志偏移量，读取时只有偏移量与索引中的
+// 最新偏移量一致才算命中。这样即使并发的 GET 在 SET 之后才把旧值填回缓存，
+// 也不会读到过期数据；SET/DEL 时的 Remove 只是为了尽早释放内存。
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List // 队头是最近使用的项
+	items    map[string]*list.Element
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+type entry struct {
+	key    string
+	offset int64
+	value  string
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)+len(e.value)) + entryOverhead
+}
+
+// Stats 是缓存的统计快照
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int
+	Bytes     int64
+	MaxBytes  int64
+}
+
+// HitRatio 返回命中率
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// New 创建一个最多占用 maxBytes 字节的缓存
+func New(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get 查找 key 在 offset 处的值
+func (c *Cache) Get(key string, offset int64) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		if e.offset == offset {
+			c.ll.MoveToFront(el)
+			c.hits++
+			return e.value, true
+		}
+	}
+	c.misses++
+	return "", false
+}
+
+// Put 缓存 key 在 offset 处的值，必要时淘汰最久未使用的项
+func (c *Cache) Put(key string, offset int64, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &entry{key: key, offset: offset, value: value}
+	if e.size() > c.maxBytes {
+		// 单个值就超出预算，不缓存
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.bytes -= el.Value.(*entry).size()
+		el.Value = e
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(e)
+	}
+	c.bytes += e.size()
+
+	for c.bytes > c.maxBytes {
+		oldest := c.ll.Back()
+		c.removeElement(oldest)
+		c.evictions++
+	}
+}
+
+// Remove 使 key 的缓存失效
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Purge 清空缓存（例如日志压缩导致所有偏移量变化之后）
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+func (c *Cache) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.bytes -= e.size()
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.ll.Len(),
+		Bytes:     c.bytes,
+		MaxBytes:  c.maxBytes,
+	}
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestEvictsByBytes 检查容量按字节计算：大值挤掉多个小值，淘汰顺序是最久未使用
+func TestEvictsByBytes(t *testing.T) {
+	small := int64(len("a")+len("x")) + entryOverhead
+	c := New(4 * small)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		c.Put(k, 0, "x")
+	}
+	if s := c.Stats(); s.Entries != 4 || s.Bytes != 4*small {
+		t.Fatalf("after 4 puts: %+v", s)
+	}
+
+	// 访问 a 让它成为最近使用的项，b 变成最久未使用
+	if _, ok := c.Get("a", 0); !ok {
+		t.Fatal("a missed")
+	}
+	// 一个占两项空间的值淘汰 b 和 c
+	big := strings.Repeat("v", int(small)+1)
+	c.Put("e", 0, big)
+	for k, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true, "e": true} {
+		if _, ok := c.Get(k, 0); ok != want {
+			t.Errorf("Get(%s) hit = %v, want %v", k, ok, want)
+		}
+	}
+	s := c.Stats()
+	if s.Evictions != 2 || s.Bytes > s.MaxBytes {
+		t.Fatalf("after the big put: %+v", s)
+	}
+
+	// 单个值超出整个预算时不缓存，也不淘汰任何项
+	c.Put("huge", 0, strings.Repeat("v", int(4*small)))
+	if _, ok := c.Get("huge", 0); ok {
+		t.Error("a value larger than the budget was cached")
+	}
+	if got := c.Stats(); got.Entries != s.Entries || got.Evictions != s.Evictions {
+		t.Errorf("oversized put changed the cache: %+v -> %+v", s, got)
+	}
+}
+
+// TestReplaceAdjustsBytes 检查覆盖同一个 key 时按新值重新计算占用
+func TestReplaceAdjustsBytes(t *testing.T) {
+	c := New(1 << 20)
+	c.Put("k", 0, strings.Repeat("v", 100))
+	c.Put("k", 9, "v")
+	if s := c.Stats(); s.Entries != 1 || s.Bytes != int64(len("k")+len("v"))+entryOverhead {
+		t.Fatalf("after replacing: %+v", s)
+	}
+}
+
+// TestStaleOffsetMisses 检查只有偏移量与查询的偏移量一致才命中：
+// 索引已经指向新偏移量时，慢一步填回的旧值不会被读到
+func TestStaleOffsetMisses(t *testing.T) {
+	c := New(1 << 20)
+	c.Put("k", 100, "old")
+	if _, ok := c.Get("k", 200); ok {
+		t.Fatal("value cached at offset 100 hit a lookup for offset 200")
+	}
+	if v, ok := c.Get("k", 100); !ok || v != "old" {
+		t.Fatalf("Get(k, 100) = %q, %v", v, ok)
+	}
+	c.Put("k", 200, "new")
+	if _, ok := c.Get("k", 100); ok {
+		t.Error("old offset still hits after the key was re-cached at a new offset")
+	}
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 2 {
+		t.Errorf("hits=%d misses=%d, want 1 and 2", s.Hits, s.Misses)
+	}
+}
+
+func TestRemoveAndPurge(t *testing.T) {
+	c := New(1 << 20)
+	c.Put("a", 0, "1")
+	c.Put("b", 0, "2")
+	c.Remove("a")
+	c.Remove("missing")
+	if _, ok := c.Get("a", 0); ok {
+		t.Error("a hit after Remove")
+	}
+	c.Purge()
+	if s := c.Stats(); s.Entries != 0 || s.Bytes != 0 {
+		t.Fatalf("after Purge: %+v", s)
+	}
+	if _, ok := c.Get("b", 0); ok {
+		t.Error("b hit after Purge")
+	}
+	// 清空之后仍可正常使用
+	c.Put("c", 0, "3")
+	if v, ok := c.Get("c", 0); !ok || v != "3" {
+		t.Errorf("Get(c) after Purge = %q, %v", v, ok)
+	}
+}
@@ -0,0 +1,335 @@
+// crashtest 是 SimpleDB 的崩溃一致性测试驱动：
+// 在 faultfs 上打开引擎，通过 query.Engine 执行随机负载，在随机时刻“崩溃”，
+// 重新打开并恢复索引，然后检查
+//  1. 持久化模式下，每个已确认的写入在恢复后都可见（崩溃时正在执行的那条指令可以生效也可以不生效，
+//     但 MSET 必须整体生效或整体不生效，单分片时多 key 的 DEL 也是如此）；
+//  2. 任何模式下都不会读到损坏的数据或从未写入过的值；
+//  3. 负载中穿插加入新分片，崩溃打断的再平衡在恢复后完成，每个 key 只留在一个分片上。
+//
+// 用法：go run ./crashtest -seed 1 -rounds 200
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ddia-labs/labs/14-simple-db/faultfs"
+	"github.com/ddia-labs/labs/14-simple-db/index"
+	"github.com/ddia-labs/labs/14-simple-db/query"
+	"github.com/ddia-labs/labs/14-simple-db/storage"
+	"github.com/ddia-labs/labs/14-simple-db/transaction"
+)
+
+const (
+	nilValue     = "(nil)"
+	manifestPath = "shards.manifest"
+	maxShards    = 4
+)
+
+// op 是一条指令以及它成功后对各个 key 的影响（值为 nilValue 表示删除）
+type op struct {
+	cmd    string
+	effect map[string]string
+}
+
+type harness struct {
+	rng     *rand.Rand
+	fs      *faultfs.FS
+	shards  int
+	durable bool
+	keys    []string
+
+	engine *query.Engine
+	// acked 是所有已确认指令生效后的状态
+	acked map[string]string
+	// history 记录每个 key 曾经被写入过的所有值
+	history map[string]map[string]bool
+	seq     int
+
+	crashes   int
+	truncated int64
+	initial   int // 开始时的分片数，之后可能因为加入分片而增多
+}
+
+func newHarness(seed int64) *harness {
+	rng := rand.New(rand.NewSource(seed))
+	h := &harness{
+		rng: rng,
+		fs: faultfs.New(seed, faultfs.Options{
+			TornWrites:  true,
+			CorruptTail: rng.Intn(2) == 0,
+		}),
+		initial: 1 + rng.Intn(3),
+		durable: rng.Intn(3) != 0,
+		acked:   make(map[string]string),
+		history: make(map[string]map[string]bool),
+	}
+	// 16 个 key 用哈希标签分成 4 组，同一组的 key 在同一个分片上，MSET 只在组内选 key
+	h.shards = h.initial
+	for i := 0; i < 16; i++ {
+		h.keys = append(h.keys, fmt.Sprintf("{g%d}k%d", i%4, i))
+	}
+	return h
+}
+
+// open 在（崩溃后的）文件系统上按清单记录的分片数重新打开所有分片并恢复索引
+func (h *harness) open() error {
+	n, ok, err := query.LoadManifest(h.fs, manifestPath)
+	if err != nil {
+		return err
+	}
+	if ok {
+		h.shards = n
+	}
+	shards := make([]*query.Shard, h.shards)
+	for i := range shards {
+		if shards[i], err = h.openShard(i); err != nil {
+			return err
+		}
+	}
+	h.engine = query.NewShardedEngine(shards...)
+	if err := h.engine.UseManifest(h.fs, manifestPath); err != nil {
+		return err
+	}
+	return h.engine.Recover()
+}
+
+func (h *harness) openShard(i int) (*query.Shard, error) {
+	s, err := storage.OpenDiskStorage(h.fs, fmt.Sprintf("shard-%d.db", i))
+	if err != nil {
+		return nil, err
+	}
+	s.SetSyncWrites(h.durable)
+	h.truncated += s.Stats().Truncated
+	return query.NewShard(s, index.NewIndex(), transaction.NewLockManager()), nil
+}
+
+// addShard 加入一个新分片并再平衡
+func (h *harness) addShard() error {
+	sh, err := h.openShard(h.shards)
+	if err != nil {
+		return err
+	}
+	if _, err := h.engine.AddShard(sh); err != nil {
+		return err
+	}
+	h.shards++
+	return nil
+}
+
+func (h *harness) randomKeys(n int) []string {
+	perm := h.rng.Perm(len(h.keys))
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = h.keys[perm[i]]
+	}
+	return keys
+}
+
+func (h *harness) value() string {
+	h.seq++
+	return fmt.Sprintf("v%d", h.seq)
+}
+
+// randomOp 生成一条随机的写指令
+func (h *harness) randomOp() op {
+	o := op{effect: make(map[string]string)}
+	if h.shards < maxShards && h.rng.Intn(100) == 0 {
+		o.cmd = "ADDSHARD"
+		return o
+	}
+	switch r := h.rng.Intn(20); {
+	case r < 10:
+		k, v := h.randomKeys(1)[0], h.value()
+		o.cmd = "SET " + k + " " + v
+		o.effect[k] = v
+	case r < 15:
+		args := []string{"MSET"}
+		group := h.rng.Intn(4)
+		for _, i := range h.rng.Perm(4)[:2+h.rng.Intn(3)] {
+			k := h.keys[4*i+group]
+			v := h.value()
+			args = append(args, k, v)
+			o.effect[k] = v
+		}
+		o.cmd = strings.Join(args, " ")
+	case r < 19:
+		keys := h.randomKeys(1 + h.rng.Intn(2))
+		o.cmd = "DEL " + strings.Join(keys, " ")
+		for _, k := range keys {
+			o.effect[k] = nilValue
+		}
+	default:
+		o.cmd = "COMPACT"
+	}
+	return o
+}
+
+func (h *harness) get(key string) string {
+	if v, ok := h.acked[key]; ok {
+		return v
+	}
+	return nilValue
+}
+
+// workload 执行随机指令直到文件系统崩溃，返回崩溃时正在执行的指令
+func (h *harness) workload(ops int) (*op, error) {
+	h.fs.CrashAfter(1 + h.rng.Intn(ops))
+	for {
+		o := h.randomOp()
+		for k, v := range o.effect {
+			if h.history[k] == nil {
+				h.history[k] = make(map[string]bool)
+			}
+			h.history[k][v] = true
+		}
+		var err error
+		if o.cmd == "ADDSHARD" {
+			err = h.addShard()
+		} else {
+			_, err = h.engine.Execute(o.cmd)
+		}
+		if err != nil {
+			if h.fs.Crashed() {
+				return &o, nil
+			}
+			return nil, fmt.Errorf("%s: %v", o.cmd, err)
+		}
+		for k, v := range o.effect {
+			h.acked[k] = v
+		}
+
+		// 崩溃前的读取必须与已确认的状态完全一致
+		k := h.randomKeys(1)[0]
+		got, err := h.engine.Execute("GET " + k)
+		if err != nil {
+			if h.fs.Crashed() {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("GET %s: %v", k, err)
+		}
+		if got != h.get(k) {
+			return nil, fmt.Errorf("GET %s before crash = %q, want %q", k, got, h.get(k))
+		}
+	}
+}
+
+// verify 检查恢复后的状态，并把它作为后续负载的起点
+func (h *harness) verify(pending *op) error {
+	state := make(map[string]string)
+	for _, k := range h.keys {
+		got, err := h.engine.Execute("GET " + k)
+		if err != nil {
+			return fmt.Errorf("GET %s after recovery: %v", k, err)
+		}
+		state[k] = got
+		if got != nilValue && !h.history[k][got] {
+			return fmt.Errorf("GET %s after recovery = %q, a value that was never written", k, got)
+		}
+
+		if !h.durable {
+			continue
+		}
+		want := h.get(k)
+		if got == want {
+			continue
+		}
+		if pending != nil {
+			if v, ok := pending.effect[k]; ok && got == v {
+				continue
+			}
+		}
+		inflight := "none"
+		if pending != nil {
+			inflight = pending.cmd
+		}
+		return fmt.Errorf("acknowledged write lost: GET %s after recovery = %q, want %q (in-flight: %s)",
+			k, got, want, inflight)
+	}
+
+	// 崩溃中的 MSET（单分片时还有多 key 的 DEL）必须整体生效或整体不生效
+	atomic := pending != nil && (strings.HasPrefix(pending.cmd, "MSET") || h.shards == 1)
+	if h.durable && atomic && len(pending.effect) > 1 {
+		all, none := true, true
+		for k, v := range pending.effect {
+			all = all && state[k] == v
+			none = none && state[k] == h.get(k)
+		}
+		if !all && !none {
+			return fmt.Errorf("in-flight batch %q partially applied after recovery", pending.cmd)
+		}
+	}
+
+	h.acked = make(map[string]string)
+	for k, v := range state {
+		if v != nilValue {
+			h.acked[k] = v
+		}
+	}
+	// 被打断的再平衡已经完成：每个 key 只在一个分片上
+	if st := h.engine.Stats(); st.Keys != len(h.acked) {
+		return fmt.Errorf("%d keys are visible but the shards hold %d (per shard: %v)", len(h.acked), st.Keys, st.ShardKeys)
+	}
+	return nil
+}
+
+func run(seed int64, cycles, ops int) (*harness, error) {
+	h := newHarness(seed)
+	if err := h.open(); err != nil {
+		return h, err
+	}
+	for c := 0; c < cycles; c++ {
+		pending, err := h.workload(ops)
+		if err != nil {
+			return h, err
+		}
+		h.fs.Crash()
+		h.crashes++
+		if err := h.open(); err != nil {
+			return h, fmt.Errorf("reopen after crash: %v", err)
+		}
+		if err := h.verify(pending); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+func main() {
+	seed := flag.Int64("seed", 1, "第一轮的随机种子")
+	rounds := flag.Int("rounds", 200, "测试轮数，每轮使用不同的种子")
+	cycles := flag.Int("cycles", 5, "每轮中“负载 -> 崩溃 -> 恢复”的次数")
+	ops := flag.Int("ops", 100, "每次崩溃前最多执行的写类文件操作数")
+	flag.Parse()
+
+	var crashes, added int
+	var truncated int64
+	modes := map[string]int{}
+	for r := 0; r < *rounds; r++ {
+		s := *seed + int64(r)
+		h, err := run(s, *cycles, *ops)
+		if err != nil {
+			fmt.Printf("FAIL seed=%d shards=%d durable=%v: %v\n", s, h.shards, h.durable, err)
+			fmt.Printf("复现: go run ./crashtest -seed %d -rounds 1 -cycles %d -ops %d\n", s, *cycles, *ops)
+			os.Exit(1)
+		}
+		crashes += h.crashes
+		added += h.shards - h.initial
+		truncated += h.truncated
+		modes[fmt.Sprintf("shards=%d->%d durable=%v", h.initial, h.shards, h.durable)]++
+	}
+
+	names := make([]string, 0, len(modes))
+	for m := range modes {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	fmt.Printf("PASS: %d 轮, %d 次崩溃, 加入 %d 个分片, 恢复时共截断 %d 字节撕裂数据\n", *rounds, crashes, added, truncated)
+	for _, m := range names {
+		fmt.Printf("  %-26s %d 轮\n", m, modes[m])
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+// TestCrashRecovery 用固定的种子跑几轮“负载 -> 崩溃 -> 恢复”，完整的随机测试见 go run ./crashtest
+func TestCrashRecovery(t *testing.T) {
+	rounds := 20
+	if testing.Short() {
+		rounds = 5
+	}
+	for seed := int64(1); seed <= int64(rounds); seed++ {
+		h, err := run(seed, 3, 50)
+		if err != nil {
+			t.Fatalf("seed=%d shards=%d durable=%v: %v\n复现: go run ./crashtest -seed %d -rounds 1 -cycles 3 -ops 50",
+				seed, h.shards, h.durable, err, seed)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+// Package faultfs 提供一个可注入故障的内存文件系统，实现了 storage.FS，
+// 用来验证 SimpleDB 在崩溃后的一致性。
+//
+// 它区分“已写入”（相当于留在页缓存里）和“已 sync”（落盘）的数据。
+// 调用 Crash 模拟断电：未 sync 的数据会丢失，或者只保留随机长度的前缀（撕裂写），
+// 保留下来的部分还可能出现字节损坏。CrashAfter 可以让崩溃发生在第 n 次写类操作
+// 的中途，此后所有文件操作都返回 ErrCrashed，直到调用 Crash 得到重启后的文件系统。
+//
+// 简化假设：Truncate、Rename 和 Remove 这类元数据操作是原子且立即持久的，
+// SyncDir 不改变任何内容，只作为一次写类操作参与崩溃点的计数。
+package faultfs
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ddia-labs/labs/14-simple-db/storage"
+)
+
+// ErrCrashed 表示文件系统已经“崩溃”，需要调用 Crash 后重新打开
+var ErrCrashed = errors.New("faultfs: simulated crash")
+
+// Options 控制崩溃时未 sync 数据的命运
+type Options struct {
+	// TornWrites 为 true 时保留未 sync 数据的随机前缀，否则全部丢弃
+	TornWrites bool
+	// CorruptTail 为 true 时，保留下来的未 sync 数据中可能有一个字节被破坏
+	CorruptTail bool
+}
+
+type inode struct {
+	data    []byte // 进程可见的内容（含页缓存）
+	durable []byte // 最近一次 sync 时的内容
+}
+
+// FS 是可注入故障的内存文件系统
+type FS struct {
+	mu    sync.Mutex
+	opts  Options
+	rng   *rand.Rand
+	files map[string]*inode
+	// gen 在每次 Crash 后递增，崩溃前打开的句柄随之失效
+	gen int
+
+	ops     int // 已执行的写类操作次数
+	crashAt int // > 0 时第 crashAt 次写类操作会触发崩溃
+	crashed bool
+}
+
+var _ storage.FS = (*FS)(nil)
+
+func New(seed int64, opts Options) *FS {
+	return &FS{
+		opts:  opts,
+		rng:   rand.New(rand.NewSource(seed)),
+		files: make(map[string]*inode),
+	}
+}
+
+// CrashAfter 让从现在起的第 n 次写类操作（Write、Sync、Truncate、Rename、Remove、SyncDir）
+// 在执行途中崩溃
+func (fs *FS) CrashAfter(n int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.crashAt = fs.ops + n
+}
+
+// Crashed 报告文件系统是否处于崩溃状态
+func (fs *FS) Crashed() bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.crashed
+}
+
+// Crash 模拟断电重启：每个文件只留下已 sync 的内容，再按 Options
+// 加上部分未 sync 的数据。之前打开的所有句柄都会失效。
+func (fs *FS) Crash() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, ino := range fs.files {
+		base := commonPrefix(ino.durable, ino.data)
+		unsynced := ino.data[base:]
+		keep := 0
+		if fs.opts.TornWrites && len(unsynced) > 0 {
+			keep = fs.rng.Intn(len(unsynced) + 1)
+		}
+		data := append(append([]byte(nil), ino.data[:base]...), unsynced[:keep]...)
+		if fs.opts.CorruptTail && keep > 0 && fs.rng.Intn(2) == 0 {
+			data[base+fs.rng.Intn(keep)] ^= byte(1 + fs.rng.Intn(255))
+		}
+		ino.data = data
+		ino.durable = append([]byte(nil), data...)
+	}
+	fs.gen++
+	fs.crashed = false
+	fs.crashAt = 0
+}
+
+func commonPrefix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// step 记录一次写类操作，返回本次操作是否应该在途中崩溃。调用方需持有 fs.mu。
+func (fs *FS) step() (crashNow bool, err error) {
+	if fs.crashed {
+		return false, ErrCrashed
+	}
+	fs.ops++
+	if fs.crashAt > 0 && fs.ops >= fs.crashAt {
+		fs.crashed = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (fs *FS) OpenFile(name string, flag int, perm os.FileMode) (storage.File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.crashed {
+		return nil, ErrCrashed
+	}
+
+	ino, ok := fs.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		ino = &inode{}
+		fs.files[name] = ino
+	}
+	if flag&os.O_TRUNC != 0 {
+		ino.data, ino.durable = nil, nil
+	}
+	return &File{fs: fs, name: name, ino: ino, gen: fs.gen, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (fs *FS) Rename(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	crash, err := fs.step()
+	if err != nil {
+		return err
+	}
+	if crash {
+		return ErrCrashed
+	}
+	ino, ok := fs.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	fs.files[newpath] = ino
+	delete(fs.files, oldpath)
+	return nil
+}
+
+func (fs *FS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	crash, err := fs.step()
+	if err != nil {
+		return err
+	}
+	if crash {
+		return ErrCrashed
+	}
+	if _, ok := fs.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+// SyncDir 在这个文件系统中是空操作（元数据操作本身已经持久），但和其他写类操作一样可能在途中崩溃
+func (fs *FS) SyncDir(dir string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	crash, err := fs.step()
+	if err != nil {
+		return err
+	}
+	if crash {
+		return ErrCrashed
+	}
+	return nil
+}
+
+// File 是 FS 中打开的文件句柄
+type File struct {
+	fs     *FS
+	name   string
+	ino    *inode
+	gen    int
+	append bool
+	pos    int64
+	closed bool
+}
+
+// check 检查句柄是否仍然可用，调用方需持有 fs.mu
+func (f *File) check() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.fs.crashed || f.gen != f.fs.gen {
+		return ErrCrashed
+	}
+	return nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+	crash, err := f.fs.step()
+	if err != nil {
+		return 0, err
+	}
+	if crash {
+		// 崩溃发生在写入途中：只有随机长度的前缀进入了页缓存
+		p = p[:f.fs.rng.Intn(len(p)+1)]
+	}
+
+	if f.append {
+		f.pos = int64(len(f.ino.data))
+	}
+	end := f.pos + int64(len(p))
+	if end > int64(len(f.ino.data)) {
+		f.ino.data = append(f.ino.data, make([]byte, end-int64(len(f.ino.data)))...)
+	}
+	copy(f.ino.data[f.pos:], p)
+	f.pos = end
+
+	if crash {
+		return len(p), ErrCrashed
+	}
+	return len(p), nil
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+	if off >= int64(len(f.ino.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.ino.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *File) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check(); err != nil {
+		return err
+	}
+	crash, err := f.fs.step()
+	if err != nil {
+		return err
+	}
+	if crash {
+		return ErrCrashed
+	}
+	f.ino.durable = append(f.ino.durable[:0], f.ino.data...)
+	return nil
+}
+
+func (f *File) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check(); err != nil {
+		return err
+	}
+	crash, err := f.fs.step()
+	if err != nil {
+		return err
+	}
+	if crash {
+		return ErrCrashed
+	}
+	if size < int64(len(f.ino.data)) {
+		f.ino.data = f.ino.data[:size]
+	}
+	if size < int64(len(f.ino.durable)) {
+		f.ino.durable = f.ino.durable[:size]
+	}
+	return nil
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return fileInfo{name: f.name, size: int64(len(f.ino.data))}, nil
+}
+
+func (f *File) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+type fileInfo struct {
+	name string
+	size int64
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return 0666 }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return nil }
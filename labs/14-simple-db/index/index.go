@@ -2,25 +2,87 @@ package index
 
 import "sync"
 
+type location struct {
+	offset int64
+	size   int64
+}
+
 // Index maps key to disk offset
 type Index struct {
 	mu    sync.RWMutex
-	table map[string]int64
+	table map[string]location
+	// liveBytes 是所有存活记录在日志中占用的字节数，用于计算垃圾比例
+	liveBytes int64
 }
 
 func NewIndex() *Index {
-	return &Index{table: make(map[string]int64)}
+	return &Index{table: make(map[string]location)}
 }
 
-func (i *Index) Put(key string, offset int64) {
+// Put 记录 key 最新版本的偏移量和记录大小
+func (i *Index) Put(key string, offset, size int64) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	i.table[key] = offset
+	i.put(key, offset, size)
+}
+
+func (i *Index) put(key string, offset, size int64) {
+	if old, ok := i.table[key]; ok {
+		i.liveBytes -= old.size
+	}
+	i.table[key] = location{offset: offset, size: size}
+	i.liveBytes += size
 }
 
 func (i *Index) Get(key string) (int64, bool) {
 	i.mu.RLock()
 	defer i.mu.RUnlock()
-	offset, ok := i.table[key]
-	return offset, ok
+	loc, ok := i.table[key]
+	return loc.offset, ok
+}
+
+// Delete 删除 key，返回 key 原本是否存在
+func (i *Index) Delete(key string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	old, ok := i.table[key]
+	if ok {
+		i.liveBytes -= old.size
+		delete(i.table, key)
+	}
+	return ok
+}
+
+// PutBatch 在同一把写锁内更新多个 key，读者要么看到整批更新，要么一个都看不到
+func (i *Index) PutBatch(keys []string, offsets, sizes []int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n, key := range keys {
+		i.put(key, offsets[n], sizes[n])
+	}
+}
+
+// Snapshot 返回当前所有 key 到偏移量的拷贝
+func (i *Index) Snapshot() map[string]int64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	snap := make(map[string]int64, len(i.table))
+	for k, loc := range i.table {
+		snap[k] = loc.offset
+	}
+	return snap
+}
+
+// Len 返回索引中的 key 数量
+func (i *Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.table)
+}
+
+// LiveBytes 返回存活记录占用的字节数
+func (i *Index) LiveBytes() int64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.liveBytes
 }
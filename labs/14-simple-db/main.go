@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"strings"
+	"github.com/ddia-labs/labs/14-simple-db/cache"
 	"github.com/ddia-labs/labs/14-simple-db/storage"
 	"github.com/ddia-labs/labs/14-simple-db/index"
 	"github.com/ddia-labs/labs/14-simple-db/transaction"
 	"github.com/ddia-labs/labs/14-simple-db/query"
+	"github.com/ddia-labs/labs/14-simple-db/server"
 )
 
 func main() {
@@ -17,7 +23,7 @@ func main() {
 	s, _ := storage.NewDiskStorage(dbFile)
 	idx := index.NewIndex()
 	lm := transaction.NewLockManager()
-	defer s.Close()
+	defer func() { s.Close() }()
 
 	// 2. 初始化查询引擎 (Query Layer)
 	engine := query.NewEngine(s, idx, lm)
@@ -36,6 +42,8 @@ func main() {
 		"SET user:1 Alice_Updated",
 		"GET user:1",
 		"GET user:999", // 不存在的 key
+		"MSET user:3 Carol user:4 Dave",
+		"MGET user:1 user:3 user:4 user:999",
 	}
 
 	for _, cmd := range commands {
@@ -47,9 +55,160 @@ func main() {
 		}
 	}
 
+	// 演示 4: 批量写入，多个 key 只加一次锁、只追加一次日志
+	fmt.Println()
+	fmt.Println("--- 场景: WriteBatch 批量写入 ---")
+	batch := query.NewWriteBatch()
+	for i := 0; i < 1000; i++ {
+		batch.Put(fmt.Sprintf("item:%d", i), fmt.Sprintf("v%d", i))
+	}
+	if err := engine.Write(batch); err != nil {
+		fmt.Printf("批量写入失败: %v\n", err)
+	} else {
+		val, _ := engine.Execute("GET item:999")
+		fmt.Printf("一次追加写入 %d 条记录, GET item:999 -> %s\n", batch.Len(), val)
+	}
+
+	// 演示 5: 网络协议上的流水线请求
+	fmt.Println()
+	fmt.Println("--- 场景: 流水线 (Pipelining) 请求 ---")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Printf("监听失败: %v\n", err)
+		return
+	}
+	defer l.Close()
+	go server.NewServer(engine).Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		fmt.Printf("连接失败: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	pipeline := []string{"SET user:5 Eve", "GET user:5", "MGET user:1 user:2", "FOO bar"}
+	// 一次性发出所有指令，不等待任何回复
+	for _, cmd := range pipeline {
+		fmt.Fprintf(conn, "%s\n", cmd)
+	}
+	reader := bufio.NewReader(conn)
+	for _, cmd := range pipeline {
+		reply, _ := reader.ReadString('\n')
+		fmt.Printf("流水线 [%s] -> %s", cmd, reply)
+	}
+
+	// 演示 6: 读缓存，热点 key 直接从内存返回
+	fmt.Println()
+	fmt.Println("--- 场景: 值缓存 (LRU, 1MB 预算) ---")
+	valueCache := cache.New(1 << 20)
+	engine.SetCache(valueCache)
+	for i := 0; i < 100; i++ {
+		engine.Execute("GET user:1")
+	}
+	cs := valueCache.Stats()
+	fmt.Printf("连续 100 次 GET user:1 -> 命中 %d 次, 未命中 %d 次\n", cs.Hits, cs.Misses)
+	for _, cmd := range []string{"SET user:1 Alice_v3", "GET user:1", "DEL user:2 user:999", "GET user:2"} {
+		result, _ := engine.Execute(cmd)
+		fmt.Printf("执行指令 [%s] -> 结果: %s\n", cmd, result)
+	}
+
+	// 演示 7: 指标与自省
+	fmt.Println()
+	fmt.Println("--- 场景: INFO 指令、日志压缩与 Prometheus 指标 ---")
+	for i := 0; i < 1000; i++ {
+		engine.Execute(fmt.Sprintf("SET item:%d v%d_updated", i%100, i))
+	}
+	info, _ := engine.Execute("INFO")
+	fmt.Printf("INFO (压缩前) -> %s\n", firstFields(info, 11))
+	engine.Execute("COMPACT")
+	info, _ = engine.Execute("INFO")
+	fmt.Printf("INFO (压缩后) -> %s\n", firstFields(info, 11))
+
+	http.Handle("/metrics", server.MetricsHandler(engine))
+	ml, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Printf("监听失败: %v\n", err)
+		return
+	}
+	defer ml.Close()
+	go http.Serve(ml, nil)
+	resp, err := http.Get("http://" + ml.Addr().String() + "/metrics")
+	if err == nil {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if strings.HasPrefix(sc.Text(), "simpledb_commands_total") {
+				fmt.Printf("GET /metrics -> %s\n", sc.Text())
+			}
+		}
+		resp.Body.Close()
+	}
+
+	// 演示 8: 重启后扫描日志重建索引
+	fmt.Println()
+	fmt.Println("--- 场景: 重启恢复 ---")
+	s.Close()
+	s, _ = storage.NewDiskStorage(dbFile)
+	engine = query.NewEngine(s, index.NewIndex(), transaction.NewLockManager())
+	if err := engine.Recover(); err != nil {
+		fmt.Printf("恢复失败: %v\n", err)
+	}
+	result, _ := engine.Execute("MGET user:1 user:2 item:99")
+	fmt.Printf("重新打开 %s 并重建索引 (%d 个 key) -> MGET user:1 user:2 item:99 = %s\n",
+		dbFile, engine.Stats().Keys, result)
+
+	// 演示 9: 多分片
+	fmt.Println()
+	fmt.Println("--- 场景: 哈希分片与再平衡 ---")
+	newShard := func(i int) *query.Shard {
+		path := fmt.Sprintf("simple-shard-%d.db", i)
+		ss, _ := storage.NewDiskStorage(path)
+		return query.NewShard(ss, index.NewIndex(), transaction.NewLockManager())
+	}
+	defer func() {
+		for i := 0; i < 4; i++ {
+			os.Remove(fmt.Sprintf("simple-shard-%d.db", i))
+		}
+		os.Remove("simple-shards.manifest")
+	}()
+	sharded := query.NewShardedEngine(newShard(0), newShard(1), newShard(2))
+	// 清单记录分片数量，重启时用错误的分片数打开会报错
+	if err := sharded.UseManifest(storage.OSFS, "simple-shards.manifest"); err != nil {
+		fmt.Printf("打开清单失败: %v\n", err)
+	}
+	for i := 0; i < 300; i++ {
+		sharded.Execute(fmt.Sprintf("SET user:%d u%d", i, i))
+	}
+	result, _ = sharded.Execute("MGET user:1 user:100 user:299")
+	fmt.Printf("跨分片 MGET user:1 user:100 user:299 -> %s\n", result)
+	// 批量写入必须落在同一个分片上才能原子提交，跨分片的批次被整体拒绝
+	if _, err := sharded.Execute("MSET user:1 a user:100 b"); err != nil {
+		fmt.Printf("跨分片 MSET user:1 a user:100 b -> 错误: %v\n", err)
+	}
+	result, _ = sharded.Execute("MSET {cart:7}:items 3 {cart:7}:total 42")
+	fmt.Printf("带哈希标签的 MSET {cart:7}:items 3 {cart:7}:total 42 -> %s（两个 key 都只按 cart:7 哈希）\n", result)
+	fmt.Printf("各分片 key 数量: %v\n", sharded.Stats().ShardKeys)
+
+	moved, err := sharded.AddShard(newShard(3))
+	if err != nil {
+		fmt.Printf("再平衡失败: %v\n", err)
+	}
+	fmt.Printf("加入第 4 个分片后迁移了 %d 个 key, 各分片 key 数量: %v\n", moved, sharded.Stats().ShardKeys)
+	result, _ = sharded.Execute("MGET user:1 user:100 user:299")
+	fmt.Printf("再平衡后 MGET user:1 user:100 user:299 -> %s\n", result)
+
 	fmt.Println()
 	fmt.Println("=== 为什么需要 Query 层？ ===")
 	fmt.Println("1. 抽象细节：用户不需要知道磁盘 Offset 或如何加锁，只需发送字符串指令。")
 	fmt.Println("2. 统一入口：所有并发冲突和组件协调都在 Query 层完成，保证了系统的正确性。")
 	fmt.Println("3. 可扩展性：如果以后要支持 SQL 或 JSON 查询，只需在 Query 层增加解析逻辑。")
 }
+
+// firstFields 截取 INFO 回复中的前 n 个字段，便于展示
+func firstFields(info string, n int) string {
+	fields := strings.Fields(info)
+	if len(fields) > n {
+		fields = fields[:n]
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets 延迟直方图的桶上界（秒），覆盖 10µs ~ 1s
+var DefaultLatencyBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.05, 0.1, 0.5, 1,
+}
+
+// Histogram 是 Prometheus 风格的累积直方图
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // counts[i] 为落在 (bounds[i-1], bounds[i]] 的样本数，最后一个为 +Inf
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// HistogramSnapshot 是直方图在某一时刻的只读拷贝
+type HistogramSnapshot struct {
+	Bounds []float64
+	Counts []uint64 // 非累积
+	Sum    float64
+	Count  uint64
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: append([]uint64(nil), h.counts...),
+		Sum:    h.sum,
+		Count:  h.count,
+	}
+}
+
+// Quantile 用样本所在桶的上界估算分位数（与 Prometheus histogram_quantile 同样粗略）
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.Count))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range s.Counts {
+		seen += c
+		if seen >= rank {
+			if i < len(s.Bounds) {
+				return s.Bounds[i]
+			}
+			break
+		}
+	}
+	return s.Bounds[len(s.Bounds)-1]
+}
+
+// CommandMetrics 按指令统计调用次数、错误次数和延迟分布
+type CommandMetrics struct {
+	mu      sync.Mutex
+	latency map[string]*Histogram
+	errors  map[string]uint64
+}
+
+func NewCommandMetrics() *CommandMetrics {
+	return &CommandMetrics{
+		latency: make(map[string]*Histogram),
+		errors:  make(map[string]uint64),
+	}
+}
+
+// Observe 记录一次指令执行
+func (m *CommandMetrics) Observe(cmd string, d time.Duration, err error) {
+	m.mu.Lock()
+	h, ok := m.latency[cmd]
+	if !ok {
+		h = NewHistogram(DefaultLatencyBuckets)
+		m.latency[cmd] = h
+	}
+	if err != nil {
+		m.errors[cmd]++
+	}
+	m.mu.Unlock()
+	h.Observe(d.Seconds())
+}
+
+// CommandStats 是单条指令的统计快照
+type CommandStats struct {
+	Name    string
+	Errors  uint64
+	Latency HistogramSnapshot
+}
+
+// Snapshot 返回按指令名排序的统计快照
+func (m *CommandMetrics) Snapshot() []CommandStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]CommandStats, 0, len(m.latency))
+	for name, h := range m.latency {
+		stats = append(stats, CommandStats{
+			Name:    name,
+			Errors:  m.errors[name],
+			Latency: h.Snapshot(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// WriteCounter 以 Prometheus 文本格式输出一个计数器
+func WriteCounter(w io.Writer, name, help string, v float64) {
+	writeMetric(w, name, help, "counter", v)
+}
+
+// WriteGauge 以 Prometheus 文本格式输出一个仪表盘
+func WriteGauge(w io.Writer, name, help string, v float64) {
+	writeMetric(w, name, help, "gauge", v)
+}
+
+func writeMetric(w io.Writer, name, help, typ string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, v)
+}
+
+// WriteCommandMetrics 以 Prometheus 文本格式输出按指令划分的计数和延迟直方图
+func WriteCommandMetrics(w io.Writer, prefix string, stats []CommandStats) {
+	fmt.Fprintf(w, "# HELP %s_commands_total Commands executed.\n# TYPE %s_commands_total counter\n", prefix, prefix)
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s_commands_total{cmd=%q} %d\n", prefix, s.Name, s.Latency.Count)
+	}
+	fmt.Fprintf(w, "# HELP %s_command_errors_total Commands that returned an error.\n# TYPE %s_command_errors_total counter\n", prefix, prefix)
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s_command_errors_total{cmd=%q} %d\n", prefix, s.Name, s.Errors)
+	}
+
+	name := prefix + "_command_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Command latency.\n# TYPE %s histogram\n", name, name)
+	for _, s := range stats {
+		var cum uint64
+		for i, b := range s.Latency.Bounds {
+			cum += s.Latency.Counts[i]
+			fmt.Fprintf(w, "%s_bucket{cmd=%q,le=\"%g\"} %d\n", name, s.Name, b, cum)
+		}
+		fmt.Fprintf(w, "%s_bucket{cmd=%q,le=\"+Inf\"} %d\n", name, s.Name, s.Latency.Count)
+		fmt.Fprintf(w, "%s_sum{cmd=%q} %g\n", name, s.Name, s.Latency.Sum)
+		fmt.Fprintf(w, "%s_count{cmd=%q} %d\n", name, s.Name, s.Latency.Count)
+	}
+}
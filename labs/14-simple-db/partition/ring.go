@@ -0,0 +1,62 @@
+package partition
+
+import (
+	"crypto/sha1"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ring 是带虚拟节点的一致性哈希环，把 key 映射到分片编号
+// （与 labs/05-partitioning/consistent-hash 中的实现思路相同）
+type Ring struct {
+	replicas int
+	hashes   []uint32
+	owners   map[uint32]int // 虚拟节点哈希 -> 分片编号
+}
+
+func NewRing(replicas int) *Ring {
+	return &Ring{
+		replicas: replicas,
+		owners:   make(map[uint32]int),
+	}
+}
+
+func hash(key string) uint32 {
+	h := sha1.Sum([]byte(key))
+	return uint32(h[0])<<24 | uint32(h[1])<<16 | uint32(h[2])<<8 | uint32(h[3])
+}
+
+// Add 把分片加入环中，每个分片对应 replicas 个虚拟节点
+func (r *Ring) Add(shard int) {
+	for i := 0; i < r.replicas; i++ {
+		h := hash("shard-" + strconv.Itoa(shard) + "#" + strconv.Itoa(i))
+		r.hashes = append(r.hashes, h)
+		r.owners[h] = shard
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// HashTag 返回 key 中参与哈希的部分：与 Redis Cluster 相同，key 中有 {...} 且括号内非空时只对第一对括号内的部分哈希，
+// 所以 {user:1}:name 和 {user:1}:email 总是落在同一个分片上，可以放进同一个原子批次
+func HashTag(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			return key[i+1 : i+1+j]
+		}
+	}
+	return key
+}
+
+// Get 返回负责 key 的分片：顺时针找到第一个哈希值 >= hash(HashTag(key)) 的虚拟节点
+func (r *Ring) Get(key string) int {
+	if len(r.hashes) == 0 {
+		return 0
+	}
+	h := hash(HashTag(key))
+	idx := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if idx == len(r.hashes) {
+		idx = 0 // 绕回环的起点
+	}
+	return r.owners[r.hashes[idx]]
+}
@@ -0,0 +1,41 @@
+package partition
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashTag(t *testing.T) {
+	for key, want := range map[string]string{
+		"user:1":             "user:1",
+		"{user:1}:name":      "user:1",
+		"cart:{7}":           "7",
+		"{a}{b}":             "a",
+		"{user:1}:{ignored}": "user:1",
+		"{}x":                "{}x",  // 空标签不算，整个 key 参与哈希
+		"x}{y":               "x}{y", // 只在第一个 '{' 之后找 '}'
+		"no{closing":         "no{closing",
+	} {
+		if got := HashTag(key); got != want {
+			t.Errorf("HashTag(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// TestTaggedKeysColocate 检查标签相同的 key 总是落在同一个分片上，而不带标签的 key 分散到所有分片
+func TestTaggedKeysColocate(t *testing.T) {
+	r := NewRing(64)
+	for i := 0; i < 4; i++ {
+		r.Add(i)
+	}
+	owners := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		owners[r.Get(fmt.Sprintf("user:%d", i))] = true
+		if a, b := r.Get(fmt.Sprintf("{user:%d}:name", i)), r.Get(fmt.Sprintf("{user:%d}:email", i)); a != b || a != r.Get(fmt.Sprintf("user:%d", i)) {
+			t.Fatalf("keys tagged user:%d landed on shards %d and %d", i, a, b)
+		}
+	}
+	if len(owners) != 4 {
+		t.Errorf("100 untagged keys landed on %d of 4 shards", len(owners))
+	}
+}
@@ -0,0 +1,65 @@
+package query
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ddia-labs/labs/14-simple-db/storage"
+)
+
+// ErrCrossShard 表示批次中的 key 分布在多个分片上。跨分片提交无法原子化，这样的批次被整体拒绝
+// （与 Redis Cluster 的 CROSSSLOT 错误相同），需要原子写入的 key 可以用 {...} 哈希标签放到同一个分片上
+var ErrCrossShard = errors.New("batch keys do not hash to the same shard, use {tag} hash tags to colocate them")
+
+// WriteBatch 收集多次写入，通过 Engine.Write 一次性提交
+type WriteBatch struct {
+	entries []storage.Entry
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put 向批次中追加一次写入，同一个 key 以最后一次为准
+func (b *WriteBatch) Put(key, value string) {
+	b.entries = append(b.entries, storage.Entry{Key: key, Value: value})
+}
+
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+// Reset 清空批次以便复用
+func (b *WriteBatch) Reset() {
+	b.entries = b.entries[:0]
+}
+
+// Write 提交一个批次：锁住涉及的 key、一次追加写日志、再整体更新索引，批次整体生效或整体不生效。
+// 批次中的 key 必须都属于同一个分片，否则返回 ErrCrossShard，什么也不写。
+func (e *Engine) Write(b *WriteBatch) error {
+	start := time.Now()
+	e.mu.RLock()
+	err := e.write(b)
+	e.mu.RUnlock()
+	e.cmds.Observe("WRITEBATCH", time.Since(start), err)
+	return err
+}
+
+func (e *Engine) write(b *WriteBatch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	sh := e.shardFor(b.entries[0].Key)
+	for _, en := range b.entries[1:] {
+		if e.shardFor(en.Key) != sh {
+			return ErrCrossShard
+		}
+	}
+	if err := sh.write(b.entries); err != nil {
+		return err
+	}
+	for _, en := range b.entries {
+		e.invalidate(en.Key)
+	}
+	return nil
+}
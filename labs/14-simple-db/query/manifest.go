@@ -0,0 +1,100 @@
+package query
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ddia-labs/labs/14-simple-db/storage"
+)
+
+// manifest 持久化记录分片数量。分片编号就是它在哈希环上的成员编号，
+// 所以只要分片数量一致，重启后得到的哈希环就和崩溃前相同。
+type manifest struct {
+	fs   storage.FS
+	path string
+}
+
+// UseManifest 让引擎在 path 处持久化分片数量：文件不存在时写入当前的分片数，
+// 存在时检查它与打开的分片数一致，不一致则返回错误（用错误的分片数打开会把 key 路由到错误的分片）。
+// 之后 AddShard 会在迁移前先更新清单。应在 Recover 之前调用。
+func (e *Engine) UseManifest(fs storage.FS, path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	m := &manifest{fs: fs, path: path}
+	n, ok, err := m.load()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := m.store(len(e.shards)); err != nil {
+			return err
+		}
+	} else if n != len(e.shards) {
+		return fmt.Errorf("manifest %s records %d shards, engine was opened with %d", path, n, len(e.shards))
+	}
+	e.manifest = m
+	return nil
+}
+
+// LoadManifest 读取 path 处清单记录的分片数，文件不存在时 ok 为 false。
+// 重启时先用它得到分片数，打开同样数量的分片后再调用 UseManifest 和 Recover
+func LoadManifest(fs storage.FS, path string) (shards int, ok bool, err error) {
+	return (&manifest{fs: fs, path: path}).load()
+}
+
+// load 读取清单中的分片数，文件不存在时 ok 为 false
+func (m *manifest) load() (n int, ok bool, err error) {
+	f, err := m.fs.OpenFile(m.path, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	buf := make([]byte, stat.Size())
+	if _, err := f.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return 0, false, err
+	}
+	line := strings.TrimSuffix(string(buf), "\n")
+	if !strings.HasPrefix(line, "shards=") {
+		return 0, false, fmt.Errorf("malformed manifest %s", m.path)
+	}
+	n, err = strconv.Atoi(strings.TrimPrefix(line, "shards="))
+	if err != nil || n <= 0 {
+		return 0, false, fmt.Errorf("malformed manifest %s", m.path)
+	}
+	return n, true, nil
+}
+
+// store 原子地替换清单：写临时文件并 fsync，rename 覆盖旧清单，再 fsync 目录
+func (m *manifest) store(n int) error {
+	tmpPath := m.path + ".tmp"
+	f, err := m.fs.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte(fmt.Sprintf("shards=%d\n", n)))
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = m.fs.Rename(tmpPath, m.path)
+	}
+	if err != nil {
+		m.fs.Remove(tmpPath)
+		return err
+	}
+	return m.fs.SyncDir(filepath.Dir(m.path))
+}
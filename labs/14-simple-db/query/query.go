@@ -3,70 +3,274 @@ package query
 import (
 	"fmt"
 	"strings"
-	"github.com/ddia-labs/labs/14-simple-db/storage"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ddia-labs/labs/14-simple-db/cache"
 	"github.com/ddia-labs/labs/14-simple-db/index"
+	"github.com/ddia-labs/labs/14-simple-db/metrics"
+	"github.com/ddia-labs/labs/14-simple-db/partition"
+	"github.com/ddia-labs/labs/14-simple-db/storage"
 	"github.com/ddia-labs/labs/14-simple-db/transaction"
 )
 
-// Engine 负责协调各个组件执行指令
+// Engine 负责协调各个组件执行指令。
+// 数据可以分布在多个分片上，Engine 按一致性哈希把 key 路由到分片，
+// MGET 和 DEL 分发到各分片并发执行后再汇总（scatter-gather）；
+// MSET 和 WriteBatch 要求所有 key 属于同一个分片，以保证批次的原子性（见 ErrCrossShard）。
 type Engine struct {
-	storage *storage.DiskStorage
-	index   *index.Index
-	lm      *transaction.LockManager
+	shards []*Shard
+	ring   *partition.Ring
+	// cache 为 nil 时所有 GET 都直接读磁盘
+	cache *cache.Cache
+	// manifest 为 nil 时分片数量不做持久化和校验（见 UseManifest）
+	manifest *manifest
+
+	// mu 让压缩、再平衡这类全局操作与普通读写互斥：读写持读锁，全局操作持写锁
+	mu          sync.RWMutex
+	cmds        *metrics.CommandMetrics
+	compactions int64
 }
 
+// ringReplicas 是每个分片在哈希环上的虚拟节点数
+const ringReplicas = 64
+
+// NewEngine 创建单分片的引擎
 func NewEngine(s *storage.DiskStorage, i *index.Index, lm *transaction.LockManager) *Engine {
-	return &Engine{
-		storage: s,
-		index:   i,
-		lm:      lm,
+	return NewShardedEngine(NewShard(s, i, lm))
+}
+
+// NewShardedEngine 创建由多个分片组成的引擎，分片编号即其在参数中的位置
+func NewShardedEngine(shards ...*Shard) *Engine {
+	e := &Engine{
+		ring: partition.NewRing(ringReplicas),
+		cmds: metrics.NewCommandMetrics(),
 	}
+	for _, sh := range shards {
+		e.ring.Add(len(e.shards))
+		e.shards = append(e.shards, sh)
+	}
+	return e
+}
+
+// Recover 扫描所有分片的日志重建内存索引，并完成崩溃时被打断的再平衡。
+// 打开已有的数据文件后、执行指令前调用。
+func (e *Engine) Recover() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, sh := range e.shards {
+		if err := sh.Recover(); err != nil {
+			return err
+		}
+	}
+	// 完成崩溃时被打断的再平衡
+	if err := e.repair(); err != nil {
+		return err
+	}
+	if e.cache != nil {
+		e.cache.Purge()
+	}
+	return nil
+}
+
+// SetCache 在磁盘读取前挂上一个值缓存，传入 nil 则关闭缓存
+func (e *Engine) SetCache(c *cache.Cache) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cache = c
+}
+
+func (e *Engine) shardFor(key string) *Shard {
+	return e.shards[e.ring.Get(key)]
+}
+
+// scatter 把 key 按所属分片分组：分片编号 -> 这些 key 在原列表中的下标
+func (e *Engine) scatter(keys []string) map[int][]int {
+	groups := make(map[int][]int)
+	for i, k := range keys {
+		id := e.ring.Get(k)
+		groups[id] = append(groups[id], i)
+	}
+	return groups
+}
+
+// gather 在每个涉及的分片上并发执行 fn，等待全部完成后返回第一个错误
+func (e *Engine) gather(groups map[int][]int, fn func(sh *Shard, idx []int) error) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(groups))
+	for id, idx := range groups {
+		wg.Add(1)
+		go func(sh *Shard, idx []int) {
+			defer wg.Done()
+			if err := fn(sh, idx); err != nil {
+				errs <- err
+			}
+		}(e.shards[id], idx)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// commands 是会被记录指标的指令，未知指令不计入以免指标无限增长
+var commands = map[string]bool{
+	"SET": true, "GET": true, "DEL": true, "MSET": true, "MGET": true,
+	"INFO": true, "STATS": true, "COMPACT": true,
 }
 
 // Execute 模拟 SQL 解析和执行
-// 支持指令: 
+// 支持指令:
 // - SET key value
 // - GET key
+// - DEL key [key ...]
+// - MSET key value [key value ...]
+// - MGET key [key ...]
+// - INFO / STATS
+// - COMPACT
 func (e *Engine) Execute(command string) (string, error) {
 	parts := strings.Fields(command)
-	if len(parts) < 2 {
+	if len(parts) == 0 {
 		return "", fmt.Errorf("invalid command")
 	}
 
 	action := strings.ToUpper(parts[0])
+	start := time.Now()
+	result, err := e.execute(action, parts)
+	if commands[action] {
+		e.cmds.Observe(action, time.Since(start), err)
+	}
+	return result, err
+}
+
+func (e *Engine) execute(action string, parts []string) (string, error) {
+	switch action {
+	case "INFO", "STATS":
+		return e.Stats().String(), nil
+	case "COMPACT":
+		if err := e.Compact(); err != nil {
+			return "", err
+		}
+		return "OK", nil
+	}
+
+	if len(parts) < 2 {
+		return "", fmt.Errorf("invalid command")
+	}
 	key := parts[1]
 
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	switch action {
 	case "SET":
 		if len(parts) < 3 {
 			return "", fmt.Errorf("SET requires a value")
 		}
 		value := parts[2]
-		
-		// 协调事务、存储和索引
-		unlock := e.lm.LockKey(key)
-		defer unlock()
 
-		offset, err := e.storage.Write(key, value)
-		if err != nil {
+		if err := e.shardFor(key).set(key, value); err != nil {
 			return "", err
 		}
-		e.index.Put(key, offset)
+		e.invalidate(key)
 		return "OK", nil
 
 	case "GET":
-		offset, ok := e.index.Get(key)
-		if !ok {
-			return "(nil)", nil
+		return e.get(key)
+
+	case "DEL":
+		keys := parts[1:]
+		var deleted int64
+		err := e.gather(e.scatter(keys), func(sh *Shard, idx []int) error {
+			group := make([]string, len(idx))
+			for n, i := range idx {
+				group[n] = keys[i]
+			}
+			removed, err := sh.del(group)
+			for _, k := range removed {
+				e.invalidate(k)
+			}
+			atomic.AddInt64(&deleted, int64(len(removed)))
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d", deleted), nil
+
+	case "MSET":
+		args := parts[1:]
+		if len(args)%2 != 0 {
+			return "", fmt.Errorf("MSET requires key value pairs")
+		}
+		b := NewWriteBatch()
+		for i := 0; i < len(args); i += 2 {
+			b.Put(args[i], args[i+1])
+		}
+		if err := e.write(b); err != nil {
+			return "", err
 		}
-		_, val, err := e.storage.ReadAt(offset)
+		return "OK", nil
+
+	case "MGET":
+		keys := parts[1:]
+		vals := make([]string, len(keys))
+		err := e.gather(e.scatter(keys), func(sh *Shard, idx []int) error {
+			for _, i := range idx {
+				val, err := e.getFrom(sh, keys[i])
+				if err != nil {
+					return err
+				}
+				vals[i] = val
+			}
+			return nil
+		})
 		if err != nil {
 			return "", err
 		}
-		return val, nil
+		return strings.Join(vals, " "), nil
 
 	default:
 		return "", fmt.Errorf("unknown command: %s", action)
 	}
 }
 
+func (e *Engine) get(key string) (string, error) {
+	return e.getFrom(e.shardFor(key), key)
+}
+
+func (e *Engine) getFrom(sh *Shard, key string) (string, error) {
+	val, ok, err := sh.get(key, e.cache)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "(nil)", nil
+	}
+	return val, nil
+}
+
+// invalidate 在写入或删除后使 key 的缓存失效
+func (e *Engine) invalidate(key string) {
+	if e.cache != nil {
+		e.cache.Remove(key)
+	}
+}
+
+// Compact 只保留每个 key 的最新版本重写各分片的日志，回收被覆盖记录占用的空间
+func (e *Engine) Compact() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, sh := range e.shards {
+		if err := sh.compact(); err != nil {
+			return err
+		}
+	}
+	if e.cache != nil {
+		// 所有偏移量都变了，旧的缓存项已不可能命中
+		e.cache.Purge()
+	}
+	atomic.AddInt64(&e.compactions, 1)
+	return nil
+}
@@ -0,0 +1,106 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ddia-labs/labs/14-simple-db/cache"
+	"github.com/ddia-labs/labs/14-simple-db/faultfs"
+	"github.com/ddia-labs/labs/14-simple-db/index"
+	"github.com/ddia-labs/labs/14-simple-db/storage"
+	"github.com/ddia-labs/labs/14-simple-db/transaction"
+)
+
+// openShard 在 fs 上打开（或创建）名为 name 的分片日志
+func openShard(t *testing.T, fs storage.FS, name string) *Shard {
+	t.Helper()
+	s, err := storage.OpenDiskStorage(fs, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewShard(s, index.NewIndex(), transaction.NewLockManager())
+}
+
+func exec(t *testing.T, e *Engine, cmd string) string {
+	t.Helper()
+	out, err := e.Execute(cmd)
+	if err != nil {
+		t.Fatalf("%s: %v", cmd, err)
+	}
+	return out
+}
+
+// fill 写入 n 个 key 并把它们都读进缓存
+func fill(t *testing.T, e *Engine, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		exec(t, e, fmt.Sprintf("SET k%d v%d", i, i))
+	}
+	for i := 0; i < n; i++ {
+		exec(t, e, fmt.Sprintf("GET k%d", i))
+	}
+}
+
+// checkValues 检查每个 key 都读到最新的值（缓存和磁盘都可能是来源）
+func checkValues(t *testing.T, e *Engine, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if got, want := exec(t, e, fmt.Sprintf("GET k%d", i)), fmt.Sprintf("v%d", i); got != want {
+			t.Fatalf("GET k%d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+// TestCachePurgedWhenOffsetsChange 检查压缩、再平衡和恢复这些会改变偏移量（或所在分片）的操作之后缓存被清空，
+// 之后的读取仍然得到正确的值
+func TestCachePurgedWhenOffsetsChange(t *testing.T) {
+	const n = 50
+	fs := faultfs.New(1, faultfs.Options{})
+	e := NewShardedEngine(openShard(t, fs, "shard-0.db"))
+	e.SetCache(cache.New(1 << 20))
+	fill(t, e, n)
+	if s := e.Stats().Cache; s.Entries != n {
+		t.Fatalf("cache holds %d entries after reading %d keys", s.Entries, n)
+	}
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"Compact", e.Compact},
+		{"AddShard", func() error { _, err := e.AddShard(openShard(t, fs, "shard-1.db")); return err }},
+		{"Recover", e.Recover},
+	}
+	for _, step := range steps {
+		checkValues(t, e, n)
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if s := e.Stats().Cache; s.Entries != 0 || s.Bytes != 0 {
+			t.Fatalf("cache not purged after %s: %+v", step.name, s)
+		}
+		checkValues(t, e, n)
+	}
+}
+
+// TestCacheInvalidatedOnWrite 检查 SET、MSET 和 DEL 之后不会从缓存读到旧值
+func TestCacheInvalidatedOnWrite(t *testing.T) {
+	fs := faultfs.New(1, faultfs.Options{})
+	e := NewShardedEngine(openShard(t, fs, "shard-0.db"))
+	e.SetCache(cache.New(1 << 20))
+
+	exec(t, e, "SET a 1")
+	exec(t, e, "GET a")
+	exec(t, e, "SET a 2")
+	if got := exec(t, e, "GET a"); got != "2" {
+		t.Fatalf("GET a after SET = %q", got)
+	}
+	exec(t, e, "MSET a 3 b 4")
+	if got := exec(t, e, "MGET a b"); got != "3 4" {
+		t.Fatalf("MGET a b after MSET = %q", got)
+	}
+	exec(t, e, "DEL a")
+	if got := exec(t, e, "GET a"); got != "(nil)" {
+		t.Fatalf("GET a after DEL = %q", got)
+	}
+}
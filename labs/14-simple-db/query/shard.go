@@ -0,0 +1,243 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ddia-labs/labs/14-simple-db/cache"
+	"github.com/ddia-labs/labs/14-simple-db/index"
+	"github.com/ddia-labs/labs/14-simple-db/storage"
+	"github.com/ddia-labs/labs/14-simple-db/transaction"
+)
+
+// Shard 是一个独立的数据分片：拥有自己的日志文件、内存索引和锁管理器。
+// 单分片的 Engine 就是原来的 SimpleDB。
+type Shard struct {
+	storage *storage.DiskStorage
+	index   *index.Index
+	lm      *transaction.LockManager
+}
+
+func NewShard(s *storage.DiskStorage, i *index.Index, lm *transaction.LockManager) *Shard {
+	return &Shard{
+		storage: s,
+		index:   i,
+		lm:      lm,
+	}
+}
+
+// Recover 扫描日志重建内存索引，用于重启后打开已有的数据文件
+func (sh *Shard) Recover() error {
+	return sh.storage.Scan(func(e storage.Entry, offset int64) {
+		if e.Deleted {
+			sh.index.Delete(e.Key)
+			return
+		}
+		sh.index.Put(e.Key, offset, storage.RecordSize(e.Key, e.Value))
+	})
+}
+
+func (sh *Shard) set(key, value string) error {
+	// 协调事务、存储和索引
+	unlock := sh.lm.LockKey(key)
+	defer unlock()
+
+	offset, err := sh.storage.Write(key, value)
+	if err != nil {
+		return err
+	}
+	sh.index.Put(key, offset, storage.RecordSize(key, value))
+	return nil
+}
+
+func (sh *Shard) get(key string, c *cache.Cache) (string, bool, error) {
+	offset, ok := sh.index.Get(key)
+	if !ok {
+		return "", false, nil
+	}
+	if c != nil {
+		if val, ok := c.Get(key, offset); ok {
+			return val, true, nil
+		}
+	}
+	_, val, err := sh.storage.ReadAt(offset)
+	if err != nil {
+		return "", false, err
+	}
+	if c != nil {
+		c.Put(key, offset, val)
+	}
+	return val, true, nil
+}
+
+// write 一次性锁住所有 key，一次追加写日志，再整体更新索引
+func (sh *Shard) write(entries []storage.Entry) error {
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+	}
+
+	unlock := sh.lm.LockKeys(keys)
+	defer unlock()
+
+	offsets, err := sh.storage.WriteBatch(entries)
+	if err != nil {
+		return err
+	}
+	sizes := make([]int64, len(entries))
+	for i, en := range entries {
+		sizes[i] = storage.RecordSize(en.Key, en.Value)
+	}
+	sh.index.PutBatch(keys, offsets, sizes)
+	return nil
+}
+
+// del 为存在的 key 追加删除标记，返回实际删除的 key（去重后）
+func (sh *Shard) del(keys []string) ([]string, error) {
+	unlock := sh.lm.LockKeys(keys)
+	defer unlock()
+
+	// 只为存在的 key 追加删除标记，重复的 key 只算一次（DEL a a 返回 1）
+	var tombstones []storage.Entry
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if _, ok := sh.index.Get(k); ok {
+			tombstones = append(tombstones, storage.Entry{Key: k, Deleted: true})
+		}
+	}
+	if len(tombstones) == 0 {
+		return nil, nil
+	}
+	if _, err := sh.storage.WriteBatch(tombstones); err != nil {
+		return nil, err
+	}
+	deleted := make([]string, len(tombstones))
+	for i, t := range tombstones {
+		sh.index.Delete(t.Key)
+		deleted[i] = t.Key
+	}
+	return deleted, nil
+}
+
+// compact 只保留每个 key 的最新版本重写日志，调用方需保证没有并发读写
+func (sh *Shard) compact() error {
+	snap := sh.index.Snapshot()
+	keys := make([]string, 0, len(snap))
+	for k := range snap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	entries := make([]storage.Entry, len(keys))
+	sizes := make([]int64, len(keys))
+	for i, k := range keys {
+		_, val, err := sh.storage.ReadAt(snap[k])
+		if err != nil {
+			return err
+		}
+		entries[i] = storage.Entry{Key: k, Value: val}
+		sizes[i] = storage.RecordSize(k, val)
+	}
+
+	offsets, err := sh.storage.Rewrite(entries)
+	if offsets != nil {
+		// 新日志已经生效（即使随后 fsync 目录失败），索引必须指向新的偏移量
+		sh.index.PutBatch(keys, offsets, sizes)
+	}
+	return err
+}
+
+// AddShard 加入一个新分片并做再平衡：只有在哈希环上改由新分片负责的 key 会被迁移，
+// 迁移期间阻塞所有读写。返回迁移的 key 数量。
+//
+// 新分片必须是空的。迁移不是原子的：每个 key 先写入新分片，再在旧分片上追加删除标记，
+// 中途崩溃会在两个分片上留下同一个 key，下次 Recover 会完成迁移。为此重启时必须用同样的分片数打开，
+// 配置了清单（UseManifest）时，新的分片数会在迁移开始前落盘，打开时分片数不一致会报错。
+func (e *Engine) AddShard(sh *Shard) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if sh.storage.Stats().Size > 0 || sh.index.Len() > 0 {
+		return 0, fmt.Errorf("new shard must be empty")
+	}
+	id := len(e.shards)
+	if e.manifest != nil {
+		if err := e.manifest.store(id + 1); err != nil {
+			return 0, err
+		}
+	}
+	e.shards = append(e.shards, sh)
+	e.ring.Add(id)
+
+	moved := 0
+	for from := range e.shards[:id] {
+		n, err := e.migrate(from)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	if e.cache != nil {
+		// 迁移后 key 所在分片和偏移量都变了
+		e.cache.Purge()
+	}
+	return moved, nil
+}
+
+// repair 把每个分片上不归它负责的 key 迁移走，用于恢复被崩溃打断的再平衡
+func (e *Engine) repair() error {
+	for from := range e.shards {
+		if _, err := e.migrate(from); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate 把分片 from 上不归它负责的 key 迁移到哈希环上负责它们的分片：先写入目标分片，
+// 再在 from 上追加删除标记。目标分片上已经有的 key 说明上次迁移在写入之后、删除之前崩溃了，
+// 目标分片上的版本更新，只需删除旧副本。返回写入目标分片的 key 数量。调用方需持有 e.mu 的写锁。
+func (e *Engine) migrate(from int) (int, error) {
+	old := e.shards[from]
+	snap := old.index.Snapshot()
+	var stray []string
+	for k := range snap {
+		if e.ring.Get(k) != from {
+			stray = append(stray, k)
+		}
+	}
+	if len(stray) == 0 {
+		return 0, nil
+	}
+	sort.Strings(stray)
+
+	targets := make(map[int][]storage.Entry)
+	for _, k := range stray {
+		to := e.ring.Get(k)
+		if _, ok := e.shards[to].index.Get(k); ok {
+			continue
+		}
+		_, val, err := old.storage.ReadAt(snap[k])
+		if err != nil {
+			return 0, err
+		}
+		targets[to] = append(targets[to], storage.Entry{Key: k, Value: val})
+	}
+
+	moved := 0
+	for to, entries := range targets {
+		if err := e.shards[to].write(entries); err != nil {
+			return moved, err
+		}
+		moved += len(entries)
+	}
+	if _, err := old.del(stray); err != nil {
+		return moved, err
+	}
+	return moved, nil
+}
@@ -0,0 +1,192 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ddia-labs/labs/14-simple-db/faultfs"
+	"github.com/ddia-labs/labs/14-simple-db/storage"
+)
+
+// openEngine 打开 n 个分片（shard-0.db ...）组成的引擎，配置清单并恢复索引
+func openEngine(t *testing.T, fs storage.FS, n int) *Engine {
+	t.Helper()
+	shards := make([]*Shard, n)
+	for i := range shards {
+		shards[i] = openShard(t, fs, fmt.Sprintf("shard-%d.db", i))
+	}
+	e := NewShardedEngine(shards...)
+	if err := e.UseManifest(fs, "shards.manifest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// checkOwnership 检查每个分片上只有哈希环分给它的 key，返回 key 的总数
+func checkOwnership(t *testing.T, e *Engine) int {
+	t.Helper()
+	total := 0
+	for id, sh := range e.shards {
+		for k := range sh.index.Snapshot() {
+			if owner := e.ring.Get(k); owner != id {
+				t.Fatalf("key %s is on shard %d, the ring assigns it to shard %d", k, id, owner)
+			}
+			total++
+		}
+	}
+	return total
+}
+
+// TestScatterGather 检查 MGET 按请求的顺序汇总各分片的结果，DEL 汇总各分片实际删除的 key 数
+func TestScatterGather(t *testing.T) {
+	e := openEngine(t, faultfs.New(1, faultfs.Options{}), 3)
+	for i := 0; i < 30; i++ {
+		exec(t, e, fmt.Sprintf("SET k%d v%d", i, i))
+	}
+	if st := e.Stats(); st.ShardKeys[0] == 0 || st.ShardKeys[1] == 0 || st.ShardKeys[2] == 0 {
+		t.Fatalf("keys are not spread over the shards: %v", st.ShardKeys)
+	}
+	if got, want := exec(t, e, "MGET k29 missing k0 k14 k0"), "v29 (nil) v0 v14 v0"; got != want {
+		t.Fatalf("MGET = %q, want %q", got, want)
+	}
+	if got := exec(t, e, "DEL k1 k2 k3 k4 k5 missing k1"); got != "5" {
+		t.Fatalf("DEL across shards = %s, want 5", got)
+	}
+	if got := exec(t, e, "MGET k1 k5 k6"); got != "(nil) (nil) v6" {
+		t.Fatalf("MGET after DEL = %q", got)
+	}
+	if n := checkOwnership(t, e); n != 25 {
+		t.Fatalf("%d keys left, want 25", n)
+	}
+}
+
+// TestCrossShardBatchRejected 检查跨分片的批次被整体拒绝、什么也不写，而用哈希标签放到同一分片的批次可以提交
+func TestCrossShardBatchRejected(t *testing.T) {
+	e := openEngine(t, faultfs.New(1, faultfs.Options{}), 3)
+	// 找两个落在不同分片上的 key
+	a, b := "k0", ""
+	for i := 1; b == ""; i++ {
+		if k := fmt.Sprintf("k%d", i); e.ring.Get(k) != e.ring.Get(a) {
+			b = k
+		}
+	}
+	if _, err := e.Execute("MSET " + a + " 1 " + b + " 2"); !errors.Is(err, ErrCrossShard) {
+		t.Fatalf("cross-shard MSET = %v, want ErrCrossShard", err)
+	}
+	batch := NewWriteBatch()
+	batch.Put(a, "1")
+	batch.Put(b, "2")
+	if err := e.Write(batch); !errors.Is(err, ErrCrossShard) {
+		t.Fatalf("cross-shard Write = %v, want ErrCrossShard", err)
+	}
+	if st := e.Stats(); st.Keys != 0 || st.Appends != 0 {
+		t.Fatalf("rejected batches wrote something: %d keys, %d appends", st.Keys, st.Appends)
+	}
+
+	ta, tb := "{"+a+"}:x", "{"+a+"}:"+b
+	if got := exec(t, e, "MSET "+ta+" 1 "+tb+" 2"); got != "OK" {
+		t.Fatalf("tagged MSET = %q", got)
+	}
+	if got := exec(t, e, "MGET "+ta+" "+tb); got != "1 2" {
+		t.Fatalf("MGET after tagged MSET = %q", got)
+	}
+	checkOwnership(t, e)
+}
+
+// TestAddShard 检查加入分片后只迁移哈希环改分给新分片的 key，所有值仍然可读，清单记录了新的分片数
+func TestAddShard(t *testing.T) {
+	fs := faultfs.New(1, faultfs.Options{})
+	e := openEngine(t, fs, 2)
+	const n = 200
+	for i := 0; i < n; i++ {
+		exec(t, e, fmt.Sprintf("SET k%d v%d", i, i))
+	}
+	before := make(map[string]int)
+	for i := 0; i < n; i++ {
+		k := fmt.Sprintf("k%d", i)
+		before[k] = e.ring.Get(k)
+	}
+
+	moved, err := e.AddShard(openShard(t, fs, "shard-2.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	for k, owner := range before {
+		switch now := e.ring.Get(k); {
+		case now == 2:
+			want++
+		case now != owner:
+			t.Fatalf("key %s moved from shard %d to old shard %d", k, owner, now)
+		}
+	}
+	if moved != want || moved == 0 {
+		t.Fatalf("AddShard moved %d keys, the ring reassigned %d", moved, want)
+	}
+	if got := checkOwnership(t, e); got != n {
+		t.Fatalf("%d keys after rebalancing, want %d", got, n)
+	}
+	checkValues(t, e, n)
+
+	if shards, ok, err := LoadManifest(fs, "shards.manifest"); err != nil || !ok || shards != 3 {
+		t.Fatalf("LoadManifest = %d, %v, %v; want 3 shards", shards, ok, err)
+	}
+	if _, err := e.AddShard(openShard(t, fs, "shard-1.db")); err == nil {
+		t.Fatal("AddShard accepted a shard that already holds data")
+	}
+}
+
+// TestManifestMismatch 检查用与清单不同的分片数打开时报错
+func TestManifestMismatch(t *testing.T) {
+	fs := faultfs.New(1, faultfs.Options{})
+	openEngine(t, fs, 2)
+	e := NewShardedEngine(openShard(t, fs, "shard-0.db"), openShard(t, fs, "shard-1.db"), openShard(t, fs, "shard-2.db"))
+	if err := e.UseManifest(fs, "shards.manifest"); err == nil {
+		t.Fatal("UseManifest accepted 3 shards for a manifest that records 2")
+	}
+}
+
+// TestRebalanceCrash 让 AddShard 在第 1、2、3……次写类文件操作时崩溃，直到它不再崩溃为止。
+// 每次崩溃后按清单记录的分片数重新打开并 Recover：被打断的迁移必须完成，每个 key 只留在负责它的分片上，值都不丢
+func TestRebalanceCrash(t *testing.T) {
+	const n = 60
+	for crashAt := 1; ; crashAt++ {
+		fs := faultfs.New(int64(crashAt), faultfs.Options{TornWrites: true})
+		e := openEngine(t, fs, 2)
+		for _, sh := range e.shards {
+			sh.storage.SetSyncWrites(true)
+		}
+		for i := 0; i < n; i++ {
+			exec(t, e, fmt.Sprintf("SET k%d v%d", i, i))
+		}
+
+		fs.CrashAfter(crashAt)
+		added := openShard(t, fs, "shard-2.db")
+		added.storage.SetSyncWrites(true)
+		_, err := e.AddShard(added)
+		if err == nil {
+			if crashAt == 1 {
+				t.Fatal("AddShard never reached a file operation")
+			}
+			return
+		}
+		if !fs.Crashed() {
+			t.Fatalf("crash point %d: AddShard failed without a crash: %v", crashAt, err)
+		}
+		fs.Crash()
+
+		shards, ok, err := LoadManifest(fs, "shards.manifest")
+		if err != nil || !ok {
+			t.Fatalf("crash point %d: LoadManifest = %v, %v", crashAt, ok, err)
+		}
+		e = openEngine(t, fs, shards)
+		if got := checkOwnership(t, e); got != n {
+			t.Fatalf("crash point %d: %d keys after recovery, want %d", crashAt, got, n)
+		}
+		checkValues(t, e, n)
+	}
+}
@@ -0,0 +1,131 @@
+package query
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ddia-labs/labs/14-simple-db/cache"
+	"github.com/ddia-labs/labs/14-simple-db/metrics"
+)
+
+// Stats 汇总各层的运行指标，多分片时为所有分片之和
+type Stats struct {
+	Shards       int     // 分片数量
+	ShardKeys    []int   // 每个分片上的 key 数量
+	Keys         int     // 索引中的 key 数量
+	LogSize      int64   // 日志文件大小
+	LiveBytes    int64   // 存活记录占用的字节数
+	DeadRatio    float64 // 被覆盖记录（垃圾）占日志的比例
+	BytesWritten int64
+	Appends      int64
+	Syncs        int64
+	LockAcquires int64
+	LockWaits    int64
+	LockWaitTime time.Duration
+	Compactions  int64
+	Cache        cache.Stats // 未开启缓存时为零值
+	Commands     []metrics.CommandStats
+}
+
+func (e *Engine) Stats() Stats {
+	e.mu.RLock()
+	shards, c := e.shards, e.cache
+	e.mu.RUnlock()
+
+	st := Stats{
+		Shards:      len(shards),
+		ShardKeys:   make([]int, len(shards)),
+		Compactions: atomic.LoadInt64(&e.compactions),
+		Commands:    e.cmds.Snapshot(),
+	}
+	for i, sh := range shards {
+		ss := sh.storage.Stats()
+		ls := sh.lm.Stats()
+		st.ShardKeys[i] = sh.index.Len()
+		st.Keys += st.ShardKeys[i]
+		st.LogSize += ss.Size
+		st.LiveBytes += sh.index.LiveBytes()
+		st.BytesWritten += ss.BytesWritten
+		st.Appends += ss.Appends
+		st.Syncs += ss.Syncs
+		st.LockAcquires += ls.Acquires
+		st.LockWaits += ls.Waits
+		st.LockWaitTime += ls.WaitTime
+	}
+	if c != nil {
+		st.Cache = c.Stats()
+	}
+	if st.LogSize > 0 {
+		st.DeadRatio = float64(st.LogSize-st.LiveBytes) / float64(st.LogSize)
+	}
+	return st
+}
+
+// String 将指标格式化为单行的 key=value 列表，作为 INFO/STATS 指令的回复
+func (s Stats) String() string {
+	fields := []string{
+		fmt.Sprintf("shards=%d", s.Shards),
+		fmt.Sprintf("keys=%d", s.Keys),
+		fmt.Sprintf("log_bytes=%d", s.LogSize),
+		fmt.Sprintf("live_bytes=%d", s.LiveBytes),
+		fmt.Sprintf("dead_ratio=%.2f", s.DeadRatio),
+		fmt.Sprintf("bytes_written=%d", s.BytesWritten),
+		fmt.Sprintf("appends=%d", s.Appends),
+		fmt.Sprintf("syncs=%d", s.Syncs),
+		fmt.Sprintf("lock_acquires=%d", s.LockAcquires),
+		fmt.Sprintf("lock_waits=%d", s.LockWaits),
+		fmt.Sprintf("lock_wait_us=%d", s.LockWaitTime.Microseconds()),
+		fmt.Sprintf("compactions=%d", s.Compactions),
+		fmt.Sprintf("cache_hits=%d", s.Cache.Hits),
+		fmt.Sprintf("cache_misses=%d", s.Cache.Misses),
+		fmt.Sprintf("cache_hit_ratio=%.2f", s.Cache.HitRatio()),
+		fmt.Sprintf("cache_evictions=%d", s.Cache.Evictions),
+		fmt.Sprintf("cache_bytes=%d", s.Cache.Bytes),
+		fmt.Sprintf("cache_max_bytes=%d", s.Cache.MaxBytes),
+	}
+	if s.Shards > 1 {
+		for i, n := range s.ShardKeys {
+			fields = append(fields, fmt.Sprintf("shard_%d_keys=%d", i, n))
+		}
+	}
+	for _, c := range s.Commands {
+		name := strings.ToLower(c.Name)
+		fields = append(fields,
+			fmt.Sprintf("cmd_%s_calls=%d", name, c.Latency.Count),
+			fmt.Sprintf("cmd_%s_errors=%d", name, c.Errors),
+			fmt.Sprintf("cmd_%s_p50_us=%.0f", name, c.Latency.Quantile(0.5)*1e6),
+			fmt.Sprintf("cmd_%s_p99_us=%.0f", name, c.Latency.Quantile(0.99)*1e6),
+		)
+	}
+	return strings.Join(fields, " ")
+}
+
+// WriteMetrics 以 Prometheus 文本格式输出所有指标
+func (e *Engine) WriteMetrics(w io.Writer) {
+	s := e.Stats()
+	metrics.WriteGauge(w, "simpledb_shards", "Number of shards.", float64(s.Shards))
+	metrics.WriteGauge(w, "simpledb_keys", "Number of keys in the index.", float64(s.Keys))
+	fmt.Fprintf(w, "# HELP simpledb_shard_keys Number of keys per shard.\n# TYPE simpledb_shard_keys gauge\n")
+	for i, n := range s.ShardKeys {
+		fmt.Fprintf(w, "simpledb_shard_keys{shard=\"%d\"} %d\n", i, n)
+	}
+	metrics.WriteGauge(w, "simpledb_log_bytes", "Size of the append-only log.", float64(s.LogSize))
+	metrics.WriteGauge(w, "simpledb_live_bytes", "Bytes of the log holding the latest version of a key.", float64(s.LiveBytes))
+	metrics.WriteGauge(w, "simpledb_dead_ratio", "Fraction of the log occupied by overwritten records.", s.DeadRatio)
+	metrics.WriteCounter(w, "simpledb_bytes_written_total", "Bytes appended to the log.", float64(s.BytesWritten))
+	metrics.WriteCounter(w, "simpledb_appends_total", "Append calls to the log.", float64(s.Appends))
+	metrics.WriteCounter(w, "simpledb_syncs_total", "fsync calls on the log.", float64(s.Syncs))
+	metrics.WriteCounter(w, "simpledb_lock_acquires_total", "Row lock acquisitions.", float64(s.LockAcquires))
+	metrics.WriteCounter(w, "simpledb_lock_waits_total", "Row lock acquisitions that had to wait.", float64(s.LockWaits))
+	metrics.WriteCounter(w, "simpledb_lock_wait_seconds_total", "Time spent waiting for row locks.", s.LockWaitTime.Seconds())
+	metrics.WriteCounter(w, "simpledb_compactions_total", "Log compaction runs.", float64(s.Compactions))
+	metrics.WriteCounter(w, "simpledb_cache_hits_total", "GETs served from the value cache.", float64(s.Cache.Hits))
+	metrics.WriteCounter(w, "simpledb_cache_misses_total", "GETs that had to read the log.", float64(s.Cache.Misses))
+	metrics.WriteCounter(w, "simpledb_cache_evictions_total", "Values evicted from the cache.", float64(s.Cache.Evictions))
+	metrics.WriteGauge(w, "simpledb_cache_bytes", "Memory charged to the value cache.", float64(s.Cache.Bytes))
+	metrics.WriteGauge(w, "simpledb_cache_max_bytes", "Memory budget of the value cache.", float64(s.Cache.MaxBytes))
+	metrics.WriteCommandMetrics(w, "simpledb", s.Commands)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ddia-labs/labs/14-simple-db/query"
+)
+
+// Server 通过一个简单的文本协议对外提供 Engine:
+// 每行一条指令，每条指令对应一行回复（成功原样返回结果，失败返回 "ERR <msg>"）。
+//
+// 协议支持流水线（Pipelining）：客户端可以连续发送多条指令而不必等待回复，
+// 服务端按顺序执行，并在读缓冲区里的指令都处理完后才统一 Flush 回复，
+// 从而把多次网络往返合并成一次。
+type Server struct {
+	engine *query.Engine
+}
+
+func NewServer(engine *query.Engine) *Server {
+	return &Server{engine: engine}
+}
+
+// ListenAndServe 监听 TCP 地址并处理连接，直到监听出错
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 在给定的 Listener 上接受连接，每个连接一个 goroutine
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			w.Flush()
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		result, err := s.engine.Execute(line)
+		if err != nil {
+			fmt.Fprintf(w, "ERR %v\n", err)
+		} else {
+			fmt.Fprintf(w, "%s\n", result)
+		}
+
+		// 缓冲区中还有已到达的指令时继续处理，批量回复
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// MetricsHandler 返回一个以 Prometheus 文本格式暴露引擎指标的 HTTP Handler，
+// 通常挂载在 /metrics 路径上
+func MetricsHandler(engine *query.Engine) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		engine.WriteMetrics(w)
+	})
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"io"
+	"os"
+)
+
+// FS 抽象了存储层用到的文件系统操作，默认使用操作系统的文件系统，
+// 测试时可以替换成能注入故障的实现（见 faultfs 包）
+type FS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	// SyncDir 把目录项的修改（创建、rename）持久化，相当于对目录执行 fsync
+	SyncDir(dir string) error
+}
+
+// File 是存储层需要的文件操作，*os.File 满足该接口
+type File interface {
+	io.Writer
+	io.ReaderAt
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// OSFS 是基于 os 包的 FS 实现
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
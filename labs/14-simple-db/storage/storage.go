@@ -1,69 +1,369 @@
 package storage
 
 import (
-	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"os"
-	"strings"
+	"path/filepath"
 	"sync"
 )
 
+// Entry 是一条待写入的 Key-Value 记录。Deleted 为 true 时是删除标记（Tombstone），Value 被忽略
+type Entry struct {
+	Key     string
+	Value   string
+	Deleted bool
+}
+
+// 日志格式：每次追加（单条写入或一个批次）写成一帧，整数都是大端序
+//
+//	帧头: 记录数(4) | 帧体长度(4) | crc32(4)，CRC 覆盖记录数、帧体长度和帧体
+//	记录: 类型(1) | key 长度(4) | value 长度(4) | key | value
+//	...
+//
+// 类型是 recordValue 或 recordTombstone（删除标记，value 为空）。key 和 value 按长度读取，
+// 可以包含任意字节（包括 '\n' 和 '|'），任何 value 都不会被误认为删除标记。
+// 打开日志时按帧校验，遇到不完整或校验失败的帧（崩溃时被撕裂的写入）就从该帧开始截断，
+// 所以一个批次要么整体可见，要么整体丢失。索引中的偏移量指向帧体中的记录头。
+const (
+	frameHeaderSize  = 12
+	recordHeaderSize = 9
+)
+
+const (
+	recordValue     byte = 1
+	recordTombstone byte = 2
+)
+
+// ErrFailed 表示之前的一次追加失败后无法确定日志的状态（写了一半的帧没能截掉，或者 fsync 失败），
+// 之后的写入全部被拒绝。重新打开日志会按帧校验并截掉不完整的尾部，恢复到一致的状态
+var ErrFailed = errors.New("storage: log is unusable after a failed append, reopen it")
+
 type DiskStorage struct {
-	file   *os.File
+	fs     FS
+	path   string
+	file   File
 	mu     sync.Mutex
 	offset int64
+	// syncWrites 为 true 时每次追加后都会 fsync（持久化模式）
+	syncWrites bool
+
+	// 统计信息
+	bytesWritten int64
+	appends      int64
+	syncs        int64
+	// truncated 是打开时因撕裂写入而被截断的字节数
+	truncated int64
+	// failed 非空时存储已失效，写入返回包装了 ErrFailed 的错误
+	failed error
+}
+
+// Stats 是存储层的统计快照
+type Stats struct {
+	Size         int64 // 日志文件当前大小
+	BytesWritten int64 // 自打开以来追加写入的字节数（含压缩重写）
+	Appends      int64 // 追加写入次数
+	Syncs        int64 // fsync 次数
+	Truncated    int64 // 打开时截断的损坏尾部字节数
+}
+
+// RecordSize 返回一条记录在日志中占用的字节数（不含帧头）
+func RecordSize(key, value string) int64 {
+	return int64(recordHeaderSize + len(key) + len(value))
 }
 
 func NewDiskStorage(path string) (*DiskStorage, error) {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	return OpenDiskStorage(OSFS, path)
+}
+
+// OpenDiskStorage 在给定的文件系统上打开日志，并截断崩溃留下的不完整尾部
+func OpenDiskStorage(fs FS, path string) (*DiskStorage, error) {
+	f, err := fs.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
-	stat, _ := f.Stat()
-	return &DiskStorage{
-		file:   f,
-		offset: stat.Size(),
-	}, nil
+
+	s := &DiskStorage{fs: fs, path: path, file: f}
+	valid, err := s.scan(stat.Size(), nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if valid < stat.Size() {
+		if err := f.Truncate(valid); err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.truncated = stat.Size() - valid
+	}
+	s.offset = valid
+	return s, nil
 }
 
-func (s *DiskStorage) Write(key, value string) (int64, error) {
+// SetSyncWrites 开启/关闭持久化模式：开启后每次追加都会调用一次 fsync
+func (s *DiskStorage) SetSyncWrites(sync bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.syncWrites = sync
+}
 
-	currOffset := s.offset
-	// 使用 | 作为 Key 和 Value 的分隔符，减少冲突
-	data := fmt.Sprintf("%s|%s\n", key, value)
-	n, err := s.file.WriteString(data)
+func (s *DiskStorage) Write(key, value string) (int64, error) {
+	offsets, err := s.WriteBatch([]Entry{{Key: key, Value: value}})
 	if err != nil {
 		return 0, err
 	}
+	return offsets[0], nil
+}
+
+// encodeFrame 把一组记录编码成一帧，返回帧数据和每条记录相对帧起点的偏移量
+func encodeFrame(entries []Entry) ([]byte, []int64) {
+	frame := make([]byte, frameHeaderSize)
+	rel := make([]int64, len(entries))
+	for i, e := range entries {
+		rel[i] = int64(len(frame))
+		kind, value := recordValue, e.Value
+		if e.Deleted {
+			kind, value = recordTombstone, ""
+		}
+		frame = append(frame, kind)
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(e.Key)))
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(value)))
+		frame = append(frame, e.Key...)
+		frame = append(frame, value...)
+	}
+	body := frame[frameHeaderSize:]
+	binary.BigEndian.PutUint32(frame[0:], uint32(len(entries)))
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(body)))
+	binary.BigEndian.PutUint32(frame[8:], frameChecksum(frame[:8], body))
+	return frame, rel
+}
+
+func frameChecksum(header, body []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, body)
+}
 
+// decodeRecord 解析 buf 开头的一条记录，返回记录和它的长度；buf 不足一条完整记录或类型未知时 ok 为 false
+func decodeRecord(buf []byte) (e Entry, n int, ok bool) {
+	if len(buf) < recordHeaderSize {
+		return Entry{}, 0, false
+	}
+	kind := buf[0]
+	klen := int64(binary.BigEndian.Uint32(buf[1:]))
+	vlen := int64(binary.BigEndian.Uint32(buf[5:]))
+	end := recordHeaderSize + klen + vlen
+	if (kind != recordValue && kind != recordTombstone) || end > int64(len(buf)) {
+		return Entry{}, 0, false
+	}
+	e.Key = string(buf[recordHeaderSize : recordHeaderSize+klen])
+	e.Value = string(buf[recordHeaderSize+klen : end])
+	e.Deleted = kind == recordTombstone
+	return e, int(end), true
+}
+
+// WriteBatch 将多条记录编码成一帧，通过一次追加写入磁盘
+// （持久化模式下也只 fsync 一次），返回每条记录的起始偏移量。
+// 写入或 fsync 失败时把日志截断回这一帧之前。日志以追加模式打开，截断失败时下一帧会接在写了一半的帧后面，
+// s.offset 和之后返回的偏移量全都错位，所以存储随之失效（见 ErrFailed）。
+// fsync 失败后即使截断成功也让存储失效：页缓存中哪些数据已经落盘无从得知
+func (s *DiskStorage) WriteBatch(entries []Entry) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed != nil {
+		return nil, s.failed
+	}
+
+	frame, offsets := encodeFrame(entries)
+	for i := range offsets {
+		offsets[i] += s.offset
+	}
+
+	n, err := s.file.Write(frame)
+	if err != nil {
+		// 丢掉写了一半的帧，避免后续追加接在损坏的数据后面
+		s.rollback(err)
+		return nil, err
+	}
+	s.appends++
+	s.bytesWritten += int64(n)
+	if s.syncWrites {
+		if err := s.file.Sync(); err != nil {
+			// 已经返回失败的写入不能在重启后重新出现
+			if s.rollback(err) {
+				s.failed = fmt.Errorf("%w: fsync failed: %v", ErrFailed, err)
+			}
+			return nil, err
+		}
+		s.syncs++
+	}
 	s.offset += int64(n)
-	return currOffset, nil
+	return offsets, nil
 }
 
-func (s *DiskStorage) ReadAt(offset int64) (string, string, error) {
-	f, err := os.Open(s.file.Name())
+// rollback 在一次追加失败后把日志截断回 s.offset，截断失败时让存储失效并返回 false
+func (s *DiskStorage) rollback(cause error) bool {
+	if err := s.file.Truncate(s.offset); err != nil {
+		s.failed = fmt.Errorf("%w: append failed (%v) and truncating it failed: %v", ErrFailed, cause, err)
+		return false
+	}
+	return true
+}
+
+// Rewrite 用给定的存活记录重写整个日志（Bitcask 的 merge/compaction）：
+// 先写入临时文件并 fsync，再原子地 rename 覆盖旧日志并 fsync 所在目录，返回每条记录的新偏移量。
+// 临时文件以追加模式打开，rename 之后直接作为新的日志句柄，rename 成功之前旧日志一直保持打开，
+// 所以任何一步失败时 DiskStorage 仍然可以继续使用旧日志。只有最后 fsync 目录失败时新日志已经生效，
+// 这时同时返回新的偏移量和错误，调用方仍需按新的偏移量更新索引。
+// 调用方需保证重写期间没有并发的读写。存储已失效时同样返回错误。
+func (s *DiskStorage) Rewrite(entries []Entry) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed != nil {
+		return nil, s.failed
+	}
+
+	tmpPath := s.path + ".compact"
+	tmp, err := s.fs.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0666)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	defer f.Close()
 
-	_, err = f.Seek(offset, 0)
+	var frame []byte
+	offsets := []int64{}
+	if len(entries) > 0 {
+		frame, offsets = encodeFrame(entries)
+	}
+	_, err = tmp.Write(frame)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = s.fs.Rename(tmpPath, s.path)
+	}
 	if err != nil {
-		return "", "", err
+		// 旧日志仍然完好且保持打开
+		tmp.Close()
+		s.fs.Remove(tmpPath)
+		return nil, err
 	}
 
-	// 使用 Scanner 读取一行，更稳健
-	scanner := bufio.NewScanner(f)
-	if scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, "|", 2)
-		if len(parts) == 2 {
-			return parts[0], parts[1], nil
+	// rename 已经生效：从此读写都使用新日志
+	s.file.Close()
+	s.file = tmp
+	s.offset = int64(len(frame))
+	s.bytesWritten += int64(len(frame))
+	s.syncs++
+	// 目录项也落盘之后，崩溃重启才一定看到新日志
+	if err := s.fs.SyncDir(filepath.Dir(s.path)); err != nil {
+		return offsets, err
+	}
+	s.syncs++
+	return offsets, nil
+}
+
+func (s *DiskStorage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{
+		Size:         s.offset,
+		BytesWritten: s.bytesWritten,
+		Appends:      s.appends,
+		Syncs:        s.syncs,
+		Truncated:    s.truncated,
+	}
+}
+
+// Scan 按写入顺序遍历日志中的每条记录（包括删除标记），用于重启后重建索引
+func (s *DiskStorage) Scan(fn func(e Entry, offset int64)) error {
+	s.mu.Lock()
+	size := s.offset
+	s.mu.Unlock()
+	_, err := s.scan(size, fn)
+	return err
+}
+
+// scan 逐帧校验日志的前 size 个字节，返回最后一个完整帧的结束位置
+func (s *DiskStorage) scan(size int64, fn func(e Entry, offset int64)) (int64, error) {
+	var pos int64
+	header := make([]byte, frameHeaderSize)
+	for pos+frameHeaderSize <= size {
+		if _, err := s.file.ReadAt(header, pos); err != nil {
+			return pos, nil
+		}
+		count := binary.BigEndian.Uint32(header[0:])
+		bodyLen := int64(binary.BigEndian.Uint32(header[4:]))
+		sum := binary.BigEndian.Uint32(header[8:])
+		if bodyLen > size-pos-frameHeaderSize {
+			return pos, nil
+		}
+		body := make([]byte, bodyLen)
+		if _, err := s.file.ReadAt(body, pos+frameHeaderSize); err != nil {
+			return pos, nil
+		}
+		if frameChecksum(header[:8], body) != sum {
+			return pos, nil
+		}
+
+		// 校验通过的帧体必须恰好由 count 条记录组成
+		type record struct {
+			e   Entry
+			off int64
+		}
+		records := make([]record, 0, min(int64(count), bodyLen/recordHeaderSize))
+		off, rest := pos+frameHeaderSize, body
+		for i := uint32(0); i < count; i++ {
+			e, n, ok := decodeRecord(rest)
+			if !ok {
+				return pos, fmt.Errorf("malformed record at offset %d", off)
+			}
+			records = append(records, record{e, off})
+			off, rest = off+int64(n), rest[n:]
+		}
+		if len(rest) != 0 {
+			return pos, fmt.Errorf("malformed frame at offset %d: %d trailing bytes", pos, len(rest))
 		}
+		if fn != nil {
+			for _, r := range records {
+				fn(r.e, r.off)
+			}
+		}
+		pos += frameHeaderSize + bodyLen
+	}
+	return pos, nil
+}
+
+func (s *DiskStorage) ReadAt(offset int64) (string, string, error) {
+	s.mu.Lock()
+	f, size := s.file, s.offset
+	s.mu.Unlock()
+	if offset < 0 || offset+recordHeaderSize > size {
+		return "", "", fmt.Errorf("read failed at offset %d", offset)
+	}
+
+	// 先读记录头得到 key 和 value 的长度，再读出整条记录
+	header := make([]byte, recordHeaderSize)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return "", "", fmt.Errorf("read failed at offset %d: %v", offset, err)
+	}
+	n := recordHeaderSize + int64(binary.BigEndian.Uint32(header[1:])) + int64(binary.BigEndian.Uint32(header[5:]))
+	if offset+n > size {
+		return "", "", fmt.Errorf("read failed at offset %d", offset)
+	}
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return "", "", fmt.Errorf("read failed at offset %d: %v", offset, err)
+	}
+	e, _, ok := decodeRecord(buf)
+	if !ok || e.Deleted {
+		return "", "", fmt.Errorf("read failed at offset %d", offset)
 	}
-	return "", "", fmt.Errorf("read failed at offset %d", offset)
+	return e.Key, e.Value, nil
 }
 
 func (s *DiskStorage) Close() {
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var errInjected = errors.New("injected I/O error")
+
+// faultyFS 包装 OSFS，让打开的文件按开关注入错误
+type faultyFS struct {
+	failWrite, failSync, failTruncate bool
+}
+
+func (fs *faultyFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := OSFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: f, fs: fs}, nil
+}
+
+func (fs *faultyFS) Rename(oldpath, newpath string) error { return OSFS.Rename(oldpath, newpath) }
+func (fs *faultyFS) Remove(name string) error             { return OSFS.Remove(name) }
+func (fs *faultyFS) SyncDir(dir string) error             { return OSFS.SyncDir(dir) }
+
+type faultyFile struct {
+	File
+	fs *faultyFS
+}
+
+// Write 出错时已经写入了一半的数据
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.fs.failWrite {
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, errInjected
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.fs.failSync {
+		return errInjected
+	}
+	return f.File.Sync()
+}
+
+func (f *faultyFile) Truncate(size int64) error {
+	if f.fs.failTruncate {
+		return errInjected
+	}
+	return f.File.Truncate(size)
+}
+
+// keys 重新打开日志，返回其中按顺序出现的 key
+func keys(t *testing.T, path string) []string {
+	t.Helper()
+	s, err := NewDiskStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var out []string
+	if err := s.Scan(func(e Entry, offset int64) { out = append(out, e.Key) }); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// TestFailedWriteIsTruncated 检查写了一半的帧被截掉，之后的追加仍然从正确的偏移量开始
+func TestFailedWriteIsTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	fs := &faultyFS{}
+	s, err := OpenDiskStorage(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	fs.failWrite = true
+	if _, err := s.Write("b", "2"); !errors.Is(err, errInjected) {
+		t.Fatalf("Write = %v, want the injected error", err)
+	}
+	fs.failWrite = false
+	off, err := s.Write("c", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, v, err := s.ReadAt(off); err != nil || k != "c" || v != "3" {
+		t.Fatalf("ReadAt(%d) = %q, %q, %v", off, k, v, err)
+	}
+	s.Close()
+	if got := keys(t, path); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("after reopen: %q, want [a c]", got)
+	}
+}
+
+// TestFailedTruncateFailsStorage 检查截断失败之后拒绝所有写入，而不是把下一帧接在写了一半的帧后面
+func TestFailedTruncateFailsStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	fs := &faultyFS{}
+	s, err := OpenDiskStorage(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	fs.failWrite, fs.failTruncate = true, true
+	if _, err := s.Write("b", "2"); err == nil {
+		t.Fatal("Write succeeded")
+	}
+	*fs = faultyFS{}
+	if _, err := s.Write("c", "3"); !errors.Is(err, ErrFailed) {
+		t.Fatalf("Write after a failed truncate = %v, want ErrFailed", err)
+	}
+	if _, err := s.Rewrite(nil); !errors.Is(err, ErrFailed) {
+		t.Fatalf("Rewrite after a failed truncate = %v, want ErrFailed", err)
+	}
+	s.Close()
+	// 重新打开时写了一半的帧被当作撕裂的尾部截掉
+	if got := keys(t, path); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("after reopen: %q, want [a]", got)
+	}
+}
+
+// TestFailedSyncIsRolledBack 检查 fsync 失败的批次被截掉（重启后不会出现），并且存储随之失效
+func TestFailedSyncIsRolledBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	fs := &faultyFS{}
+	s, err := OpenDiskStorage(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetSyncWrites(true)
+	if _, err := s.Write("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	fs.failSync = true
+	if _, err := s.WriteBatch([]Entry{{Key: "b", Value: "2"}, {Key: "c", Value: "3"}}); !errors.Is(err, errInjected) {
+		t.Fatalf("WriteBatch = %v, want the injected error", err)
+	}
+	fs.failSync = false
+	if _, err := s.Write("d", "4"); !errors.Is(err, ErrFailed) {
+		t.Fatalf("Write after a failed fsync = %v, want ErrFailed", err)
+	}
+	if st := s.Stats(); st.Size != int64(frameHeaderSize)+RecordSize("a", "1") {
+		t.Fatalf("Size = %d after the rollback", st.Size)
+	}
+	s.Close()
+	if got := keys(t, path); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("after reopen: %q, want [a]", got)
+	}
+}
@@ -1,12 +1,27 @@
 package transaction
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // LockManager handles row-level concurrency control
 type LockManager struct {
 	locks sync.Map
+
+	// contention statistics
+	acquires int64
+	waits    int64
+	waitNs   int64
+}
+
+// Stats is a snapshot of lock contention counters
+type Stats struct {
+	Acquires int64         // total lock acquisitions
+	Waits    int64         // acquisitions that found the lock already held
+	WaitTime time.Duration // total time spent blocked
 }
 
 func NewLockManager() *LockManager {
@@ -17,7 +32,47 @@ func NewLockManager() *LockManager {
 func (lm *LockManager) LockKey(key string) func() {
 	l, _ := lm.locks.LoadOrStore(key, &sync.Mutex{})
 	mtx := l.(*sync.Mutex)
-	mtx.Lock()
+	atomic.AddInt64(&lm.acquires, 1)
+	if !mtx.TryLock() {
+		// Lock is held by someone else: record the contention
+		start := time.Now()
+		mtx.Lock()
+		atomic.AddInt64(&lm.waits, 1)
+		atomic.AddInt64(&lm.waitNs, int64(time.Since(start)))
+	}
 	// Return an unlock function for easy deferring
 	return func() { mtx.Unlock() }
 }
+
+// LockKeys acquires the locks of several keys at once. Keys are deduplicated
+// and locked in sorted order so concurrent batches cannot deadlock.
+func (lm *LockManager) LockKeys(keys []string) func() {
+	sorted := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+
+	unlocks := make([]func(), 0, len(sorted))
+	for _, k := range sorted {
+		unlocks = append(unlocks, lm.LockKey(k))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// Stats returns the lock contention counters
+func (lm *LockManager) Stats() Stats {
+	return Stats{
+		Acquires: atomic.LoadInt64(&lm.acquires),
+		Waits:    atomic.LoadInt64(&lm.waits),
+		WaitTime: time.Duration(atomic.LoadInt64(&lm.waitNs)),
+	}
+}
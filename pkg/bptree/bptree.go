@@ -0,0 +1,450 @@
+// Package bptree 实现了一个存放在页文件中的 B+tree：
+// 所有键值都在叶子节点中，叶子节点之间有双向兄弟指针以支持范围扫描，
+// 节点通过 pager.BufferPool 读写，因此可以观察到真实的页 I/O。
+//
+// 每次 Put/Delete 是一个原子操作：结束时把修改过的页提交到页级 WAL（path + ".wal"），
+// 进程在分裂进行到一半时崩溃，重新打开后树仍然停留在最后一次提交后的完整状态；
+// 操作中途出错（例如 I/O 错误或缓冲池已满）时撤销已经修改的页，效果与崩溃相同。
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ddia-labs/pkg/pager"
+)
+
+var ErrValueTooLarge = errors.New("bptree: value too large")
+
+// MinPoolPages 是缓冲池的最小页数：一次操作修改过的页在提交前不能被淘汰，
+// 缓冲池至少要能同时容纳一次分裂沿途修改的所有页
+const MinPoolPages = 16
+
+// Tree 是磁盘上的 B+tree
+type Tree struct {
+	pool   *pager.BufferPool
+	splits int64
+
+	// splitHook 在分裂写到一半时被调用，用于崩溃测试
+	splitHook func()
+}
+
+// Stats 汇总了树的形状以及页 I/O 统计
+type Stats struct {
+	Height    int
+	Pages     int // 页文件中的页数（含元数据页和空闲页）
+	FreePages int
+	Splits    int64 // 自打开以来的分裂次数
+	pager.Stats
+	pager.PoolStats
+	WAL pager.WALStats
+}
+
+// Open 打开（或创建）path 处的 B+tree，缓冲池最多缓存 poolPages 个页。
+// 如果上次没有正常关闭，会先用 WAL 重做已提交的操作。
+func Open(path string, poolPages int) (*Tree, error) {
+	p, err := pager.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	wal, err := pager.OpenWAL(p, path+".wal")
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	t := &Tree{pool: pager.NewBufferPool(p, max(poolPages, MinPoolPages))}
+	t.pool.SetWAL(wal)
+	if p.Root() == pager.InvalidPage {
+		// 新文件：根节点是一个空叶子
+		err := t.atomic(func() error {
+			id, err := t.newNode(&node{leaf: true})
+			p.SetRoot(id)
+			return err
+		})
+		if err != nil {
+			t.pool.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// SetSyncCommits 设置每次提交后是否 fsync WAL（默认开启）
+func (t *Tree) SetSyncCommits(sync bool) { t.pool.WAL().SetSync(sync) }
+
+// SetCheckpointBytes 设置 WAL 超过多少字节后做检查点（默认 pager.DefaultCheckpointBytes）
+func (t *Tree) SetCheckpointBytes(n int64) { t.pool.SetCheckpointBytes(n) }
+
+// SetSplitHook 注册一个在分裂过程中（两半节点都已写入、父节点还不知道新节点时）
+// 调用的函数，崩溃测试用它在分裂中途杀掉进程
+func (t *Tree) SetSplitHook(fn func()) { t.splitHook = fn }
+
+func (t *Tree) root() pager.PageID { return t.pool.Pager().Root() }
+
+// atomic 执行一次修改并提交。op 或提交失败时撤销本次修改过的页和元数据，
+// 树回到上一次提交后的状态，改了一半的页不会被之后的提交带进日志
+func (t *Tree) atomic(op func() error) error {
+	err := op()
+	if err == nil {
+		err = t.pool.Commit()
+	}
+	if err != nil {
+		if aerr := t.pool.Abort(); aerr != nil {
+			return fmt.Errorf("%w (abort: %v)", err, aerr)
+		}
+	}
+	return err
+}
+
+func (t *Tree) readNode(id pager.PageID) (*node, error) {
+	pg, err := t.pool.Fetch(id)
+	if err != nil {
+		return nil, err
+	}
+	defer t.pool.Unpin(pg, false)
+	return decode(pg.Data[:])
+}
+
+func (t *Tree) writeNode(id pager.PageID, n *node) error {
+	pg, err := t.pool.Fetch(id)
+	if err != nil {
+		return err
+	}
+	n.encode(pg.Data[:])
+	t.pool.Unpin(pg, true)
+	return nil
+}
+
+func (t *Tree) newNode(n *node) (pager.PageID, error) {
+	pg, err := t.pool.NewPage()
+	if err != nil {
+		return pager.InvalidPage, err
+	}
+	n.encode(pg.Data[:])
+	t.pool.Unpin(pg, true)
+	return pg.ID, nil
+}
+
+// childIndex 返回 key 所在子树的下标
+func (n *node) childIndex(key int) int {
+	return sort.Search(len(n.keys), func(i int) bool { return n.keys[i] > key })
+}
+
+// findLeaf 从根节点下降到可能包含 key 的叶子
+func (t *Tree) findLeaf(key int) (pager.PageID, *node, error) {
+	id := t.root()
+	for {
+		n, err := t.readNode(id)
+		if err != nil {
+			return pager.InvalidPage, nil, err
+		}
+		if n.leaf {
+			return id, n, nil
+		}
+		id = n.children[n.childIndex(key)]
+	}
+}
+
+// Get 查找 key
+func (t *Tree) Get(key int) (string, bool, error) {
+	_, n, err := t.findLeaf(key)
+	if err != nil {
+		return "", false, err
+	}
+	i := sort.SearchInts(n.keys, key)
+	if i < len(n.keys) && n.keys[i] == key {
+		return n.values[i], true, nil
+	}
+	return "", false, nil
+}
+
+// Put 插入或更新 key。叶子节点放不下时分裂，并把右半部分的最小键复制到父节点
+func (t *Tree) Put(key int, value string) error {
+	if len(value) > MaxValueSize {
+		return ErrValueTooLarge
+	}
+	return t.atomic(func() error { return t.put(key, value) })
+}
+
+func (t *Tree) put(key int, value string) error {
+	sep, right, err := t.insert(t.root(), key, value)
+	if err != nil || right == pager.InvalidPage {
+		return err
+	}
+	// 根节点分裂：树长高一层
+	rootID, err := t.newNode(&node{keys: []int{sep}, children: []pager.PageID{t.root(), right}})
+	if err != nil {
+		return err
+	}
+	t.pool.Pager().SetRoot(rootID)
+	return nil
+}
+
+// insert 把 key 插入以 id 为根的子树，如果该节点分裂，返回分隔键和新的右节点
+func (t *Tree) insert(id pager.PageID, key int, value string) (int, pager.PageID, error) {
+	n, err := t.readNode(id)
+	if err != nil {
+		return 0, pager.InvalidPage, err
+	}
+
+	if n.leaf {
+		i := sort.SearchInts(n.keys, key)
+		if i < len(n.keys) && n.keys[i] == key {
+			n.values[i] = value
+		} else {
+			n.keys = insertAt(n.keys, i, key)
+			n.values = insertAt(n.values, i, value)
+		}
+	} else {
+		i := n.childIndex(key)
+		sep, right, err := t.insert(n.children[i], key, value)
+		if err != nil || right == pager.InvalidPage {
+			return 0, pager.InvalidPage, err
+		}
+		n.keys = insertAt(n.keys, i, sep)
+		n.children = insertAt(n.children, i+1, right)
+	}
+
+	if n.size() <= pager.PageSize {
+		return 0, pager.InvalidPage, t.writeNode(id, n)
+	}
+	return t.split(id, n)
+}
+
+// split 把放不下的节点一分为二，左半部分留在原页，右半部分写入新页
+func (t *Tree) split(id pager.PageID, n *node) (int, pager.PageID, error) {
+	right := &node{leaf: n.leaf}
+	var sep int
+	if n.leaf {
+		// 按字节数找到中点，值长短不一时两半也都能放进一页
+		half, sz, mid := n.size()/2, leafHeader, 0
+		for mid < len(n.keys)-1 && sz < half {
+			sz += leafEntryFixed + len(n.values[mid])
+			mid++
+		}
+		right.keys = append([]int(nil), n.keys[mid:]...)
+		right.values = append([]string(nil), n.values[mid:]...)
+		n.keys, n.values = n.keys[:mid], n.values[:mid]
+		sep = right.keys[0]
+	} else {
+		// 内部节点的中间键上移到父节点，不再保留在子节点中
+		mid := len(n.keys) / 2
+		sep = n.keys[mid]
+		right.keys = append([]int(nil), n.keys[mid+1:]...)
+		right.children = append([]pager.PageID(nil), n.children[mid+1:]...)
+		n.keys, n.children = n.keys[:mid], n.children[:mid+1]
+	}
+
+	if n.leaf {
+		right.prev, right.next = id, n.next
+	}
+	rightID, err := t.newNode(right)
+	if err != nil {
+		return 0, pager.InvalidPage, err
+	}
+	if n.leaf {
+		// 维护叶子之间的双向链表
+		if n.next != pager.InvalidPage {
+			nx, err := t.readNode(n.next)
+			if err != nil {
+				return 0, pager.InvalidPage, err
+			}
+			nx.prev = rightID
+			if err := t.writeNode(n.next, nx); err != nil {
+				return 0, pager.InvalidPage, err
+			}
+		}
+		n.next = rightID
+	}
+	if err := t.writeNode(id, n); err != nil {
+		return 0, pager.InvalidPage, err
+	}
+	t.splits++
+	if t.splitHook != nil {
+		t.splitHook()
+	}
+	return sep, rightID, nil
+}
+
+// Delete 删除 key，返回 key 是否存在。
+// 简化：不做借位与合并，只有叶子节点被删空时才把它从树中摘除并回收页
+func (t *Tree) Delete(key int) (bool, error) {
+	var found bool
+	err := t.atomic(func() (err error) {
+		found, err = t.del(key)
+		return err
+	})
+	return found, err
+}
+
+func (t *Tree) del(key int) (bool, error) {
+	found, empty, err := t.delete(t.root(), key)
+	if err != nil || !found {
+		return found, err
+	}
+	if empty {
+		// 根节点本身被删空：把它重置为一个空叶子
+		return true, t.writeNode(t.root(), &node{leaf: true})
+	}
+
+	// 根节点只剩一个子节点时，树高度减一
+	for {
+		root, err := t.readNode(t.root())
+		if err != nil {
+			return true, err
+		}
+		if root.leaf || len(root.children) > 1 {
+			return true, nil
+		}
+		old := t.root()
+		t.pool.Pager().SetRoot(root.children[0])
+		if err := t.pool.FreePage(old); err != nil {
+			return true, err
+		}
+	}
+}
+
+// delete 返回 key 是否被删除，以及节点 id 是否因此变空（此时调用方负责回收它）
+func (t *Tree) delete(id pager.PageID, key int) (bool, bool, error) {
+	n, err := t.readNode(id)
+	if err != nil {
+		return false, false, err
+	}
+
+	if n.leaf {
+		i := sort.SearchInts(n.keys, key)
+		if i == len(n.keys) || n.keys[i] != key {
+			return false, false, nil
+		}
+		n.keys, n.values = removeAt(n.keys, i), removeAt(n.values, i)
+		if len(n.keys) == 0 && id != t.root() {
+			return true, true, t.unlinkLeaf(n)
+		}
+		return true, false, t.writeNode(id, n)
+	}
+
+	i := n.childIndex(key)
+	found, childEmpty, err := t.delete(n.children[i], key)
+	if err != nil || !childEmpty {
+		return found, false, err
+	}
+
+	// 子节点被删空：回收它，并删掉对应的分隔键
+	if err := t.pool.FreePage(n.children[i]); err != nil {
+		return found, false, err
+	}
+	n.children = removeAt(n.children, i)
+	if len(n.children) == 0 {
+		// 只有一个子节点的内部节点也被删空了，交给上一层回收
+		return found, true, nil
+	}
+	n.keys = removeAt(n.keys, max(i-1, 0))
+	return found, false, t.writeNode(id, n)
+}
+
+// unlinkLeaf 把空叶子从兄弟链表中摘除
+func (t *Tree) unlinkLeaf(n *node) error {
+	if n.prev != pager.InvalidPage {
+		p, err := t.readNode(n.prev)
+		if err != nil {
+			return err
+		}
+		p.next = n.next
+		if err := t.writeNode(n.prev, p); err != nil {
+			return err
+		}
+	}
+	if n.next != pager.InvalidPage {
+		nx, err := t.readNode(n.next)
+		if err != nil {
+			return err
+		}
+		nx.prev = n.prev
+		if err := t.writeNode(n.next, nx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan 按键的顺序遍历 [start, end] 内的键值，fn 返回 false 时停止。
+// 只在定位起点时从根节点下降一次，之后沿着叶子的兄弟指针向右移动。
+func (t *Tree) Scan(start, end int, fn func(key int, value string) bool) error {
+	_, n, err := t.findLeaf(start)
+	if err != nil {
+		return err
+	}
+	i := sort.SearchInts(n.keys, start)
+	for {
+		for ; i < len(n.keys); i++ {
+			if n.keys[i] > end || !fn(n.keys[i], n.values[i]) {
+				return nil
+			}
+		}
+		if n.next == pager.InvalidPage {
+			return nil
+		}
+		if n, err = t.readNode(n.next); err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+// Height 返回树的高度（只有根节点时为 1）
+func (t *Tree) Height() (int, error) {
+	h := 1
+	n, err := t.readNode(t.root())
+	for err == nil && !n.leaf {
+		h++
+		n, err = t.readNode(n.children[0])
+	}
+	return h, err
+}
+
+// Stats 返回树的形状和 I/O 计数
+func (t *Tree) Stats() (Stats, error) {
+	h, err := t.Height()
+	if err != nil {
+		return Stats{}, err
+	}
+	free, err := t.pool.FreePages()
+	if err != nil {
+		return Stats{}, err
+	}
+	p := t.pool.Pager()
+	return Stats{
+		Height:    h,
+		Pages:     p.NumPages(),
+		FreePages: free,
+		Splits:    t.splits,
+		Stats:     p.Stats(),
+		PoolStats: t.pool.Stats(),
+		WAL:       t.pool.WAL().Stats(),
+	}, nil
+}
+
+// Flush 做一次检查点：把脏页和元数据写回数据文件并 fsync，然后清空 WAL
+func (t *Tree) Flush() error {
+	return t.pool.Checkpoint()
+}
+
+func (t *Tree) Close() error {
+	return t.pool.Close()
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
@@ -0,0 +1,267 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ddia-labs/pkg/pager"
+)
+
+// contents 用全量扫描读出树中的所有键值
+func contents(t *testing.T, tr *Tree) map[int]string {
+	t.Helper()
+	got := make(map[int]string)
+	if err := tr.Scan(-1, 1<<30, func(k int, v string) bool {
+		got[k] = v
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func sameContents(a, b map[int]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// TestRandomOps 执行随机的插入、覆盖和删除（值的长度随机，以便触发分裂和叶子回收），
+// 定期检查不变式并与 map 模型比较，最后关闭再打开，内容必须不变
+func TestRandomOps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tr, err := Open(path, MinPoolPages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.SetSyncCommits(false)
+
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[int]string)
+	for i := 0; i < 4000; i++ {
+		key := rng.Intn(1000)
+		if rng.Intn(3) == 0 {
+			_, want := model[key]
+			got, err := tr.Delete(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("op %d: Delete(%d) = %v, want %v", i, key, got, want)
+			}
+			delete(model, key)
+		} else {
+			value := fmt.Sprintf("op%d-", i) + strings.Repeat("v", rng.Intn(300))
+			if err := tr.Put(key, value); err != nil {
+				t.Fatal(err)
+			}
+			model[key] = value
+		}
+		if i%100 == 0 {
+			if err := tr.CheckInvariants(); err != nil {
+				t.Fatalf("op %d: %v", i, err)
+			}
+		}
+	}
+	if err := tr.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range model {
+		got, ok, err := tr.Get(k)
+		if err != nil || !ok || got != v {
+			t.Fatalf("Get(%d) = %.12q, %v, %v; want %.12q", k, got, ok, err, v)
+		}
+	}
+	if !sameContents(contents(t, tr), model) {
+		t.Fatal("Scan does not match the model")
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err = Open(path, MinPoolPages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if err := tr.CheckInvariants(); err != nil {
+		t.Fatalf("after reopen: %v", err)
+	}
+	if !sameContents(contents(t, tr), model) {
+		t.Fatal("contents changed across reopen")
+	}
+}
+
+// errCrash 是分裂钩子中抛出的 panic，模拟进程在分裂写到一半时死掉
+type errCrash struct{}
+
+// TestCrashRedo 在第 k 次分裂的中途“杀死”进程（panic 后直接丢弃树，不关闭也不刷盘），
+// 然后重新打开（重放 WAL），检查不变式，并要求内容等于已确认操作生效后的状态，
+// 崩溃时正在执行的那条操作可以生效也可以不生效。完整的多进程版本见 disk-btree/crashtest。
+func TestCrashRedo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	rng := rand.New(rand.NewSource(2))
+	model := make(map[int]string)
+	seq := 0
+
+	for round := 0; round < 20; round++ {
+		tr, err := Open(path, MinPoolPages)
+		if err != nil {
+			t.Fatalf("round %d: reopen: %v", round, err)
+		}
+		if err := tr.CheckInvariants(); err != nil {
+			t.Fatalf("round %d: invariant violated after recovery: %v", round, err)
+		}
+		got := contents(t, tr)
+		if !sameContents(got, model) {
+			t.Fatalf("round %d: recovered %d keys, want %d", round, len(got), len(model))
+		}
+
+		tr.SetSyncCommits(false)
+		// 检查点间隔随机，让崩溃落在检查点前后的不同位置
+		tr.SetCheckpointBytes([]int64{16 << 10, 256 << 10, 4 << 20}[rng.Intn(3)])
+		killSplit, splits := 1+rng.Intn(5), 0
+		tr.SetSplitHook(func() {
+			splits++
+			if splits == killSplit {
+				panic(errCrash{})
+			}
+		})
+
+		// apply 执行一条操作，返回是否在中途崩溃
+		apply := func(key int, value string) (crashed bool) {
+			defer func() {
+				if r := recover(); r != nil {
+					if _, ok := r.(errCrash); !ok {
+						panic(r)
+					}
+					crashed = true
+				}
+			}()
+			if value == "" {
+				_, err = tr.Delete(key)
+			} else {
+				err = tr.Put(key, value)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			return false
+		}
+
+		for {
+			seq++
+			key, value := rng.Intn(2000), ""
+			if rng.Intn(4) != 0 {
+				value = fmt.Sprintf("op%d-", seq) + strings.Repeat("v", rng.Intn(400))
+			}
+			if !apply(key, value) {
+				if value == "" {
+					delete(model, key)
+				} else {
+					model[key] = value
+				}
+				continue
+			}
+
+			// 崩溃中的操作必然是触发分裂的 Put：重放后它可能生效也可能没有
+			tr2, err := Open(path, MinPoolPages)
+			if err != nil {
+				t.Fatalf("round %d: reopen after crash: %v", round, err)
+			}
+			if got := contents(t, tr2); got[key] == value {
+				model[key] = value
+			}
+			if err := tr2.Close(); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+}
+
+// TestAbortOnError 让操作因缓冲池已满在中途失败：每隔几次操作就固定住大部分页帧，只留下一两个可用。
+// 失败的操作必须被完整撤销——树满足不变式、内容与模型一致，之后的提交不会把改了一半的页带进日志，
+// 不关闭直接重新打开（重放 WAL）得到的也是同样的内容
+func TestAbortOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	tr, err := Open(path, MinPoolPages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.SetSyncCommits(false)
+	tr.SetCheckpointBytes(256 << 10)
+
+	rng := rand.New(rand.NewSource(3))
+	model := make(map[int]string)
+	failures := 0
+	for i := 0; i < 3000; i++ {
+		var pinned []*pager.Page
+		if i%4 == 0 {
+			n := tr.pool.Pager().NumPages() - 1
+			for _, j := range rng.Perm(n)[:min(n, MinPoolPages-1-rng.Intn(2))] {
+				pg, err := tr.pool.Fetch(pager.PageID(j + 1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				pinned = append(pinned, pg)
+			}
+		}
+
+		key, value := rng.Intn(1000), ""
+		if rng.Intn(3) != 0 {
+			value = fmt.Sprintf("op%d-", i) + strings.Repeat("v", rng.Intn(600))
+			err = tr.Put(key, value)
+		} else {
+			_, err = tr.Delete(key)
+		}
+		for _, pg := range pinned {
+			tr.pool.Unpin(pg, false)
+		}
+
+		switch {
+		case err == nil && value == "":
+			delete(model, key)
+		case err == nil:
+			model[key] = value
+		case errors.Is(err, pager.ErrPoolFull):
+			failures++
+		default:
+			t.Fatalf("op %d: %v", i, err)
+		}
+		if failed := err != nil; failed || i%100 == 0 {
+			if err := tr.CheckInvariants(); err != nil {
+				t.Fatalf("op %d (failed=%v): %v", i, failed, err)
+			}
+			if !sameContents(contents(t, tr), model) {
+				t.Fatalf("op %d (failed=%v): contents differ from the model", i, failed)
+			}
+		}
+	}
+	if failures == 0 {
+		t.Fatal("no operation failed, the test did not exercise Abort")
+	}
+
+	tr2, err := Open(path, MinPoolPages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr2.Close()
+	if err := tr2.CheckInvariants(); err != nil {
+		t.Fatalf("after reopen: %v", err)
+	}
+	if !sameContents(contents(t, tr2), model) {
+		t.Fatal("reopened tree differs from the model")
+	}
+	t.Logf("%d of 3000 operations failed and were rolled back", failures)
+}
@@ -0,0 +1,127 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ddia-labs/pkg/pager"
+)
+
+// CheckInvariants 校验整棵树的结构，崩溃恢复后用它确认树没有被撕裂：
+//   - 节点内的键严格递增，并且落在父节点分隔键限定的范围内
+//   - 所有叶子深度相同，除根节点外没有空叶子
+//   - 叶子的兄弟指针与中序遍历的顺序一致
+//   - 每个页最多被引用一次，树中的页、空闲页和元数据页恰好覆盖整个文件（没有泄漏）
+func (t *Tree) CheckInvariants() error {
+	c := &checker{t: t, seen: make(map[pager.PageID]bool), leafDepth: -1}
+	root := t.root()
+	n, err := t.readNode(root)
+	if err != nil {
+		return err
+	}
+	if !n.leaf && len(n.children) < 2 {
+		return fmt.Errorf("internal root %d has %d children", root, len(n.children))
+	}
+	if err := c.walk(root, 0, nil, nil); err != nil {
+		return err
+	}
+
+	for i, l := range c.leaves {
+		var prev, next pager.PageID
+		if i > 0 {
+			prev = c.leaves[i-1].id
+		}
+		if i+1 < len(c.leaves) {
+			next = c.leaves[i+1].id
+		}
+		if l.n.prev != prev || l.n.next != next {
+			return fmt.Errorf("leaf %d: sibling pointers prev=%d next=%d, want prev=%d next=%d",
+				l.id, l.n.prev, l.n.next, prev, next)
+		}
+	}
+
+	// 空闲链表中的页不能出现在树中，也不能成环
+	free := 0
+	for id := t.pool.Pager().FreeHead(); id != pager.InvalidPage; free++ {
+		if c.seen[id] {
+			return fmt.Errorf("free page %d is also reachable (or free list has a cycle)", id)
+		}
+		c.seen[id] = true
+		pg, err := t.pool.Fetch(id)
+		if err != nil {
+			return err
+		}
+		id = pager.PageID(binary.LittleEndian.Uint32(pg.Data[:4]))
+		t.pool.Unpin(pg, false)
+	}
+	if used := 1 + len(c.seen); used != t.pool.Pager().NumPages() {
+		return fmt.Errorf("%d pages in file but %d reachable from tree and free list", t.pool.Pager().NumPages(), used)
+	}
+	return nil
+}
+
+type checker struct {
+	t         *Tree
+	seen      map[pager.PageID]bool
+	leafDepth int
+	leaves    []leafRef
+}
+
+type leafRef struct {
+	id pager.PageID
+	n  *node
+}
+
+// walk 校验以 id 为根的子树，其中的键必须满足 lo <= key < hi（nil 表示无界）
+func (c *checker) walk(id pager.PageID, depth int, lo, hi *int) error {
+	if id == pager.InvalidPage || int(id) >= c.t.pool.Pager().NumPages() {
+		return fmt.Errorf("dangling page pointer %d", id)
+	}
+	if c.seen[id] {
+		return fmt.Errorf("page %d is referenced twice", id)
+	}
+	c.seen[id] = true
+
+	n, err := c.t.readNode(id)
+	if err != nil {
+		return fmt.Errorf("page %d: %w", id, err)
+	}
+	for i, k := range n.keys {
+		if i > 0 && n.keys[i-1] >= k {
+			return fmt.Errorf("page %d: keys not strictly increasing: %v", id, n.keys)
+		}
+		if (lo != nil && k < *lo) || (hi != nil && k >= *hi) {
+			return fmt.Errorf("page %d: key %d outside separator range", id, k)
+		}
+	}
+
+	if n.leaf {
+		if c.leafDepth == -1 {
+			c.leafDepth = depth
+		} else if c.leafDepth != depth {
+			return fmt.Errorf("leaf %d at depth %d, other leaves at depth %d", id, depth, c.leafDepth)
+		}
+		if len(n.keys) == 0 && id != c.t.root() {
+			return fmt.Errorf("non-root leaf %d is empty", id)
+		}
+		c.leaves = append(c.leaves, leafRef{id, n})
+		return nil
+	}
+
+	if len(n.children) != len(n.keys)+1 {
+		return fmt.Errorf("page %d: %d keys but %d children", id, len(n.keys), len(n.children))
+	}
+	for i, child := range n.children {
+		clo, chi := lo, hi
+		if i > 0 {
+			clo = &n.keys[i-1]
+		}
+		if i < len(n.keys) {
+			chi = &n.keys[i]
+		}
+		if err := c.walk(child, depth+1, clo, chi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
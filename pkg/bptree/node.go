@@ -0,0 +1,127 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ddia-labs/pkg/pager"
+)
+
+// 节点在页中的布局（小端序）：
+//
+//	叶子节点: kind(1) | count(2) | next(4) | prev(4) | count × [key(8) | vlen(2) | value]
+//	内部节点: kind(1) | count(2) | child0(4) | count × [key(8) | child(4)]
+//
+// 内部节点中 keys[i] 是 children[i+1] 子树中的最小键。
+const (
+	kindLeaf     = 1
+	kindInternal = 2
+
+	leafHeader     = 11
+	internalHeader = 7
+	leafEntryFixed = 10
+	internalEntry  = 12
+)
+
+// MaxValueSize 是单个值的最大字节数，保证分裂后的两半都能放进一页
+const MaxValueSize = pager.PageSize / 4
+
+type node struct {
+	leaf     bool
+	keys     []int
+	values   []string       // 仅叶子节点
+	children []pager.PageID // 仅内部节点，len(children) == len(keys)+1
+	next     pager.PageID   // 仅叶子节点：右兄弟
+	prev     pager.PageID   // 仅叶子节点：左兄弟
+}
+
+// size 返回节点编码后的字节数
+func (n *node) size() int {
+	if !n.leaf {
+		return internalHeader + len(n.keys)*internalEntry
+	}
+	sz := leafHeader
+	for _, v := range n.values {
+		sz += leafEntryFixed + len(v)
+	}
+	return sz
+}
+
+func (n *node) encode(buf []byte) {
+	le := binary.LittleEndian
+	clear(buf)
+	le.PutUint16(buf[1:], uint16(len(n.keys)))
+	if n.leaf {
+		buf[0] = kindLeaf
+		le.PutUint32(buf[3:], uint32(n.next))
+		le.PutUint32(buf[7:], uint32(n.prev))
+		off := leafHeader
+		for i, k := range n.keys {
+			le.PutUint64(buf[off:], uint64(k))
+			le.PutUint16(buf[off+8:], uint16(len(n.values[i])))
+			off += leafEntryFixed
+			off += copy(buf[off:], n.values[i])
+		}
+		return
+	}
+
+	buf[0] = kindInternal
+	le.PutUint32(buf[3:], uint32(n.children[0]))
+	off := internalHeader
+	for i, k := range n.keys {
+		le.PutUint64(buf[off:], uint64(k))
+		le.PutUint32(buf[off+8:], uint32(n.children[i+1]))
+		off += internalEntry
+	}
+}
+
+// decode 解码节点页；页内容被破坏时返回错误而不是越界
+func decode(buf []byte) (*node, error) {
+	le := binary.LittleEndian
+	count := int(le.Uint16(buf[1:]))
+	switch buf[0] {
+	case kindLeaf:
+		if leafHeader+count*leafEntryFixed > len(buf) {
+			return nil, fmt.Errorf("bptree: leaf with %d keys does not fit in a page", count)
+		}
+		n := &node{
+			leaf:   true,
+			keys:   make([]int, count),
+			values: make([]string, count),
+			next:   pager.PageID(le.Uint32(buf[3:])),
+			prev:   pager.PageID(le.Uint32(buf[7:])),
+		}
+		off := leafHeader
+		for i := 0; i < count; i++ {
+			if off+leafEntryFixed > len(buf) {
+				return nil, fmt.Errorf("bptree: leaf entry %d out of page bounds", i)
+			}
+			n.keys[i] = int(int64(le.Uint64(buf[off:])))
+			vlen := int(le.Uint16(buf[off+8:]))
+			off += leafEntryFixed
+			if off+vlen > len(buf) {
+				return nil, fmt.Errorf("bptree: leaf entry %d out of page bounds", i)
+			}
+			n.values[i] = string(buf[off : off+vlen])
+			off += vlen
+		}
+		return n, nil
+	case kindInternal:
+		if internalHeader+count*internalEntry > len(buf) {
+			return nil, fmt.Errorf("bptree: internal node with %d keys does not fit in a page", count)
+		}
+		n := &node{
+			keys:     make([]int, count),
+			children: make([]pager.PageID, count+1),
+		}
+		n.children[0] = pager.PageID(le.Uint32(buf[3:]))
+		off := internalHeader
+		for i := 0; i < count; i++ {
+			n.keys[i] = int(int64(le.Uint64(buf[off:])))
+			n.children[i+1] = pager.PageID(le.Uint32(buf[off+8:]))
+			off += internalEntry
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("bptree: page is not a tree node (kind %d)", buf[0])
+}
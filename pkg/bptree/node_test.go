@@ -0,0 +1,36 @@
+package bptree
+
+import (
+	"testing"
+
+	"github.com/ddia-labs/pkg/pager"
+)
+
+// FuzzDecode 解码任意的页内容：被破坏的页必须返回错误而不是越界 panic，
+// 能解码的页重新编码后必须得到同样的节点
+func FuzzDecode(f *testing.F) {
+	var buf [pager.PageSize]byte
+	(&node{leaf: true, keys: []int{-3, 7, 42}, values: []string{"a", "", "hello"}, next: 5, prev: 2}).encode(buf[:])
+	f.Add(append([]byte(nil), buf[:64]...))
+	(&node{keys: []int{10, 20}, children: []pager.PageID{3, 4, 9}}).encode(buf[:])
+	f.Add(append([]byte(nil), buf[:64]...))
+	f.Add([]byte{kindLeaf, 0xFF, 0xFF})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var page [pager.PageSize]byte
+		copy(page[:], data)
+		n, err := decode(page[:])
+		if err != nil {
+			return
+		}
+		var again [pager.PageSize]byte
+		n.encode(again[:])
+		m, err := decode(again[:])
+		if err != nil {
+			t.Fatalf("re-encoded node does not decode: %v", err)
+		}
+		if len(m.keys) != len(n.keys) || m.leaf != n.leaf || len(m.values) != len(n.values) || len(m.children) != len(n.children) {
+			t.Fatalf("round trip changed the node: %+v -> %+v", n, m)
+		}
+	})
+}
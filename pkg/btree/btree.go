@@ -0,0 +1,390 @@
+// Package btree 实现了一个内存中的 B-tree（键值同时存放在内部节点和叶子节点中），
+// 支持节点分裂、根节点增长，以及删除时的借位与合并。
+// 键和值都是字节串，键按 kv.Comparator 排序；BTree 实现了 kv.KV。
+package btree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// BTreeNode 是 B-tree 的节点
+type BTreeNode struct {
+	keys     [][]byte
+	values   [][]byte
+	children []*BTreeNode
+	isLeaf   bool
+}
+
+// BTree 的阶数 order 是每个节点最多拥有的子节点数：
+// 节点最多 order-1 个键，除根节点外最少 ceil(order/2)-1 个键
+type BTree struct {
+	root  *BTreeNode
+	order int
+	size  int
+	cmp   kv.Comparator
+}
+
+var _ kv.KV = (*BTree)(nil)
+
+// New 创建一个阶数为 order、按字节序比较键的 B-tree，order 至少为 3
+func New(order int) *BTree {
+	return NewWithComparator(order, kv.Bytewise)
+}
+
+// NewWithComparator 创建一个阶数为 order、用 cmp 比较键的 B-tree
+func NewWithComparator(order int, cmp kv.Comparator) *BTree {
+	if order < 3 {
+		order = 3
+	}
+	return &BTree{
+		root:  &BTreeNode{isLeaf: true},
+		order: order,
+		cmp:   cmp,
+	}
+}
+
+func (bt *BTree) maxKeys() int { return bt.order - 1 }
+func (bt *BTree) minKeys() int { return (bt.order+1)/2 - 1 }
+
+// Len 返回树中键的数量
+func (bt *BTree) Len() int { return bt.size }
+
+// Height 返回树的高度（只有根节点时为 1）
+func (bt *BTree) Height() int {
+	h := 1
+	for n := bt.root; !n.isLeaf; n = n.children[0] {
+		h++
+	}
+	return h
+}
+
+// find 返回第一个 >= key 的位置，以及该位置上的键是否等于 key
+func (bt *BTree) find(n *BTreeNode, key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool { return bt.cmp(n.keys[i], key) >= 0 })
+	return i, i < len(n.keys) && bt.cmp(n.keys[i], key) == 0
+}
+
+// Get 查找 key。内存中的树不会出错，error 总是 nil（为了实现 kv.KV）
+func (bt *BTree) Get(key []byte) ([]byte, bool, error) {
+	n := bt.root
+	for {
+		i, found := bt.find(n, key)
+		if found {
+			// 内部节点上的键同样携带值
+			return n.values[i], true, nil
+		}
+		if n.isLeaf {
+			return nil, false, nil
+		}
+		n = n.children[i]
+	}
+}
+
+// Put 插入或更新 key：先插入叶子节点，节点溢出时从下往上分裂，必要时根节点增长一层
+func (bt *BTree) Put(key, value []byte) error {
+	// 复制键和值，调用方之后可以复用自己的缓冲区
+	key, value = append([]byte{}, key...), append([]byte{}, value...)
+	midKey, midVal, right := bt.insert(bt.root, key, value)
+	if right != nil {
+		bt.root = &BTreeNode{
+			keys:     [][]byte{midKey},
+			values:   [][]byte{midVal},
+			children: []*BTreeNode{bt.root, right},
+		}
+	}
+	return nil
+}
+
+// insert 把 key 插入以 n 为根的子树。如果 n 因此分裂，返回被提升的中间键值和新的右半节点
+func (bt *BTree) insert(n *BTreeNode, key, value []byte) ([]byte, []byte, *BTreeNode) {
+	i, found := bt.find(n, key)
+	if found {
+		// 如果key已存在，更新value
+		n.values[i] = value
+		return nil, nil, nil
+	}
+
+	if n.isLeaf {
+		n.keys = insertAt(n.keys, i, key)
+		n.values = insertAt(n.values, i, value)
+		bt.size++
+	} else {
+		midKey, midVal, right := bt.insert(n.children[i], key, value)
+		if right == nil {
+			return nil, nil, nil
+		}
+		// 子节点分裂：中间键提升到当前节点，新节点挂在它的右边
+		n.keys = insertAt(n.keys, i, midKey)
+		n.values = insertAt(n.values, i, midVal)
+		n.children = insertAt(n.children, i+1, right)
+	}
+
+	if len(n.keys) <= bt.maxKeys() {
+		return nil, nil, nil
+	}
+	return bt.split(n)
+}
+
+// split 把溢出的节点一分为二，返回中间键值和右半节点
+func (bt *BTree) split(n *BTreeNode) ([]byte, []byte, *BTreeNode) {
+	mid := len(n.keys) / 2
+	right := &BTreeNode{
+		keys:   append([][]byte(nil), n.keys[mid+1:]...),
+		values: append([][]byte(nil), n.values[mid+1:]...),
+		isLeaf: n.isLeaf,
+	}
+	if !n.isLeaf {
+		right.children = append([]*BTreeNode(nil), n.children[mid+1:]...)
+		n.children = n.children[:mid+1]
+	}
+	midKey, midVal := n.keys[mid], n.values[mid]
+	n.keys = n.keys[:mid]
+	n.values = n.values[:mid]
+	return midKey, midVal, right
+}
+
+// Delete 删除 key，key 不存在时什么也不做（为了实现 kv.KV）
+func (bt *BTree) Delete(key []byte) error {
+	bt.Remove(key)
+	return nil
+}
+
+// Remove 删除 key，节点下溢时向兄弟节点借键或与兄弟节点合并，返回 key 是否存在
+func (bt *BTree) Remove(key []byte) bool {
+	if !bt.delete(bt.root, key) {
+		return false
+	}
+	bt.size--
+	// 根节点的最后一个键被合并下去后，树高度减一
+	if len(bt.root.keys) == 0 && !bt.root.isLeaf {
+		bt.root = bt.root.children[0]
+	}
+	return true
+}
+
+func (bt *BTree) delete(n *BTreeNode, key []byte) bool {
+	i, found := bt.find(n, key)
+	if n.isLeaf {
+		if !found {
+			return false
+		}
+		n.keys = removeAt(n.keys, i)
+		n.values = removeAt(n.values, i)
+		return true
+	}
+
+	if found {
+		// 内部节点上的键：用左子树中的最大键（前驱）替换它，再到左子树中删除前驱
+		pred := n.children[i]
+		for !pred.isLeaf {
+			pred = pred.children[len(pred.children)-1]
+		}
+		last := len(pred.keys) - 1
+		n.keys[i], n.values[i] = pred.keys[last], pred.values[last]
+		bt.delete(n.children[i], pred.keys[last])
+	} else if !bt.delete(n.children[i], key) {
+		return false
+	}
+
+	bt.rebalance(n, i)
+	return true
+}
+
+// rebalance 修复 parent 的第 i 个子节点的下溢
+func (bt *BTree) rebalance(parent *BTreeNode, i int) {
+	child := parent.children[i]
+	if len(child.keys) >= bt.minKeys() {
+		return
+	}
+
+	if i > 0 && len(parent.children[i-1].keys) > bt.minKeys() {
+		// 向左兄弟借：父节点的分隔键下移到 child 头部，左兄弟的最大键上移
+		left := parent.children[i-1]
+		last := len(left.keys) - 1
+		child.keys = insertAt(child.keys, 0, parent.keys[i-1])
+		child.values = insertAt(child.values, 0, parent.values[i-1])
+		parent.keys[i-1], parent.values[i-1] = left.keys[last], left.values[last]
+		left.keys, left.values = left.keys[:last], left.values[:last]
+		if !left.isLeaf {
+			child.children = insertAt(child.children, 0, left.children[last+1])
+			left.children = left.children[:last+1]
+		}
+		return
+	}
+
+	if i < len(parent.children)-1 && len(parent.children[i+1].keys) > bt.minKeys() {
+		// 向右兄弟借：父节点的分隔键下移到 child 尾部，右兄弟的最小键上移
+		right := parent.children[i+1]
+		child.keys = append(child.keys, parent.keys[i])
+		child.values = append(child.values, parent.values[i])
+		parent.keys[i], parent.values[i] = right.keys[0], right.values[0]
+		right.keys, right.values = removeAt(right.keys, 0), removeAt(right.values, 0)
+		if !right.isLeaf {
+			child.children = append(child.children, right.children[0])
+			right.children = removeAt(right.children, 0)
+		}
+		return
+	}
+
+	// 兄弟节点都没有富余的键：与一个兄弟合并
+	if i > 0 {
+		bt.merge(parent, i-1)
+	} else {
+		bt.merge(parent, i)
+	}
+}
+
+// merge 把 parent 的第 i+1 个子节点和分隔键 keys[i] 并入第 i 个子节点
+func (bt *BTree) merge(parent *BTreeNode, i int) {
+	left, right := parent.children[i], parent.children[i+1]
+	left.keys = append(append(left.keys, parent.keys[i]), right.keys...)
+	left.values = append(append(left.values, parent.values[i]), right.values...)
+	left.children = append(left.children, right.children...)
+	parent.keys = removeAt(parent.keys, i)
+	parent.values = removeAt(parent.values, i)
+	parent.children = removeAt(parent.children, i+1)
+}
+
+// Scan 按键的顺序遍历 [start, end) 内的键值，fn 返回 false 时停止；nil 边界表示不限
+func (bt *BTree) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	bt.scan(bt.root, start, end, fn)
+	return nil
+}
+
+// scan 中序遍历以 node 为根的子树中 [start, end) 内的键，返回是否应该继续
+func (bt *BTree) scan(node *BTreeNode, start, end []byte, fn func(key, value []byte) bool) bool {
+	i := 0
+	if start != nil {
+		i, _ = bt.find(node, start)
+	}
+	for ; i <= len(node.keys); i++ {
+		// 第 i 个子树中的键都小于 keys[i]
+		if !node.isLeaf && !bt.scan(node.children[i], start, end, fn) {
+			return false
+		}
+		if i == len(node.keys) {
+			return true
+		}
+		if end != nil && bt.cmp(node.keys[i], end) >= 0 {
+			return false
+		}
+		if !fn(node.keys[i], node.values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckInvariants 检查 B-tree 的结构不变式，测试可以在每次操作后调用：
+//   - 每个节点内的键严格递增，且落在父节点分隔键限定的范围内
+//   - 除根节点外每个节点的键数在 [ceil(order/2)-1, order-1] 之间
+//   - 内部节点的子节点数等于键数加一
+//   - 所有叶子节点位于同一深度
+//   - 键的总数与 Len 一致
+func (bt *BTree) CheckInvariants() error {
+	leafDepth := -1
+	count := 0
+	// lo、hi 为 nil 表示没有下界/上界（存进树的键都不是 nil）
+	var check func(n *BTreeNode, depth int, lo, hi []byte) error
+	check = func(n *BTreeNode, depth int, lo, hi []byte) error {
+		if len(n.keys) != len(n.values) {
+			return fmt.Errorf("node %s: %d keys but %d values", formatKeys(n.keys), len(n.keys), len(n.values))
+		}
+		if len(n.keys) > bt.maxKeys() {
+			return fmt.Errorf("node %s: %d keys exceeds max %d", formatKeys(n.keys), len(n.keys), bt.maxKeys())
+		}
+		if n != bt.root && len(n.keys) < bt.minKeys() {
+			return fmt.Errorf("node %s: %d keys below min %d", formatKeys(n.keys), len(n.keys), bt.minKeys())
+		}
+		for i, k := range n.keys {
+			if i > 0 && bt.cmp(n.keys[i-1], k) >= 0 {
+				return fmt.Errorf("node %s: keys not sorted", formatKeys(n.keys))
+			}
+			if (lo != nil && bt.cmp(k, lo) <= 0) || (hi != nil && bt.cmp(k, hi) >= 0) {
+				return fmt.Errorf("node %s: key %s outside parent bounds", formatKeys(n.keys), kv.FormatKey(k))
+			}
+		}
+		count += len(n.keys)
+
+		if n.isLeaf {
+			if len(n.children) != 0 {
+				return fmt.Errorf("leaf %s has children", formatKeys(n.keys))
+			}
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				return fmt.Errorf("leaf %s at depth %d, want %d", formatKeys(n.keys), depth, leafDepth)
+			}
+			return nil
+		}
+
+		if len(n.children) != len(n.keys)+1 {
+			return fmt.Errorf("node %s: %d children for %d keys", formatKeys(n.keys), len(n.children), len(n.keys))
+		}
+		for i, c := range n.children {
+			cl, ch := lo, hi
+			if i > 0 {
+				cl = n.keys[i-1]
+			}
+			if i < len(n.keys) {
+				ch = n.keys[i]
+			}
+			if err := check(c, depth+1, cl, ch); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := check(bt.root, 0, nil, nil); err != nil {
+		return err
+	}
+	if count != bt.size {
+		return fmt.Errorf("found %d keys, Len() = %d", count, bt.size)
+	}
+	return nil
+}
+
+// String 按层打印树的结构，例如 "[10 20]\n[5] [15] [25 30]"，键用 kv.FormatKey 格式化
+func (bt *BTree) String() string {
+	var lines []string
+	level := []*BTreeNode{bt.root}
+	for len(level) > 0 {
+		var parts []string
+		var next []*BTreeNode
+		for _, n := range level {
+			parts = append(parts, formatKeys(n.keys))
+			next = append(next, n.children...)
+		}
+		lines = append(lines, strings.Join(parts, " "))
+		level = next
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatKeys(keys [][]byte) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = kv.FormatKey(k)
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
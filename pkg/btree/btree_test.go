@@ -0,0 +1,91 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// TestRandomOps 对不同阶数的树执行随机的插入、覆盖和删除，每次操作后检查不变式，并与 map 模型比较
+func TestRandomOps(t *testing.T) {
+	for _, order := range []int{3, 4, 5, 8, 32} {
+		t.Run(fmt.Sprintf("order%d", order), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(order)))
+			bt := New(order)
+			model := make(map[string]string)
+			for i := 0; i < 3000; i++ {
+				key := kv.IntKey(rng.Intn(300))
+				if rng.Intn(3) == 0 {
+					_, want := model[string(key)]
+					if got := bt.Remove(key); got != want {
+						t.Fatalf("op %d: Remove(%s) = %v, want %v", i, kv.FormatKey(key), got, want)
+					}
+					delete(model, string(key))
+				} else {
+					value := fmt.Sprintf("v%d", i)
+					if err := bt.Put(key, []byte(value)); err != nil {
+						t.Fatal(err)
+					}
+					model[string(key)] = value
+				}
+				if err := bt.CheckInvariants(); err != nil {
+					t.Fatalf("op %d: %v\n%s", i, err, bt)
+				}
+			}
+			checkContents(t, bt, model)
+
+			// 全部删除后应退化成一个空的根叶子
+			for k := range model {
+				if !bt.Remove([]byte(k)) {
+					t.Fatalf("Remove(%s) = false", kv.FormatKey([]byte(k)))
+				}
+			}
+			if err := bt.CheckInvariants(); err != nil {
+				t.Fatal(err)
+			}
+			if bt.Len() != 0 || bt.Height() != 1 {
+				t.Fatalf("after deleting everything: Len=%d Height=%d", bt.Len(), bt.Height())
+			}
+		})
+	}
+}
+
+// checkContents 检查点查和全量有序扫描都与模型一致
+func checkContents(t *testing.T, bt *BTree, model map[string]string) {
+	t.Helper()
+	if bt.Len() != len(model) {
+		t.Fatalf("Len = %d, want %d", bt.Len(), len(model))
+	}
+	keys := make([]string, 0, len(model))
+	for k, v := range model {
+		keys = append(keys, k)
+		got, ok, err := bt.Get([]byte(k))
+		if err != nil || !ok || string(got) != v {
+			t.Fatalf("Get(%s) = %q, %v, %v; want %q", kv.FormatKey([]byte(k)), got, ok, err, v)
+		}
+	}
+	sort.Strings(keys)
+
+	var scanned []string
+	err := bt.Scan(nil, nil, func(key, value []byte) bool {
+		if string(value) != model[string(key)] {
+			t.Errorf("Scan: %s -> %q, want %q", kv.FormatKey(key), value, model[string(key)])
+		}
+		scanned = append(scanned, string(key))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != len(keys) {
+		t.Fatalf("Scan returned %d keys, want %d", len(scanned), len(keys))
+	}
+	for i := range keys {
+		if scanned[i] != keys[i] {
+			t.Fatalf("Scan key %d = %s, want %s", i, kv.FormatKey([]byte(scanned[i])), kv.FormatKey([]byte(keys[i])))
+		}
+	}
+}
@@ -0,0 +1,374 @@
+// Package btreeindex 实现内存中的 B+tree 索引 BTreeIndex：键值只在叶子中，叶子串成双向链表，
+// 支持游标（Seek/Next/Prev）、正反向流式扫描和基于令牌的分页。BTreeIndex 实现了 kv.KV。
+package btreeindex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// B-tree索引节点。这是一棵 B+tree：键值只存放在叶子节点中，叶子按键的顺序用 prev/next 串成双向链表；
+// 内部节点的 keys[i] 是子树 children[i] 中所有键的上界，children[i] 中的键都大于 keys[i-1] 且不大于 keys[i]，
+// 最后一个子节点没有上界
+type BTreeIndexNode struct {
+	keys       [][]byte
+	values     [][]byte
+	children   []*BTreeIndexNode
+	isLeaf     bool
+	prev, next *BTreeIndexNode // 只用于叶子节点
+}
+
+// B-tree索引实现，键按比较器 cmp 排序。节点的键数超过 order-1 时分裂，树从根部增长一层；
+// 删除只摘掉变空的节点，不合并不满的节点（与 PostgreSQL 等许多数据库的做法相同）
+type BTreeIndex struct {
+	root    *BTreeIndexNode
+	cmp     kv.Comparator
+	order   int
+	size    int
+	version uint64 // 每次修改加一，游标据此发现树被修改过
+}
+
+var _ kv.KV = (*BTreeIndex)(nil)
+
+// DefaultOrder 是 NewBTreeIndex 和 NewBTreeIndexWithComparator 使用的阶数
+const DefaultOrder = 32
+
+func NewBTreeIndex() *BTreeIndex {
+	return NewBTreeIndexWithComparator(kv.Bytewise)
+}
+
+// NewBTreeIndexWithComparator 创建按 cmp 排序的索引
+func NewBTreeIndexWithComparator(cmp kv.Comparator) *BTreeIndex {
+	return NewBTreeIndexWithOrder(DefaultOrder, cmp)
+}
+
+// NewBTreeIndexWithOrder 创建阶数为 order（每个内部节点最多 order 个子节点、每个叶子最多 order-1 个键）
+// 的索引，order 至少为 4
+func NewBTreeIndexWithOrder(order int, cmp kv.Comparator) *BTreeIndex {
+	if order < 4 {
+		order = 4
+	}
+	return &BTreeIndex{
+		root:  &BTreeIndexNode{isLeaf: true},
+		cmp:   cmp,
+		order: order,
+	}
+}
+
+func (bti *BTreeIndex) maxKeys() int { return bti.order - 1 }
+
+// Len 返回索引中键的数量
+func (bti *BTreeIndex) Len() int { return bti.size }
+
+// Height 返回树的高度（只有根节点时为 1）
+func (bti *BTreeIndex) Height() int {
+	h := 1
+	for n := bti.root; !n.isLeaf; n = n.children[0] {
+		h++
+	}
+	return h
+}
+
+// position 返回节点中第一个不小于 key 的位置；在内部节点中就是可能包含 key 的子节点
+func (bti *BTreeIndex) position(node *BTreeIndexNode, key []byte) int {
+	return sort.Search(len(node.keys), func(i int) bool { return bti.cmp(node.keys[i], key) >= 0 })
+}
+
+// leafFor 返回可能包含 key 的叶子节点
+func (bti *BTreeIndex) leafFor(key []byte) *BTreeIndexNode {
+	node := bti.root
+	for !node.isLeaf {
+		node = node.children[bti.position(node, key)]
+	}
+	return node
+}
+
+// 查找
+func (bti *BTreeIndex) Get(key []byte) ([]byte, bool, error) {
+	leaf := bti.leafFor(key)
+	if i := bti.position(leaf, key); i < len(leaf.keys) && bti.cmp(leaf.keys[i], key) == 0 {
+		return leaf.values[i], true, nil
+	}
+	return nil, false, nil
+}
+
+// 插入（复制键和值，调用方之后可以复用自己的缓冲区）。叶子溢出时从下往上分裂，必要时根节点增长一层
+func (bti *BTreeIndex) Put(key, value []byte) error {
+	sep, right := bti.insert(bti.root, append([]byte{}, key...), append([]byte{}, value...))
+	if right != nil {
+		bti.root = &BTreeIndexNode{
+			keys:     [][]byte{sep},
+			children: []*BTreeIndexNode{bti.root, right},
+		}
+	}
+	bti.version++
+	return nil
+}
+
+// insert 把 key 插入以 node 为根的子树。如果 node 因此分裂，返回左半的上界和新的右半节点
+func (bti *BTreeIndex) insert(node *BTreeIndexNode, key, value []byte) ([]byte, *BTreeIndexNode) {
+	pos := bti.position(node, key)
+	if node.isLeaf {
+		// 如果key已存在，更新value
+		if pos < len(node.keys) && bti.cmp(node.keys[pos], key) == 0 {
+			node.values[pos] = value
+			return nil, nil
+		}
+		node.keys = insertAt(node.keys, pos, key)
+		node.values = insertAt(node.values, pos, value)
+		bti.size++
+	} else {
+		sep, right := bti.insert(node.children[pos], key, value)
+		if right == nil {
+			return nil, nil
+		}
+		// 子节点分裂：左半的上界插在 pos，右半接在它后面，沿用原来的上界
+		node.keys = insertAt(node.keys, pos, sep)
+		node.children = insertAt(node.children, pos+1, right)
+	}
+
+	if len(node.keys) <= bti.maxKeys() {
+		return nil, nil
+	}
+	return bti.split(node)
+}
+
+// split 把溢出的节点一分为二。叶子的后一半键值移到新节点，左半的最后一个键成为上界；
+// 内部节点的中间键提升到父节点，不留在任何一半中
+func (bti *BTreeIndex) split(node *BTreeIndexNode) ([]byte, *BTreeIndexNode) {
+	mid := len(node.keys) / 2
+	right := &BTreeIndexNode{isLeaf: node.isLeaf}
+	if node.isLeaf {
+		right.keys = append([][]byte(nil), node.keys[mid:]...)
+		right.values = append([][]byte(nil), node.values[mid:]...)
+		node.keys = node.keys[:mid]
+		node.values = node.values[:mid]
+		// 新叶子链在 node 后面
+		right.prev, right.next = node, node.next
+		if node.next != nil {
+			node.next.prev = right
+		}
+		node.next = right
+		return node.keys[mid-1], right
+	}
+	sep := node.keys[mid]
+	right.keys = append([][]byte(nil), node.keys[mid+1:]...)
+	right.children = append([]*BTreeIndexNode(nil), node.children[mid+1:]...)
+	node.keys = node.keys[:mid]
+	node.children = node.children[:mid+1]
+	return sep, right
+}
+
+// 删除
+func (bti *BTreeIndex) Delete(key []byte) error {
+	if !bti.delete(bti.root, key) {
+		return nil
+	}
+	bti.size--
+	bti.version++
+	// 根只剩一个子节点时树高减一，所有子节点都被摘掉时变回空叶子
+	for !bti.root.isLeaf && len(bti.root.children) == 1 {
+		bti.root = bti.root.children[0]
+	}
+	if !bti.root.isLeaf && len(bti.root.children) == 0 {
+		bti.root = &BTreeIndexNode{isLeaf: true}
+	}
+	return nil
+}
+
+// delete 从以 node 为根的子树中删除 key，返回 key 是否存在。变空的子节点从 node 中摘掉，
+// 连同它的上界（最后一个子节点没有上界，摘掉前一个键，前一个子节点继承 node 的上界）
+func (bti *BTreeIndex) delete(node *BTreeIndexNode, key []byte) bool {
+	pos := bti.position(node, key)
+	if node.isLeaf {
+		if pos == len(node.keys) || bti.cmp(node.keys[pos], key) != 0 {
+			return false
+		}
+		node.keys = removeAt(node.keys, pos)
+		node.values = removeAt(node.values, pos)
+		return true
+	}
+
+	child := node.children[pos]
+	if !bti.delete(child, key) {
+		return false
+	}
+	if len(child.keys) > 0 || len(child.children) > 0 {
+		return true
+	}
+	if child.isLeaf {
+		if child.prev != nil {
+			child.prev.next = child.next
+		}
+		if child.next != nil {
+			child.next.prev = child.prev
+		}
+	}
+	switch {
+	case len(node.keys) == 0:
+		node.children = nil
+	case pos < len(node.keys):
+		node.keys = removeAt(node.keys, pos)
+		node.children = removeAt(node.children, pos)
+	default:
+		node.keys = removeAt(node.keys, pos-1)
+		node.children = removeAt(node.children, pos)
+	}
+	return true
+}
+
+// 范围查询：按键的顺序遍历 [start, end) 内的键值，nil 边界表示不限，fn 返回 false 时停止。
+// 用游标沿叶子链表前进，不把结果收集到切片中
+func (bti *BTreeIndex) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	return bti.iterate(start, end, false, fn)
+}
+
+// RangeScan 返回闭区间 [start, end] 内所有键的值（WHERE key BETWEEN start AND end），
+// nil 边界表示不限。保留了改成 kv.KV 之前的语义：注意 Scan 的上界是开区间，RangeScan 的上界是闭区间。
+// 结果收集在切片中，大范围请用 Scan 或游标流式遍历
+func (bti *BTreeIndex) RangeScan(start, end []byte) [][]byte {
+	var result [][]byte
+	c := bti.Cursor()
+	for ok := c.Seek(start); ok && (end == nil || bti.cmp(c.Key(), end) <= 0); ok = c.Next() {
+		result = append(result, c.Value())
+	}
+	return result
+}
+
+// 有序遍历：沿叶子链表返回所有值
+func (bti *BTreeIndex) InOrderTraversal() [][]byte {
+	var result [][]byte
+	leaf := bti.root
+	for !leaf.isLeaf {
+		leaf = leaf.children[0]
+	}
+	for ; leaf != nil; leaf = leaf.next {
+		result = append(result, leaf.values...)
+	}
+	return result
+}
+
+// CheckInvariants 检查树的结构不变式：
+//   - 每个节点内的键严格递增，且落在父节点的上界限定的范围内
+//   - 每个节点最多 order-1 个键；内部节点的子节点数等于键数加一
+//   - 所有叶子位于同一深度，除根节点外没有空节点
+//   - 叶子链表按从左到右的顺序串起所有叶子，prev 与 next 一致
+//   - 键的总数与 Len 一致
+func (bti *BTreeIndex) CheckInvariants() error {
+	var leaves []*BTreeIndexNode
+	leafDepth := -1
+	// lo 是不包含的下界，hi 是包含的上界，nil 表示不限
+	var check func(node *BTreeIndexNode, depth int, lo, hi []byte) error
+	check = func(node *BTreeIndexNode, depth int, lo, hi []byte) error {
+		if len(node.keys) > bti.maxKeys() {
+			return fmt.Errorf("node %s: %d keys exceeds max %d", formatKeys(node.keys), len(node.keys), bti.maxKeys())
+		}
+		for i, k := range node.keys {
+			if i > 0 && bti.cmp(node.keys[i-1], k) >= 0 {
+				return fmt.Errorf("node %s: keys not sorted", formatKeys(node.keys))
+			}
+			if (lo != nil && bti.cmp(k, lo) <= 0) || (hi != nil && bti.cmp(k, hi) > 0) {
+				return fmt.Errorf("node %s: key %s outside parent bounds", formatKeys(node.keys), kv.FormatKey(k))
+			}
+		}
+		if node.isLeaf {
+			if len(node.keys) != len(node.values) || len(node.children) != 0 {
+				return fmt.Errorf("leaf %s: %d values, %d children", formatKeys(node.keys), len(node.values), len(node.children))
+			}
+			if node != bti.root && len(node.keys) == 0 {
+				return fmt.Errorf("empty leaf at depth %d", depth)
+			}
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				return fmt.Errorf("leaf %s at depth %d, want %d", formatKeys(node.keys), depth, leafDepth)
+			}
+			leaves = append(leaves, node)
+			return nil
+		}
+		if len(node.values) != 0 {
+			return fmt.Errorf("internal node %s has values", formatKeys(node.keys))
+		}
+		if len(node.children) != len(node.keys)+1 {
+			return fmt.Errorf("node %s: %d children for %d keys", formatKeys(node.keys), len(node.children), len(node.keys))
+		}
+		for i, c := range node.children {
+			cl, ch := lo, hi
+			if i > 0 {
+				cl = node.keys[i-1]
+			}
+			if i < len(node.keys) {
+				ch = node.keys[i]
+			}
+			if err := check(c, depth+1, cl, ch); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := check(bti.root, 0, nil, nil); err != nil {
+		return err
+	}
+
+	count := 0
+	for i, leaf := range leaves {
+		count += len(leaf.keys)
+		var prev, next *BTreeIndexNode
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i+1 < len(leaves) {
+			next = leaves[i+1]
+		}
+		if leaf.prev != prev || leaf.next != next {
+			return fmt.Errorf("leaf %s: sibling links do not match tree order", formatKeys(leaf.keys))
+		}
+	}
+	if count != bti.size {
+		return fmt.Errorf("found %d keys, Len() = %d", count, bti.size)
+	}
+	return nil
+}
+
+// String 按层打印树的结构，例如 "[12 25]\n[5 8 10 12] [15 20 25] [30]"，键用 kv.FormatKey 格式化
+func (bti *BTreeIndex) String() string {
+	var lines []string
+	level := []*BTreeIndexNode{bti.root}
+	for len(level) > 0 {
+		var parts []string
+		var next []*BTreeIndexNode
+		for _, n := range level {
+			parts = append(parts, formatKeys(n.keys))
+			next = append(next, n.children...)
+		}
+		lines = append(lines, strings.Join(parts, " "))
+		level = next
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatKeys(keys [][]byte) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = kv.FormatKey(k)
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
@@ -0,0 +1,149 @@
+package btreeindex
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/kvcheck"
+)
+
+// TestModel 用 pkg/kvcheck 把随机序列与 map 模型比较，每一步之后校验叶子链表的 prev/next 与从左到右的叶子顺序一致。
+// 阶数 4 和 5 的分裂点不同，几个键就会长出多层；删除摘掉变空的叶子时要把它从链表中解开，否则 Scan 会沿链表走进已经摘掉的叶子
+func TestModel(t *testing.T) {
+	for _, order := range []int{4, 5, DefaultOrder} {
+		order := order
+		factory := func() (kv.KV, error) { return NewBTreeIndexWithOrder(order, kv.Bytewise), nil }
+		if failure := kvcheck.CheckSeeds(kvcheck.Config{}, factory, 1, 20); failure != nil {
+			t.Fatalf("order %d: %s", order, failure)
+		}
+	}
+}
+
+func intKeys(t *testing.T, bti *BTreeIndex, keys ...int) {
+	t.Helper()
+	for _, k := range keys {
+		if err := bti.Put(kv.IntKey(k), kv.IntKey(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func ints(t *testing.T, values [][]byte) []int {
+	t.Helper()
+	var out []int
+	for _, v := range values {
+		k, err := kv.KeyInt(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, k)
+	}
+	return out
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestRangeScanClosed 检查 RangeScan 包含上界，而 Scan 不包含
+func TestRangeScanClosed(t *testing.T) {
+	bti := NewBTreeIndexWithOrder(4, kv.Bytewise)
+	intKeys(t, bti, 10, 20, 5, 15, 25, 30, 8, 12)
+
+	if got, want := ints(t, bti.RangeScan(kv.IntKey(10), kv.IntKey(25))), []int{10, 12, 15, 20, 25}; !equalInts(got, want) {
+		t.Errorf("RangeScan(10, 25) = %v, want %v", got, want)
+	}
+	if got, want := ints(t, bti.RangeScan(kv.IntKey(26), nil)), []int{30}; !equalInts(got, want) {
+		t.Errorf("RangeScan(26, nil) = %v, want %v", got, want)
+	}
+	var scanned [][]byte
+	if err := bti.Scan(kv.IntKey(10), kv.IntKey(25), func(key, value []byte) bool {
+		scanned = append(scanned, value)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ints(t, scanned), []int{10, 12, 15, 20}; !equalInts(got, want) {
+		t.Errorf("Scan(10, 25) = %v, want %v", got, want)
+	}
+}
+
+// TestScanPage 正反两个方向逐页读完所有键，并拒绝方向不符的令牌
+func TestScanPage(t *testing.T) {
+	bti := NewBTreeIndexWithOrder(4, kv.Bytewise)
+	intKeys(t, bti, 1, 2, 3, 4, 5, 6, 7)
+
+	for _, reverse := range []bool{false, true} {
+		var got []int
+		opts := ScanOptions{Limit: 3, Reverse: reverse}
+		for {
+			page, err := bti.ScanPage(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, ints(t, page.Values)...)
+			if page.NextPageToken == "" {
+				break
+			}
+			opts.PageToken = page.NextPageToken
+		}
+		want := []int{1, 2, 3, 4, 5, 6, 7}
+		if reverse {
+			want = []int{7, 6, 5, 4, 3, 2, 1}
+		}
+		if !equalInts(got, want) {
+			t.Errorf("reverse=%v: pages = %v, want %v", reverse, got, want)
+		}
+	}
+
+	first, err := bti.ScanPage(ScanOptions{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bti.ScanPage(ScanOptions{Limit: 3, Reverse: true, PageToken: first.NextPageToken}); !errors.Is(err, ErrBadPageToken) {
+		t.Errorf("forward token used in reverse: %v, want ErrBadPageToken", err)
+	}
+}
+
+// TestScanPageClamp 令牌来自另一个更大范围的扫描时，下一页仍然不超出本次的 [Start, End)；
+// 令牌中的键在 Start 之前时，等于 Start 的键不能被当作上一页的最后一个键跳过
+func TestScanPageClamp(t *testing.T) {
+	bti := NewBTreeIndexWithOrder(4, kv.Bytewise)
+	intKeys(t, bti, 1, 2, 3, 4, 5, 6, 7)
+
+	tests := []struct {
+		name       string
+		from, opts ScanOptions // from 产生令牌，opts 用这个令牌翻页
+		want       []int
+	}{
+		{"forward token before Start", ScanOptions{Limit: 2}, ScanOptions{Start: kv.IntKey(4), End: kv.IntKey(6), Limit: 5}, []int{4, 5}},
+		{"forward token after End", ScanOptions{Limit: 6}, ScanOptions{Start: kv.IntKey(2), End: kv.IntKey(5), Limit: 5}, nil},
+		{"forward token inside", ScanOptions{Start: kv.IntKey(2), Limit: 2}, ScanOptions{Start: kv.IntKey(2), End: kv.IntKey(6), Limit: 5}, []int{4, 5}},
+		{"reverse token after End", ScanOptions{Reverse: true, Limit: 2}, ScanOptions{Start: kv.IntKey(2), End: kv.IntKey(4), Reverse: true, Limit: 5}, []int{3, 2}},
+		{"reverse token before Start", ScanOptions{Reverse: true, Limit: 6}, ScanOptions{Start: kv.IntKey(3), End: kv.IntKey(6), Reverse: true, Limit: 5}, nil},
+		{"reverse token inside", ScanOptions{End: kv.IntKey(7), Reverse: true, Limit: 2}, ScanOptions{Start: kv.IntKey(2), End: kv.IntKey(7), Reverse: true, Limit: 5}, []int{4, 3, 2}},
+	}
+	for _, tt := range tests {
+		from, err := bti.ScanPage(tt.from)
+		if err != nil || from.NextPageToken == "" {
+			t.Fatalf("%s: first page %v, %v", tt.name, ints(t, from.Values), err)
+		}
+		tt.opts.PageToken = from.NextPageToken
+		page, err := bti.ScanPage(tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := ints(t, page.Values); !equalInts(got, tt.want) {
+			t.Errorf("%s: page = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
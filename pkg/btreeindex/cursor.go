@@ -0,0 +1,216 @@
+package btreeindex
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// Cursor 是 BTreeIndex 上的游标，指向某个叶子中的一个键，沿叶子链表向前或向后移动，
+// 不需要回到根节点，所以可以流式地遍历很大的范围。
+//
+// 游标记住定位时的键值。树在游标存在期间被修改（节点可能分裂或被摘掉）后，下一次 Next/Prev
+// 先从根重新查找当前键再移动，因此边遍历边写入也不会跳过或重复键，遍历时看到的是每个键在读到那一刻的值
+type Cursor struct {
+	bti     *BTreeIndex
+	leaf    *BTreeIndexNode // nil 表示游标无效（还没定位，或者已经移出了两端）
+	pos     int
+	version uint64
+	key     []byte
+	value   []byte
+}
+
+// Cursor 返回一个还没定位的游标，先调用 Seek、SeekBefore、First 或 Last
+func (bti *BTreeIndex) Cursor() *Cursor {
+	return &Cursor{bti: bti}
+}
+
+// Valid 报告游标是否指向一个键
+func (c *Cursor) Valid() bool { return c.leaf != nil }
+
+// Key 返回当前的键，游标无效时返回 nil。调用方不能修改返回的切片
+func (c *Cursor) Key() []byte { return c.key }
+
+// Value 返回当前的值，游标无效时返回 nil。调用方不能修改返回的切片
+func (c *Cursor) Value() []byte { return c.value }
+
+// set 把游标放到 leaf 的第 pos 个键上；pos 越过叶子的两端时移到相邻的叶子，没有相邻叶子时游标失效
+func (c *Cursor) set(leaf *BTreeIndexNode, pos int) bool {
+	if leaf != nil && pos >= len(leaf.keys) {
+		leaf, pos = leaf.next, 0
+	} else if leaf != nil && pos < 0 {
+		leaf = leaf.prev
+		if leaf != nil {
+			pos = len(leaf.keys) - 1
+		}
+	}
+	// 空叶子只可能是空树的根
+	if leaf == nil || len(leaf.keys) == 0 {
+		c.leaf, c.key, c.value = nil, nil, nil
+		return false
+	}
+	c.leaf, c.pos, c.version = leaf, pos, c.bti.version
+	c.key, c.value = leaf.keys[pos], leaf.values[pos]
+	return true
+}
+
+// Seek 把游标定位到第一个不小于 key 的键，key 为 nil 时定位到第一个键。没有这样的键时返回 false
+func (c *Cursor) Seek(key []byte) bool {
+	if key == nil {
+		return c.First()
+	}
+	leaf := c.bti.leafFor(key)
+	return c.set(leaf, c.bti.position(leaf, key))
+}
+
+// SeekBefore 把游标定位到最后一个小于 key 的键，key 为 nil 时定位到最后一个键，用于从范围的右端开始反向遍历
+func (c *Cursor) SeekBefore(key []byte) bool {
+	if key == nil {
+		return c.Last()
+	}
+	leaf := c.bti.leafFor(key)
+	return c.set(leaf, c.bti.position(leaf, key)-1)
+}
+
+// First 把游标定位到最小的键
+func (c *Cursor) First() bool {
+	node := c.bti.root
+	for !node.isLeaf {
+		node = node.children[0]
+	}
+	return c.set(node, 0)
+}
+
+// Last 把游标定位到最大的键
+func (c *Cursor) Last() bool {
+	node := c.bti.root
+	for !node.isLeaf {
+		node = node.children[len(node.children)-1]
+	}
+	return c.set(node, len(node.keys)-1)
+}
+
+// Next 移到下一个键，已经是最后一个键时游标失效并返回 false
+func (c *Cursor) Next() bool {
+	if c.leaf == nil {
+		return false
+	}
+	if c.version != c.bti.version {
+		// 树被修改过，原来的叶子可能已经分裂或被摘掉：重新找到第一个大于当前键的键
+		key := c.key
+		if !c.Seek(key) || c.bti.cmp(c.key, key) > 0 {
+			return c.Valid()
+		}
+	}
+	return c.set(c.leaf, c.pos+1)
+}
+
+// Prev 移到上一个键，已经是第一个键时游标失效并返回 false
+func (c *Cursor) Prev() bool {
+	if c.leaf == nil {
+		return false
+	}
+	if c.version != c.bti.version {
+		return c.SeekBefore(c.key)
+	}
+	return c.set(c.leaf, c.pos-1)
+}
+
+// ScanReverse 与 Scan 相同，但按键从大到小遍历 [start, end)
+func (bti *BTreeIndex) ScanReverse(start, end []byte, fn func(key, value []byte) bool) error {
+	return bti.iterate(start, end, true, fn)
+}
+
+// iterate 用游标按正向或反向遍历 [start, end)
+func (bti *BTreeIndex) iterate(start, end []byte, reverse bool, fn func(key, value []byte) bool) error {
+	c := bti.Cursor()
+	if reverse {
+		for ok := c.SeekBefore(end); ok && (start == nil || bti.cmp(c.Key(), start) >= 0); ok = c.Prev() {
+			if !fn(c.Key(), c.Value()) {
+				break
+			}
+		}
+		return nil
+	}
+	for ok := c.Seek(start); ok && (end == nil || bti.cmp(c.Key(), end) < 0); ok = c.Next() {
+		if !fn(c.Key(), c.Value()) {
+			break
+		}
+	}
+	return nil
+}
+
+// ErrBadPageToken 表示分页令牌无法解码，或者与请求的扫描方向不一致
+var ErrBadPageToken = errors.New("btreeindex: invalid page token")
+
+// ScanOptions 描述一次分页扫描
+type ScanOptions struct {
+	Start, End []byte // 扫描 [Start, End)，nil 表示不限
+	Reverse    bool   // 按键从大到小返回
+	Limit      int    // 每页最多返回的条数，0 表示不限
+	PageToken  string // 上一页的 NextPageToken，空表示从第一页开始
+}
+
+// Page 是分页扫描的一页结果
+type Page struct {
+	Keys          [][]byte
+	Values        [][]byte
+	NextPageToken string // 后面还有数据时非空，传给下一次 ScanPage 继续扫描
+}
+
+// 令牌的第一个字节是方向，后面是本页的最后一个键
+const (
+	tokenForward = 'f'
+	tokenReverse = 'r'
+)
+
+// ScanPage 返回 [Start, End) 中的一页结果。令牌只记录上一页的最后一个键，下一页从紧挨着它的键开始，
+// 所以两页之间的写入不会让翻页出错：插入的键如果落在还没读到的部分就会出现在后面的页中，
+// 已经读过的部分不会重复出现。服务端不需要为翻页保存任何状态。
+// 令牌只会缩小 [Start, End)：令牌中的键在范围之外（比如来自另一个范围的扫描）时仍然不返回范围外的键
+func (bti *BTreeIndex) ScanPage(opts ScanOptions) (Page, error) {
+	dir := byte(tokenForward)
+	if opts.Reverse {
+		dir = tokenReverse
+	}
+	start, end := opts.Start, opts.End
+	var last []byte
+	if opts.PageToken != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(opts.PageToken)
+		if err != nil || len(raw) == 0 || raw[0] != dir {
+			return Page{}, ErrBadPageToken
+		}
+		last = raw[1:]
+		// 反向时上一页停在 last，下一页只看比它小的键：end = min(End, last)；
+		// 正向时从比它大的键开始：start = max(Start, last)
+		if opts.Reverse {
+			if end == nil || bti.cmp(last, end) < 0 {
+				end = last
+			}
+		} else if start == nil || bti.cmp(last, start) > 0 {
+			start = last
+		}
+	}
+
+	var page Page
+	more := false
+	err := bti.iterate(start, end, opts.Reverse, func(key, value []byte) bool {
+		if !opts.Reverse && opts.PageToken != "" && bti.cmp(key, last) == 0 {
+			return true
+		}
+		if opts.Limit > 0 && len(page.Keys) == opts.Limit {
+			more = true
+			return false
+		}
+		page.Keys = append(page.Keys, key)
+		page.Values = append(page.Values, value)
+		return true
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	if more {
+		token := append([]byte{dir}, page.Keys[len(page.Keys)-1]...)
+		page.NextPageToken = base64.RawURLEncoding.EncodeToString(token)
+	}
+	return page, nil
+}
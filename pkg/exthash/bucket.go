@@ -0,0 +1,156 @@
+package exthash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ddia-labs/pkg/pager"
+)
+
+// 页布局（小端序）：
+//
+//	头部页: magic(4) | 全局深度(1) | 保留(3) | 条目数(8) | 桶数(4) | 目录页数(2) | 目录页号 × 目录页数(4)
+//	目录页: 1024 × 桶页号(4)，目录项 i 位于第 i/1024 个目录页的第 i%1024 项
+//	桶页:   kind(1) | 局部深度(1) | count(2) | count × [klen(2) | vlen(2) | key | value]
+const (
+	headerMagic    = "EXTH"
+	headerDepth    = 4
+	headerCount    = 8
+	headerBuckets  = 16
+	headerNumDir   = 20
+	headerDirPages = 22
+	entriesPerDir  = pager.PageSize / 4
+	kindBucket     = 3
+	bucketHeader   = 4
+	entryFixed     = 4
+	mergeThreshold = pager.PageSize / 2
+)
+
+// bucket 是解码后的桶页，键值都是从页中复制出来的
+type bucket struct {
+	depth  int // 局部深度：桶中所有键的哈希值低 depth 位相同
+	keys   [][]byte
+	values [][]byte
+}
+
+// size 返回桶编码后的字节数
+func (b *bucket) size() int {
+	sz := bucketHeader
+	for i := range b.keys {
+		sz += entryFixed + len(b.keys[i]) + len(b.values[i])
+	}
+	return sz
+}
+
+// find 返回 key 在桶中的下标，不存在时返回 -1
+func (b *bucket) find(key []byte) int {
+	for i, k := range b.keys {
+		if bytes.Equal(k, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *bucket) add(key, value []byte) {
+	b.keys = append(b.keys, key)
+	b.values = append(b.values, value)
+}
+
+func (b *bucket) remove(i int) {
+	last := len(b.keys) - 1
+	b.keys[i], b.values[i] = b.keys[last], b.values[last]
+	b.keys, b.values = b.keys[:last], b.values[:last]
+}
+
+func (b *bucket) encode(buf []byte) {
+	le := binary.LittleEndian
+	clear(buf)
+	buf[0] = kindBucket
+	buf[1] = byte(b.depth)
+	le.PutUint16(buf[2:], uint16(len(b.keys)))
+	off := bucketHeader
+	for i, k := range b.keys {
+		le.PutUint16(buf[off:], uint16(len(k)))
+		le.PutUint16(buf[off+2:], uint16(len(b.values[i])))
+		off += entryFixed
+		off += copy(buf[off:], k)
+		off += copy(buf[off:], b.values[i])
+	}
+}
+
+// decodeBucket 解码桶页；页内容被破坏时返回错误而不是越界
+func decodeBucket(buf []byte) (*bucket, error) {
+	le := binary.LittleEndian
+	if buf[0] != kindBucket {
+		return nil, fmt.Errorf("exthash: page is not a bucket (kind %d)", buf[0])
+	}
+	count := int(le.Uint16(buf[2:]))
+	b := &bucket{depth: int(buf[1]), keys: make([][]byte, 0, count), values: make([][]byte, 0, count)}
+	off := bucketHeader
+	for i := 0; i < count; i++ {
+		if off+entryFixed > len(buf) {
+			return nil, fmt.Errorf("exthash: bucket entry %d out of page bounds", i)
+		}
+		klen, vlen := int(le.Uint16(buf[off:])), int(le.Uint16(buf[off+2:]))
+		off += entryFixed
+		if off+klen+vlen > len(buf) {
+			return nil, fmt.Errorf("exthash: bucket entry %d out of page bounds", i)
+		}
+		b.add(bytes.Clone(buf[off:off+klen]), bytes.Clone(buf[off+klen:off+klen+vlen]))
+		off += klen + vlen
+	}
+	return b, nil
+}
+
+// header 是头部页在内存中的副本，每次修改后写回头部页（经过缓冲池，随提交写入 WAL）
+type header struct {
+	globalDepth int
+	count       int64
+	buckets     int
+	dirPages    []pager.PageID
+}
+
+func (h *header) encode(buf []byte) {
+	le := binary.LittleEndian
+	clear(buf)
+	copy(buf, headerMagic)
+	buf[headerDepth] = byte(h.globalDepth)
+	le.PutUint64(buf[headerCount:], uint64(h.count))
+	le.PutUint32(buf[headerBuckets:], uint32(h.buckets))
+	le.PutUint16(buf[headerNumDir:], uint16(len(h.dirPages)))
+	for i, id := range h.dirPages {
+		le.PutUint32(buf[headerDirPages+4*i:], uint32(id))
+	}
+}
+
+func decodeHeader(buf []byte, numPages int) (*header, error) {
+	le := binary.LittleEndian
+	if string(buf[:4]) != headerMagic {
+		return nil, fmt.Errorf("exthash: root page is not a hash index header")
+	}
+	h := &header{
+		globalDepth: int(buf[headerDepth]),
+		count:       int64(le.Uint64(buf[headerCount:])),
+		buckets:     int(le.Uint32(buf[headerBuckets:])),
+	}
+	n := int(le.Uint16(buf[headerNumDir:]))
+	if h.globalDepth > MaxGlobalDepth || n != dirPagesFor(h.globalDepth) || h.count < 0 || h.buckets < 1 || h.buckets > numPages {
+		return nil, fmt.Errorf("exthash: corrupt header (global depth %d, %d directory pages, %d buckets, %d entries)",
+			h.globalDepth, n, h.buckets, h.count)
+	}
+	for i := 0; i < n; i++ {
+		id := pager.PageID(le.Uint32(buf[headerDirPages+4*i:]))
+		if id == pager.InvalidPage || int(id) >= numPages {
+			return nil, fmt.Errorf("exthash: corrupt header: directory page %d", id)
+		}
+		h.dirPages = append(h.dirPages, id)
+	}
+	return h, nil
+}
+
+// dirPagesFor 返回全局深度为 depth 的目录需要的目录页数
+func dirPagesFor(depth int) int {
+	return max(1, (1<<depth)/entriesPerDir)
+}
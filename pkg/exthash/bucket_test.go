@@ -0,0 +1,61 @@
+package exthash
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ddia-labs/pkg/pager"
+)
+
+// FuzzDecodeBucket 解码任意的页内容：被破坏的桶页必须返回错误而不是越界 panic，
+// 能解码的桶重新编码后必须得到同样的条目
+func FuzzDecodeBucket(f *testing.F) {
+	var buf [pager.PageSize]byte
+	(&bucket{depth: 3, keys: [][]byte{[]byte("a"), []byte("key")}, values: [][]byte{nil, []byte("value")}}).encode(buf[:])
+	f.Add(append([]byte(nil), buf[:64]...))
+	f.Add([]byte{kindBucket, 1, 0xFF, 0xFF})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var page [pager.PageSize]byte
+		copy(page[:], data)
+		b, err := decodeBucket(page[:])
+		if err != nil || b.size() > pager.PageSize {
+			return
+		}
+		var again [pager.PageSize]byte
+		b.encode(again[:])
+		c, err := decodeBucket(again[:])
+		if err != nil {
+			t.Fatalf("re-encoded bucket does not decode: %v", err)
+		}
+		if c.depth != b.depth || len(c.keys) != len(b.keys) {
+			t.Fatalf("round trip changed the bucket: depth %d -> %d, %d -> %d keys", b.depth, c.depth, len(b.keys), len(c.keys))
+		}
+		for i := range b.keys {
+			if !bytes.Equal(b.keys[i], c.keys[i]) || !bytes.Equal(b.values[i], c.values[i]) {
+				t.Fatalf("round trip changed entry %d", i)
+			}
+		}
+	})
+}
+
+// FuzzDecodeHeader 解码任意的头部页，页号、深度和目录页数不合理时必须返回错误
+func FuzzDecodeHeader(f *testing.F) {
+	var buf [pager.PageSize]byte
+	(&header{globalDepth: 2, count: 10, buckets: 3, dirPages: []pager.PageID{2}}).encode(buf[:])
+	f.Add(append([]byte(nil), buf[:64]...), 8)
+
+	f.Fuzz(func(t *testing.T, data []byte, numPages int) {
+		var page [pager.PageSize]byte
+		copy(page[:], data)
+		h, err := decodeHeader(page[:], numPages)
+		if err != nil {
+			return
+		}
+		for _, id := range h.dirPages {
+			if id == pager.InvalidPage || int(id) >= numPages {
+				t.Fatalf("accepted directory page %d of %d", id, numPages)
+			}
+		}
+	})
+}
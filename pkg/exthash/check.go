@@ -0,0 +1,102 @@
+package exthash
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/pager"
+)
+
+// CheckInvariants 校验整个索引的结构，崩溃恢复后用它确认分裂和合并没有被撕裂：
+//   - 目录页数与全局深度相符，每个桶的局部深度不超过全局深度
+//   - 局部深度为 d 的桶恰好被 2^(全局深度-d) 个目录项指向，这些目录项的低 d 位相同，
+//     桶中每个键的哈希值低 d 位也与之相同
+//   - 键不重复，桶数和条目总数与头部页中的计数一致
+//   - 头部页、目录页、桶页和空闲页恰好覆盖整个文件（没有泄漏）
+func (ix *Index) CheckInvariants() error {
+	gd := ix.hdr.globalDepth
+	if len(ix.hdr.dirPages) != dirPagesFor(gd) {
+		return fmt.Errorf("global depth %d needs %d directory pages, header lists %d", gd, dirPagesFor(gd), len(ix.hdr.dirPages))
+	}
+	p := ix.pool.Pager()
+	seen := map[pager.PageID]bool{p.Root(): true}
+	for _, id := range ix.hdr.dirPages {
+		if seen[id] {
+			return fmt.Errorf("directory page %d is referenced twice", id)
+		}
+		seen[id] = true
+	}
+
+	refs := make(map[pager.PageID]int)
+	pattern := make(map[pager.PageID]int)
+	var count int64
+	for i := 0; i < 1<<gd; i++ {
+		id, err := ix.dir(i)
+		if err != nil {
+			return err
+		}
+		if id == pager.InvalidPage || int(id) >= p.NumPages() {
+			return fmt.Errorf("directory entry %d: dangling page pointer %d", i, id)
+		}
+		b, err := ix.readBucket(id)
+		if err != nil {
+			return fmt.Errorf("directory entry %d: %w", i, err)
+		}
+		mask := 1<<b.depth - 1
+		if refs[id] == 0 {
+			if seen[id] {
+				return fmt.Errorf("bucket page %d is also a header or directory page", id)
+			}
+			seen[id] = true
+			pattern[id] = i & mask
+			for j, k := range b.keys {
+				if int(hash(k))&mask != i&mask {
+					return fmt.Errorf("bucket %d (depth %d, pattern %b): key %s hashes elsewhere", id, b.depth, i&mask, kv.FormatKey(k))
+				}
+				for _, prev := range b.keys[:j] {
+					if kv.Bytewise(prev, k) == 0 {
+						return fmt.Errorf("bucket %d: duplicate key %s", id, kv.FormatKey(k))
+					}
+				}
+			}
+			count += int64(len(b.keys))
+		} else if pattern[id] != i&mask {
+			return fmt.Errorf("bucket %d (depth %d, pattern %b) is also referenced by directory entry %d", id, b.depth, pattern[id], i)
+		}
+		refs[id]++
+	}
+	for id, n := range refs {
+		b, err := ix.readBucket(id)
+		if err != nil {
+			return err
+		}
+		if want := 1 << (gd - b.depth); n != want {
+			return fmt.Errorf("bucket %d (depth %d) is referenced by %d directory entries, want %d", id, b.depth, n, want)
+		}
+	}
+	if len(refs) != ix.hdr.buckets {
+		return fmt.Errorf("directory points to %d buckets, header says %d", len(refs), ix.hdr.buckets)
+	}
+	if count != ix.hdr.count {
+		return fmt.Errorf("buckets hold %d entries, header says %d", count, ix.hdr.count)
+	}
+
+	// 空闲链表中的页不能被索引使用，也不能成环
+	for id := p.FreeHead(); id != pager.InvalidPage; {
+		if seen[id] {
+			return fmt.Errorf("free page %d is also in use (or free list has a cycle)", id)
+		}
+		seen[id] = true
+		pg, err := ix.pool.Fetch(id)
+		if err != nil {
+			return err
+		}
+		id = pager.PageID(binary.LittleEndian.Uint32(pg.Data[:4]))
+		ix.pool.Unpin(pg, false)
+	}
+	if used := 1 + len(seen); used != p.NumPages() {
+		return fmt.Errorf("%d pages in file but %d reachable from index and free list", p.NumPages(), used)
+	}
+	return nil
+}
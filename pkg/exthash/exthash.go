@@ -0,0 +1,586 @@
+// Package exthash 实现了一个存放在页文件中的可扩展哈希（extendible hashing）索引：
+// 目录是 2^全局深度 个桶指针，用键的哈希值的低位做下标；每个桶是一个页，记录自己的局部深度。
+// 桶满时只分裂这一个桶（局部深度加一，按下一位哈希值把条目分到两个页），
+// 只有局部深度等于全局深度时目录才需要翻倍；翻倍只是复制目录项，不搬动任何桶。
+// 删除后相邻的“伙伴”桶足够空时合并，所有桶的局部深度都小于全局深度时目录减半。
+//
+// 目录只有每个桶 4 字节，数据远大于内存时它仍然可以常驻缓冲池，
+// 因此一次点查只需要读一个桶页——这正是 DDIA 中哈希索引“把键映射到磁盘位置”的做法。
+// 代价是键没有顺序，Scan 只能读遍所有桶。
+//
+// 与 bptree 一样，页通过 pager.BufferPool 读写并使用页级 WAL（path + ".wal"）：
+// 每次分裂、目录翻倍、合并和写入都是一次提交，崩溃后索引停留在最后一次提交后的状态；
+// 其中任何一步出错时撤销它修改过的页并重新读取头部，效果与崩溃相同。
+package exthash
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/pager"
+)
+
+var (
+	ErrTooLarge = errors.New("exthash: key and value too large")
+	// ErrDirectoryFull 表示桶已经分裂到最大深度仍然放不下（大量键的哈希值低位全部相同）
+	ErrDirectoryFull = errors.New("exthash: directory reached MaxGlobalDepth")
+)
+
+const (
+	// MaxGlobalDepth 是全局深度的上限：目录最多 2^16 项、64 个目录页，约可容纳 256MB 的桶
+	MaxGlobalDepth = 16
+	// MaxEntrySize 是单个条目键和值的最大总字节数，保证分裂后任何一个条目都能放进桶
+	MaxEntrySize = pager.PageSize / 4
+	// MinPoolPages 是缓冲池的最小页数：一次提交修改过的页在提交前不能被淘汰，
+	// 分裂或合并最坏情况下要改写所有目录页
+	MinPoolPages = 1<<(MaxGlobalDepth-10) + 16
+)
+
+// Index 是磁盘上的可扩展哈希索引，实现了 kv.KV
+type Index struct {
+	pool *pager.BufferPool
+	hdr  *header
+
+	splits, merges, doublings, halvings int64
+}
+
+var _ kv.KV = (*Index)(nil)
+
+// Stats 汇总了目录、桶的形状以及页 I/O 统计。获取它不读任何页，前后两次的差值就是期间的 I/O
+type Stats struct {
+	GlobalDepth int
+	DirPages    int
+	Buckets     int
+	Entries     int64
+	Pages       int   // 页文件中的页数（含元数据页和空闲页）
+	FreePages   int   // 由页数推算，不遍历空闲链表
+	Splits      int64 // 自打开以来的桶分裂次数
+	Merges      int64
+	Doublings   int64 // 目录翻倍次数
+	Halvings    int64
+	pager.Stats
+	pager.PoolStats
+	WAL pager.WALStats
+}
+
+// Open 打开（或创建）path 处的哈希索引，缓冲池最多缓存 poolPages 个页。
+// 如果上次没有正常关闭，会先用 WAL 重做已提交的操作。
+func Open(path string, poolPages int) (*Index, error) {
+	p, err := pager.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	wal, err := pager.OpenWAL(p, path+".wal")
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	ix := &Index{pool: pager.NewBufferPool(p, max(poolPages, MinPoolPages))}
+	ix.pool.SetWAL(wal)
+	if p.Root() == pager.InvalidPage {
+		err = ix.create()
+	} else {
+		err = ix.readHeader()
+	}
+	if err != nil {
+		ix.pool.Abort()
+		ix.pool.Close()
+		return nil, err
+	}
+	return ix, nil
+}
+
+// create 初始化新文件：头部页、一个目录页和一个局部深度为 0 的空桶
+func (ix *Index) create() error {
+	hp, err := ix.pool.NewPage()
+	if err != nil {
+		return err
+	}
+	ix.pool.Unpin(hp, true)
+	dp, err := ix.pool.NewPage()
+	if err != nil {
+		return err
+	}
+	ix.pool.Unpin(dp, true)
+	ix.hdr = &header{buckets: 1, dirPages: []pager.PageID{dp.ID}}
+	id, err := ix.newBucket(&bucket{})
+	if err != nil {
+		return err
+	}
+	if err := ix.setDir(0, id); err != nil {
+		return err
+	}
+	ix.pool.Pager().SetRoot(hp.ID)
+	if err := ix.writeHeader(); err != nil {
+		return err
+	}
+	return ix.pool.Commit()
+}
+
+func (ix *Index) readHeader() error {
+	pg, err := ix.pool.Fetch(ix.pool.Pager().Root())
+	if err != nil {
+		return err
+	}
+	defer ix.pool.Unpin(pg, false)
+	ix.hdr, err = decodeHeader(pg.Data[:], ix.pool.Pager().NumPages())
+	return err
+}
+
+// atomic 执行一步修改并提交。op 或提交失败时撤销本次修改过的页，并从头部页重新读取内存中的头部：
+// 条目数、全局深度、目录页和桶数可能已经改了一半，留着它们会让之后的提交写进一个错误的头部
+func (ix *Index) atomic(op func() error) error {
+	err := op()
+	if err == nil {
+		err = ix.pool.Commit()
+	}
+	if err != nil {
+		if aerr := ix.pool.Abort(); aerr != nil {
+			return fmt.Errorf("%w (abort: %v)", err, aerr)
+		}
+		if herr := ix.readHeader(); herr != nil {
+			return fmt.Errorf("%w (reload header: %v)", err, herr)
+		}
+	}
+	return err
+}
+
+func (ix *Index) writeHeader() error {
+	pg, err := ix.pool.Fetch(ix.pool.Pager().Root())
+	if err != nil {
+		return err
+	}
+	ix.hdr.encode(pg.Data[:])
+	ix.pool.Unpin(pg, true)
+	return nil
+}
+
+// SetSyncCommits 设置每次提交后是否 fsync WAL（默认开启）
+func (ix *Index) SetSyncCommits(sync bool) { ix.pool.WAL().SetSync(sync) }
+
+// SetCheckpointBytes 设置 WAL 超过多少字节后做检查点（默认 pager.DefaultCheckpointBytes）
+func (ix *Index) SetCheckpointBytes(n int64) { ix.pool.SetCheckpointBytes(n) }
+
+// Len 返回条目数
+func (ix *Index) Len() int64 { return ix.hdr.count }
+
+// hash 是 64 位 FNV-1a。哈希值决定了条目在哪个桶，会被持久化，所以不能使用随机种子
+func hash(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// slot 返回哈希值在目录中的下标：低“全局深度”位
+func (ix *Index) slot(h uint64) int {
+	return int(h & (1<<ix.hdr.globalDepth - 1))
+}
+
+func (ix *Index) dir(i int) (pager.PageID, error) {
+	pg, err := ix.pool.Fetch(ix.hdr.dirPages[i/entriesPerDir])
+	if err != nil {
+		return pager.InvalidPage, err
+	}
+	defer ix.pool.Unpin(pg, false)
+	return pager.PageID(binary.LittleEndian.Uint32(pg.Data[i%entriesPerDir*4:])), nil
+}
+
+func (ix *Index) setDir(i int, id pager.PageID) error {
+	pg, err := ix.pool.Fetch(ix.hdr.dirPages[i/entriesPerDir])
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(pg.Data[i%entriesPerDir*4:], uint32(id))
+	ix.pool.Unpin(pg, true)
+	return nil
+}
+
+func (ix *Index) readBucket(id pager.PageID) (*bucket, error) {
+	pg, err := ix.pool.Fetch(id)
+	if err != nil {
+		return nil, err
+	}
+	defer ix.pool.Unpin(pg, false)
+	b, err := decodeBucket(pg.Data[:])
+	if err == nil && b.depth > ix.hdr.globalDepth {
+		err = fmt.Errorf("exthash: bucket %d has local depth %d > global depth %d", id, b.depth, ix.hdr.globalDepth)
+	}
+	return b, err
+}
+
+func (ix *Index) writeBucket(id pager.PageID, b *bucket) error {
+	pg, err := ix.pool.Fetch(id)
+	if err != nil {
+		return err
+	}
+	b.encode(pg.Data[:])
+	ix.pool.Unpin(pg, true)
+	return nil
+}
+
+func (ix *Index) newBucket(b *bucket) (pager.PageID, error) {
+	pg, err := ix.pool.NewPage()
+	if err != nil {
+		return pager.InvalidPage, err
+	}
+	b.encode(pg.Data[:])
+	ix.pool.Unpin(pg, true)
+	return pg.ID, nil
+}
+
+// lookup 返回 key 的哈希值所在的目录下标、桶页和解码后的桶
+func (ix *Index) lookup(h uint64) (int, pager.PageID, *bucket, error) {
+	i := ix.slot(h)
+	id, err := ix.dir(i)
+	if err != nil {
+		return 0, pager.InvalidPage, nil, err
+	}
+	b, err := ix.readBucket(id)
+	return i, id, b, err
+}
+
+// Get 查找 key：读一个目录页（通常在缓冲池中）和一个桶页
+func (ix *Index) Get(key []byte) ([]byte, bool, error) {
+	_, _, b, err := ix.lookup(hash(key))
+	if err != nil {
+		return nil, false, err
+	}
+	if i := b.find(key); i >= 0 {
+		return b.values[i], true, nil
+	}
+	return nil, false, nil
+}
+
+// Put 插入或更新 key。桶放不下时先分裂它（单独提交），然后重试，
+// 直到新条目所在的桶放得下为止
+func (ix *Index) Put(key, value []byte) error {
+	if len(key)+len(value) > MaxEntrySize {
+		return ErrTooLarge
+	}
+	h := hash(key)
+	for {
+		slot, id, b, err := ix.lookup(h)
+		if err != nil {
+			return err
+		}
+		i := b.find(key)
+		if i >= 0 {
+			b.values[i] = value
+		} else {
+			b.add(key, value)
+		}
+		if b.size() <= pager.PageSize {
+			return ix.atomic(func() error {
+				if err := ix.writeBucket(id, b); err != nil {
+					return err
+				}
+				if i >= 0 {
+					return nil
+				}
+				ix.hdr.count++
+				return ix.writeHeader()
+			})
+		}
+		if err := ix.split(slot, id); err != nil {
+			return err
+		}
+	}
+}
+
+// split 把目录下标 slot 指向的桶 id 一分为二：局部深度加一，哈希值第 depth 位为 1 的条目移到新页，
+// 目录中原来指向它、且该位为 1 的那一半目录项改为指向新页。局部深度已经等于全局深度时先把目录翻倍（翻倍和分裂各自提交）。
+func (ix *Index) split(slot int, id pager.PageID) error {
+	b, err := ix.readBucket(id)
+	if err != nil {
+		return err
+	}
+	if b.depth == ix.hdr.globalDepth {
+		if ix.hdr.globalDepth == MaxGlobalDepth {
+			return ErrDirectoryFull
+		}
+		if err := ix.atomic(ix.double); err != nil {
+			return err
+		}
+		ix.doublings++
+	}
+	if err := ix.atomic(func() error { return ix.splitBucket(slot, id, b) }); err != nil {
+		return err
+	}
+	ix.splits++
+	return nil
+}
+
+// splitBucket 把桶 b 的条目分到原页和一个新页，并更新目录和头部
+func (ix *Index) splitBucket(slot int, id pager.PageID, b *bucket) error {
+	bit := 1 << b.depth
+	lo, hi := &bucket{depth: b.depth + 1}, &bucket{depth: b.depth + 1}
+	for i, k := range b.keys {
+		if hash(k)&uint64(bit) != 0 {
+			hi.add(k, b.values[i])
+		} else {
+			lo.add(k, b.values[i])
+		}
+	}
+	hiID, err := ix.newBucket(hi)
+	if err != nil {
+		return err
+	}
+	if err := ix.writeBucket(id, lo); err != nil {
+		return err
+	}
+	// 低 depth+1 位等于 (slot 的低 depth 位 | bit) 的目录项每隔 2*bit 出现一次
+	for j := slot&(bit-1) | bit; j < 1<<ix.hdr.globalDepth; j += bit << 1 {
+		if err := ix.setDir(j, hiID); err != nil {
+			return err
+		}
+	}
+	ix.hdr.buckets++
+	return ix.writeHeader()
+}
+
+// double 把目录翻倍：新的后一半是前一半的副本，桶一个都不用动
+func (ix *Index) double() error {
+	n := 1 << ix.hdr.globalDepth
+	for len(ix.hdr.dirPages) < dirPagesFor(ix.hdr.globalDepth+1) {
+		pg, err := ix.pool.NewPage()
+		if err != nil {
+			return err
+		}
+		ix.pool.Unpin(pg, true)
+		ix.hdr.dirPages = append(ix.hdr.dirPages, pg.ID)
+	}
+	for i := 0; i < n; i++ {
+		id, err := ix.dir(i)
+		if err != nil {
+			return err
+		}
+		if err := ix.setDir(i+n, id); err != nil {
+			return err
+		}
+	}
+	ix.hdr.globalDepth++
+	return ix.writeHeader()
+}
+
+// Delete 删除 key。删除后尝试与伙伴桶合并，并在可能时把目录减半
+func (ix *Index) Delete(key []byte) error {
+	h := hash(key)
+	_, id, b, err := ix.lookup(h)
+	if err != nil {
+		return err
+	}
+	i := b.find(key)
+	if i < 0 {
+		return nil
+	}
+	b.remove(i)
+	err = ix.atomic(func() error {
+		if err := ix.writeBucket(id, b); err != nil {
+			return err
+		}
+		ix.hdr.count--
+		return ix.writeHeader()
+	})
+	if err != nil {
+		return err
+	}
+	merged, err := ix.merge(h)
+	if err != nil || !merged {
+		return err
+	}
+	return ix.shrink()
+}
+
+// merge 反复把哈希值 h 所在的桶与它的伙伴（局部深度相同、只有最高一位不同的桶）合并，
+// 直到伙伴的深度不同或合并后超过半页——留出一半空间，避免在边界上反复分裂和合并。
+// 每次合并单独提交
+func (ix *Index) merge(h uint64) (bool, error) {
+	merged := false
+	for {
+		var ok bool
+		err := ix.atomic(func() (err error) {
+			ok, err = ix.mergeBuddy(h)
+			return err
+		})
+		if err != nil || !ok {
+			return merged, err
+		}
+		ix.merges++
+		merged = true
+	}
+}
+
+// mergeBuddy 尝试把哈希值 h 所在的桶与伙伴合并一次，返回是否合并了
+func (ix *Index) mergeBuddy(h uint64) (bool, error) {
+	slot, id, b, err := ix.lookup(h)
+	if err != nil || b.depth == 0 {
+		return false, err
+	}
+	bit := 1 << (b.depth - 1)
+	buddyID, err := ix.dir(slot ^ bit)
+	if err != nil {
+		return false, err
+	}
+	if buddyID == id {
+		return false, fmt.Errorf("exthash: bucket %d with local depth %d is its own buddy", id, b.depth)
+	}
+	buddy, err := ix.readBucket(buddyID)
+	if err != nil {
+		return false, err
+	}
+	if buddy.depth != b.depth || b.size()+buddy.size()-bucketHeader > mergeThreshold {
+		return false, nil
+	}
+
+	// 保留该位为 0 的桶，该位为 1 的桶的目录项改为指向它，然后回收后者
+	keep, drop := id, buddyID
+	if slot&bit != 0 {
+		keep, drop = buddyID, id
+	}
+	for i, k := range buddy.keys {
+		b.add(k, buddy.values[i])
+	}
+	b.depth--
+	if err := ix.writeBucket(keep, b); err != nil {
+		return false, err
+	}
+	for j := slot&(bit-1) | bit; j < 1<<ix.hdr.globalDepth; j += bit << 1 {
+		if err := ix.setDir(j, keep); err != nil {
+			return false, err
+		}
+	}
+	if err := ix.pool.FreePage(drop); err != nil {
+		return false, err
+	}
+	ix.hdr.buckets--
+	return true, ix.writeHeader()
+}
+
+// shrink 在目录的前后两半完全相同（所有桶的局部深度都小于全局深度）时把目录减半，
+// 多余的目录页放回空闲链表。每次减半单独提交
+func (ix *Index) shrink() error {
+	for {
+		var ok bool
+		err := ix.atomic(func() (err error) {
+			ok, err = ix.halve()
+			return err
+		})
+		if err != nil || !ok {
+			return err
+		}
+		ix.halvings++
+	}
+}
+
+// halve 在可能时把目录减半一次，返回是否减半了
+func (ix *Index) halve() (bool, error) {
+	if ix.hdr.globalDepth == 0 {
+		return false, nil
+	}
+	half := 1 << (ix.hdr.globalDepth - 1)
+	for i := 0; i < half; i++ {
+		a, err := ix.dir(i)
+		if err != nil {
+			return false, err
+		}
+		b, err := ix.dir(i + half)
+		if err != nil || a != b {
+			return false, err
+		}
+	}
+	ix.hdr.globalDepth--
+	for len(ix.hdr.dirPages) > dirPagesFor(ix.hdr.globalDepth) {
+		last := ix.hdr.dirPages[len(ix.hdr.dirPages)-1]
+		ix.hdr.dirPages = ix.hdr.dirPages[:len(ix.hdr.dirPages)-1]
+		if err := ix.pool.FreePage(last); err != nil {
+			return false, err
+		}
+	}
+	return true, ix.writeHeader()
+}
+
+// buckets 按目录顺序对每个不同的桶调用 fn（一个桶被多个目录项指向时只访问一次）
+func (ix *Index) buckets(fn func(id pager.PageID, b *bucket) error) error {
+	seen := make(map[pager.PageID]bool)
+	for i := 0; i < 1<<ix.hdr.globalDepth; i++ {
+		id, err := ix.dir(i)
+		if err != nil {
+			return err
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		b, err := ix.readBucket(id)
+		if err != nil {
+			return err
+		}
+		if err := fn(id, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan 哈希索引中的键是无序的：读遍所有桶页，取出 [start, end) 内的条目排序后遍历
+func (ix *Index) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	var keys, values [][]byte
+	err := ix.buckets(func(_ pager.PageID, b *bucket) error {
+		for i, k := range b.keys {
+			if kv.InRange(kv.Bytewise, k, start, end) {
+				keys = append(keys, k)
+				values = append(values, b.values[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return kv.Bytewise(keys[order[i]], keys[order[j]]) < 0 })
+	for _, i := range order {
+		if !fn(keys[i], values[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+// Stats 返回目录和桶的形状以及 I/O 计数
+func (ix *Index) Stats() Stats {
+	p := ix.pool.Pager()
+	return Stats{
+		GlobalDepth: ix.hdr.globalDepth,
+		DirPages:    len(ix.hdr.dirPages),
+		Buckets:     ix.hdr.buckets,
+		Entries:     ix.hdr.count,
+		Pages:       p.NumPages(),
+		FreePages:   p.NumPages() - 2 - len(ix.hdr.dirPages) - ix.hdr.buckets,
+		Splits:      ix.splits,
+		Merges:      ix.merges,
+		Doublings:   ix.doublings,
+		Halvings:    ix.halvings,
+		Stats:       p.Stats(),
+		PoolStats:   ix.pool.Stats(),
+		WAL:         ix.pool.WAL().Stats(),
+	}
+}
+
+// Flush 做一次检查点：把脏页和元数据写回数据文件并 fsync，然后清空 WAL
+func (ix *Index) Flush() error {
+	return ix.pool.Checkpoint()
+}
+
+func (ix *Index) Close() error {
+	return ix.pool.Close()
+}
@@ -0,0 +1,171 @@
+package exthash
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/kvcheck"
+	"github.com/ddia-labs/pkg/pager"
+)
+
+// engine 给 Index 加上 kvcheck 需要的 Reopen，并累计每次打开期间的结构变化（Stats 的计数在重新打开后清零）
+type engine struct {
+	*Index
+	path  string
+	total *Stats
+}
+
+func (e *engine) addStats() {
+	s := e.Index.Stats()
+	e.total.Splits += s.Splits
+	e.total.Doublings += s.Doublings
+	e.total.Merges += s.Merges
+	e.total.Halvings += s.Halvings
+}
+
+func (e *engine) Reopen() error {
+	e.addStats()
+	if err := e.Index.Close(); err != nil {
+		return err
+	}
+	ix, err := Open(e.path, MinPoolPages)
+	if err != nil {
+		return err
+	}
+	ix.SetSyncCommits(false)
+	e.Index = ix
+	return nil
+}
+
+func (e *engine) Close() error {
+	e.addStats()
+	return e.Index.Close()
+}
+
+// TestModel 用 pkg/kvcheck 把随机序列与 map 模型比较，序列中穿插关闭后重新打开，
+// 每一步之后校验目录、局部深度和桶内哈希位的不变式。值接近 MaxEntrySize，一个桶只放得下三四个条目，
+// 80 个键足以让目录翻倍又减半；最后检查四种结构变化都真的发生过，并且都经历过重新打开
+func TestModel(t *testing.T) {
+	seeds := 5
+	if testing.Short() {
+		seeds = 2
+	}
+	parent := t.TempDir()
+	var total Stats
+	cfg := kvcheck.Config{Keys: 80, MaxValue: MaxEntrySize - 16, Reopen: true}
+	factory := func() (kv.KV, error) {
+		dir, err := os.MkdirTemp(parent, "exthash")
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, "hash.db")
+		ix, err := Open(path, MinPoolPages)
+		if err != nil {
+			return nil, err
+		}
+		ix.SetSyncCommits(false)
+		return &engine{Index: ix, path: path, total: &total}, nil
+	}
+	if failure := kvcheck.CheckSeeds(cfg, factory, 1, seeds); failure != nil {
+		t.Fatal(failure)
+	}
+	if total.Splits == 0 || total.Doublings == 0 || total.Merges == 0 || total.Halvings == 0 {
+		t.Errorf("sequences did not exercise every structural change: splits=%d doublings=%d merges=%d halvings=%d",
+			total.Splits, total.Doublings, total.Merges, total.Halvings)
+	}
+}
+
+// TestAbortOnError 每隔几次操作就固定住缓冲池中几乎所有的页帧，让分裂、翻倍、合并或写入因缓冲池已满而中途失败。
+// 失败的那一步必须连同内存中的头部一起撤销：不变式成立（包括头部的条目数和桶数），内容与模型一致，
+// 不关闭直接重新打开后也是同样的内容
+func TestAbortOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hash.db")
+	ix, err := Open(path, MinPoolPages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.SetSyncCommits(false)
+	ix.SetCheckpointBytes(1 << 20)
+
+	check := func(ix *Index, model map[string]string) error {
+		if err := ix.CheckInvariants(); err != nil {
+			return err
+		}
+		got := make(map[string]string)
+		if err := ix.Scan(nil, nil, func(k, v []byte) bool {
+			got[string(k)] = string(v)
+			return true
+		}); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(got, model) {
+			return fmt.Errorf("index holds %d keys, model %d, or values differ", len(got), len(model))
+		}
+		return nil
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[string]string)
+	failures := 0
+	for i := 0; i < 4000; i++ {
+		// 文件比缓冲池大时才固定页帧，否则每个页都已经在池中，操作不会失败
+		var pinned []*pager.Page
+		if n := ix.pool.Pager().NumPages() - 1; i%4 == 0 && n > MinPoolPages {
+			for _, j := range rng.Perm(n)[:MinPoolPages-1-rng.Intn(3)] {
+				pg, err := ix.pool.Fetch(pager.PageID(j + 1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				pinned = append(pinned, pg)
+			}
+		}
+
+		key, value := fmt.Sprintf("k%03d", rng.Intn(400)), ""
+		if rng.Intn(3) == 0 {
+			err = ix.Delete([]byte(key))
+		} else {
+			value = fmt.Sprintf("op%d-", i) + strings.Repeat("v", 400+rng.Intn(MaxEntrySize-420))
+			err = ix.Put([]byte(key), []byte(value))
+		}
+		for _, pg := range pinned {
+			ix.pool.Unpin(pg, false)
+		}
+
+		switch {
+		case errors.Is(err, pager.ErrPoolFull):
+			failures++
+			// 失败的 Put 可能在中途的分裂之后才失败：已提交的分裂不改变内容，新的值一定没有写入
+		case err != nil:
+			t.Fatalf("op %d: %v", i, err)
+		case value == "":
+			delete(model, key)
+		default:
+			model[key] = value
+		}
+		if failed := err != nil; failed || i%200 == 0 {
+			if err := check(ix, model); err != nil {
+				t.Fatalf("op %d (failed=%v): %v", i, failed, err)
+			}
+		}
+	}
+	if failures == 0 {
+		t.Fatal("no operation failed, the test did not exercise the rollback")
+	}
+
+	ix2, err := Open(path, MinPoolPages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix2.Close()
+	if err := check(ix2, model); err != nil {
+		t.Fatalf("after reopen: %v", err)
+	}
+	t.Logf("%d of 4000 operations failed and were rolled back, %d buckets", failures, ix2.Stats().Buckets)
+}
@@ -0,0 +1,147 @@
+package fulltext
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Token 是分析器输出的一个词及其在文本中的位置（第几个词，从 0 开始）
+type Token struct {
+	Term string
+	Pos  int
+}
+
+// TokenFilter 是分析流水线中的一步：接收上一步的词，返回处理后的词。
+// 过滤器可以改写、删除词，但不改变剩下的词的位置，短语查询依靠位置判断词是否相邻
+type TokenFilter func(tokens []Token) []Token
+
+// Analyzer 把文本切分成词再依次经过过滤器。建索引和解析查询必须使用同一个分析器，
+// 否则查询中的词与索引中的词对不上
+type Analyzer struct {
+	Filters []TokenFilter
+}
+
+// StandardAnalyzer 返回默认的分析器：切词、转小写、去掉英文停用词、简化的词干提取
+func StandardAnalyzer() *Analyzer {
+	return &Analyzer{Filters: []TokenFilter{Lowercase, StopWords(EnglishStopWords...), Stem}}
+}
+
+// Analyze 把文本转换成词序列
+func (a *Analyzer) Analyze(text string) []Token {
+	tokens := Tokenize(text)
+	for _, f := range a.Filters {
+		tokens = f(tokens)
+	}
+	return tokens
+}
+
+// Tokenize 切词：连续的字母和数字组成一个词，其他字符是分隔符。
+// 中文没有空格分词，这里把每个汉字当作一个词（unigram），词组靠短语查询的位置相邻来匹配
+func Tokenize(text string) []Token {
+	var tokens []Token
+	start := -1
+	emit := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, Token{Term: text[start:end], Pos: len(tokens)})
+			start = -1
+		}
+	}
+	for i, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			emit(i)
+			tokens = append(tokens, Token{Term: string(r), Pos: len(tokens)})
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+		default:
+			emit(i)
+		}
+	}
+	emit(len(text))
+	return tokens
+}
+
+// Lowercase 把词转换成小写
+func Lowercase(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = strings.ToLower(tokens[i].Term)
+	}
+	return tokens
+}
+
+// EnglishStopWords 是常见的英文停用词：出现在几乎所有文档中，对区分文档没有帮助
+var EnglishStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in", "into", "is", "it",
+	"no", "not", "of", "on", "or", "such", "that", "the", "their", "then", "there", "these",
+	"they", "this", "to", "was", "will", "with",
+}
+
+// StopWords 返回去掉 words 中的词的过滤器。被去掉的词留下位置空洞，
+// 所以短语 "the quick fox" 与 "a quick fox" 都匹配 “quick 之后隔一个位置是 fox” 的文档
+func StopWords(words ...string) TokenFilter {
+	stop := make(map[string]bool, len(words))
+	for _, w := range words {
+		stop[w] = true
+	}
+	return func(tokens []Token) []Token {
+		out := tokens[:0]
+		for _, t := range tokens {
+			if !stop[t.Term] {
+				out = append(out, t)
+			}
+		}
+		return out
+	}
+}
+
+// Stem 是简化的英文词干提取，不是完整的 Porter 算法：只去掉常见的复数、-ing、-ed、-ly 词尾和结尾的 e，
+// 足以让 index/indexes/indexed/indexing、store/stored/storing 各自归为同一个词。
+// 不超过 3 个字母的词和非 ASCII 的词保持不变
+func Stem(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = stem(tokens[i].Term)
+	}
+	return tokens
+}
+
+func stem(w string) string {
+	if len(w) <= 3 {
+		return w
+	}
+	for _, c := range w {
+		if c < 'a' || c > 'z' {
+			return w
+		}
+	}
+	switch {
+	case strings.HasSuffix(w, "ies") || strings.HasSuffix(w, "ied"):
+		w = w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "xes") || strings.HasSuffix(w, "ches") || strings.HasSuffix(w, "shes") || strings.HasSuffix(w, "zes"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") && !strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "is"):
+		w = w[:len(w)-1]
+	case strings.HasSuffix(w, "ing") && len(w) > 5:
+		w = undouble(w[:len(w)-3])
+	case strings.HasSuffix(w, "ed") && len(w) > 4:
+		w = undouble(w[:len(w)-2])
+	case strings.HasSuffix(w, "ly") && len(w) > 5:
+		w = w[:len(w)-2]
+	}
+	if len(w) > 3 && strings.HasSuffix(w, "e") {
+		w = w[:len(w)-1]
+	}
+	return w
+}
+
+// undouble 把去掉词尾后重复的辅音合并（running → runn → run），l、s、z 除外（falling → fall）
+func undouble(w string) string {
+	n := len(w)
+	if n >= 2 && w[n-1] == w[n-2] && !strings.ContainsRune("aeioulsz", rune(w[n-1])) {
+		return w[:n-1]
+	}
+	return w
+}
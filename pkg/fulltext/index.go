@@ -0,0 +1,615 @@
+// Package fulltext 实现全文检索的倒排索引：分析器把文本变成词，每个词对应一个倒排列表
+// （包含它的文档以及它在每个文档中出现的位置），查询支持布尔组合和短语，结果按 BM25 排序。
+//
+// 索引的组织方式和 LSM 树相同：新文档先进入内存缓冲区，攒够一批后写成一个不可变的段文件；
+// 段越来越多时按大小分层合并，合并时丢掉已删除的文档。MANIFEST 记录当前的段以及每个段中被删除的文档。
+package fulltext
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// Options 是索引的参数，零值字段使用默认值
+type Options struct {
+	Analyzer    *Analyzer // 默认 StandardAnalyzer()
+	BufferDocs  int       // 内存缓冲区攒够多少个文档后写成段，默认 1000
+	MergeFactor int       // 同一层的段达到多少个时合并成一个，默认 10，小于 0 表示不自动合并
+	K1          float64   // BM25 的词频饱和参数，默认 1.2
+	B           float64   // BM25 的文档长度归一化参数，默认 0.75
+}
+
+func (o *Options) setDefaults() {
+	if o.Analyzer == nil {
+		o.Analyzer = StandardAnalyzer()
+	}
+	if o.BufferDocs <= 0 {
+		o.BufferDocs = 1000
+	}
+	if o.MergeFactor == 0 {
+		o.MergeFactor = 10
+	} else if o.MergeFactor == 1 {
+		o.MergeFactor = 2
+	}
+	if o.K1 == 0 {
+		o.K1 = 1.2
+	}
+	if o.B == 0 {
+		o.B = 0.75
+	}
+}
+
+// docRef 指向一个文档当前所在的段和文档号
+type docRef struct {
+	seg *segment
+	doc uint32
+}
+
+// Index 是一个目录中的全文索引。文档由调用者给定的字符串 ID 标识，
+// 缓冲区中的文档和删除操作在 Flush（或 Close）之后才持久化
+type Index struct {
+	mu      sync.Mutex
+	dir     string
+	opts    Options
+	segs    []*segment // 磁盘段，按编号递增
+	buf     *segment   // 内存缓冲区
+	live    map[string]docRef
+	nextNum uint64
+	dirty   bool // 有还没写入 MANIFEST 的删除
+
+	merges       int
+	postingLists int
+	bytesRead    int64
+}
+
+// Hit 是一个查询结果
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+// Stats 是索引的统计信息
+type Stats struct {
+	Segments     int   // 磁盘段数
+	Docs         int   // 未删除的文档数（含缓冲区）
+	BufferedDocs int   // 缓冲区中的文档数
+	DeletedDocs  int   // 磁盘段中已删除、等待合并清理的文档数
+	DiskBytes    int64 // 所有段文件的大小
+	Merges       int   // 合并次数（含 Merge 的强制合并）
+	PostingLists int   // 查询读取的倒排列表数
+	BytesRead    int64 // 查询从段文件读取的字节数
+}
+
+// Open 打开 dir 中的索引，目录不存在时创建
+func Open(dir string, opts Options) (*Index, error) {
+	opts.setDefaults()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	ix := &Index{dir: dir, opts: opts, buf: newMemSegment(), live: make(map[string]docRef), nextNum: 1}
+	if err := ix.load(); err != nil {
+		ix.closeSegments()
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (ix *Index) segPath(num uint64) string {
+	return filepath.Join(ix.dir, fmt.Sprintf("%06d.seg", num))
+}
+
+// load 按 MANIFEST 打开段并恢复删除标记，删除不在 MANIFEST 中的段文件（刷盘或合并写到一半时崩溃留下的输出）
+func (ix *Index) load() error {
+	entries, err := readManifest(ix.dir)
+	if err != nil {
+		return err
+	}
+	inManifest := make(map[uint64]bool)
+	for _, e := range entries {
+		s, err := openSegment(ix.segPath(e.num), e.num)
+		if err != nil {
+			return err
+		}
+		ix.segs = append(ix.segs, s)
+		inManifest[e.num] = true
+		ix.nextNum = max(ix.nextNum, e.num+1)
+		for _, doc := range e.deleted {
+			if int(doc) >= len(s.ids) {
+				return fmt.Errorf("%w: deleted document %d of segment %d out of range", ErrCorruptSegment, doc, e.num)
+			}
+			s.remove(doc)
+		}
+		for doc, id := range s.ids {
+			if s.deleted[uint32(doc)] {
+				continue
+			}
+			if _, dup := ix.live[id]; dup {
+				return fmt.Errorf("%w: document %q is live in two segments", ErrCorruptSegment, id)
+			}
+			ix.live[id] = docRef{s, uint32(doc)}
+		}
+	}
+
+	names, err := filepath.Glob(filepath.Join(ix.dir, "*.seg"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		num, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".seg"), 10, 64)
+		if err == nil && !inManifest[num] {
+			os.Remove(name)
+			ix.nextNum = max(ix.nextNum, num+1)
+		}
+	}
+	return nil
+}
+
+// Add 索引一个文档；ID 已存在时替换旧文档（旧文档标记为删除）
+func (ix *Index) Add(id, text string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.deleteLocked(id)
+	doc := ix.buf.add(id, ix.opts.Analyzer.Analyze(text))
+	ix.live[id] = docRef{ix.buf, doc}
+	if len(ix.buf.ids) >= ix.opts.BufferDocs {
+		return ix.flushLocked()
+	}
+	return nil
+}
+
+// Delete 删除一个文档，返回它是否存在
+func (ix *Index) Delete(id string) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.deleteLocked(id)
+}
+
+func (ix *Index) deleteLocked(id string) bool {
+	ref, ok := ix.live[id]
+	if !ok {
+		return false
+	}
+	ref.seg.remove(ref.doc)
+	delete(ix.live, id)
+	if ref.seg != ix.buf {
+		ix.dirty = true
+	}
+	return true
+}
+
+// Flush 把缓冲区写成段，并把删除记录写入 MANIFEST
+func (ix *Index) Flush() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.flushLocked()
+}
+
+func (ix *Index) flushLocked() error {
+	if len(ix.buf.ids) == 0 && !ix.dirty {
+		return nil
+	}
+	segs := ix.segs[:len(ix.segs):len(ix.segs)]
+	var out *segment
+	if ix.buf.liveDocs() > 0 {
+		var err error
+		if out, err = ix.writeMerged([]*segment{ix.buf}); err != nil {
+			return err
+		}
+		segs = append(segs, out)
+	}
+	// MANIFEST 没写成时缓冲区和 live 保持原样，下次 Flush 重试
+	if err := ix.commit(segs, out); err != nil {
+		return err
+	}
+	ix.buf = newMemSegment()
+	ix.dirty = false
+	if err := syncDir(ix.dir); err != nil {
+		return err
+	}
+	if ix.opts.MergeFactor > 0 {
+		return ix.maybeMerge()
+	}
+	return nil
+}
+
+// writeMerged 把 inputs 中未删除的文档写成一个新段，文档按输入的顺序重新编号。
+// 刷盘是只有缓冲区一个输入的合并。没有未删除的文档时不写段，返回 nil。
+// 新段写入 MANIFEST 之前 segs 和 live 都不变（见 commit）
+func (ix *Index) writeMerged(inputs []*segment) (*segment, error) {
+	var src segmentSource
+	remap := make([][]int64, len(inputs))
+	var terms []string
+	for i, s := range inputs {
+		remap[i] = make([]int64, len(s.ids))
+		for doc, id := range s.ids {
+			if s.deleted[uint32(doc)] {
+				remap[i][doc] = -1
+				continue
+			}
+			remap[i][doc] = int64(len(src.ids))
+			src.ids = append(src.ids, id)
+			src.lens = append(src.lens, s.lens[doc])
+		}
+		terms = append(terms, s.terms()...)
+	}
+	// 所有输入的词去重排序；只出现在已删除文档中的词得到空的倒排列表，writeSegment 会跳过它们
+	sort.Strings(terms)
+	for i, t := range terms {
+		if i == 0 || t != terms[i-1] {
+			src.terms = append(src.terms, t)
+		}
+	}
+	src.postings = func(term string) ([]posting, error) {
+		var out []posting
+		for i, s := range inputs {
+			list, _, err := s.postings(term)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range list {
+				if doc := remap[i][p.doc]; doc >= 0 {
+					out = append(out, posting{doc: uint32(doc), positions: p.positions})
+				}
+			}
+		}
+		return out, nil
+	}
+	if len(src.ids) == 0 {
+		return nil, nil
+	}
+
+	num := ix.nextNum
+	ix.nextNum++
+	return writeSegment(ix.segPath(num), num, src)
+}
+
+// commit 用 segs 替换 MANIFEST，成功后才让 segs 成为当前的段、让 live 指向新段 out 中的文档；
+// 失败时关闭并删除 out，内存中的状态仍与磁盘上旧的 MANIFEST 一致。
+// 调用者在清理完输入之后还要 syncDir，让 rename 持久化
+func (ix *Index) commit(segs []*segment, out *segment) error {
+	if err := writeManifest(ix.dir, segs); err != nil {
+		if out != nil {
+			out.close()
+			os.Remove(ix.segPath(out.num))
+		}
+		return err
+	}
+	ix.segs = segs
+	if out != nil {
+		for doc, id := range out.ids {
+			ix.live[id] = docRef{out, uint32(doc)}
+		}
+	}
+	return nil
+}
+
+// maybeMerge 按大小分层合并：第 n 层是文档数（含已删除的）在 [BufferDocs·F^n, BufferDocs·F^(n+1)) 之间的段，
+// 某一层攒够 MergeFactor 个段时把它们合并成一个（大约属于上一层），重复直到每层都不够。
+// 已删除的文档要等所在的段参与合并（或调用 Merge）时才被清理
+func (ix *Index) maybeMerge() error {
+	for {
+		tiers := make(map[int][]*segment)
+		var full []*segment
+		for _, s := range ix.segs {
+			tier := 0
+			for n := ix.opts.BufferDocs * ix.opts.MergeFactor; len(s.ids) >= n; n *= ix.opts.MergeFactor {
+				tier++
+			}
+			tiers[tier] = append(tiers[tier], s)
+			if len(tiers[tier]) == ix.opts.MergeFactor {
+				full = tiers[tier]
+				break
+			}
+		}
+		if full == nil {
+			return nil
+		}
+		if err := ix.merge(full); err != nil {
+			return err
+		}
+	}
+}
+
+// merge 把 inputs 合并成一个段：写新段 → 更新 MANIFEST → 删除输入的段文件
+func (ix *Index) merge(inputs []*segment) error {
+	out, err := ix.writeMerged(inputs)
+	if err != nil {
+		return err
+	}
+	var segs []*segment
+	for _, s := range ix.segs {
+		if !contains(inputs, s) {
+			segs = append(segs, s)
+		}
+	}
+	if out != nil {
+		segs = append(segs, out)
+	}
+	if err := ix.commit(segs, out); err != nil {
+		return err
+	}
+	ix.merges++
+	for _, s := range inputs {
+		s.close()
+		os.Remove(ix.segPath(s.num))
+	}
+	return syncDir(ix.dir)
+}
+
+func contains(segs []*segment, s *segment) bool {
+	for _, x := range segs {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge 刷盘后把所有段合并成一个，同时清除所有已删除的文档
+func (ix *Index) Merge() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if err := ix.flushLocked(); err != nil {
+		return err
+	}
+	if len(ix.segs) <= 1 && (len(ix.segs) == 0 || len(ix.segs[0].deleted) == 0) {
+		return nil
+	}
+	return ix.merge(append([]*segment(nil), ix.segs...))
+}
+
+// allSegments 返回所有磁盘段和缓冲区，查询时依次在其中查找
+func (ix *Index) allSegments() []*segment {
+	return append(append([]*segment(nil), ix.segs...), ix.buf)
+}
+
+// Len 返回未删除的文档数
+func (ix *Index) Len() int {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return len(ix.live)
+}
+
+// Stats 返回统计信息
+func (ix *Index) Stats() Stats {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	st := Stats{
+		Segments:     len(ix.segs),
+		Docs:         len(ix.live),
+		BufferedDocs: ix.buf.liveDocs(),
+		Merges:       ix.merges,
+		PostingLists: ix.postingLists,
+		BytesRead:    ix.bytesRead,
+	}
+	for _, s := range ix.segs {
+		st.DeletedDocs += len(s.deleted)
+		st.DiskBytes += s.size
+	}
+	return st
+}
+
+// Close 刷盘并关闭所有段文件
+func (ix *Index) Close() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	err := ix.flushLocked()
+	ix.closeSegments()
+	return err
+}
+
+func (ix *Index) closeSegments() {
+	for _, s := range ix.segs {
+		s.close()
+	}
+	ix.segs = nil
+}
+
+// Search 执行查询，返回得分最高的 k 个结果（k <= 0 时返回全部），按得分降序、得分相同时按 ID 排列
+func (ix *Index) Search(q Query, k int) ([]Hit, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	var hits []Hit
+	if q == nil {
+		return hits, nil
+	}
+	ctx := ix.newSearchContext()
+	for _, s := range ix.allSegments() {
+		scores, err := q.eval(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		for doc, score := range scores {
+			hits = append(hits, Hit{ID: s.ids[doc], Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if k > 0 && len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits, nil
+}
+
+// SearchString 用索引的分析器解析查询字符串（语法见 Parse）后执行
+func (ix *Index) SearchString(query string, k int) ([]Hit, error) {
+	q, err := Parse(ix.opts.Analyzer, query)
+	if err != nil {
+		return nil, err
+	}
+	return ix.Search(q, k)
+}
+
+// searchContext 保存一次查询中所有段共用的 BM25 统计量
+type searchContext struct {
+	ix    *Index
+	n     int     // 所有段中的文档总数，包括还没被合并清理的已删除文档（Lucene 的 maxDoc）
+	avgdl float64 // 未删除文档的平均长度
+	df    map[string]int
+}
+
+func (ix *Index) newSearchContext() *searchContext {
+	ctx := &searchContext{ix: ix, df: make(map[string]int)}
+	var total int64
+	for _, s := range ix.allSegments() {
+		ctx.n += len(s.ids)
+		total += s.liveLen
+	}
+	if live := len(ix.live); live > 0 {
+		ctx.avgdl = float64(total) / float64(live)
+	}
+	return ctx
+}
+
+// idf 是 BM25 的逆文档频率 ln(1 + (N - df + 0.5) / (df + 0.5))。
+// df 是所有段中包含该词的文档数，包括还没被合并清理的已删除文档；N 也按同样的范围计数，
+// 所以 df 不会超过 N，idf 总是正的（只用未删除的文档数作 N 时，删掉大部分文档后 idf 会变成负数）
+func (ctx *searchContext) idf(term string) float64 {
+	df, ok := ctx.df[term]
+	if !ok {
+		for _, s := range ctx.ix.allSegments() {
+			df += s.docFreq(term)
+		}
+		ctx.df[term] = df
+	}
+	return math.Log(1 + (float64(ctx.n)-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+// bm25 是词频为 tf、长度为 dl 的文档的得分
+func (ctx *searchContext) bm25(idf float64, tf int, dl uint32) float64 {
+	k1, b := ctx.ix.opts.K1, ctx.ix.opts.B
+	norm := 1 - b
+	if ctx.avgdl > 0 {
+		norm += b * float64(dl) / ctx.avgdl
+	}
+	return idf * float64(tf) * (k1 + 1) / (float64(tf) + k1*norm)
+}
+
+// postings 读取 s 中 term 的倒排列表并记录读取量
+func (ctx *searchContext) postings(s *segment, term string) ([]posting, error) {
+	list, n, err := s.postings(term)
+	if n > 0 {
+		ctx.ix.postingLists++
+		ctx.ix.bytesRead += n
+	}
+	return list, err
+}
+
+// IndexKV 扫描 store 中 [start, end) 的键值对，把 text(key, value) 作为文本索引，文档 ID 是键的字节串。
+// 查询结果的 ID 可以用 []byte(hit.ID) 回到 store 中读取原始记录
+func (ix *Index) IndexKV(store kv.KV, start, end []byte, text func(key, value []byte) string) error {
+	var err error
+	scanErr := store.Scan(start, end, func(key, value []byte) bool {
+		err = ix.Add(string(key), text(key, value))
+		return err == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	return err
+}
+
+// MANIFEST 记录当前的段，每行一个 "段编号 已删除的文档号..."。每次刷盘或合并完成后
+// 把完整的列表写入临时文件、fsync 后 rename 覆盖，所以它总是描述某一个完整的时刻
+const manifestName = "MANIFEST"
+
+type manifestEntry struct {
+	num     uint64
+	deleted []uint32
+}
+
+// readManifest 读取 MANIFEST，文件不存在时返回 nil
+func readManifest(dir string) ([]manifestEntry, error) {
+	f, err := os.Open(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []manifestEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<30)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			return nil, errors.New("fulltext: empty manifest line")
+		}
+		var e manifestEntry
+		if e.num, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("fulltext: bad manifest line %q", sc.Text())
+		}
+		for _, f := range fields[1:] {
+			doc, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("fulltext: bad manifest line %q", sc.Text())
+			}
+			e.deleted = append(e.deleted, uint32(doc))
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// writeManifest 原子地用 segs 及其删除标记替换 MANIFEST。rename 成功后新的 MANIFEST 就生效了，
+// 但要等调用者 syncDir 之后才能保证崩溃后仍然可见
+func writeManifest(dir string, segs []*segment) error {
+	path := filepath.Join(dir, manifestName)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, s := range segs {
+		deleted := make([]int, 0, len(s.deleted))
+		for doc := range s.deleted {
+			deleted = append(deleted, int(doc))
+		}
+		sort.Ints(deleted)
+		fmt.Fprint(w, s.num)
+		for _, doc := range deleted {
+			fmt.Fprintf(w, " %d", doc)
+		}
+		fmt.Fprintln(w)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return nil
+}
+
+// syncDir fsync 目录，让其中文件的创建、rename 和删除持久化
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,313 @@
+package fulltext
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestScoresPositiveAfterDeletes 删掉大部分文档后（还没合并），仍然匹配的文档得分必须是正的，
+// 并且匹配词越多得分越高
+func TestScoresPositiveAfterDeletes(t *testing.T) {
+	ix, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := ix.Add(fmt.Sprint(i), "apple banana"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ix.Add("cherry", "apple cherry"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 98; i++ {
+		ix.Delete(fmt.Sprint(i))
+	}
+
+	hits, err := ix.SearchString("apple", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 {
+		t.Fatalf("apple: %d hits, want 3", len(hits))
+	}
+	for _, h := range hits {
+		if h.Score <= 0 {
+			t.Errorf("apple: %s scored %v, want > 0", h.ID, h.Score)
+		}
+	}
+
+	hits, err = ix.SearchString("apple cherry", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) == 0 || hits[0].ID != "cherry" {
+		t.Fatalf("apple cherry: hits %v, want cherry first", hits)
+	}
+}
+
+var testDocs = map[string]string{
+	"fox":     "the quick brown fox jumps over the lazy dog",
+	"cat":     "a lazy cat sleeps all day",
+	"dog":     "the dog barks at the quick brown cat",
+	"reverse": "dog lazy the over jumps fox brown quick",
+	"kv":      "a key-value store keeps an index of keys",
+	"zh":      "倒排索引把词映射到文档",
+}
+
+// testQueries 是查询字符串及其应该匹配的文档
+var testQueries = []struct {
+	query string
+	want  string
+}{
+	{`"quick brown fox"`, "fox"},
+	{`"brown quick"`, "reverse"},
+	{`"over a lazy dog"`, "fox"}, // 停用词留下的位置空洞也要对上
+	{`quick brown`, "dog fox reverse"},
+	{`fox OR cat`, "cat dog fox reverse"},
+	{`lazy -fox`, "cat"},
+	{`(fox OR barks) NOT lazy`, "dog"},
+	{`-lazy`, "dog kv zh"},
+	{`"key value"`, "kv"},
+	{`key-value`, "kv"},
+	{`index`, "kv"},
+	{`索引`, "zh"},
+	{`引索`, ""},
+}
+
+func hitIDs(hits []Hit) string {
+	ids := make([]string, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, " ")
+}
+
+func checkQueries(t *testing.T, ix *Index, stage string) {
+	t.Helper()
+	for _, tt := range testQueries {
+		hits, err := ix.SearchString(tt.query, 0)
+		if err != nil {
+			t.Fatalf("%s: %s: %v", stage, tt.query, err)
+		}
+		if got := hitIDs(hits); got != tt.want {
+			t.Errorf("%s: %s matched [%s], want [%s]", stage, tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestPhraseAndBoolQueries 在文档还在缓冲区、分散在多个段中和合并成一个段之后，短语和布尔查询的结果都相同
+func TestPhraseAndBoolQueries(t *testing.T) {
+	ix, err := Open(t.TempDir(), Options{BufferDocs: 4, MergeFactor: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	ids := make([]string, 0, len(testDocs))
+	for id := range testDocs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids[:3] {
+		if err := ix.Add(id, testDocs[id]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ix.Add("kv", "to be replaced"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[3:] {
+		if err := ix.Add(id, testDocs[id]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := ix.Stats(); st.Segments != 1 || st.BufferedDocs != 3 {
+		t.Fatalf("stats %+v, want 1 segment and 3 buffered docs", st)
+	}
+	checkQueries(t, ix, "buffered")
+
+	if err := ix.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	checkQueries(t, ix, "two segments")
+
+	if err := ix.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if st := ix.Stats(); st.Segments != 1 || st.DeletedDocs != 0 {
+		t.Fatalf("stats after Merge %+v, want 1 segment without deleted docs", st)
+	}
+	checkQueries(t, ix, "merged")
+}
+
+// TestReopen 关闭后重新打开，文档、删除和替换都还在；刷盘之后的删除靠 MANIFEST 记录
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	ix, err := Open(dir, Options{BufferDocs: 2, MergeFactor: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, text := range testDocs {
+		if id == "cat" {
+			text = "this one is replaced below"
+		}
+		if err := ix.Add(id, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix.Add("gone", "quick brown fox")
+	if err := ix.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ix.Add("cat", testDocs["cat"])
+	if !ix.Delete("gone") {
+		t.Fatal("Delete(gone) = false")
+	}
+	if err := ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	if n := ix.Len(); n != len(testDocs) {
+		t.Errorf("Len after reopen = %d, want %d", n, len(testDocs))
+	}
+	if st := ix.Stats(); st.DeletedDocs != 2 {
+		t.Errorf("DeletedDocs after reopen = %d, want 2 (gone and the old cat)", st.DeletedDocs)
+	}
+	checkQueries(t, ix, "reopened")
+	if ix.Delete("gone") {
+		t.Error("a deleted document came back after reopen")
+	}
+}
+
+// TestMergeDropsDeletedDocs 合并后已删除的文档从段文件中消失，段变小，重新打开后也不再出现
+func TestMergeDropsDeletedDocs(t *testing.T) {
+	dir := t.TempDir()
+	ix, err := Open(dir, Options{BufferDocs: 10, MergeFactor: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := ix.Add(fmt.Sprint(i), fmt.Sprintf("common word%d", i%5)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i += 2 {
+		ix.Delete(fmt.Sprint(i))
+	}
+	if err := ix.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	before := ix.Stats()
+	if before.Segments != 5 || before.DeletedDocs != 25 {
+		t.Fatalf("stats before Merge %+v, want 5 segments and 25 deleted docs", before)
+	}
+
+	if err := ix.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	after := ix.Stats()
+	if after.Segments != 1 || after.DeletedDocs != 0 || after.Docs != 25 || after.Merges != 1 {
+		t.Fatalf("stats after Merge %+v", after)
+	}
+	if after.DiskBytes >= before.DiskBytes {
+		t.Errorf("Merge did not shrink the index: %d -> %d bytes", before.DiskBytes, after.DiskBytes)
+	}
+	if err := ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(names) != 1 {
+		t.Errorf("segment files after Merge: %v", names)
+	}
+
+	ix, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	hits, err := ix.SearchString("word0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// i%5 == 0 的文档中只有奇数的 5、15、25、35、45 没被删除
+	if got := hitIDs(hits); got != "15 25 35 45 5" {
+		t.Errorf("word0 after Merge and reopen: [%s]", got)
+	}
+}
+
+// TestManifestFailureKeepsState 写 MANIFEST 失败时，刷盘和合并都不改变内存中的状态、不留下新的段文件，
+// 修复之后重试成功
+func TestManifestFailureKeepsState(t *testing.T) {
+	dir := t.TempDir()
+	ix, err := Open(dir, Options{BufferDocs: 100, MergeFactor: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	segFiles := func() int {
+		names, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+		return len(names)
+	}
+	// MANIFEST.tmp 是目录时无法创建临时文件
+	block := func() {
+		if err := os.Mkdir(filepath.Join(dir, manifestName+".tmp"), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unblock := func() {
+		if err := os.Remove(filepath.Join(dir, manifestName+".tmp")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, text := range testDocs {
+		ix.Add(id, text)
+	}
+
+	block()
+	if err := ix.Flush(); err == nil {
+		t.Fatal("Flush succeeded without a MANIFEST")
+	}
+	if st := ix.Stats(); st.Segments != 0 || st.BufferedDocs != len(testDocs) || segFiles() != 0 {
+		t.Fatalf("after a failed Flush: stats %+v, %d segment files", st, segFiles())
+	}
+	checkQueries(t, ix, "failed flush")
+	unblock()
+	if err := ix.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ix.Add("kv", testDocs["kv"])
+	if err := ix.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	block()
+	if err := ix.Merge(); err == nil {
+		t.Fatal("Merge succeeded without a MANIFEST")
+	}
+	if st := ix.Stats(); st.Segments != 2 || st.Merges != 0 || segFiles() != 2 {
+		t.Fatalf("after a failed Merge: stats %+v, %d segment files", st, segFiles())
+	}
+	checkQueries(t, ix, "failed merge")
+	unblock()
+	if err := ix.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if st := ix.Stats(); st.Segments != 1 || segFiles() != 1 {
+		t.Fatalf("after Merge: stats %+v, %d segment files", st, segFiles())
+	}
+	checkQueries(t, ix, "merged")
+}
@@ -0,0 +1,355 @@
+package fulltext
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Query 是一个查询：在一个段中求出匹配的文档号及其得分
+type Query interface {
+	eval(ctx *searchContext, s *segment) (map[uint32]float64, error)
+	String() string
+}
+
+// TermQuery 匹配包含一个词的文档，Term 是分析之后的词
+type TermQuery struct {
+	Term string
+}
+
+func (q TermQuery) String() string { return q.Term }
+
+func (q TermQuery) eval(ctx *searchContext, s *segment) (map[uint32]float64, error) {
+	list, err := ctx.postings(s, q.Term)
+	if err != nil {
+		return nil, err
+	}
+	idf := ctx.idf(q.Term)
+	scores := make(map[uint32]float64, len(list))
+	for _, p := range list {
+		if !s.deleted[p.doc] {
+			scores[p.doc] = ctx.bm25(idf, len(p.positions), s.lens[p.doc])
+		}
+	}
+	return scores, nil
+}
+
+// PhraseQuery 匹配各个词按给定的相对位置出现的文档。Terms 通常来自分析器的输出，
+// 位置之间的空洞（被去掉的停用词）也要对上。得分按短语出现的次数计算，idf 是各个词的 idf 之和
+type PhraseQuery struct {
+	Terms []Token
+}
+
+func (q PhraseQuery) String() string {
+	terms := make([]string, len(q.Terms))
+	for i, t := range q.Terms {
+		terms[i] = t.Term
+	}
+	return `"` + strings.Join(terms, " ") + `"`
+}
+
+func (q PhraseQuery) eval(ctx *searchContext, s *segment) (map[uint32]float64, error) {
+	scores := make(map[uint32]float64)
+	if len(q.Terms) == 0 {
+		return scores, nil
+	}
+	// 每个词的倒排列表按文档号建成 文档号 → 位置 的映射，以第一个词的列表为驱动
+	var first []posting
+	rest := make([]map[uint32][]uint32, len(q.Terms)-1)
+	var idf float64
+	for i, t := range q.Terms {
+		list, err := ctx.postings(s, t.Term)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			return scores, nil
+		}
+		idf += ctx.idf(t.Term)
+		if i == 0 {
+			first = list
+			continue
+		}
+		m := make(map[uint32][]uint32, len(list))
+		for _, p := range list {
+			m[p.doc] = p.positions
+		}
+		rest[i-1] = m
+	}
+	for _, p := range first {
+		if s.deleted[p.doc] {
+			continue
+		}
+		freq := 0
+		for _, start := range p.positions {
+			ok := true
+			for i, m := range rest {
+				want := int(start) + q.Terms[i+1].Pos - q.Terms[0].Pos
+				if !hasPosition(m[p.doc], want) {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				freq++
+			}
+		}
+		if freq > 0 {
+			scores[p.doc] = ctx.bm25(idf, freq, s.lens[p.doc])
+		}
+	}
+	return scores, nil
+}
+
+// hasPosition 在递增的位置列表中二分查找 pos
+func hasPosition(positions []uint32, pos int) bool {
+	if pos < 0 {
+		return false
+	}
+	i := sort.Search(len(positions), func(i int) bool { return int(positions[i]) >= pos })
+	return i < len(positions) && int(positions[i]) == pos
+}
+
+// BoolQuery 组合子查询：文档必须匹配所有 Must、不匹配任何 MustNot；
+// 没有 Must 时至少匹配一个 Should，有 Must 时 Should 只用来加分。
+// 只有 MustNot 时匹配所有其他未删除的文档，得分为 0。得分是匹配的子查询得分之和
+type BoolQuery struct {
+	Must, Should, MustNot []Query
+}
+
+func (q BoolQuery) String() string {
+	var parts []string
+	for _, c := range q.Must {
+		parts = append(parts, "+"+c.String())
+	}
+	for _, c := range q.Should {
+		parts = append(parts, c.String())
+	}
+	for _, c := range q.MustNot {
+		parts = append(parts, "-"+c.String())
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func (q BoolQuery) eval(ctx *searchContext, s *segment) (map[uint32]float64, error) {
+	var scores map[uint32]float64
+	for _, c := range q.Must {
+		m, err := c.eval(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		if scores == nil {
+			scores = m
+			continue
+		}
+		for doc := range scores {
+			if score, ok := m[doc]; ok {
+				scores[doc] += score
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+	if len(q.Should) > 0 {
+		union := scores == nil
+		if union {
+			scores = make(map[uint32]float64)
+		}
+		for _, c := range q.Should {
+			m, err := c.eval(ctx, s)
+			if err != nil {
+				return nil, err
+			}
+			for doc, score := range m {
+				if _, ok := scores[doc]; ok || union {
+					scores[doc] += score
+				}
+			}
+		}
+	}
+	if scores == nil {
+		scores = make(map[uint32]float64)
+		if len(q.MustNot) > 0 {
+			for doc := range s.ids {
+				if !s.deleted[uint32(doc)] {
+					scores[uint32(doc)] = 0
+				}
+			}
+		}
+	}
+	for _, c := range q.MustNot {
+		m, err := c.eval(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		for doc := range m {
+			delete(scores, doc)
+		}
+	}
+	return scores, nil
+}
+
+// ErrSyntax 是查询字符串的语法错误
+var ErrSyntax = errors.New("fulltext: query syntax error")
+
+// Parse 把查询字符串解析成 Query，词和短语都经过分析器 a：
+//
+//	foo bar        两个词都要出现（也可以写 foo AND bar）
+//	foo OR bar     至少出现一个，AND 的优先级高于 OR
+//	-foo, NOT foo  不能出现
+//	"foo bar"      短语：词按顺序相邻出现
+//	(foo OR bar)   括号分组
+//
+// 一个词分析后得到多个词时（如 key-value 或中文“索引”）按短语处理；
+// 完全被分析器去掉的词（停用词）被忽略。整个查询没有剩下任何词时返回 nil，它不匹配任何文档
+func Parse(a *Analyzer, query string) (Query, error) {
+	p := &parser{a: a, tokens: lex(query)}
+	q, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrSyntax, p.tokens[p.pos])
+	}
+	return q, nil
+}
+
+// lex 把查询切成 ( ) - 带引号的短语（保留开头的引号）和以空白分隔的词
+func lex(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(' || c == ')' || c == '-':
+			tokens = append(tokens, s[i:i+1])
+			i++
+		case c == '"':
+			j := strings.IndexByte(s[i+1:], '"')
+			if j < 0 {
+				j = len(s) - i - 1
+			}
+			tokens = append(tokens, s[i:i+1+j])
+			i += j + 2
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && !strings.ContainsRune(`()"`, rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+type parser struct {
+	a      *Analyzer
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// or := and ("OR" and)*
+func (p *parser) or() (Query, error) {
+	var should []Query
+	for {
+		q, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		if q != nil {
+			should = append(should, q)
+		}
+		if p.peek() != "OR" {
+			break
+		}
+		p.pos++
+	}
+	switch len(should) {
+	case 0:
+		return nil, nil
+	case 1:
+		return should[0], nil
+	}
+	return BoolQuery{Should: should}, nil
+}
+
+// and := unary (["AND"] unary)*
+func (p *parser) and() (Query, error) {
+	var must, mustNot []Query
+	for {
+		switch tok := p.peek(); tok {
+		case "", ")", "OR":
+			if len(must) == 1 && len(mustNot) == 0 {
+				return must[0], nil
+			}
+			if len(must) == 0 && len(mustNot) == 0 {
+				return nil, nil
+			}
+			return BoolQuery{Must: must, MustNot: mustNot}, nil
+		case "AND":
+			p.pos++
+			continue
+		}
+		negate := false
+		for p.peek() == "-" || p.peek() == "NOT" {
+			negate = !negate
+			p.pos++
+		}
+		q, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case q == nil:
+		case negate:
+			mustNot = append(mustNot, q)
+		default:
+			must = append(must, q)
+		}
+	}
+}
+
+// primary := "(" or ")" | 短语 | 词
+func (p *parser) primary() (Query, error) {
+	tok := p.peek()
+	switch {
+	case tok == "" || tok == ")" || tok == "OR" || tok == "AND":
+		return nil, fmt.Errorf("%w: expected a term before %q", ErrSyntax, tok)
+	case tok == "(":
+		p.pos++
+		q, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: missing )", ErrSyntax)
+		}
+		p.pos++
+		return q, nil
+	}
+	p.pos++
+	return p.text(strings.TrimPrefix(tok, `"`)), nil
+}
+
+// text 把一个词或短语的文本变成查询：没有词时返回 nil，一个词是 TermQuery，多个词是 PhraseQuery
+func (p *parser) text(s string) Query {
+	tokens := p.a.Analyze(s)
+	switch len(tokens) {
+	case 0:
+		return nil
+	case 1:
+		return TermQuery{Term: tokens[0].Term}
+	}
+	return PhraseQuery{Terms: tokens}
+}
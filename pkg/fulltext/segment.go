@@ -0,0 +1,366 @@
+package fulltext
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// 段文件格式：
+//
+//	倒排列表 0 | 倒排列表 1 | ... | 文档表 | 词典 | 页脚
+//
+// 倒排列表: 文档数(uvarint)，然后每个文档一项：文档号差值(uvarint) | 词频(uvarint) | 词频 × 位置差值(uvarint)，
+//
+//	最后是 crc32(4)。文档号和位置都是递增的，存差值让 varint 更短
+//
+// 文档表:   文档数(uvarint)，然后每个文档一项：外部 ID 长度(uvarint) | 外部 ID | 文档长度（词数，uvarint）
+// 词典:     词数(uvarint)，然后按词的字节序每个词一项：词长度(uvarint) | 词 | 文档频率(uvarint) | 偏移(uvarint) | 长度(uvarint)
+// 页脚:     文档表偏移(8) | 词典偏移(8) | crc32(4) | magic(4)
+//
+// 打开段时只读入文档表和词典（页脚中的 crc32 覆盖它们以及页脚中它之前的字段），
+// 查询时按词典中的偏移读取需要的倒排列表。段写好之后不再修改，删除记录在 MANIFEST 中。
+
+const (
+	segFooterSize = 24
+	segMagic      = 0x46545331 // "FTS1"
+)
+
+var ErrCorruptSegment = errors.New("fulltext: corrupt segment")
+
+// posting 是倒排列表中的一项：文档号和词在文档中出现的所有位置（词频 = 位置数）
+type posting struct {
+	doc       uint32
+	positions []uint32
+}
+
+type termInfo struct {
+	df             int
+	offset, length int64
+}
+
+// segment 是一组文档的倒排索引。内存缓冲区也是一个段（mem 不为空），刷盘后变成只读的磁盘段
+type segment struct {
+	num     uint64
+	ids     []string // 文档号 → 外部 ID
+	lens    []uint32 // 文档号 → 文档长度（分析后的词数）
+	deleted map[uint32]bool
+	liveLen int64 // 未删除文档的长度之和，用于计算 BM25 的平均文档长度
+
+	mem map[string][]posting // 仅内存缓冲区
+
+	file *os.File // 仅磁盘段
+	dict map[string]termInfo
+	size int64
+}
+
+func newMemSegment() *segment {
+	return &segment{deleted: make(map[uint32]bool), mem: make(map[string][]posting)}
+}
+
+func (s *segment) liveDocs() int { return len(s.ids) - len(s.deleted) }
+
+// add 把一个文档的词加入内存缓冲区，返回文档号
+func (s *segment) add(id string, tokens []Token) uint32 {
+	doc := uint32(len(s.ids))
+	s.ids = append(s.ids, id)
+	s.lens = append(s.lens, uint32(len(tokens)))
+	s.liveLen += int64(len(tokens))
+	for _, t := range tokens {
+		list := s.mem[t.Term]
+		if n := len(list); n > 0 && list[n-1].doc == doc {
+			list[n-1].positions = append(list[n-1].positions, uint32(t.Pos))
+		} else {
+			list = append(list, posting{doc: doc, positions: []uint32{uint32(t.Pos)}})
+		}
+		s.mem[t.Term] = list
+	}
+	return doc
+}
+
+// remove 标记文档已删除
+func (s *segment) remove(doc uint32) {
+	if !s.deleted[doc] {
+		s.deleted[doc] = true
+		s.liveLen -= int64(s.lens[doc])
+	}
+}
+
+// docFreq 返回包含 term 的文档数（含已删除的文档，不为删除重算统计量，BM25 的 N 也按同样的范围计数）
+func (s *segment) docFreq(term string) int {
+	if s.mem != nil {
+		return len(s.mem[term])
+	}
+	return s.dict[term].df
+}
+
+// terms 返回段中所有的词，按字节序排列
+func (s *segment) terms() []string {
+	var terms []string
+	if s.mem != nil {
+		for t := range s.mem {
+			terms = append(terms, t)
+		}
+	} else {
+		for t := range s.dict {
+			terms = append(terms, t)
+		}
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// postings 返回 term 的倒排列表（含已删除的文档），第二个返回值是从文件读取的字节数
+func (s *segment) postings(term string) ([]posting, int64, error) {
+	if s.mem != nil {
+		return s.mem[term], 0, nil
+	}
+	ti, ok := s.dict[term]
+	if !ok {
+		return nil, 0, nil
+	}
+	buf := make([]byte, ti.length)
+	if _, err := s.file.ReadAt(buf, ti.offset); err != nil {
+		return nil, 0, fmt.Errorf("%w: reading postings of %q: %v", ErrCorruptSegment, term, err)
+	}
+	list, err := decodePostings(buf, len(s.ids))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: postings of %q: %v", ErrCorruptSegment, term, err)
+	}
+	return list, ti.length, nil
+}
+
+func appendPostings(b []byte, list []posting) []byte {
+	start := len(b)
+	b = binary.AppendUvarint(b, uint64(len(list)))
+	var prevDoc uint32
+	for _, p := range list {
+		b = binary.AppendUvarint(b, uint64(p.doc-prevDoc))
+		prevDoc = p.doc
+		b = binary.AppendUvarint(b, uint64(len(p.positions)))
+		var prevPos uint32
+		for _, pos := range p.positions {
+			b = binary.AppendUvarint(b, uint64(pos-prevPos))
+			prevPos = pos
+		}
+	}
+	return binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b[start:]))
+}
+
+// decodePostings 解码倒排列表，检查校验和以及文档号不超出段的文档数
+func decodePostings(buf []byte, numDocs int) ([]posting, error) {
+	if len(buf) < 4 || crc32.ChecksumIEEE(buf[:len(buf)-4]) != binary.LittleEndian.Uint32(buf[len(buf)-4:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	r := &reader{buf: buf[:len(buf)-4]}
+	n := r.uvarint()
+	if n > uint64(numDocs) {
+		return nil, fmt.Errorf("%d postings in a segment of %d documents", n, numDocs)
+	}
+	list := make([]posting, 0, n)
+	var doc uint64
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		doc += r.uvarint()
+		if i > 0 && doc == uint64(list[i-1].doc) || doc >= uint64(numDocs) {
+			return nil, fmt.Errorf("document number %d out of order or range", doc)
+		}
+		freq := r.uvarint()
+		if freq == 0 || freq > uint64(len(r.buf)) {
+			return nil, fmt.Errorf("bad term frequency %d", freq)
+		}
+		p := posting{doc: uint32(doc), positions: make([]uint32, freq)}
+		var pos uint64
+		for j := range p.positions {
+			pos += r.uvarint()
+			p.positions[j] = uint32(pos)
+		}
+		list = append(list, p)
+	}
+	if r.err == nil && len(r.buf) > 0 {
+		r.err = errors.New("trailing bytes")
+	}
+	return list, r.err
+}
+
+// reader 顺序解码 uvarint 和字节串，遇到越界时记录错误并返回零值
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errors.New("truncated varint")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.err = errors.New("truncated string")
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+// segmentSource 是写段时的输入：已经按文档号排好的文档表，以及按字节序给出每个词的倒排列表（空列表的词不写入）
+type segmentSource struct {
+	ids      []string
+	lens     []uint32
+	terms    []string
+	postings func(term string) ([]posting, error)
+}
+
+// writeSegment 把 src 写成段文件 path，fsync 后返回打开的只读段
+func writeSegment(path string, num uint64, src segmentSource) (*segment, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	err = func() error {
+		w := bufio.NewWriter(f)
+		var offset int64
+		var dict, buf []byte
+		numTerms := 0
+		for _, term := range src.terms {
+			list, err := src.postings(term)
+			if err != nil {
+				return err
+			}
+			if len(list) == 0 {
+				continue
+			}
+			numTerms++
+			buf = appendPostings(buf[:0], list)
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			dict = binary.AppendUvarint(dict, uint64(len(term)))
+			dict = append(dict, term...)
+			dict = binary.AppendUvarint(dict, uint64(len(list)))
+			dict = binary.AppendUvarint(dict, uint64(offset))
+			dict = binary.AppendUvarint(dict, uint64(len(buf)))
+			offset += int64(len(buf))
+		}
+
+		docs := binary.AppendUvarint(nil, uint64(len(src.ids)))
+		for i, id := range src.ids {
+			docs = binary.AppendUvarint(docs, uint64(len(id)))
+			docs = append(docs, id...)
+			docs = binary.AppendUvarint(docs, uint64(src.lens[i]))
+		}
+		meta := binary.AppendUvarint(docs, uint64(numTerms))
+		meta = append(meta, dict...)
+		meta = binary.LittleEndian.AppendUint64(meta, uint64(offset))
+		meta = binary.LittleEndian.AppendUint64(meta, uint64(offset)+uint64(len(docs)))
+		meta = binary.LittleEndian.AppendUint32(meta, crc32.ChecksumIEEE(meta))
+		meta = binary.LittleEndian.AppendUint32(meta, segMagic)
+		if _, err := w.Write(meta); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return openSegment(path, num)
+}
+
+// openSegment 打开段文件，读入文档表和词典
+func openSegment(path string, num uint64) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := loadSegment(f, num)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func loadSegment(f *os.File, num uint64) (*segment, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := stat.Size()
+	if size < segFooterSize {
+		return nil, ErrCorruptSegment
+	}
+	var footer [segFooterSize]byte
+	if _, err := f.ReadAt(footer[:], size-segFooterSize); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	docsOff, dictOff := le.Uint64(footer[0:]), le.Uint64(footer[8:])
+	if le.Uint32(footer[20:]) != segMagic || docsOff > dictOff || dictOff > uint64(size-segFooterSize) {
+		return nil, ErrCorruptSegment
+	}
+	meta := make([]byte, size-int64(docsOff))
+	if _, err := f.ReadAt(meta, int64(docsOff)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(meta[:len(meta)-8]) != le.Uint32(meta[len(meta)-8:]) {
+		return nil, fmt.Errorf("%w: metadata checksum mismatch", ErrCorruptSegment)
+	}
+
+	s := &segment{num: num, file: f, size: size, deleted: make(map[uint32]bool), dict: make(map[string]termInfo)}
+	r := &reader{buf: meta[:dictOff-docsOff]}
+	n := r.uvarint()
+	if n > uint64(len(r.buf)) {
+		return nil, fmt.Errorf("%w: %d documents in a %d-byte table", ErrCorruptSegment, n, len(r.buf))
+	}
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		s.ids = append(s.ids, string(r.bytes()))
+		l := r.uvarint()
+		s.lens = append(s.lens, uint32(l))
+		s.liveLen += int64(l)
+	}
+	r = &reader{buf: meta[dictOff-docsOff : len(meta)-segFooterSize], err: r.err}
+	n = r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		term := string(r.bytes())
+		df, off, length := r.uvarint(), r.uvarint(), r.uvarint()
+		if off+length > docsOff || df > uint64(len(s.ids)) {
+			return nil, fmt.Errorf("%w: dictionary entry %q out of range", ErrCorruptSegment, term)
+		}
+		s.dict[term] = termInfo{df: int(df), offset: int64(off), length: int64(length)}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptSegment, r.err)
+	}
+	return s, nil
+}
+
+func (s *segment) close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
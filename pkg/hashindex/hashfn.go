@@ -0,0 +1,46 @@
+package hashindex
+
+import (
+	"hash/maphash"
+)
+
+// HashFunc 把键映射成 64 位哈希值，桶（槽）的下标是哈希值对桶数取模
+type HashFunc func(key []byte) uint64
+
+// FNV1a 是 64 位 FNV-1a：逐字节异或再乘以素数，简单且分布良好，但种子固定，
+// 攻击者可以构造大量冲突的键（哈希洪水）
+func FNV1a(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// DJB2 是经典的 h*33 + c 字符串哈希，高位混合得不充分
+func DJB2(key []byte) uint64 {
+	h := uint64(5381)
+	for _, c := range key {
+		h = h*33 + uint64(c)
+	}
+	return h
+}
+
+// SumHash 把所有字节相加：反面教材，字节相同但顺序不同的键全部冲突，
+// 而且取值范围很小（8 字节的键最多 2040），桶再多也用不上
+func SumHash(key []byte) uint64 {
+	var h uint64
+	for _, c := range key {
+		h += uint64(c)
+	}
+	return h
+}
+
+// NewMaphash 返回使用 hash/maphash 的哈希函数（Go 运行时的 map 同款，有硬件加速）。
+// 每次调用生成随机种子，同一个键在不同进程中的哈希值不同，可以抵御哈希洪水；
+// 代价是哈希值不能持久化
+func NewMaphash() HashFunc {
+	seed := maphash.MakeSeed()
+	return func(key []byte) uint64 { return maphash.Bytes(seed, key) }
+}
@@ -0,0 +1,337 @@
+// Package hashindex 实现内存中的哈希索引：链式哈希 HashIndex（渐进式 rehash）和开放寻址 OpenHashIndex
+// （线性探测或 Robin Hood）。两者都实现了 kv.KV，点查是 O(1)，范围扫描要取出所有条目排序。
+package hashindex
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// Options 控制哈希表的容量和扩容，零值字段使用默认值
+type Options struct {
+	InitialBuckets int      // 初始桶（槽）数，也是缩容的下限，默认 8
+	MaxLoadFactor  float64  // 负载因子（条目数 / 桶数）超过它时扩容为两倍，链式默认 1.0，开放寻址默认 0.85
+	MinLoadFactor  float64  // 负载因子低于它时缩小一半，默认 0.1，小于 0 表示从不缩容
+	RehashStep     int      // 渐进式 rehash 时每次操作迁移的非空桶数，默认 1；小于 0 表示一次迁移完（stop-the-world）
+	Hash           HashFunc // 哈希函数，默认 FNV1a
+}
+
+func (o Options) withDefaults(maxLoad float64) Options {
+	if o.InitialBuckets <= 0 {
+		o.InitialBuckets = 8
+	}
+	if o.MaxLoadFactor <= 0 {
+		o.MaxLoadFactor = maxLoad
+	}
+	if o.MinLoadFactor == 0 {
+		o.MinLoadFactor = 0.1
+	}
+	if o.RehashStep == 0 {
+		o.RehashStep = 1
+	}
+	if o.Hash == nil {
+		o.Hash = FNV1a
+	}
+	return o
+}
+
+// Stats 汇总哈希表的填充和探测情况
+type Stats struct {
+	Entries    int
+	Buckets    int     // 桶（槽）数，rehash 期间是新旧两张表之和
+	LoadFactor float64 // Entries / Buckets
+	AvgProbe   float64 // 成功查找平均需要比较的键数
+	MaxProbe   int     // 最坏情况下需要比较的键数（最长的链，或最远的探测距离 + 1）
+	Resizes    int     // 扩容和缩容的次数
+	Rehashing  bool    // 是否正处于渐进式 rehash 中
+	MaxMoved   int     // 单次操作中迁移的最多条目数，反映扩容造成的最长停顿
+}
+
+func (s Stats) String() string {
+	str := fmt.Sprintf("条目 %d, 桶 %d, 负载因子 %.2f, 平均探测 %.2f, 最大探测 %d, 扩缩容 %d 次, 单次最多迁移 %d 条",
+		s.Entries, s.Buckets, s.LoadFactor, s.AvgProbe, s.MaxProbe, s.Resizes, s.MaxMoved)
+	if s.Rehashing {
+		str += "（rehash 进行中，桶数是新旧两张表之和）"
+	}
+	return str
+}
+
+// 哈希索引实现（链式哈希，支持自动扩缩容）
+//
+// 负载因子超过上限时分配一张两倍大的新表，但不立即搬动数据：像 Redis 的 dict 一样，
+// 之后的每次 Get/Put/Delete 顺带把旧表中的 RehashStep 个桶迁移到新表（渐进式 rehash），
+// 把一次 O(n) 的停顿摊到后续的许多次操作上。rehash 期间新条目只写入新表，
+// 查找和删除两张表都要查；旧表迁移完后被丢弃。
+type HashIndex struct {
+	tables    [2]*table // tables[1] 只在 rehash 期间存在
+	rehashIdx int       // tables[0] 中下一个要迁移的桶
+	opts      Options
+	resizes   int
+	maxMoved  int
+}
+
+type table struct {
+	buckets []Bucket
+	count   int
+}
+
+type Bucket struct {
+	entries []Entry
+}
+
+type Entry struct {
+	Key   []byte
+	Value []byte
+	hash  uint64 // 缓存的哈希值，迁移时不用重新计算
+}
+
+var _ kv.KV = (*HashIndex)(nil)
+
+// NewHashIndex 创建初始有 size 个桶的哈希索引，其他选项使用默认值
+func NewHashIndex(size int) *HashIndex {
+	return NewHashIndexWithOptions(Options{InitialBuckets: size})
+}
+
+// NewHashIndexWithOptions 按 opts 创建哈希索引
+func NewHashIndexWithOptions(opts Options) *HashIndex {
+	opts = opts.withDefaults(1.0)
+	return &HashIndex{
+		tables: [2]*table{newTable(opts.InitialBuckets)},
+		opts:   opts,
+	}
+}
+
+func newTable(n int) *table {
+	return &table{buckets: make([]Bucket, n)}
+}
+
+func (t *table) bucket(h uint64) *Bucket {
+	return &t.buckets[h%uint64(len(t.buckets))]
+}
+
+// active 返回新条目写入的表：rehash 期间是新表
+func (hi *HashIndex) active() *table {
+	if hi.tables[1] != nil {
+		return hi.tables[1]
+	}
+	return hi.tables[0]
+}
+
+// BucketIndex 返回 key 在当前写入的表中的桶下标（哈希值对桶数取模，哈希值是无符号数，不会得到负下标）
+func (hi *HashIndex) BucketIndex(key []byte) int {
+	t := hi.active()
+	return int(hi.opts.Hash(key) % uint64(len(t.buckets)))
+}
+
+// Len 返回条目数
+func (hi *HashIndex) Len() int {
+	n := hi.tables[0].count
+	if hi.tables[1] != nil {
+		n += hi.tables[1].count
+	}
+	return n
+}
+
+// find 在两张表中查找 key，返回所在的表、桶和下标；不存在时 i 为 -1
+func (hi *HashIndex) find(key []byte, h uint64) (*table, *Bucket, int) {
+	for _, t := range hi.tables {
+		if t == nil {
+			continue
+		}
+		b := t.bucket(h)
+		// 在bucket中线性查找（处理哈希冲突）
+		for i, entry := range b.entries {
+			if entry.hash == h && bytes.Equal(entry.Key, key) {
+				return t, b, i
+			}
+		}
+	}
+	return nil, nil, -1
+}
+
+// 插入（复制键和值，调用方之后可以复用自己的缓冲区）
+func (hi *HashIndex) Put(key, value []byte) error {
+	hi.rehashStep()
+	h := hi.opts.Hash(key)
+	value = append([]byte{}, value...)
+
+	// 检查key是否已存在
+	if _, b, i := hi.find(key, h); i >= 0 {
+		// 更新现有值
+		b.entries[i].Value = value
+		return nil
+	}
+
+	// 添加新条目
+	t := hi.active()
+	b := t.bucket(h)
+	b.entries = append(b.entries, Entry{Key: append([]byte{}, key...), Value: value, hash: h})
+	t.count++
+	hi.maybeResize()
+	return nil
+}
+
+// 查找
+func (hi *HashIndex) Get(key []byte) ([]byte, bool, error) {
+	hi.rehashStep()
+	if _, b, i := hi.find(key, hi.opts.Hash(key)); i >= 0 {
+		return b.entries[i].Value, true, nil
+	}
+	return nil, false, nil
+}
+
+// 删除
+func (hi *HashIndex) Delete(key []byte) error {
+	hi.rehashStep()
+	if t, b, i := hi.find(key, hi.opts.Hash(key)); i >= 0 {
+		b.entries = append(b.entries[:i], b.entries[i+1:]...)
+		t.count--
+		hi.maybeResize()
+	}
+	return nil
+}
+
+// maybeResize 在负载因子越界时开始扩容或缩容（rehash 进行中时等它完成）
+func (hi *HashIndex) maybeResize() {
+	if hi.tables[1] != nil {
+		return
+	}
+	t := hi.tables[0]
+	n := len(t.buckets)
+	load := float64(t.count) / float64(n)
+	switch {
+	case load > hi.opts.MaxLoadFactor:
+		hi.resize(n * 2)
+	case load < hi.opts.MinLoadFactor && n > hi.opts.InitialBuckets:
+		hi.resize(max(n/2, hi.opts.InitialBuckets))
+	}
+}
+
+func (hi *HashIndex) resize(n int) {
+	hi.tables[1] = newTable(n)
+	hi.rehashIdx = 0
+	hi.resizes++
+	if hi.opts.RehashStep < 0 {
+		hi.migrate(len(hi.tables[0].buckets))
+	}
+}
+
+// rehashStep 在每次操作开始时迁移 RehashStep 个非空桶
+func (hi *HashIndex) rehashStep() {
+	if hi.tables[1] != nil && hi.opts.RehashStep > 0 {
+		hi.migrate(hi.opts.RehashStep)
+	}
+}
+
+// migrate 把旧表中最多 n 个非空桶搬到新表。和 Redis 一样，一次最多跳过 10*n 个空桶，
+// 避免稀疏的旧表让单次操作扫描太多桶
+func (hi *HashIndex) migrate(n int) {
+	old, next := hi.tables[0], hi.tables[1]
+	emptyVisits := n * 10
+	moved := 0
+	for n > 0 && hi.rehashIdx < len(old.buckets) {
+		b := &old.buckets[hi.rehashIdx]
+		hi.rehashIdx++
+		if len(b.entries) == 0 {
+			if emptyVisits--; emptyVisits == 0 {
+				break
+			}
+			continue
+		}
+		for _, e := range b.entries {
+			nb := next.bucket(e.hash)
+			nb.entries = append(nb.entries, e)
+		}
+		moved += len(b.entries)
+		old.count -= len(b.entries)
+		next.count += len(b.entries)
+		b.entries = nil
+		n--
+	}
+	hi.maxMoved = max(hi.maxMoved, moved)
+	if hi.rehashIdx == len(old.buckets) {
+		hi.tables = [2]*table{next, nil}
+		hi.rehashIdx = 0
+	}
+}
+
+// 范围扫描：哈希索引中的键是无序的，只能扫描所有bucket，
+// 取出 [start, end) 内的条目后按字节序排序，代价与数据总量成正比
+func (hi *HashIndex) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	var matched []Entry
+	for _, t := range hi.tables {
+		if t == nil {
+			continue
+		}
+		for _, bucket := range t.buckets {
+			for _, entry := range bucket.entries {
+				if kv.InRange(kv.Bytewise, entry.Key, start, end) {
+					matched = append(matched, entry)
+				}
+			}
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return bytes.Compare(matched[i].Key, matched[j].Key) < 0 })
+	for _, entry := range matched {
+		if !fn(entry.Key, entry.Value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Stats 统计负载因子和链长：链中第 i 个条目需要比较 i+1 次
+func (hi *HashIndex) Stats() Stats {
+	s := Stats{Resizes: hi.resizes, Rehashing: hi.tables[1] != nil, MaxMoved: hi.maxMoved}
+	probes := 0
+	for _, t := range hi.tables {
+		if t == nil {
+			continue
+		}
+		s.Entries += t.count
+		s.Buckets += len(t.buckets)
+		for _, b := range t.buckets {
+			n := len(b.entries)
+			probes += n * (n + 1) / 2
+			s.MaxProbe = max(s.MaxProbe, n)
+		}
+	}
+	s.LoadFactor = float64(s.Entries) / float64(s.Buckets)
+	if s.Entries > 0 {
+		s.AvgProbe = float64(probes) / float64(s.Entries)
+	}
+	return s
+}
+
+// CheckInvariants 校验每个条目都在它的哈希值对应的桶中、已迁移的旧桶为空、计数正确且没有重复的键
+func (hi *HashIndex) CheckInvariants() error {
+	seen := make(map[string]bool)
+	for ti, t := range hi.tables {
+		if t == nil {
+			continue
+		}
+		count := 0
+		for bi, b := range t.buckets {
+			if ti == 0 && hi.tables[1] != nil && bi < hi.rehashIdx && len(b.entries) > 0 {
+				return fmt.Errorf("bucket %d was migrated but still has %d entries", bi, len(b.entries))
+			}
+			for _, e := range b.entries {
+				if e.hash != hi.opts.Hash(e.Key) || t.bucket(e.hash) != &t.buckets[bi] {
+					return fmt.Errorf("key %s is in the wrong bucket %d of table %d", kv.FormatKey(e.Key), bi, ti)
+				}
+				if seen[string(e.Key)] {
+					return fmt.Errorf("duplicate key %s", kv.FormatKey(e.Key))
+				}
+				seen[string(e.Key)] = true
+				count++
+			}
+		}
+		if count != t.count {
+			return fmt.Errorf("table %d holds %d entries, count says %d", ti, count, t.count)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package hashindex
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/kvcheck"
+)
+
+// TestModel 用 pkg/kvcheck 把随机序列与 map 模型比较，每一步之后校验 CheckInvariants。
+// 初始只有 1~2 个桶，kvcheck 默认的 24 个键会让大多数操作发生在渐进式 rehash 进行中：
+// 查找和删除要同时查新旧两张表，覆盖写不能在新表中留下第二份。SumHash 让几乎所有键落进同一条链（或同一段探测序列）
+func TestModel(t *testing.T) {
+	targets := []struct {
+		name string
+		open func() kv.KV
+	}{
+		{"chained", func() kv.KV { return NewHashIndexWithOptions(Options{InitialBuckets: 1}) }},
+		{"chained/stop-the-world", func() kv.KV { return NewHashIndexWithOptions(Options{InitialBuckets: 2, RehashStep: -1}) }},
+		{"chained/sum-hash", func() kv.KV { return NewHashIndexWithOptions(Options{InitialBuckets: 1, Hash: SumHash}) }},
+		{"robin-hood", func() kv.KV { return NewOpenHashIndex(Options{InitialBuckets: 2}, true) }},
+		{"linear-probing/sum-hash", func() kv.KV {
+			return NewOpenHashIndex(Options{InitialBuckets: 2, MaxLoadFactor: 0.95, Hash: SumHash}, false)
+		}},
+	}
+	for _, tg := range targets {
+		open := tg.open
+		factory := func() (kv.KV, error) { return open(), nil }
+		if failure := kvcheck.CheckSeeds(kvcheck.Config{}, factory, 1, 20); failure != nil {
+			t.Errorf("%s: %s", tg.name, failure)
+		}
+	}
+}
+
+// TestIncrementalRehash 检查渐进式 rehash 把扩容的停顿摊开：每次操作最多迁移一个非空桶，
+// 而一次性 rehash 在最后一次扩容时要搬动全部条目
+func TestIncrementalRehash(t *testing.T) {
+	const n = 5000
+	var maxMoved [2]int
+	for i, step := range []int{1, -1} {
+		hi := NewHashIndexWithOptions(Options{InitialBuckets: 4, RehashStep: step})
+		for k := 0; k < n; k++ {
+			if err := hi.Put(kv.IntKey(k), []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := hi.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+		s := hi.Stats()
+		if s.Entries != n || s.Resizes == 0 {
+			t.Fatalf("step %d: %s", step, s)
+		}
+		maxMoved[i] = s.MaxMoved
+	}
+	if maxMoved[0] > 10 || maxMoved[1] < n/2 {
+		t.Errorf("max entries moved by one operation: incremental %d, stop-the-world %d", maxMoved[0], maxMoved[1])
+	}
+}
+
+// TestShrink 检查删除让负载因子低于下限时缩容（每次删除最多开始一次减半），但不会缩到 InitialBuckets 以下
+func TestShrink(t *testing.T) {
+	hi := NewHashIndexWithOptions(Options{InitialBuckets: 8})
+	for k := 0; k < 1000; k++ {
+		hi.Put([]byte(fmt.Sprint(k)), []byte("v"))
+	}
+	grown := hi.Stats().Buckets
+	for k := 0; k < 1000; k++ {
+		hi.Delete([]byte(fmt.Sprint(k)))
+	}
+	for hi.Stats().Rehashing { // 每次操作迁移一步，让进行中的缩容完成
+		hi.Get(nil)
+	}
+	if err := hi.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if s := hi.Stats(); s.Buckets >= grown || s.Buckets < 8 {
+		t.Errorf("after deleting everything from %d buckets: %s", grown, s)
+	}
+}
@@ -0,0 +1,239 @@
+package hashindex
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// 开放寻址哈希索引：条目直接存放在槽数组中，没有链表。冲突时线性探测下一个槽，
+// 每个条目记录它离自己的“家”（哈希值对应的槽）的距离 dist。
+//
+// Robin Hood 变体在插入时“劫富济贫”：探测途中遇到 dist 比自己小的条目就交换位置，
+// 让新条目住下、被挤出的条目继续向后找。这样探测距离的方差很小，
+// 查找时遇到 dist 小于当前探测距离的条目即可断定 key 不存在。
+// 删除使用后移删除（backward shift），不需要墓碑。
+//
+// 扩容一次性把所有条目重新插入新的槽数组（stop-the-world），不做渐进式 rehash。
+type OpenHashIndex struct {
+	slots     []slot
+	count     int
+	robinHood bool
+	opts      Options
+	resizes   int
+	maxMoved  int
+}
+
+type slot struct {
+	key, value []byte
+	hash       uint64
+	dist       int // 离家的距离，-1 表示空槽
+}
+
+var _ kv.KV = (*OpenHashIndex)(nil)
+
+// NewOpenHashIndex 创建开放寻址哈希索引，robinHood 为 false 时是普通的线性探测。
+// opts 中的 RehashStep 不起作用
+func NewOpenHashIndex(opts Options, robinHood bool) *OpenHashIndex {
+	opts = opts.withDefaults(0.85)
+	if opts.MaxLoadFactor >= 1 {
+		// 槽数组必须留有空槽，否则插入和查找找不到停止的位置
+		opts.MaxLoadFactor = 0.95
+	}
+	return &OpenHashIndex{slots: newSlots(opts.InitialBuckets), robinHood: robinHood, opts: opts}
+}
+
+func newSlots(n int) []slot {
+	slots := make([]slot, n)
+	for i := range slots {
+		slots[i].dist = -1
+	}
+	return slots
+}
+
+func (oh *OpenHashIndex) home(h uint64) int { return int(h % uint64(len(oh.slots))) }
+
+// Len 返回条目数
+func (oh *OpenHashIndex) Len() int { return oh.count }
+
+// find 返回 key 所在的槽，不存在时返回 -1
+func (oh *OpenHashIndex) find(key []byte, h uint64) int {
+	n := len(oh.slots)
+	i := oh.home(h)
+	for d := 0; d < n; d++ {
+		s := &oh.slots[i]
+		if s.dist < 0 || oh.robinHood && s.dist < d {
+			return -1
+		}
+		if s.hash == h && bytes.Equal(s.key, key) {
+			return i
+		}
+		i = (i + 1) % n
+	}
+	return -1
+}
+
+// Put 插入或更新 key（复制键和值）
+func (oh *OpenHashIndex) Put(key, value []byte) error {
+	h := oh.opts.Hash(key)
+	value = append([]byte{}, value...)
+	if i := oh.find(key, h); i >= 0 {
+		oh.slots[i].value = value
+		return nil
+	}
+	if float64(oh.count+1) > oh.opts.MaxLoadFactor*float64(len(oh.slots)) {
+		oh.resize(len(oh.slots) * 2)
+	}
+	oh.insert(slot{key: append([]byte{}, key...), value: value, hash: h})
+	oh.count++
+	return nil
+}
+
+// insert 从家开始向后找空槽；Robin Hood 模式下遇到比自己“富”（dist 更小）的条目就交换
+func (oh *OpenHashIndex) insert(s slot) {
+	n := len(oh.slots)
+	i := oh.home(s.hash)
+	s.dist = 0
+	for {
+		cur := &oh.slots[i]
+		if cur.dist < 0 {
+			*cur = s
+			return
+		}
+		if oh.robinHood && cur.dist < s.dist {
+			*cur, s = s, *cur
+		}
+		i = (i + 1) % n
+		s.dist++
+	}
+}
+
+// Get 查找 key
+func (oh *OpenHashIndex) Get(key []byte) ([]byte, bool, error) {
+	if i := oh.find(key, oh.opts.Hash(key)); i >= 0 {
+		return oh.slots[i].value, true, nil
+	}
+	return nil, false, nil
+}
+
+// Delete 删除 key，并把后面的条目前移填补空位
+func (oh *OpenHashIndex) Delete(key []byte) error {
+	i := oh.find(key, oh.opts.Hash(key))
+	if i < 0 {
+		return nil
+	}
+	n := len(oh.slots)
+	if oh.robinHood {
+		// Robin Hood 的簇中条目按家的位置排列：后面不在家的条目依次前移一格
+		for {
+			j := (i + 1) % n
+			next := oh.slots[j]
+			if next.dist <= 0 {
+				break
+			}
+			next.dist--
+			oh.slots[i] = next
+			i = j
+		}
+		oh.slots[i] = slot{dist: -1}
+	} else {
+		// 线性探测（Knuth 算法 R）：向后扫描整个簇，家不在 (空位, j] 之间的条目可以移进空位
+		oh.slots[i] = slot{dist: -1}
+		for j := (i + 1) % n; oh.slots[j].dist >= 0; j = (j + 1) % n {
+			home := oh.home(oh.slots[j].hash)
+			if (i < j && (home <= i || home > j)) || (i > j && home <= i && home > j) {
+				oh.slots[i] = oh.slots[j]
+				oh.slots[i].dist = (i - home + n) % n
+				oh.slots[j] = slot{dist: -1}
+				i = j
+			}
+		}
+	}
+	oh.count--
+	if float64(oh.count) < oh.opts.MinLoadFactor*float64(len(oh.slots)) && len(oh.slots) > oh.opts.InitialBuckets {
+		oh.resize(max(len(oh.slots)/2, oh.opts.InitialBuckets))
+	}
+	return nil
+}
+
+// resize 分配 n 个槽并重新插入所有条目
+func (oh *OpenHashIndex) resize(n int) {
+	old := oh.slots
+	oh.slots = newSlots(n)
+	for _, s := range old {
+		if s.dist >= 0 {
+			oh.insert(s)
+		}
+	}
+	oh.resizes++
+	oh.maxMoved = max(oh.maxMoved, oh.count)
+}
+
+// Scan 与链式哈希索引相同：取出范围内的所有条目排序后遍历
+func (oh *OpenHashIndex) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	var matched []slot
+	for _, s := range oh.slots {
+		if s.dist >= 0 && kv.InRange(kv.Bytewise, s.key, start, end) {
+			matched = append(matched, s)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return bytes.Compare(matched[i].key, matched[j].key) < 0 })
+	for _, s := range matched {
+		if !fn(s.key, s.value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Stats 统计探测距离：离家 dist 格的条目查找时要比较 dist+1 次
+func (oh *OpenHashIndex) Stats() Stats {
+	s := Stats{Entries: oh.count, Buckets: len(oh.slots), Resizes: oh.resizes, MaxMoved: oh.maxMoved}
+	probes := 0
+	for _, sl := range oh.slots {
+		if sl.dist >= 0 {
+			probes += sl.dist + 1
+			s.MaxProbe = max(s.MaxProbe, sl.dist+1)
+		}
+	}
+	s.LoadFactor = float64(s.Entries) / float64(s.Buckets)
+	if s.Entries > 0 {
+		s.AvgProbe = float64(probes) / float64(s.Entries)
+	}
+	return s
+}
+
+// CheckInvariants 校验 dist 与实际位置一致、从家到当前位置之间没有空槽（否则查找会提前停止）、
+// Robin Hood 模式下前一个槽的 dist 不小于当前 dist-1，以及计数和键的唯一性
+func (oh *OpenHashIndex) CheckInvariants() error {
+	n := len(oh.slots)
+	count := 0
+	seen := make(map[string]bool)
+	for i, s := range oh.slots {
+		if s.dist < 0 {
+			continue
+		}
+		count++
+		if s.hash != oh.opts.Hash(s.key) || (oh.home(s.hash)+s.dist)%n != i {
+			return fmt.Errorf("slot %d: key %s has dist %d but lives %d slots from home", i, kv.FormatKey(s.key), s.dist, (i-oh.home(s.hash)+n)%n)
+		}
+		for d := 1; d <= s.dist; d++ {
+			if oh.slots[(i-d+n)%n].dist < 0 {
+				return fmt.Errorf("slot %d: empty slot between key %s and its home", (i-d+n)%n, kv.FormatKey(s.key))
+			}
+		}
+		if prev := oh.slots[(i-1+n)%n]; oh.robinHood && s.dist > 0 && prev.dist < s.dist-1 {
+			return fmt.Errorf("slot %d: robin hood order violated (dist %d after %d)", i, s.dist, prev.dist)
+		}
+		if seen[string(s.key)] {
+			return fmt.Errorf("duplicate key %s", kv.FormatKey(s.key))
+		}
+		seen[string(s.key)] = true
+	}
+	if count != oh.count {
+		return fmt.Errorf("%d slots in use, count says %d", count, oh.count)
+	}
+	return nil
+}
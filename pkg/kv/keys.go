@@ -0,0 +1,123 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// 保持顺序的键编码：编码后的字节串按 Bytewise 比较的结果，与原值按自然顺序比较的结果相同，
+// 所以多个字段依次编码拼接起来就是一个按 (字段1, 字段2, ...) 排序的复合键，
+// 前缀相同的键在有序引擎中相邻，可以用 PrefixEnd 做前缀扫描。
+//
+//	无符号整数: 8 字节大端序
+//	有符号整数: 符号位取反后按无符号整数编码（负数排在正数前面）
+//	字节串:     0x00 转义成 0x00 0xFF，末尾加 0x00 0x01 作为结束符，
+//	            这样 "a" < "a\x00" < "ab"，而且一个字段不会吃掉后面字段的字节
+
+// ErrBadKey 表示解码时遇到了不合法的编码
+var ErrBadKey = errors.New("kv: malformed key encoding")
+
+// AppendUint64 把 v 按保持顺序的编码追加到 b 后面
+func AppendUint64(b []byte, v uint64) []byte { return binary.BigEndian.AppendUint64(b, v) }
+
+// AppendInt64 把 v 按保持顺序的编码追加到 b 后面
+func AppendInt64(b []byte, v int64) []byte { return AppendUint64(b, uint64(v)^(1<<63)) }
+
+// AppendBytes 把 s 按保持顺序、可拼接的编码追加到 b 后面
+func AppendBytes(b, s []byte) []byte {
+	for _, c := range s {
+		if c == 0x00 {
+			b = append(b, 0x00, 0xFF)
+		} else {
+			b = append(b, c)
+		}
+	}
+	return append(b, 0x00, 0x01)
+}
+
+// AppendString 与 AppendBytes 相同
+func AppendString(b []byte, s string) []byte { return AppendBytes(b, []byte(s)) }
+
+// DecodeUint64 从 b 的开头解码一个 AppendUint64 编码的整数，返回剩余的字节
+func DecodeUint64(b []byte) (uint64, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, ErrBadKey
+	}
+	return binary.BigEndian.Uint64(b), b[8:], nil
+}
+
+// DecodeInt64 从 b 的开头解码一个 AppendInt64 编码的整数，返回剩余的字节
+func DecodeInt64(b []byte) (int64, []byte, error) {
+	v, rest, err := DecodeUint64(b)
+	return int64(v ^ (1 << 63)), rest, err
+}
+
+// DecodeBytes 从 b 的开头解码一个 AppendBytes 编码的字节串，返回剩余的字节
+func DecodeBytes(b []byte) ([]byte, []byte, error) {
+	var s []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			s = append(s, b[i])
+			continue
+		}
+		if i+1 == len(b) {
+			break
+		}
+		switch b[i+1] {
+		case 0x01:
+			return s, b[i+2:], nil
+		case 0xFF:
+			s = append(s, 0x00)
+			i++
+		default:
+			return nil, nil, ErrBadKey
+		}
+	}
+	return nil, nil, ErrBadKey
+}
+
+// IntKey 把一个 int 编码成单字段的键，用于以整数为键的演示和测试
+func IntKey(n int) []byte { return AppendInt64(make([]byte, 0, 8), int64(n)) }
+
+// KeyInt 解码 IntKey 编码的键
+func KeyInt(key []byte) (int, error) {
+	v, rest, err := DecodeInt64(key)
+	if err == nil && len(rest) != 0 {
+		err = ErrBadKey
+	}
+	return int(v), err
+}
+
+// PrefixEnd 返回大于所有以 prefix 开头的键的最小键，用作前缀扫描 Scan(prefix, PrefixEnd(prefix)) 的上界；
+// prefix 全是 0xFF（或为空）时没有这样的键，返回 nil（不限上界）
+func PrefixEnd(prefix []byte) []byte {
+	end := Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// FormatKey 把键格式化成便于阅读的形式：可打印的键原样显示，
+// 其他 8 字节的键当作 IntKey 显示为整数，剩下的显示为十六进制
+func FormatKey(key []byte) string {
+	printable := true
+	for _, c := range key {
+		if c < 0x20 || c > 0x7E {
+			printable = false
+			break
+		}
+	}
+	if printable {
+		return string(key)
+	}
+	if n, err := KeyInt(key); err == nil {
+		return strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%x", key)
+}
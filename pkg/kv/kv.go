@@ -0,0 +1,45 @@
+// Package kv 定义了各个存储引擎共用的键值接口：键和值都是任意字节串，
+// 有序的引擎（B-tree、LSM-tree）按 Comparator 给键排序。
+// 同一段业务代码可以换用任何一个实现了 KV 的引擎；
+// 复合键（例如 (用户ID, 时间戳)）用 keys.go 中保持顺序的编码拼成一个字节串。
+package kv
+
+import "bytes"
+
+// Comparator 比较两个键，a < b 时返回负数，a == b 时返回 0，a > b 时返回正数。
+// 持久化的引擎按比较器的顺序把键写到磁盘上，重新打开时必须使用同一个比较器。
+type Comparator func(a, b []byte) int
+
+// Bytewise 按字节序比较键（bytes.Compare），是所有引擎的默认比较器。
+// 配合 keys.go 中的编码，整数和复合键的字节序与它们的自然顺序一致。
+func Bytewise(a, b []byte) int { return bytes.Compare(a, b) }
+
+// KV 是存储引擎的公共接口。
+//
+// 引擎在 Put 时复制键和值，调用方之后可以复用自己的缓冲区；
+// Get 和 Scan 返回的切片可能指向引擎内部的数据，调用方不能修改它们。
+type KV interface {
+	// Get 返回 key 的值，key 不存在时 ok 为 false
+	Get(key []byte) (value []byte, ok bool, err error)
+	// Put 写入或覆盖 key 的值
+	Put(key, value []byte) error
+	// Delete 删除 key，key 不存在时什么也不做
+	Delete(key []byte) error
+	// Scan 按键的顺序遍历 [start, end) 内的键值，fn 返回 false 时停止。
+	// start 为 nil 表示从第一个键开始，end 为 nil 表示一直到最后一个键。
+	// 无序的引擎（哈希索引）需要取出所有键排序，代价与数据量成正比
+	Scan(start, end []byte, fn func(key, value []byte) bool) error
+}
+
+// InRange 报告 key 是否在 [start, end) 内，nil 边界表示不限
+func InRange(cmp Comparator, key, start, end []byte) bool {
+	return (start == nil || cmp(key, start) >= 0) && (end == nil || cmp(key, end) < 0)
+}
+
+// Clone 返回 b 的副本，nil 仍然是 nil
+func Clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
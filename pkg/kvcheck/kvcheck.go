@@ -0,0 +1,449 @@
+// Package kvcheck 是存储引擎的基于模型的随机测试工具：生成随机的 Put/Get/Delete/Scan 操作序列，
+// 同时在被测引擎和一个参考模型（map + 排序后的键）上执行，比较每一步的结果。
+// 发现不一致时不断删减操作序列（delta debugging），直到得到仍然失败的最小序列，
+// 并把它打印成可以直接粘贴进 Go 代码的调用列表。
+//
+// 被测引擎只需实现 kv.KV；如果还实现了 Reopener、Checker 或 io.Closer，
+// 序列中会相应地穿插重启、在每一步之后校验内部不变量、在结束时关闭引擎。
+package kvcheck
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// Reopener 由持久化引擎实现：关闭后在同样的文件上重新打开，之前的写入必须仍然可见
+type Reopener interface {
+	Reopen() error
+}
+
+// Checker 由能够自检的引擎实现（例如 B-tree 的键有序、节点填充率、叶子深度）
+type Checker interface {
+	CheckInvariants() error
+}
+
+// Factory 创建一个全新的空引擎。每次执行操作序列（包括缩减时的每次重试）都会调用一次
+type Factory func() (kv.KV, error)
+
+// Kind 是操作的类型
+type Kind int
+
+const (
+	OpPut Kind = iota
+	OpGet
+	OpDelete
+	OpScan
+	OpReopen
+)
+
+// Op 是序列中的一条操作
+type Op struct {
+	Kind  Kind
+	Key   []byte // Scan 时为 start，nil 表示不限
+	End   []byte // 只用于 Scan，nil 表示不限
+	Value []byte // 只用于 Put
+	Limit int    // 只用于 Scan：读到 Limit 条后让 fn 返回 false，0 表示不限
+}
+
+func (o Op) String() string {
+	switch o.Kind {
+	case OpPut:
+		return fmt.Sprintf("Put(%s, %s)", goBytes(o.Key), goBytes(o.Value))
+	case OpGet:
+		return fmt.Sprintf("Get(%s)", goBytes(o.Key))
+	case OpDelete:
+		return fmt.Sprintf("Delete(%s)", goBytes(o.Key))
+	case OpScan:
+		s := fmt.Sprintf("Scan(%s, %s)", goBytes(o.Key), goBytes(o.End))
+		if o.Limit > 0 {
+			s += fmt.Sprintf(" // limit %d", o.Limit)
+		}
+		return s
+	case OpReopen:
+		return "Reopen()"
+	}
+	return fmt.Sprintf("Op(%d)", int(o.Kind))
+}
+
+// goBytes 把键或值格式化成 Go 表达式：IntKey 编码的 8 字节键写成 kv.IntKey(n)，其他写成 []byte("...")
+func goBytes(b []byte) string {
+	if b == nil {
+		return "nil"
+	}
+	if len(b) == 8 {
+		if n, err := kv.KeyInt(b); err == nil && n > -1<<20 && n < 1<<20 {
+			return fmt.Sprintf("kv.IntKey(%d)", n)
+		}
+	}
+	return fmt.Sprintf("[]byte(%q)", b)
+}
+
+// Config 控制操作序列的生成
+type Config struct {
+	// Comparator 是被测引擎的键顺序，模型按它排序扫描结果，默认 kv.Bytewise
+	Comparator kv.Comparator
+	// Keys 是一个序列使用的不同键的个数，键少时同一个键会被反复覆盖和删除，默认 24
+	Keys int
+	// Ops 是每个序列的操作数，默认 300
+	Ops int
+	// MaxValue 是值的最大长度，默认 16
+	MaxValue int
+	// NewKey 生成一个键，默认混合 IntKey 编码的整数（含负数）和由 0x00、0x01、'a'、'b'、0xFF
+	// 组成的短字节串（含空键）。只支持整数键的引擎可以改成只生成 IntKey
+	NewKey func(r *rand.Rand) []byte
+	// Reopen 为 true 时序列中穿插重启操作（引擎需实现 Reopener，否则重启什么也不做）
+	Reopen bool
+	// MaxShrinkRuns 限制缩减时重新执行序列的次数，默认 2000
+	MaxShrinkRuns int
+}
+
+func (c Config) withDefaults() Config {
+	if c.Comparator == nil {
+		c.Comparator = kv.Bytewise
+	}
+	if c.Keys <= 0 {
+		c.Keys = 24
+	}
+	if c.Ops <= 0 {
+		c.Ops = 300
+	}
+	if c.MaxValue <= 0 {
+		c.MaxValue = 16
+	}
+	if c.NewKey == nil {
+		c.NewKey = defaultKey
+	}
+	if c.MaxShrinkRuns <= 0 {
+		c.MaxShrinkRuns = 2000
+	}
+	return c
+}
+
+func defaultKey(r *rand.Rand) []byte {
+	if r.Intn(2) == 0 {
+		return kv.IntKey(r.Intn(200) - 100)
+	}
+	alphabet := []byte{0x00, 0x01, 'a', 'b', 0xFF}
+	key := make([]byte, r.Intn(4))
+	for i := range key {
+		key[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return key
+}
+
+// Generate 用 seed 生成一个操作序列：先生成 cfg.Keys 个键组成键空间，操作的键和扫描边界都从中选取
+func Generate(cfg Config, seed int64) []Op {
+	cfg = cfg.withDefaults()
+	r := rand.New(rand.NewSource(seed))
+	keys := make([][]byte, cfg.Keys)
+	for i := range keys {
+		keys[i] = cfg.NewKey(r)
+	}
+	key := func() []byte { return keys[r.Intn(len(keys))] }
+	bound := func() []byte {
+		if r.Intn(5) == 0 {
+			return nil
+		}
+		return key()
+	}
+
+	ops := make([]Op, 0, cfg.Ops)
+	for len(ops) < cfg.Ops {
+		switch n := r.Intn(100); {
+		case n < 40:
+			value := make([]byte, r.Intn(cfg.MaxValue+1))
+			for i := range value {
+				value[i] = byte('a' + r.Intn(26))
+			}
+			ops = append(ops, Op{Kind: OpPut, Key: key(), Value: value})
+		case n < 65:
+			ops = append(ops, Op{Kind: OpGet, Key: key()})
+		case n < 80:
+			ops = append(ops, Op{Kind: OpDelete, Key: key()})
+		case n < 97:
+			op := Op{Kind: OpScan, Key: bound(), End: bound()}
+			if r.Intn(3) == 0 {
+				op.Limit = 1 + r.Intn(4)
+			}
+			ops = append(ops, op)
+		default:
+			if cfg.Reopen {
+				ops = append(ops, Op{Kind: OpReopen})
+			}
+		}
+	}
+	return ops
+}
+
+// Failure 描述一个失败的操作序列
+type Failure struct {
+	Seed int64
+	Ops  []Op // 失败的序列；经过 Shrink 后是最小序列
+	Step int  // 第一个结果与模型不一致的操作下标，等于 len(Ops) 表示关闭引擎时失败
+	Msg  string
+	Runs int // 缩减时重新执行序列的次数
+}
+
+// String 返回失败的最小复现：每行一条操作，出错的那一行做了标记
+func (f *Failure) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "seed %d: %d ops after shrinking (%d runs):\n", f.Seed, len(f.Ops), f.Runs)
+	for i, op := range f.Ops {
+		mark := "  "
+		if i == f.Step {
+			mark = "=>"
+		}
+		fmt.Fprintf(&b, "  %s %3d  %s\n", mark, i, op)
+	}
+	if f.Step == len(f.Ops) {
+		fmt.Fprintf(&b, "  => Close()\n")
+	}
+	fmt.Fprintf(&b, "  %s\n", f.Msg)
+	return b.String()
+}
+
+// model 是参考实现：map 保存内容，扫描时把键排序
+type model struct {
+	cmp  kv.Comparator
+	data map[string][]byte
+}
+
+func (m *model) scan(start, end []byte, limit int) [][2][]byte {
+	var keys [][]byte
+	for k := range m.data {
+		if key := []byte(k); kv.InRange(m.cmp, key, start, end) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return m.cmp(keys[i], keys[j]) < 0 })
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	out := make([][2][]byte, len(keys))
+	for i, k := range keys {
+		out[i] = [2][]byte{k, m.data[string(k)]}
+	}
+	return out
+}
+
+// Run 在 factory 创建的新引擎上执行 ops，返回第一个与模型不一致的操作；全部一致时返回 nil。
+// 引擎的 panic 也算作失败。
+func Run(cfg Config, factory Factory, ops []Op) (failure *Failure) {
+	cfg = cfg.withDefaults()
+	step := 0
+	defer func() {
+		if p := recover(); p != nil {
+			failure = &Failure{Ops: ops, Step: step, Msg: fmt.Sprintf("panic: %v\n%s", p, panicSite())}
+		}
+	}()
+
+	db, err := factory()
+	if err != nil {
+		return &Failure{Ops: ops, Msg: fmt.Sprintf("open: %v", err)}
+	}
+	closed := false
+	defer func() {
+		if c, ok := db.(io.Closer); ok && !closed {
+			c.Close()
+		}
+	}()
+	m := &model{cmp: cfg.Comparator, data: make(map[string][]byte)}
+	fail := func(format string, args ...any) *Failure {
+		return &Failure{Ops: ops, Step: step, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	for step = 0; step < len(ops); step++ {
+		op := ops[step]
+		switch op.Kind {
+		case OpPut:
+			// 传给引擎的是临时缓冲区，返回后立即涂改：引擎必须复制键和值
+			key, value := kv.Clone(op.Key), kv.Clone(op.Value)
+			if key == nil {
+				key = []byte{}
+			}
+			err = db.Put(key, value)
+			scribble(key)
+			scribble(value)
+			if err != nil {
+				return fail("Put: %v", err)
+			}
+			m.data[string(op.Key)] = op.Value
+		case OpDelete:
+			key := kv.Clone(op.Key)
+			err = db.Delete(key)
+			scribble(key)
+			if err != nil {
+				return fail("Delete: %v", err)
+			}
+			delete(m.data, string(op.Key))
+		case OpGet:
+			value, ok, err := db.Get(op.Key)
+			if err != nil {
+				return fail("Get: %v", err)
+			}
+			want, wantOK := m.data[string(op.Key)]
+			if ok != wantOK || !bytes.Equal(value, want) {
+				return fail("Get returned %s, model has %s", found(value, ok), found(want, wantOK))
+			}
+		case OpScan:
+			var got [][2][]byte
+			stopped := false
+			err := db.Scan(op.Key, op.End, func(key, value []byte) bool {
+				if stopped {
+					got = append(got, [2][]byte{nil, nil})
+					return false
+				}
+				got = append(got, [2][]byte{kv.Clone(key), kv.Clone(value)})
+				stopped = op.Limit > 0 && len(got) >= op.Limit
+				return !stopped
+			})
+			if err != nil {
+				return fail("Scan: %v", err)
+			}
+			want := m.scan(op.Key, op.End, op.Limit)
+			if op.Limit > 0 && len(got) > op.Limit {
+				return fail("Scan kept calling fn after it returned false: %d calls, limit %d", len(got), op.Limit)
+			}
+			if !equalPairs(got, want) {
+				return fail("Scan returned %s\n  model has     %s", formatPairs(got), formatPairs(want))
+			}
+		case OpReopen:
+			if r, ok := db.(Reopener); ok {
+				if err := r.Reopen(); err != nil {
+					return fail("Reopen: %v", err)
+				}
+			}
+		}
+		if c, ok := db.(Checker); ok {
+			if err := c.CheckInvariants(); err != nil {
+				return fail("invariant violated: %v", err)
+			}
+		}
+	}
+	if c, ok := db.(io.Closer); ok {
+		closed = true
+		if err := c.Close(); err != nil {
+			return fail("Close: %v", err)
+		}
+	}
+	return nil
+}
+
+// Shrink 反复删除失败序列中的操作（先成块删除，块逐渐变小，最后逐条删除），
+// 再把 Put 的值缩短，只要序列仍然失败就保留修改，返回最小的失败
+func Shrink(cfg Config, factory Factory, f *Failure) *Failure {
+	cfg = cfg.withDefaults()
+	best := *f
+	runs := 0
+	try := func(ops []Op) bool {
+		if runs >= cfg.MaxShrinkRuns {
+			return false
+		}
+		runs++
+		g := Run(cfg, factory, ops)
+		if g == nil {
+			return false
+		}
+		best.Ops, best.Step, best.Msg = g.Ops, g.Step, g.Msg
+		return true
+	}
+
+	// 失败之后的操作与失败无关
+	if best.Step < len(best.Ops) {
+		try(best.Ops[:best.Step+1])
+	}
+	for chunk := len(best.Ops) / 2; chunk >= 1; chunk /= 2 {
+		for i := 0; i+chunk <= len(best.Ops); {
+			cand := append(append([]Op{}, best.Ops[:i]...), best.Ops[i+chunk:]...)
+			if !try(cand) {
+				i += chunk
+			}
+		}
+	}
+	for i := range best.Ops {
+		if op := best.Ops[i]; op.Kind == OpPut && len(op.Value) > 1 {
+			cand := append([]Op{}, best.Ops...)
+			cand[i].Value = []byte("v")
+			try(cand)
+		}
+	}
+	best.Runs = runs
+	return &best
+}
+
+// Check 生成 seed 对应的序列并执行，失败时缩减后返回
+func Check(cfg Config, factory Factory, seed int64) *Failure {
+	ops := Generate(cfg, seed)
+	f := Run(cfg, factory, ops)
+	if f == nil {
+		return nil
+	}
+	f = Shrink(cfg, factory, f)
+	f.Seed = seed
+	return f
+}
+
+// CheckSeeds 依次检查种子 first, first+1, ... 共 n 个序列，返回第一个失败
+func CheckSeeds(cfg Config, factory Factory, first int64, n int) *Failure {
+	for seed := first; seed < first+int64(n); seed++ {
+		if f := Check(cfg, factory, seed); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// scribble 涂改调用方已经交给引擎的缓冲区
+func scribble(b []byte) {
+	for i := range b {
+		b[i] = 0xEE
+	}
+}
+
+func found(value []byte, ok bool) string {
+	if !ok {
+		return "not found"
+	}
+	return goBytes(value)
+}
+
+func equalPairs(a, b [][2][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i][0], b[i][0]) || !bytes.Equal(a[i][1], b[i][1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatPairs(pairs [][2][]byte) string {
+	parts := make([]string, len(pairs))
+	for i, p := range pairs {
+		parts[i] = goBytes(p[0]) + "=" + goBytes(p[1])
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// panicSite 从调用栈中找出引发 panic 的那一帧（跳过 runtime 和本包）
+func panicSite() string {
+	lines := strings.Split(string(debug.Stack()), "\n")
+	for i := 1; i+1 < len(lines); i += 2 {
+		fn := lines[i]
+		if strings.HasPrefix(fn, "runtime") || strings.HasPrefix(fn, "panic(") ||
+			strings.Contains(fn, "/kvcheck.") || strings.HasPrefix(fn, "goroutine ") {
+			continue
+		}
+		return "  at " + fn + "\n    " + strings.TrimSpace(lines[i+1])
+	}
+	return ""
+}
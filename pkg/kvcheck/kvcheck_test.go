@@ -0,0 +1,72 @@
+package kvcheck
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// mapKV 是最简单的正确实现；inclusiveEnd 为 true 时 Scan 错误地包含上界
+type mapKV struct {
+	data         map[string][]byte
+	inclusiveEnd bool
+}
+
+func (m *mapKV) Get(key []byte) ([]byte, bool, error) {
+	v, ok := m.data[string(key)]
+	return v, ok, nil
+}
+
+func (m *mapKV) Put(key, value []byte) error {
+	m.data[string(key)] = kv.Clone(value)
+	return nil
+}
+
+func (m *mapKV) Delete(key []byte) error {
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *mapKV) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	keys := make([]string, 0, len(m.data))
+	for k := range m.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key := []byte(k)
+		if !kv.InRange(kv.Bytewise, key, start, end) && !(m.inclusiveEnd && end != nil && k == string(end)) {
+			continue
+		}
+		if !fn(key, m.data[k]) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func TestCheckSeedsPasses(t *testing.T) {
+	factory := func() (kv.KV, error) { return &mapKV{data: make(map[string][]byte)}, nil }
+	if f := CheckSeeds(Config{}, factory, 1, 10); f != nil {
+		t.Fatalf("correct KV failed:\n%s", f)
+	}
+}
+
+// TestCheckSeedsShrinks 检查有 bug 的实现会被发现，并且失败序列被缩减到几条操作
+func TestCheckSeedsShrinks(t *testing.T) {
+	factory := func() (kv.KV, error) { return &mapKV{data: make(map[string][]byte), inclusiveEnd: true}, nil }
+	f := CheckSeeds(Config{}, factory, 1, 10)
+	if f == nil {
+		t.Fatal("inclusive-end Scan was not detected")
+	}
+	if f.Ops[f.Step].Kind != OpScan {
+		t.Errorf("failing op is %s, want a Scan:\n%s", f.Ops[f.Step], f)
+	}
+	if len(f.Ops) > 3 {
+		t.Errorf("shrunk to %d ops, want at most 3 (Put + Scan):\n%s", len(f.Ops), f)
+	}
+	if Run(Config{}, factory, f.Ops) == nil {
+		t.Error("shrunk sequence no longer fails")
+	}
+}
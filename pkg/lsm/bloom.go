@@ -0,0 +1,63 @@
+package lsm
+
+// bloomFilter 是一个 SSTable 的布隆过滤器：位数组后面跟 1 字节的哈希函数个数 k。
+// 写表时为每个键计算一个 64 位哈希，用双重哈希（h1 + i*h2）导出 k 个位置；
+// 查找时只要有一位为 0，键就一定不在表中，可以跳过这个表而不读取任何数据块。
+// 每个键 10 位时 k ≈ 7，理论误判率约 1%。
+type bloomFilter []byte
+
+// keyHash 把键打散成 64 位哈希：FNV-1a 逐字节累积，再用 splitmix64 的终结函数打散各位。
+// 哈希值决定了过滤器中的位，写进了 SSTable，不能使用每个进程随机种子的哈希
+func keyHash(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// newBloomFilter 用键的哈希值构造过滤器，bitsPerKey 越大误判率越低
+func newBloomFilter(hashes []uint64, bitsPerKey int) bloomFilter {
+	// k = bitsPerKey × ln2 时误判率最低
+	k := max(1, min(30, bitsPerKey*69/100))
+	nbits := max(64, len(hashes)*bitsPerKey)
+	nbytes := (nbits + 7) / 8
+	nbits = nbytes * 8
+
+	f := make(bloomFilter, nbytes+1)
+	f[nbytes] = byte(k)
+	for _, h := range hashes {
+		h1, h2 := uint32(h), uint32(h>>32)|1
+		for i := 0; i < k; i++ {
+			pos := h1 % uint32(nbits)
+			f[pos/8] |= 1 << (pos % 8)
+			h1 += h2
+		}
+	}
+	return f
+}
+
+// mayContain 返回 false 时键一定不在表中；没有过滤器时总是返回 true
+func (f bloomFilter) mayContain(key []byte) bool {
+	if len(f) < 2 {
+		return true
+	}
+	nbits := uint32(len(f)-1) * 8
+	k := int(f[len(f)-1])
+	h := keyHash(key)
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	for i := 0; i < k; i++ {
+		pos := h1 % nbits
+		if f[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+		h1 += h2
+	}
+	return true
+}
@@ -0,0 +1,427 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// CompactionStrategy 选择合并策略
+type CompactionStrategy int
+
+const (
+	// CompactionLeveled 是 LevelDB/RocksDB 风格的分层合并：L0 中的表互相重叠，
+	// L1..Ln 每层内的表键范围互不重叠，第 i 层的容量是第 i-1 层的 LevelSizeRatio 倍。
+	// L0 的表数达到 L0CompactionTrigger 时与 L1 中重叠的表合并；
+	// 某层超出容量时挑一个表与下一层中重叠的表合并。读放大和空间放大小，写放大大。
+	CompactionLeveled CompactionStrategy = iota
+	// CompactionSizeTiered 是 Cassandra 风格的分级合并：每个 SSTable 是一个有序段（run），
+	// 大小相近（相差不超过 2 倍）的相邻段凑够 TierMinMerge 个就合并成一个更大的段。
+	// 写放大小，但读取要查的段更多，合并前后的空间放大也更大。
+	CompactionSizeTiered
+	// CompactionNone 不做合并，SSTable 只增不减
+	CompactionNone
+)
+
+func (s CompactionStrategy) String() string {
+	switch s {
+	case CompactionLeveled:
+		return "leveled"
+	case CompactionSizeTiered:
+		return "size-tiered"
+	case CompactionNone:
+		return "none"
+	}
+	return fmt.Sprintf("CompactionStrategy(%d)", int(s))
+}
+
+// maxLevels 是 leveled 策略的最大层数（L0..L6）
+const maxLevels = 7
+
+// compaction 描述一次合并：把 sources（从新到旧）归并后写到 outputLevel
+type compaction struct {
+	sources     [][]*Table // 每个来源是一个有序段：L0 的单个表或者一整层中的若干表
+	inputs      []*Table
+	inputLevels map[*Table]int
+	outputLevel int
+	// dropTombstones 为 true 时输出中不再保留删除标记：
+	// 更旧的数据都已经参与了这次合并，墓碑已经没有需要遮蔽的东西了
+	dropTombstones bool
+}
+
+func (c *compaction) addSource(level int, tables ...*Table) {
+	if len(tables) == 0 {
+		return
+	}
+	c.sources = append(c.sources, tables)
+	for _, t := range tables {
+		c.inputs = append(c.inputs, t)
+		c.inputLevels[t] = level
+	}
+}
+
+func newCompaction(outputLevel int) *compaction {
+	return &compaction{outputLevel: outputLevel, inputLevels: make(map[*Table]int)}
+}
+
+// keyRange 返回一组表的最小键和最大键
+func keyRange(cmp kv.Comparator, tables []*Table) ([]byte, []byte) {
+	lo, hi := tables[0].minKey, tables[0].maxKey
+	for _, t := range tables[1:] {
+		if cmp(t.minKey, lo) < 0 {
+			lo = t.minKey
+		}
+		if cmp(t.maxKey, hi) > 0 {
+			hi = t.maxKey
+		}
+	}
+	return lo, hi
+}
+
+// overlapping 返回 tables 中与 [lo, hi] 有重叠的表，lo、hi 为 nil 表示不限
+func overlapping(cmp kv.Comparator, tables []*Table, lo, hi []byte) []*Table {
+	var out []*Table
+	for _, t := range tables {
+		if (lo == nil || cmp(t.maxKey, lo) >= 0) && (hi == nil || cmp(t.minKey, hi) <= 0) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// levelMaxBytes 返回 leveled 策略中第 level（>=1）层的容量
+func (lsm *LSMTree) levelMaxBytes(level int) int64 {
+	n := lsm.opts.BaseLevelBytes
+	for i := 1; i < level; i++ {
+		n *= int64(lsm.opts.LevelSizeRatio)
+	}
+	return n
+}
+
+func levelBytes(tables []*Table) int64 {
+	var n int64
+	for _, t := range tables {
+		n += t.size
+	}
+	return n
+}
+
+// pickCompaction 在持有 mu 时调用，返回下一个需要执行的合并，没有时返回 nil
+func (lsm *LSMTree) pickCompaction() *compaction {
+	switch lsm.opts.Compaction {
+	case CompactionLeveled:
+		return lsm.pickLeveled()
+	case CompactionSizeTiered:
+		return lsm.pickSizeTiered()
+	}
+	return nil
+}
+
+func (lsm *LSMTree) pickLeveled() *compaction {
+	for len(lsm.levels) < maxLevels {
+		lsm.levels = append(lsm.levels, nil)
+	}
+
+	var c *compaction
+	if l0 := lsm.levels[0]; len(l0) >= lsm.opts.L0CompactionTrigger {
+		// L0 的表互相重叠，全部与 L1 中重叠的部分一起合并
+		c = newCompaction(1)
+		for i := len(l0) - 1; i >= 0; i-- {
+			c.addSource(0, l0[i])
+		}
+		lo, hi := keyRange(lsm.cmp, l0)
+		c.addSource(1, overlapping(lsm.cmp, lsm.levels[1], lo, hi)...)
+	} else {
+		// 找出超出容量最多的一层
+		best, bestScore := 0, 1.0
+		for level := 1; level < maxLevels-1; level++ {
+			score := float64(levelBytes(lsm.levels[level])) / float64(lsm.levelMaxBytes(level))
+			if score > bestScore {
+				best, bestScore = level, score
+			}
+		}
+		if best == 0 {
+			return nil
+		}
+		// 轮流选择该层中的表（从上次合并的位置继续），让整层的键空间都能被合并到
+		tables := lsm.levels[best]
+		i := 0
+		if ptr, ok := lsm.compactPointer[best]; ok {
+			i = sort.Search(len(tables), func(i int) bool { return lsm.cmp(tables[i].minKey, ptr) > 0 })
+		}
+		if i == len(tables) {
+			i = 0
+		}
+		t := tables[i]
+		lsm.compactPointer[best] = t.maxKey
+		c = newCompaction(best + 1)
+		c.addSource(best, t)
+		c.addSource(best+1, overlapping(lsm.cmp, lsm.levels[best+1], t.minKey, t.maxKey)...)
+	}
+
+	// 更深的层里没有重叠的数据时，可以丢弃删除标记
+	lo, hi := keyRange(lsm.cmp, c.inputs)
+	c.dropTombstones = true
+	for level := c.outputLevel + 1; level < len(lsm.levels); level++ {
+		if len(overlapping(lsm.cmp, lsm.levels[level], lo, hi)) > 0 {
+			c.dropTombstones = false
+		}
+	}
+	return c
+}
+
+func (lsm *LSMTree) pickSizeTiered() *compaction {
+	runs := lsm.levels[0] // 从旧到新
+	// 从最新的段开始向旧的方向找一组大小相近的相邻段
+	for end := len(runs); end >= lsm.opts.TierMinMerge; end-- {
+		lo, hi := runs[end-1].size, runs[end-1].size
+		start := end - 1
+		for start > 0 {
+			s := runs[start-1].size
+			if max(hi, s) > 2*min(lo, s) {
+				break
+			}
+			lo, hi = min(lo, s), max(hi, s)
+			start--
+		}
+		if end-start < lsm.opts.TierMinMerge {
+			continue
+		}
+		c := newCompaction(0)
+		for i := end - 1; i >= start; i-- {
+			c.addSource(0, runs[i])
+		}
+		// 包含最旧的段时，没有更旧的数据需要被墓碑遮蔽
+		c.dropTombstones = start == 0
+		return c
+	}
+	return nil
+}
+
+// runCompaction 归并输入并写出新的 SSTable，然后在持有 mu 时替换输入。
+// 归并期间不持有 mu：输入的表是不可变的，只会在安装结果时被移除。
+func (lsm *LSMTree) runCompaction(c *compaction) error {
+	sources := make([]iterator, len(c.sources))
+	for i, tables := range c.sources {
+		sources[i] = newConcatIter(tables, nil)
+	}
+	it := newMergingIter(lsm.cmp, sources, c.dropTombstones)
+
+	// leveled 策略的输出按 TargetFileSize 切分成多个表；size-tiered 的每个段只有一个表
+	var outputs []*Table
+	var tw *tableWriter
+	var num uint64
+	finish := func() error {
+		if tw == nil {
+			return nil
+		}
+		path := tw.path
+		err := tw.finish()
+		tw = nil
+		if err != nil {
+			return err
+		}
+		t, err := openTable(path, num, lsm.cmp, &lsm.stats)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, t)
+		return nil
+	}
+	abort := func(err error) error {
+		if tw != nil {
+			tw.abort()
+		}
+		for _, t := range outputs {
+			t.Close()
+			os.Remove(t.path)
+		}
+		return err
+	}
+
+	for it.Next() {
+		if tw == nil {
+			lsm.mu.Lock()
+			num = lsm.allocFile()
+			lsm.mu.Unlock()
+			var err error
+			if tw, err = newTableWriter(lsm.tablePath(num), lsm.opts.BlockSize, lsm.opts.Compression, lsm.opts.BloomBitsPerKey); err != nil {
+				return abort(err)
+			}
+		}
+		if err := tw.add(it.Entry()); err != nil {
+			return abort(err)
+		}
+		if c.outputLevel > 0 && tw.size() >= lsm.opts.TargetFileSize {
+			if err := finish(); err != nil {
+				return abort(err)
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return abort(err)
+	}
+	if err := finish(); err != nil {
+		return abort(err)
+	}
+
+	lsm.mu.Lock()
+	lsm.installCompaction(c, outputs)
+	err := writeManifest(lsm.dir, lsm.levels)
+	if err == nil {
+		lsm.stats.compactions++
+		for _, t := range c.inputs {
+			lsm.stats.compactionRead += t.size
+		}
+		for _, t := range outputs {
+			lsm.stats.compactionWritten += t.size
+		}
+		lsm.cond.Broadcast() // L0 可能已经降到限流阈值以下
+	}
+	lsm.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 新的 MANIFEST 已经生效，读者也看不到输入的表了，可以删除它们
+	for _, t := range c.inputs {
+		t.Close()
+		os.Remove(t.path)
+	}
+	return nil
+}
+
+// installCompaction 用 outputs 替换 c 的输入，调用方持有 mu
+func (lsm *LSMTree) installCompaction(c *compaction, outputs []*Table) {
+	if c.outputLevel == 0 {
+		// size-tiered：新的段放在被合并的那组段原来的位置，保持从旧到新的顺序
+		runs := lsm.levels[0]
+		var kept []*Table
+		inserted := false
+		for _, t := range runs {
+			if _, ok := c.inputLevels[t]; !ok {
+				kept = append(kept, t)
+			} else if !inserted {
+				kept = append(kept, outputs...)
+				inserted = true
+			}
+		}
+		lsm.levels[0] = kept
+		return
+	}
+
+	for level := range lsm.levels {
+		var kept []*Table
+		for _, t := range lsm.levels[level] {
+			if _, ok := c.inputLevels[t]; !ok {
+				kept = append(kept, t)
+			}
+		}
+		lsm.levels[level] = kept
+	}
+	out := append(lsm.levels[c.outputLevel], outputs...)
+	sort.Slice(out, func(i, j int) bool { return lsm.cmp(out[i].minKey, out[j].minKey) < 0 })
+	lsm.levels[c.outputLevel] = out
+}
+
+// compactUntilIdle 反复执行合并，直到没有需要合并的层
+func (lsm *LSMTree) compactUntilIdle() error {
+	lsm.compactMu.Lock()
+	defer lsm.compactMu.Unlock()
+	for {
+		lsm.mu.Lock()
+		if lsm.closed {
+			lsm.mu.Unlock()
+			return ErrClosed
+		}
+		c := lsm.pickCompaction()
+		lsm.mu.Unlock()
+		if c == nil {
+			return nil
+		}
+		if err := lsm.runCompaction(c); err != nil {
+			return err
+		}
+	}
+}
+
+// compactor 是后台合并协程：每次刷盘后被唤醒
+func (lsm *LSMTree) compactor() {
+	defer lsm.bg.Done()
+	for {
+		select {
+		case <-lsm.done:
+			return
+		case <-lsm.compactCh:
+			if err := lsm.compactUntilIdle(); err != nil {
+				lsm.mu.Lock()
+				if lsm.bgErr == nil {
+					lsm.bgErr = err
+				}
+				lsm.cond.Broadcast()
+				lsm.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Compact 在当前协程中执行所有待做的合并并等待它们完成
+func (lsm *LSMTree) Compact() error {
+	return lsm.compactUntilIdle()
+}
+
+// Amplification 汇总了当前的写放大、读放大和空间放大
+type Amplification struct {
+	UserBytes     int64   // 用户写入的数据量（编码后的记录大小）
+	WrittenBytes  int64   // 刷盘和合并写入 SSTable 的总字节数，加上写入值日志的字节数
+	TableBytes    int64   // 当前所有 SSTable 的总大小
+	ValueLogBytes int64   // 当前所有值日志段的总大小
+	LiveBytes     int64   // 去掉旧版本和删除标记后的有效数据量（指针按它指向的值计算）
+	Write         float64 // WrittenBytes / UserBytes
+	Read          float64 // 一次点查在最坏情况下需要查找的 SSTable 数
+	Space         float64 // (TableBytes + ValueLogBytes) / LiveBytes
+}
+
+// Amplification 计算放大系数。LiveBytes 需要归并扫描所有 SSTable，开销与数据量成正比。
+func (lsm *LSMTree) Amplification() (Amplification, error) {
+	lsm.mu.RLock()
+	defer lsm.mu.RUnlock()
+	if lsm.closed {
+		return Amplification{}, ErrClosed
+	}
+
+	a := Amplification{
+		UserBytes:     lsm.stats.userBytes,
+		WrittenBytes:  lsm.stats.flushWritten + lsm.stats.compactionWritten + lsm.stats.vlogWritten.Load(),
+		ValueLogBytes: lsm.vlog.totalSize(),
+	}
+	var sources []iterator
+	for level, tables := range lsm.levels {
+		a.TableBytes += levelBytes(tables)
+		if level == 0 {
+			for i := len(tables) - 1; i >= 0; i-- {
+				sources = append(sources, tables[i].iter())
+			}
+			a.Read += float64(len(tables))
+		} else if len(tables) > 0 {
+			sources = append(sources, newConcatIter(tables, nil))
+			a.Read++ // 同一层的表互不重叠，每层最多查一个
+		}
+	}
+	it := newMergingIter(lsm.cmp, sources, true)
+	for it.Next() {
+		a.LiveBytes += int64(logicalSize(it.Entry()))
+	}
+	if err := it.Err(); err != nil {
+		return a, err
+	}
+	if a.UserBytes > 0 {
+		a.Write = float64(a.WrittenBytes) / float64(a.UserBytes)
+	}
+	if a.LiveBytes > 0 {
+		a.Space = float64(a.TableBytes+a.ValueLogBytes) / float64(a.LiveBytes)
+	}
+	return a, nil
+}
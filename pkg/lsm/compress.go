@@ -0,0 +1,110 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// 一个简化的 Snappy 风格 LZ77 压缩：用哈希表记住每个 4 字节序列最近出现的位置，
+// 找到重复时输出“回拷”，否则输出字面量。压缩后的数据由两种操作组成：
+//
+//	字面量: 0x00 | 长度(uvarint) | 原始字节
+//	回拷:   0x01 | 距离(uvarint) | 长度(uvarint)
+//
+// 开头是解压后的总长度（uvarint）。SSTable 的数据块在压缩后更短时才使用压缩结果。
+
+const (
+	opLiteral = 0
+	opCopy    = 1
+
+	minMatch  = 4
+	hashBits  = 14
+	maxOffset = 1 << 16
+)
+
+var errCorrupt = errors.New("lsm: corrupt compressed block")
+
+func hash4(b []byte) uint32 {
+	return (binary.LittleEndian.Uint32(b) * 0x1e35a7bd) >> (32 - hashBits)
+}
+
+// compress 返回 src 的压缩结果
+func compress(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	var table [1 << hashBits]int32 // 位置 + 1，0 表示空
+
+	lit := 0 // 尚未输出的字面量的起点
+	emitLiteral := func(end int) {
+		if end > lit {
+			dst = append(dst, opLiteral)
+			dst = binary.AppendUvarint(dst, uint64(end-lit))
+			dst = append(dst, src[lit:end]...)
+		}
+	}
+
+	for i := 0; i+minMatch <= len(src); {
+		h := hash4(src[i:])
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > maxOffset || binary.LittleEndian.Uint32(src[cand:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+		n := minMatch
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		emitLiteral(i)
+		dst = append(dst, opCopy)
+		dst = binary.AppendUvarint(dst, uint64(i-cand))
+		dst = binary.AppendUvarint(dst, uint64(n))
+		i += n
+		lit = i
+	}
+	emitLiteral(len(src))
+	return dst
+}
+
+// decompress 还原 compress 的输出
+func decompress(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errCorrupt
+	}
+	src = src[n:]
+	dst := make([]byte, 0, size)
+	for len(src) > 0 {
+		op := src[0]
+		src = src[1:]
+		a, n := binary.Uvarint(src)
+		if n <= 0 {
+			return nil, errCorrupt
+		}
+		src = src[n:]
+		switch op {
+		case opLiteral:
+			if uint64(len(src)) < a {
+				return nil, errCorrupt
+			}
+			dst = append(dst, src[:a]...)
+			src = src[a:]
+		case opCopy:
+			length, n := binary.Uvarint(src)
+			if n <= 0 || a == 0 || a > uint64(len(dst)) {
+				return nil, errCorrupt
+			}
+			src = src[n:]
+			// 回拷可能与自身重叠（例如连续重复的字节），只能逐字节复制
+			start := len(dst) - int(a)
+			for i := 0; i < int(length); i++ {
+				dst = append(dst, dst[start+i])
+			}
+		default:
+			return nil, errCorrupt
+		}
+	}
+	if uint64(len(dst)) != size {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
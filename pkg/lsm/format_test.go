@@ -0,0 +1,115 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+// FuzzDecodeEntry 从任意字节中连续解码记录：被破坏的数据块必须返回 ErrCorruptTable 而不是越界 panic，
+// 解码出的记录重新编码后必须得到同样的字节
+func FuzzDecodeEntry(f *testing.F) {
+	var b []byte
+	b = appendEntry(b, Entry{Key: []byte("a"), Value: []byte("1")})
+	b = appendEntry(b, Entry{Key: []byte("b"), Deleted: true})
+	b = appendEntry(b, Entry{Key: []byte("c"), Value: valuePointer{file: 3, offset: 10, length: 20}.encode(), Pointer: true})
+	f.Add(b)
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for rest := data; len(rest) > 0; {
+			e, next, err := decodeEntry(rest)
+			if err != nil {
+				return
+			}
+			if enc := appendEntry(nil, e); !bytes.Equal(enc, rest[:len(rest)-len(next)]) && canonical(rest[:len(rest)-len(next)]) {
+				t.Fatalf("round trip changed %x to %x", rest[:len(rest)-len(next)], enc)
+			}
+			rest = next
+		}
+	})
+}
+
+// canonical 报告 b 中的 uvarint 是否都是最短编码（非最短编码同样能解码，但重新编码后字节不同）
+func canonical(b []byte) bool {
+	for k := 0; k < 2; k++ {
+		v, n := binary.Uvarint(b)
+		if n != len(binary.AppendUvarint(nil, v)) {
+			return false
+		}
+		skip := v
+		if k == 1 {
+			skip = v >> 2
+		}
+		b = b[n+int(skip):]
+	}
+	return true
+}
+
+// FuzzDecodeVlogRecord 解码任意的值日志记录：长度或 crc 不符时必须返回错误
+func FuzzDecodeVlogRecord(f *testing.F) {
+	rec := binary.AppendUvarint(make([]byte, vlogHeaderSize), 3)
+	rec = append(rec, "keyvalue"...)
+	binary.LittleEndian.PutUint32(rec[4:], uint32(len(rec)-vlogHeaderSize))
+	binary.LittleEndian.PutUint32(rec[0:], crc32.ChecksumIEEE(rec[4:]))
+	f.Add(rec)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		key, value, err := decodeVlogRecord(data)
+		if err != nil {
+			return
+		}
+		if len(key)+len(value) > len(data) {
+			t.Fatalf("decoded %d+%d bytes from a %d-byte record", len(key), len(value), len(data))
+		}
+	})
+}
+
+// FuzzDecodePointer 解码任意的值指针，能解码的指针重新编码后必须得到同样的指针
+func FuzzDecodePointer(f *testing.F) {
+	f.Add(valuePointer{file: 7, offset: 4096, length: 100}.encode())
+	f.Add([]byte{0x80})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := decodePointer(data)
+		if err != nil {
+			return
+		}
+		q, err := decodePointer(p.encode())
+		if err != nil || q != p {
+			t.Fatalf("round trip changed %+v to %+v (%v)", p, q, err)
+		}
+	})
+}
+
+// FuzzParseManifest 解析任意的 MANIFEST 内容：要么返回错误，要么每一项的层号都在范围内、表编号各不相同，
+// 而且按 writeManifest 的格式写回后能解析出同样的结果
+func FuzzParseManifest(f *testing.F) {
+	f.Add([]byte("0 1\n0 2\n1 3\n"))
+	f.Add([]byte("-1 5\n"))
+	f.Add([]byte("1000000000 5\n"))
+	f.Add([]byte("0 7\n2 7\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entries, err := parseManifest(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		var out bytes.Buffer
+		seen := make(map[uint64]bool)
+		for _, e := range entries {
+			if e.level < 0 || e.level >= maxLevels || seen[e.num] {
+				t.Fatalf("accepted entry %+v", e)
+			}
+			seen[e.num] = true
+			fmt.Fprintf(&out, "%d %d\n", e.level, e.num)
+		}
+		again, err := parseManifest(&out)
+		if err != nil || !reflect.DeepEqual(again, entries) {
+			t.Fatalf("rewritten manifest parsed as %v, %v; want %v", again, err, entries)
+		}
+	})
+}
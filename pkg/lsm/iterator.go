@@ -0,0 +1,221 @@
+package lsm
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"sort"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// iterator 按键升序遍历一组记录，用法与 bufio.Scanner 相同：
+//
+//	for it.Next() { e := it.Entry() ... }
+//	if err := it.Err(); err != nil { ... }
+type iterator interface {
+	Next() bool
+	Entry() Entry
+	Err() error
+}
+
+// 记录标志，和值长度一起编码在 tag 的低两位
+const (
+	entryDeleted = 1 << 0
+	entryPointer = 1 << 1
+)
+
+// appendEntry 把一条记录编码到 b 后面：键长度(uvarint) | 键 | 值长度<<2|标志(uvarint) | 值
+func appendEntry(b []byte, e Entry) []byte {
+	tag := uint64(len(e.Value)) << 2
+	if e.Deleted {
+		tag |= entryDeleted
+	}
+	if e.Pointer {
+		tag |= entryPointer
+	}
+	b = binary.AppendUvarint(b, uint64(len(e.Key)))
+	b = append(b, e.Key...)
+	b = binary.AppendUvarint(b, tag)
+	return append(b, e.Value...)
+}
+
+// decodeEntry 从 b 中解码一条记录，返回剩余的字节。记录的键和值直接引用 b，不做复制
+func decodeEntry(b []byte) (Entry, []byte, error) {
+	klen, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < klen {
+		return Entry{}, nil, ErrCorruptTable
+	}
+	key := b[n : n+int(klen) : n+int(klen)]
+	b = b[n+int(klen):]
+	tag, n := binary.Uvarint(b)
+	vlen := tag >> 2
+	if n <= 0 || uint64(len(b)-n) < vlen {
+		return Entry{}, nil, ErrCorruptTable
+	}
+	b = b[n:]
+	e := Entry{
+		Key:     key,
+		Value:   b[:vlen:vlen],
+		Deleted: tag&entryDeleted != 0,
+		Pointer: tag&entryPointer != 0,
+	}
+	return e, b[vlen:], nil
+}
+
+// tableIter 顺序读取一个 SSTable 的数据块
+type tableIter struct {
+	t     *Table
+	block int    // 下一个要读取的块
+	buf   []byte // 当前块中尚未解码的部分
+	cur   Entry
+	err   error
+}
+
+func (t *Table) iter() *tableIter { return &tableIter{t: t} }
+
+// iterFrom 从可能包含 key 的那个块开始遍历，跳过之前的块（该块中 key 之前的记录仍会输出）；
+// key 为 nil 时从第一个块开始
+func (t *Table) iterFrom(key []byte) *tableIter {
+	if key == nil {
+		return t.iter()
+	}
+	i := sort.Search(len(t.index), func(i int) bool { return t.cmp(t.index[i].firstKey, key) > 0 }) - 1
+	return &tableIter{t: t, block: max(i, 0)}
+}
+
+func (it *tableIter) Next() bool {
+	for len(it.buf) == 0 {
+		if it.err != nil || it.block >= len(it.t.index) {
+			return false
+		}
+		it.buf, it.err = it.t.readBlock(it.block)
+		it.block++
+	}
+	it.cur, it.buf, it.err = decodeEntry(it.buf)
+	return it.err == nil
+}
+
+func (it *tableIter) Entry() Entry { return it.cur }
+func (it *tableIter) Err() error   { return it.err }
+
+// concatIter 依次遍历多个互不重叠、按键排好序的 SSTable（leveled 策略中的一层），
+// 每个表都从可能包含 start 的块开始读
+type concatIter struct {
+	tables []*Table
+	start  []byte
+	cur    *tableIter
+	err    error
+}
+
+func newConcatIter(tables []*Table, start []byte) *concatIter {
+	return &concatIter{tables: tables, start: start}
+}
+
+func (it *concatIter) Next() bool {
+	for {
+		if it.cur != nil && it.cur.Next() {
+			return true
+		}
+		if it.cur != nil && it.cur.Err() != nil {
+			it.err = it.cur.Err()
+			return false
+		}
+		if len(it.tables) == 0 {
+			return false
+		}
+		it.cur, it.tables = it.tables[0].iterFrom(it.start), it.tables[1:]
+	}
+}
+
+func (it *concatIter) Entry() Entry { return it.cur.Entry() }
+func (it *concatIter) Err() error   { return it.err }
+
+// mergingIter 对多个有序的输入做 k 路归并。sources 按从新到旧排列，
+// 同一个键只输出最新来源中的版本，旧版本被跳过（被遮蔽）。
+// skipTombstones 为 true 时删除标记本身也不输出。
+type mergingIter struct {
+	h              mergeHeap
+	skipTombstones bool
+	started        bool
+	cur            Entry
+	err            error
+}
+
+func newMergingIter(cmp kv.Comparator, sources []iterator, skipTombstones bool) *mergingIter {
+	m := &mergingIter{h: mergeHeap{cmp: cmp}, skipTombstones: skipTombstones}
+	for i, src := range sources {
+		m.h.srcs = append(m.h.srcs, &mergeSource{it: src, rank: i})
+	}
+	return m
+}
+
+type mergeSource struct {
+	it   iterator
+	rank int // 越小越新
+}
+
+type mergeHeap struct {
+	srcs []*mergeSource
+	cmp  kv.Comparator
+}
+
+func (h *mergeHeap) Len() int { return len(h.srcs) }
+func (h *mergeHeap) Less(i, j int) bool {
+	c := h.cmp(h.srcs[i].it.Entry().Key, h.srcs[j].it.Entry().Key)
+	return c < 0 || (c == 0 && h.srcs[i].rank < h.srcs[j].rank)
+}
+func (h *mergeHeap) Swap(i, j int) { h.srcs[i], h.srcs[j] = h.srcs[j], h.srcs[i] }
+func (h *mergeHeap) Push(x any)    { h.srcs = append(h.srcs, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() any {
+	x := h.srcs[len(h.srcs)-1]
+	h.srcs = h.srcs[:len(h.srcs)-1]
+	return x
+}
+
+// top 返回当前键最小（同键时最新）的输入
+func (h *mergeHeap) top() *mergeSource { return h.srcs[0] }
+
+// advance 把 src 移到下一条记录，读完时把它从堆中移除
+func (m *mergingIter) advance(src *mergeSource) {
+	if src.it.Next() {
+		heap.Fix(&m.h, 0)
+		return
+	}
+	if err := src.it.Err(); err != nil && m.err == nil {
+		m.err = err
+	}
+	heap.Pop(&m.h)
+}
+
+func (m *mergingIter) Next() bool {
+	if !m.started {
+		// 第一次调用时把每个输入定位到第一条记录
+		sources := m.h.srcs
+		m.h.srcs = nil
+		for _, src := range sources {
+			if src.it.Next() {
+				m.h.srcs = append(m.h.srcs, src)
+			} else if err := src.it.Err(); err != nil && m.err == nil {
+				m.err = err
+			}
+		}
+		heap.Init(&m.h)
+		m.started = true
+	}
+	for m.err == nil && m.h.Len() > 0 {
+		m.cur = m.h.top().it.Entry()
+		m.advance(m.h.top())
+		// 跳过更旧的来源中同一个键的版本
+		for m.h.Len() > 0 && m.h.cmp(m.h.top().it.Entry().Key, m.cur.Key) == 0 {
+			m.advance(m.h.top())
+		}
+		if m.cur.Deleted && m.skipTombstones {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (m *mergingIter) Entry() Entry { return m.cur }
+func (m *mergingIter) Err() error   { return m.err }
@@ -0,0 +1,879 @@
+// Package lsm 实现了一个简化的 LSM-tree：写入先追加到预写日志（WAL）再进入内存表（MemTable，跳表），
+// 内存表写满后变为只读的不可变内存表，由后台协程按键排序刷成磁盘上的 SSTable 文件；
+// 另一个后台协程按所选策略（leveled / size-tiered）合并 SSTable。
+// 读取依次查内存表、不可变内存表和从新到旧的 SSTable。所有方法都可以被多个协程并发调用。
+// 键和值都是字节串，键按 Options.Comparator 排序；LSMTree 实现了 kv.KV。
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// Options 控制 LSM-tree 的行为，零值字段使用 DefaultOptions 中的默认值
+type Options struct {
+	// Comparator 决定键的顺序（默认按字节序）。SSTable 按它排序写入，重新打开时必须使用同一个比较器
+	Comparator kv.Comparator
+
+	MemTableSize int  // 内存表中的条目数达到该值时刷盘
+	BlockSize    int  // SSTable 数据块的目标大小（字节）
+	Compression  bool // 是否压缩数据块
+	SyncWrites   bool // 每次写入 WAL 后是否 fsync（持久化模式）
+	// BloomBitsPerKey 是每个 SSTable 的布隆过滤器为每个键分配的位数，小于 0 时不生成过滤器
+	BloomBitsPerKey int
+
+	Compaction          CompactionStrategy
+	L0CompactionTrigger int   // leveled：L0 的表数达到该值时合并到 L1
+	BaseLevelBytes      int64 // leveled：L1 的容量
+	LevelSizeRatio      int   // leveled：相邻两层的容量之比
+	TargetFileSize      int64 // leveled：合并输出的单个 SSTable 的目标大小
+	TierMinMerge        int   // size-tiered：凑够多少个大小相近的段才合并
+
+	// 写入限流：不可变内存表排队达到 MaxImmutableMemTables 个时，写满内存表的写入等待后台刷盘；
+	// leveled 策略中 L0 的表数达到 L0SlowdownTrigger 时每次写入先让出 1ms，
+	// 达到 L0StopTrigger 时写入暂停，直到合并把 L0 降下来
+	MaxImmutableMemTables int
+	L0SlowdownTrigger     int
+	L0StopTrigger         int
+
+	// 键值分离（见 vlog.go）：长度达到 ValueThreshold 的值写入值日志，SSTable 中只保存指针；
+	// 0 表示不分离。ValueLogFileSize 是单个值日志段的目标大小
+	ValueThreshold   int
+	ValueLogFileSize int64
+}
+
+// DefaultOptions 返回默认配置
+func DefaultOptions() Options {
+	return Options{
+		Comparator:          kv.Bytewise,
+		MemTableSize:        1024,
+		BlockSize:           4096,
+		BloomBitsPerKey:     10,
+		Compaction:          CompactionLeveled,
+		L0CompactionTrigger: 4,
+		BaseLevelBytes:      1 << 20,
+		LevelSizeRatio:      10,
+		TargetFileSize:      256 << 10,
+		TierMinMerge:        4,
+
+		MaxImmutableMemTables: 2,
+		L0SlowdownTrigger:     8,
+		L0StopTrigger:         12,
+
+		ValueLogFileSize: 4 << 20,
+	}
+}
+
+func (o Options) withDefaults() Options {
+	d := DefaultOptions()
+	if o.Comparator == nil {
+		o.Comparator = d.Comparator
+	}
+	if o.MemTableSize <= 0 {
+		o.MemTableSize = d.MemTableSize
+	}
+	if o.BlockSize <= 0 {
+		o.BlockSize = d.BlockSize
+	}
+	if o.BloomBitsPerKey == 0 {
+		o.BloomBitsPerKey = d.BloomBitsPerKey
+	}
+	if o.L0CompactionTrigger <= 0 {
+		o.L0CompactionTrigger = d.L0CompactionTrigger
+	}
+	if o.BaseLevelBytes <= 0 {
+		o.BaseLevelBytes = d.BaseLevelBytes
+	}
+	if o.LevelSizeRatio <= 1 {
+		o.LevelSizeRatio = d.LevelSizeRatio
+	}
+	if o.TargetFileSize <= 0 {
+		o.TargetFileSize = d.TargetFileSize
+	}
+	if o.TierMinMerge <= 1 {
+		o.TierMinMerge = d.TierMinMerge
+	}
+	if o.MaxImmutableMemTables <= 0 {
+		o.MaxImmutableMemTables = d.MaxImmutableMemTables
+	}
+	if o.L0SlowdownTrigger <= 0 {
+		o.L0SlowdownTrigger = d.L0SlowdownTrigger
+	}
+	if o.L0StopTrigger <= 0 {
+		o.L0StopTrigger = d.L0StopTrigger
+	}
+	if o.ValueLogFileSize <= 0 {
+		o.ValueLogFileSize = d.ValueLogFileSize
+	}
+	// 限流阈值必须高于合并的触发点，否则写入会等待一个永远不会发生的合并
+	o.L0SlowdownTrigger = max(o.L0SlowdownTrigger, o.L0CompactionTrigger)
+	o.L0StopTrigger = max(o.L0StopTrigger, o.L0SlowdownTrigger+1)
+	return o
+}
+
+// LSMTree 是存放在目录 dir 中的 LSM-tree，每个 SSTable 是一个 <编号>.sst 文件，
+// 当前内存表的预写日志是 <编号>.log，值日志段是 <编号>.vlog，它们共用一个递增的文件编号；
+// 各层包含哪些 SSTable 记录在 MANIFEST 中。
+type LSMTree struct {
+	dir  string
+	opts Options
+	cmp  kv.Comparator // opts.Comparator
+
+	// writeMu 串行化写入：同一时间只有一个协程追加 WAL、写内存表、切换内存表。
+	// 写内存表不需要持有 mu，跳表允许读者并发读取。
+	writeMu sync.Mutex
+
+	// mu 保护下面的所有状态。读操作持有读锁直到读完 SSTable，
+	// 因此合并在持有写锁替换输入之后就可以安全地关闭并删除它们。
+	mu sync.RWMutex
+	// cond 在不可变内存表刷完、合并完成或后台出错时广播，唤醒后台刷盘协程和被限流的写入
+	cond     *sync.Cond
+	memTable *MemTable
+	// imm 是等待后台刷盘的不可变内存表，从旧到新排列
+	imm     []immutable
+	closing bool
+	// closed 在 Close 关闭所有文件之后置位，之后的操作都返回 ErrClosed
+	closed bool
+	// levels[0] 中的表从旧到新排列并且可能重叠（size-tiered 策略只使用 levels[0]）；
+	// leveled 策略中 levels[1..] 的每一层按最小键排序、互不重叠
+	levels         [][]*Table
+	compactPointer map[int][]byte // leveled：每层下一次从哪个键之后选表合并
+	nextFile       uint64
+
+	wal *walWriter
+	// memLogs 是内容在当前内存表中的旧日志（打开时重放的日志），随内存表一起交给刷盘协程
+	memLogs []string
+	vlog    *valueLog
+	gcMu    sync.Mutex // 同一时间只运行一次值日志回收
+
+	// 后台刷盘与合并
+	compactMu sync.Mutex // 同一时间只运行一个合并
+	compactCh chan struct{}
+	done      chan struct{}
+	bg        sync.WaitGroup
+	bgErr     error
+
+	stats stats
+}
+
+// immutable 是一个写满的内存表和记录了它的内容的日志，SSTable 持久化之后日志才能删除
+type immutable struct {
+	mem  *MemTable
+	logs []string
+}
+
+type stats struct {
+	flushes           int64
+	recovered         int64
+	compactions       int64
+	userBytes         int64
+	walBytes          int64
+	flushWritten      int64
+	compactionRead    int64
+	compactionWritten int64
+	writeStalls       int64
+	stallTime         time.Duration
+	slowdowns         int64
+	gcRuns            int64
+	gcRelocated       int64
+	blockReads        atomic.Int64
+	bytesRead         atomic.Int64
+	vlogWritten       atomic.Int64 // 在持有 writeMu（而不是 mu）时更新
+
+	// Get 并发地持有读锁，下面的计数器用原子操作更新
+	tableProbes    atomic.Int64
+	fenceSkips     atomic.Int64
+	bloomSkips     atomic.Int64
+	falsePositives atomic.Int64
+}
+
+// Stats 是 LSM-tree 的统计快照
+type Stats struct {
+	MemTableEntries   int
+	ImmutableTables   int // 等待刷盘的不可变内存表个数
+	Tables            int
+	Levels            []int // 每层的表数
+	TableBytes        int64
+	Flushes           int64
+	FlushWritten      int64 // 刷盘写出的字节数
+	WALBytes          int64 // 写入 WAL 的字节数
+	Recovered         int64 // 打开时从 WAL 重放的记录数
+	Compactions       int64
+	CompactionRead    int64         // 合并读入的字节数
+	CompactionWritten int64         // 合并写出的字节数
+	WriteStalls       int64         // 写入因不可变内存表排满或 L0 过多而暂停的次数
+	StallTime         time.Duration // 写入暂停的总时长
+	Slowdowns         int64         // 因 L0 接近上限而被放慢的写入次数
+	BlockReads        int64         // 读取的数据块数
+	BytesRead         int64         // 读取的数据块字节数
+	ValueLogFiles     int           // 值日志段数
+	ValueLogBytes     int64         // 值日志的总大小
+	ValueLogWritten   int64         // 写入值日志的字节数（包括回收时搬动的值）
+	ValueLogGCs       int64         // 值日志回收的次数
+	GCRelocated       int64         // 回收时搬到当前段的值的个数
+
+	// 点查时每个候选 SSTable（L0 的每个表，L1 之后每层二分查找出的那一个表）的去向：
+	// 键不在 [最小键, 最大键] 内（FenceSkips）、
+	// 被布隆过滤器排除（BloomSkips），或者真正读取数据块查找（TableProbes）。
+	// 过滤器判断“可能存在”但表中并没有这个键时计为一次误判（FalsePositives）。
+	TableProbes    int64
+	FenceSkips     int64
+	BloomSkips     int64
+	FalsePositives int64
+}
+
+// FalsePositiveRate 返回观测到的布隆过滤器误判率：
+// 在键确实不在表中的查询里，有多少比例没有被过滤器排除
+func (s Stats) FalsePositiveRate() float64 {
+	if n := s.BloomSkips + s.FalsePositives; n > 0 {
+		return float64(s.FalsePositives) / float64(n)
+	}
+	return 0
+}
+
+// Open 打开（或创建）目录 dir 中的 LSM-tree，加载已有的 SSTable 并重放 WAL
+func Open(dir string, opts Options) (*LSMTree, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+	lsm := &LSMTree{
+		dir:            dir,
+		opts:           opts,
+		cmp:            opts.Comparator,
+		memTable:       NewMemTable(opts.Comparator),
+		levels:         make([][]*Table, 1),
+		compactPointer: make(map[int][]byte),
+		nextFile:       1,
+		compactCh:      make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+	lsm.cond = sync.NewCond(&lsm.mu)
+	if err := lsm.load(); err != nil {
+		lsm.closeFiles()
+		return nil, err
+	}
+	lsm.bg.Add(2)
+	go lsm.flusher()
+	go lsm.compactor()
+	lsm.maybeScheduleCompaction()
+	return lsm, nil
+}
+
+// load 按 MANIFEST 加载 SSTable，按编号顺序重放剩下的日志，然后为内存表创建新的日志。
+// 在启动后台协程之前调用，不需要加锁。
+func (lsm *LSMTree) load() error {
+	manifest, err := readManifest(lsm.dir)
+	if err != nil {
+		return err
+	}
+	names, err := os.ReadDir(lsm.dir)
+	if err != nil {
+		return err
+	}
+	var logs, tables, vlogs []uint64
+	for _, e := range names {
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// 刷盘或合并时崩溃留下的半成品
+			os.Remove(filepath.Join(lsm.dir, name))
+			continue
+		}
+		num, ext, ok := parseFileName(name)
+		if !ok {
+			continue
+		}
+		lsm.nextFile = max(lsm.nextFile, num+1)
+		switch ext {
+		case ".log":
+			logs = append(logs, num)
+		case ".vlog":
+			vlogs = append(vlogs, num)
+		default:
+			tables = append(tables, num)
+		}
+	}
+
+	if manifest == nil {
+		// 没有 MANIFEST（旧版本创建的目录）：所有 SSTable 按编号放进 L0
+		sort.Slice(tables, func(i, j int) bool { return tables[i] < tables[j] })
+		for _, num := range tables {
+			manifest = append(manifest, manifestEntry{level: 0, num: num})
+		}
+	} else {
+		// 不在 MANIFEST 中的 SSTable 是没有完成的刷盘或合并的输出
+		live := make(map[uint64]bool)
+		for _, e := range manifest {
+			live[e.num] = true
+		}
+		for _, num := range tables {
+			if !live[num] {
+				os.Remove(lsm.tablePath(num))
+			}
+		}
+	}
+	for _, e := range manifest {
+		t, err := openTable(lsm.tablePath(e.num), e.num, lsm.cmp, &lsm.stats)
+		if err != nil {
+			return err
+		}
+		for len(lsm.levels) <= e.level {
+			lsm.levels = append(lsm.levels, nil)
+		}
+		lsm.levels[e.level] = append(lsm.levels[e.level], t)
+	}
+	if lsm.opts.Compaction == CompactionSizeTiered {
+		lsm.flattenLevels()
+	}
+
+	// 打开已有的值日志段：SSTable 和要重放的日志中可能有指向它们的指针
+	if err := lsm.openValueLog(vlogs); err != nil {
+		return err
+	}
+
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	for _, num := range logs {
+		path := filepath.Join(lsm.dir, logName(num))
+		n, err := replayWAL(path, lsm.memTable.apply)
+		if err != nil {
+			return err
+		}
+		lsm.stats.recovered += int64(n)
+		if n == 0 {
+			os.Remove(path)
+			continue
+		}
+		lsm.memLogs = append(lsm.memLogs, path)
+	}
+
+	if err := lsm.newWAL(); err != nil {
+		return err
+	}
+	if lsm.memTable.Size() >= lsm.opts.MemTableSize {
+		return lsm.rotate()
+	}
+	return nil
+}
+
+// flattenLevels 把分层的表全部放进 levels[0]，从最深（最旧）的层开始排列，
+// 用于以 size-tiered 策略打开一个按 leveled 策略写成的目录
+func (lsm *LSMTree) flattenLevels() {
+	var runs []*Table
+	for level := len(lsm.levels) - 1; level >= 0; level-- {
+		runs = append(runs, lsm.levels[level]...)
+	}
+	lsm.levels = [][]*Table{runs}
+}
+
+func (lsm *LSMTree) newWAL() error {
+	w, err := createWAL(filepath.Join(lsm.dir, logName(lsm.allocFile())), lsm.opts.SyncWrites)
+	if err != nil {
+		return err
+	}
+	lsm.wal = w
+	return nil
+}
+
+// allocFile 分配一个新的文件编号，调用方持有 mu（或处于初始化阶段）
+func (lsm *LSMTree) allocFile() uint64 {
+	num := lsm.nextFile
+	lsm.nextFile++
+	return num
+}
+
+func (lsm *LSMTree) tablePath(num uint64) string { return filepath.Join(lsm.dir, tableName(num)) }
+
+func tableName(num uint64) string { return fmt.Sprintf("%06d.sst", num) }
+func logName(num uint64) string   { return fmt.Sprintf("%06d.log", num) }
+
+// parseFileName 解析 <编号>.sst、<编号>.log 和 <编号>.vlog
+func parseFileName(name string) (uint64, string, bool) {
+	ext := filepath.Ext(name)
+	if ext != ".sst" && ext != ".log" && ext != ".vlog" {
+		return 0, "", false
+	}
+	num, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+	return num, ext, err == nil
+}
+
+// entrySize 返回一条记录编码后的字节数
+func entrySize(e Entry) int {
+	return encodedSize(len(e.Key), len(e.Value))
+}
+
+// encodedSize 返回键长 klen、值长 vlen 的记录编码后的字节数（见 appendEntry）
+func encodedSize(klen, vlen int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(klen)) + klen + binary.PutUvarint(buf[:], uint64(vlen)<<2) + vlen
+}
+
+var _ kv.KV = (*LSMTree)(nil)
+
+// ErrClosed 表示树已经关闭
+var ErrClosed = errors.New("lsm: tree is closed")
+
+// Put 先把记录追加到 WAL，再写入内存表；内存表写满时刷成新的 SSTable。
+// 键和值被复制进内存表，调用方之后可以复用自己的缓冲区
+func (lsm *LSMTree) Put(key, value []byte) error {
+	buf := make([]byte, len(key)+len(value))
+	n := copy(buf, key)
+	copy(buf[n:], value)
+	return lsm.write(Entry{Key: buf[:n:n], Value: buf[n:]})
+}
+
+// Delete 写入一个删除标记（墓碑）。它和普通写入一样经过 WAL、内存表并刷成 SSTable，
+// 读取时遮蔽更旧的 SSTable 中的同一个键；合并到没有更深层数据可遮蔽时才被丢弃
+func (lsm *LSMTree) Delete(key []byte) error {
+	return lsm.write(Entry{Key: append([]byte{}, key...), Deleted: true})
+}
+
+func (lsm *LSMTree) write(e Entry) error {
+	lsm.writeMu.Lock()
+	defer lsm.writeMu.Unlock()
+	return lsm.writeLocked(e, true)
+}
+
+// writeLocked 执行一次写入：大的值先写入值日志，再把（可能替换成指针的）记录追加到 WAL 并写入内存表。
+// user 为 false 时是值日志回收搬动的值，不计入用户写入量。调用方持有 writeMu
+func (lsm *LSMTree) writeLocked(e Entry, user bool) error {
+	lsm.mu.Lock()
+	var err error
+	if lsm.closed {
+		err = ErrClosed
+	} else {
+		err = lsm.throttle()
+	}
+	lsm.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	stored, err := lsm.separateValue(e)
+	if err != nil {
+		return err
+	}
+	if err := lsm.wal.append(stored); err != nil {
+		return err
+	}
+	lsm.memTable.apply(stored)
+
+	lsm.mu.Lock()
+	defer lsm.mu.Unlock()
+	if user {
+		lsm.stats.userBytes += int64(entrySize(e))
+	}
+	lsm.stats.walBytes += int64(entrySize(stored) + walHeaderSize)
+	if lsm.memTable.Size() >= lsm.opts.MemTableSize {
+		return lsm.rotate()
+	}
+	return nil
+}
+
+// throttle 在写入之前检查 L0 的表数（仅 leveled 策略）：接近上限时放慢一次，
+// 达到上限时等待合并。调用方持有 writeMu 和 mu
+func (lsm *LSMTree) throttle() error {
+	if lsm.opts.Compaction != CompactionLeveled || lsm.bgErr != nil {
+		return lsm.bgErr
+	}
+	if len(lsm.levels[0]) >= lsm.opts.L0SlowdownTrigger && len(lsm.levels[0]) < lsm.opts.L0StopTrigger {
+		// 每次写入让出一点时间给合并，把一次长时间的停顿摊成许多次短暂的延迟
+		lsm.stats.slowdowns++
+		lsm.mu.Unlock()
+		time.Sleep(time.Millisecond)
+		lsm.mu.Lock()
+	}
+	return lsm.stallWhile(func() bool { return len(lsm.levels[0]) >= lsm.opts.L0StopTrigger })
+}
+
+// stallWhile 在 cond 上等待直到 blocked 返回 false 或后台出错，并记录暂停的次数和时长。调用方持有 mu
+func (lsm *LSMTree) stallWhile(blocked func() bool) error {
+	if lsm.bgErr != nil || !blocked() {
+		return lsm.bgErr
+	}
+	start := time.Now()
+	lsm.stats.writeStalls++
+	for lsm.bgErr == nil && blocked() {
+		lsm.cond.Wait()
+	}
+	lsm.stats.stallTime += time.Since(start)
+	return lsm.bgErr
+}
+
+// rotate 把当前内存表连同它的日志放进不可变队列交给后台刷盘，换上新的内存表和日志。
+// 队列已满时先等待刷盘。调用方持有 writeMu 和 mu（打开时除外）
+func (lsm *LSMTree) rotate() error {
+	if err := lsm.stallWhile(func() bool { return len(lsm.imm) >= lsm.opts.MaxImmutableMemTables }); err != nil {
+		return err
+	}
+	// 内存表中的指针刷进 SSTable 后日志就会被删除，在此之前值日志中对应的值必须已经落盘
+	if lsm.vlog.active != nil && !lsm.opts.SyncWrites {
+		if err := lsm.vlog.active.file.Sync(); err != nil {
+			return err
+		}
+	}
+	oldWAL := lsm.wal
+	if err := lsm.newWAL(); err != nil {
+		return err
+	}
+	if err := oldWAL.close(); err != nil {
+		return err
+	}
+	lsm.imm = append(lsm.imm, immutable{mem: lsm.memTable, logs: append(lsm.memLogs, oldWAL.path)})
+	lsm.memTable = NewMemTable(lsm.cmp)
+	lsm.memLogs = nil
+	lsm.cond.Broadcast()
+	return nil
+}
+
+// Flush 把当前内存表交给后台刷盘，并等待所有不可变内存表都写成 SSTable
+func (lsm *LSMTree) Flush() error {
+	lsm.writeMu.Lock()
+	defer lsm.writeMu.Unlock()
+	lsm.mu.Lock()
+	defer lsm.mu.Unlock()
+	if lsm.closed {
+		return ErrClosed
+	}
+	if lsm.memTable.Size() > 0 {
+		if err := lsm.rotate(); err != nil {
+			return err
+		}
+	}
+	for len(lsm.imm) > 0 && lsm.bgErr == nil {
+		lsm.cond.Wait()
+	}
+	return lsm.bgErr
+}
+
+// flusher 是后台刷盘协程：按从旧到新的顺序把不可变内存表写成 L0 中的 SSTable。
+// 关闭时先把队列刷完再退出。
+func (lsm *LSMTree) flusher() {
+	defer lsm.bg.Done()
+	lsm.mu.Lock()
+	defer lsm.mu.Unlock()
+	for {
+		for len(lsm.imm) == 0 && !lsm.closing {
+			lsm.cond.Wait()
+		}
+		if len(lsm.imm) == 0 {
+			return
+		}
+		imm := lsm.imm[0]
+		lsm.mu.Unlock()
+		err := lsm.flushMemTable(imm)
+		lsm.mu.Lock()
+		if err != nil {
+			if lsm.bgErr == nil {
+				lsm.bgErr = err
+			}
+			lsm.cond.Broadcast()
+			return
+		}
+	}
+}
+
+// flushMemTable 把队首的不可变内存表写成 L0 中的一个新 SSTable，调用时不持有 mu。
+// 顺序：写 SSTable 并 fsync、rename → 更新 MANIFEST → 删除它的日志，
+// 任何一步崩溃，重新打开时日志都还在，可以重放出同样的数据。
+func (lsm *LSMTree) flushMemTable(imm immutable) error {
+	lsm.mu.Lock()
+	num := lsm.allocFile()
+	lsm.mu.Unlock()
+
+	path := lsm.tablePath(num)
+	tw, err := newTableWriter(path, lsm.opts.BlockSize, lsm.opts.Compression, lsm.opts.BloomBitsPerKey)
+	if err != nil {
+		return err
+	}
+	for _, e := range imm.mem.Entries() {
+		if err := tw.add(e); err != nil {
+			tw.abort()
+			return err
+		}
+	}
+	if err := tw.finish(); err != nil {
+		return err
+	}
+	t, err := openTable(path, num, lsm.cmp, &lsm.stats)
+	if err != nil {
+		return err
+	}
+
+	lsm.mu.Lock()
+	defer lsm.mu.Unlock()
+	lsm.levels[0] = append(lsm.levels[0], t)
+	if err := writeManifest(lsm.dir, lsm.levels); err != nil {
+		lsm.levels[0] = lsm.levels[0][:len(lsm.levels[0])-1]
+		t.Close()
+		return err
+	}
+	lsm.imm = lsm.imm[1:]
+	lsm.stats.flushes++
+	lsm.stats.flushWritten += t.size
+	lsm.cond.Broadcast()
+	lsm.maybeScheduleCompaction()
+
+	for _, path := range imm.logs {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maybeScheduleCompaction 唤醒后台合并协程（不会阻塞）
+func (lsm *LSMTree) maybeScheduleCompaction() {
+	if lsm.opts.Compaction == CompactionNone {
+		return
+	}
+	select {
+	case lsm.compactCh <- struct{}{}:
+	default:
+	}
+}
+
+// Get 依次查内存表、从新到旧的不可变内存表和 SSTable，遇到的第一个版本是删除标记时返回未找到；
+// 值在值日志中时再读一次值日志。返回的切片不能修改
+func (lsm *LSMTree) Get(key []byte) ([]byte, bool, error) {
+	lsm.mu.RLock()
+	defer lsm.mu.RUnlock()
+	if lsm.closed {
+		return nil, false, ErrClosed
+	}
+
+	e, ok, err := lsm.lookup(key)
+	if err != nil || !ok || e.Deleted {
+		return nil, false, err
+	}
+	if e, err = lsm.resolve(e); err != nil {
+		return nil, false, err
+	}
+	return e.Value, true, nil
+}
+
+// lookup 返回 key 最新的记录（可能是删除标记或指针），调用方持有 mu（读锁即可）
+func (lsm *LSMTree) lookup(key []byte) (Entry, bool, error) {
+	e, ok := lsm.memTable.lookup(key)
+	for i := len(lsm.imm) - 1; i >= 0 && !ok; i-- {
+		e, ok = lsm.imm[i].mem.lookup(key)
+	}
+	if ok {
+		return e, true, nil
+	}
+	return lsm.getFromTables(key)
+}
+
+// getFromTables 按从新到旧的顺序在 SSTable 中查找 key，返回找到的第一个版本（可能是删除标记）
+func (lsm *LSMTree) getFromTables(key []byte) (Entry, bool, error) {
+	l0 := lsm.levels[0]
+	for i := len(l0) - 1; i >= 0; i-- {
+		if e, ok, err := lsm.probe(l0[i], key); err != nil || ok {
+			return e, ok, err
+		}
+	}
+	for _, tables := range lsm.levels[1:] {
+		// 同一层的表互不重叠，最多只有一个表可能包含 key
+		i := sort.Search(len(tables), func(i int) bool { return lsm.cmp(tables[i].maxKey, key) >= 0 })
+		if i == len(tables) {
+			lsm.stats.fenceSkips.Add(1) // 整层都被最大键排除
+			continue
+		}
+		if e, ok, err := lsm.probe(tables[i], key); err != nil || ok {
+			return e, ok, err
+		}
+	}
+	return Entry{}, false, nil
+}
+
+// probe 依次用最小/最大键和布隆过滤器排除表 t，排除不了时才读取数据块，并记录每一步的计数
+func (lsm *LSMTree) probe(t *Table, key []byte) (Entry, bool, error) {
+	if lsm.cmp(key, t.minKey) < 0 || lsm.cmp(key, t.maxKey) > 0 {
+		lsm.stats.fenceSkips.Add(1)
+		return Entry{}, false, nil
+	}
+	if !t.filter.mayContain(key) {
+		lsm.stats.bloomSkips.Add(1)
+		return Entry{}, false, nil
+	}
+	lsm.stats.tableProbes.Add(1)
+	e, ok, err := t.get(key)
+	if err == nil && !ok && t.filter != nil {
+		lsm.stats.falsePositives.Add(1)
+	}
+	return e, ok, err
+}
+
+// Scan 按键的顺序遍历 [start, end) 内的键值，fn 返回 false 时停止；start、end 为 nil 表示不限。
+// 内存表和所有与范围重叠的 SSTable 各提供一个有序输入，经 k 路归并后每个键只输出最新的版本，
+// 最新版本是删除标记的键被跳过。遍历期间持有读锁，fn 中不能写入这棵树，也不能修改拿到的切片。
+func (lsm *LSMTree) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	lsm.mu.RLock()
+	defer lsm.mu.RUnlock()
+	if lsm.closed {
+		return ErrClosed
+	}
+
+	// 输入按从新到旧排列：内存表、不可变内存表、L0 从新到旧、L1、L2……
+	sources := []iterator{lsm.memTable.iter(start)}
+	for i := len(lsm.imm) - 1; i >= 0; i-- {
+		sources = append(sources, lsm.imm[i].mem.iter(start))
+	}
+	l0 := overlapping(lsm.cmp, lsm.levels[0], start, end)
+	for i := len(l0) - 1; i >= 0; i-- {
+		sources = append(sources, l0[i].iterFrom(start))
+	}
+	for _, tables := range lsm.levels[1:] {
+		if tables = overlapping(lsm.cmp, tables, start, end); len(tables) > 0 {
+			sources = append(sources, newConcatIter(tables, start))
+		}
+	}
+	it := newMergingIter(lsm.cmp, sources, true)
+	for it.Next() {
+		e := it.Entry()
+		if start != nil && lsm.cmp(e.Key, start) < 0 {
+			continue
+		}
+		if end != nil && lsm.cmp(e.Key, end) >= 0 {
+			return nil
+		}
+		e, err := lsm.resolve(e)
+		if err != nil {
+			return err
+		}
+		if !fn(e.Key, e.Value) {
+			return nil
+		}
+	}
+	return it.Err()
+}
+
+// Tables 返回所有 SSTable 的元数据，按层排列，L0 中从旧到新
+func (lsm *LSMTree) Tables() []TableInfo {
+	lsm.mu.RLock()
+	defer lsm.mu.RUnlock()
+	var infos []TableInfo
+	for level, tables := range lsm.levels {
+		for _, t := range tables {
+			info := t.Info()
+			info.Level = level
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// Stats 返回统计快照。关闭之后仍然可以调用，此时只包含累计的计数器，
+// 表和值日志相关的字段为零
+func (lsm *LSMTree) Stats() Stats {
+	lsm.mu.RLock()
+	defer lsm.mu.RUnlock()
+	s := Stats{
+		MemTableEntries:   lsm.memTable.Size(),
+		ImmutableTables:   len(lsm.imm),
+		Flushes:           lsm.stats.flushes,
+		FlushWritten:      lsm.stats.flushWritten,
+		WALBytes:          lsm.stats.walBytes,
+		Recovered:         lsm.stats.recovered,
+		Compactions:       lsm.stats.compactions,
+		CompactionRead:    lsm.stats.compactionRead,
+		CompactionWritten: lsm.stats.compactionWritten,
+		WriteStalls:       lsm.stats.writeStalls,
+		StallTime:         lsm.stats.stallTime,
+		Slowdowns:         lsm.stats.slowdowns,
+		BlockReads:        lsm.stats.blockReads.Load(),
+		BytesRead:         lsm.stats.bytesRead.Load(),
+		TableProbes:       lsm.stats.tableProbes.Load(),
+		FenceSkips:        lsm.stats.fenceSkips.Load(),
+		BloomSkips:        lsm.stats.bloomSkips.Load(),
+		FalsePositives:    lsm.stats.falsePositives.Load(),
+		ValueLogWritten:   lsm.stats.vlogWritten.Load(),
+		ValueLogGCs:       lsm.stats.gcRuns,
+		GCRelocated:       lsm.stats.gcRelocated,
+	}
+	if lsm.closed {
+		// 关闭后只剩累计的计数器，SSTable 和值日志都已关闭
+		return s
+	}
+	s.ValueLogFiles = len(lsm.vlog.segments)
+	s.ValueLogBytes = lsm.vlog.totalSize()
+	last := 0
+	for level, tables := range lsm.levels {
+		if len(tables) > 0 {
+			last = level
+		}
+	}
+	for _, tables := range lsm.levels[:last+1] {
+		s.Levels = append(s.Levels, len(tables))
+		s.Tables += len(tables)
+		s.TableBytes += levelBytes(tables)
+	}
+	return s
+}
+
+// Close 把内存表交给后台刷盘，等待刷盘协程清空队列、合并协程退出，然后关闭日志和所有 SSTable。
+// 之后除 Stats 和 Tables 外的操作都返回 ErrClosed，重复关闭也返回 ErrClosed
+func (lsm *LSMTree) Close() error {
+	lsm.writeMu.Lock()
+	defer lsm.writeMu.Unlock()
+
+	lsm.mu.Lock()
+	if lsm.closed {
+		lsm.mu.Unlock()
+		return ErrClosed
+	}
+	var err error
+	if lsm.memTable.Size() > 0 && lsm.bgErr == nil {
+		err = lsm.rotate()
+	}
+	lsm.closing = true
+	lsm.cond.Broadcast()
+	lsm.mu.Unlock()
+
+	close(lsm.done)
+	lsm.bg.Wait()
+
+	lsm.mu.Lock()
+	defer lsm.mu.Unlock()
+	if err == nil {
+		err = lsm.bgErr
+	}
+	if cerr := lsm.closeFiles(); err == nil {
+		err = cerr
+	}
+	lsm.closed = true
+	return err
+}
+
+func (lsm *LSMTree) closeFiles() error {
+	var err error
+	if lsm.wal != nil {
+		err = lsm.wal.close()
+		lsm.wal = nil
+	}
+	for _, tables := range lsm.levels {
+		for _, t := range tables {
+			if cerr := t.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	lsm.levels = nil
+	if lsm.vlog != nil {
+		if cerr := lsm.vlog.close(); err == nil {
+			err = cerr
+		}
+		lsm.vlog = nil
+	}
+	return err
+}
@@ -0,0 +1,274 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestClosed 检查关闭之后的操作返回 ErrClosed 而不是因为日志已关闭而 panic
+func TestClosed(t *testing.T) {
+	tree, err := Open(t.TempDir(), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.Put([]byte("k"), []byte("v2")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Put after Close: %v, want ErrClosed", err)
+	}
+	if err := tree.Delete([]byte("k")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Delete after Close: %v, want ErrClosed", err)
+	}
+	if _, _, err := tree.Get([]byte("k")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get after Close: %v, want ErrClosed", err)
+	}
+	if err := tree.Scan(nil, nil, func(k, v []byte) bool { return true }); !errors.Is(err, ErrClosed) {
+		t.Errorf("Scan after Close: %v, want ErrClosed", err)
+	}
+	if err := tree.Flush(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Flush after Close: %v, want ErrClosed", err)
+	}
+	if err := tree.Compact(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Compact after Close: %v, want ErrClosed", err)
+	}
+	if _, err := tree.GCValueLog(); !errors.Is(err, ErrClosed) {
+		t.Errorf("GCValueLog after Close: %v, want ErrClosed", err)
+	}
+	if _, err := tree.Amplification(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Amplification after Close: %v, want ErrClosed", err)
+	}
+	if err := tree.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close: %v, want ErrClosed", err)
+	}
+	if s := tree.Stats(); s.WALBytes == 0 {
+		t.Errorf("Stats after Close lost the counters: %+v", s)
+	}
+}
+
+// TestCorruptManifest 检查层号越界或表编号重复的 MANIFEST 让 Open 返回 ErrCorruptManifest，
+// 而不是 panic 或按损坏的内容分配层；失败的 Open 不能删掉任何 SSTable，恢复原来的 MANIFEST 后数据都还在
+func TestCorruptManifest(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, manifestName)
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var num uint64
+	if _, err := fmt.Sscanf(string(orig), "0 %d", &num); err != nil {
+		t.Fatalf("unexpected manifest %q: %v", orig, err)
+	}
+	for _, bad := range []string{
+		fmt.Sprintf("-1 %d\n", num),
+		fmt.Sprintf("%d %d\n", maxLevels, num),
+		fmt.Sprintf("1000000000 %d\n", num),
+		fmt.Sprintf("0 %d\n1 %d\n", num, num),
+		"0 x\n",
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if tree, err := Open(dir, DefaultOptions()); !errors.Is(err, ErrCorruptManifest) {
+			if err == nil {
+				tree.Close()
+			}
+			t.Fatalf("Open with manifest %q: %v, want ErrCorruptManifest", bad, err)
+		}
+	}
+
+	if err := os.WriteFile(path, orig, 0666); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if v, ok, err := tree.Get([]byte("k")); err != nil || !ok || string(v) != "v" {
+		t.Fatalf("Get after restoring the manifest = %q, %v, %v", v, ok, err)
+	}
+}
+
+// TestConcurrentReadWrite 让多个写者和读者与后台刷盘、合并、写入限流同时运行（配合 go test -race）：
+// 内存表和 L0 的上限都很小，还有一个协程不停地强制 Flush 和 Compact。每个写者独占一组键，
+// 按递增的版本号覆盖它们；读者对同一个键读到的版本不能倒退，也不能在读到过之后又找不到这个键
+// （刷盘或合并换表的瞬间最容易出这种错），Scan 返回的键必须有序。最后每个键都是写者最后写入的版本
+func TestConcurrentReadWrite(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MemTableSize = 16
+	opts.MaxImmutableMemTables = 1
+	opts.L0CompactionTrigger = 2
+	opts.L0SlowdownTrigger = 3
+	opts.L0StopTrigger = 4
+	opts.BaseLevelBytes = 16 << 10
+	opts.TargetFileSize = 4 << 10
+	opts.ValueThreshold = 48
+	tree, err := Open(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	const writers, readers, keys = 4, 4, 32
+	versions := 15
+	if testing.Short() {
+		versions = 5
+	}
+	key := func(w, k int) []byte { return []byte(fmt.Sprintf("w%d-k%03d", w, k)) }
+	value := func(v int) []byte {
+		// 一半的值超过 ValueThreshold，走值日志
+		return []byte(fmt.Sprintf("%06d", v) + strings.Repeat("x", v%2*64))
+	}
+	version := func(b []byte) (int, error) {
+		var v int
+		_, err := fmt.Sscanf(string(b[:min(len(b), 6)]), "%06d", &v)
+		return v, err
+	}
+
+	var writing, background sync.WaitGroup
+	errc := make(chan error, writers+readers+2)
+	done := make(chan struct{})
+	for w := 0; w < writers; w++ {
+		writing.Add(1)
+		go func(w int) {
+			defer writing.Done()
+			for v := 1; v <= versions; v++ {
+				for k := 0; k < keys; k++ {
+					if err := tree.Put(key(w, k), value(v)); err != nil {
+						errc <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		background.Add(1)
+		go func(seed int64) {
+			defer background.Done()
+			rng := rand.New(rand.NewSource(seed))
+			seen := make(map[string]int)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				k := key(rng.Intn(writers), rng.Intn(keys))
+				got, ok, err := tree.Get(k)
+				if err != nil {
+					errc <- err
+					return
+				}
+				v := 0
+				if ok {
+					if v, err = version(got); err != nil || !bytes.Equal(got, value(v)) {
+						errc <- fmt.Errorf("Get(%s) returned malformed value %q", k, got)
+						return
+					}
+				}
+				if prev := seen[string(k)]; v < prev {
+					errc <- fmt.Errorf("Get(%s) went back from version %d to %d (found=%v)", k, prev, v, ok)
+					return
+				}
+				seen[string(k)] = v
+				// 读者不停地抢锁会拖慢写者，在 -race 下尤其明显
+				time.Sleep(50 * time.Microsecond)
+			}
+		}(int64(r))
+	}
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			var prev []byte
+			if err := tree.Scan(nil, nil, func(k, v []byte) bool {
+				if prev != nil && bytes.Compare(prev, k) >= 0 {
+					err = fmt.Errorf("Scan returned %q after %q", k, prev)
+					return false
+				}
+				prev = append(prev[:0], k...)
+				return true
+			}); err != nil {
+				errc <- err
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	go func() {
+		defer background.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			op := tree.Flush
+			if i%3 == 2 {
+				op = tree.Compact
+			}
+			if err := op(); err != nil {
+				errc <- err
+				return
+			}
+			// 强制刷盘会持有写锁，连续调用会让写者饿死
+			time.Sleep(2 * time.Millisecond)
+		}
+	}()
+
+	writing.Wait()
+	close(done)
+	background.Wait()
+	close(errc)
+	for err := range errc {
+		t.Fatal(err)
+	}
+
+	for w := 0; w < writers; w++ {
+		for k := 0; k < keys; k++ {
+			got, ok, err := tree.Get(key(w, k))
+			if err != nil || !ok || !bytes.Equal(got, value(versions)) {
+				t.Fatalf("Get(%s) = %q, %v, %v; want %q", key(w, k), got, ok, err, value(versions))
+			}
+		}
+	}
+	s := tree.Stats()
+	if s.Flushes == 0 || s.Compactions == 0 {
+		t.Fatalf("background work did not run: %d flushes, %d compactions", s.Flushes, s.Compactions)
+	}
+	t.Logf("flushes=%d compactions=%d stalls=%d slowdowns=%d levels=%v",
+		s.Flushes, s.Compactions, s.WriteStalls, s.Slowdowns, s.Levels)
+}
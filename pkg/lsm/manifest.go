@@ -0,0 +1,88 @@
+package lsm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// MANIFEST 记录当前有哪些 SSTable 以及它们所在的层，每行一个 "层 文件编号"。
+// 每次刷盘或合并完成后把完整的列表写入临时文件、fsync 后 rename 覆盖，
+// 所以它总是描述某一个完整的时刻。目录中不在 MANIFEST 里的 SSTable
+// （刷盘或合并写到一半时崩溃留下的输出）在打开时被删除。
+const manifestName = "MANIFEST"
+
+// ErrCorruptManifest 表示 MANIFEST 无法解析，或者层号越界、同一个表出现了两次
+var ErrCorruptManifest = errors.New("lsm: corrupt manifest")
+
+type manifestEntry struct {
+	level int
+	num   uint64
+}
+
+// readManifest 读取 MANIFEST，文件不存在时返回 nil
+func readManifest(dir string) ([]manifestEntry, error) {
+	f, err := os.Open(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseManifest(f)
+}
+
+// parseManifest 解析 MANIFEST 的内容。层号必须在 [0, maxLevels) 内，每个表只能出现一次，
+// 否则打开时会按损坏的层号分配层、或者把同一个文件当成两个表
+func parseManifest(r io.Reader) ([]manifestEntry, error) {
+	var entries []manifestEntry
+	seen := make(map[uint64]bool)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var e manifestEntry
+		if _, err := fmt.Sscanf(sc.Text(), "%d %d", &e.level, &e.num); err != nil {
+			return nil, fmt.Errorf("%w: bad line %q", ErrCorruptManifest, sc.Text())
+		}
+		if e.level < 0 || e.level >= maxLevels {
+			return nil, fmt.Errorf("%w: level %d out of range", ErrCorruptManifest, e.level)
+		}
+		if seen[e.num] {
+			return nil, fmt.Errorf("%w: table %d listed twice", ErrCorruptManifest, e.num)
+		}
+		seen[e.num] = true
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// writeManifest 原子地用 levels 中的表替换 MANIFEST
+func writeManifest(dir string, levels [][]*Table) error {
+	path := filepath.Join(dir, manifestName)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for level, tables := range levels {
+		for _, t := range tables {
+			fmt.Fprintf(w, "%d %d\n", level, t.num)
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
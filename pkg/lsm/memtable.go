@@ -0,0 +1,99 @@
+package lsm
+
+import "github.com/ddia-labs/pkg/kv"
+
+// MemTable 是 LSM-tree 的内存表，写入先进入这里，写满后整体刷成一个 SSTable。
+// 删除也是一次写入：记录一个删除标记，刷盘后由它遮蔽更旧的 SSTable 中的同一个键。
+// 底层是跳表，可以在一个协程写入的同时被多个协程读取。
+type MemTable struct {
+	list *skiplist
+}
+
+// NewMemTable 创建一个用 cmp 排序键的内存表，cmp 为 nil 时按字节序
+func NewMemTable(cmp kv.Comparator) *MemTable {
+	if cmp == nil {
+		cmp = kv.Bytewise
+	}
+	return &MemTable{
+		list: newSkiplist(cmp),
+	}
+}
+
+// Put 写入 key 的值。内存表直接保存这两个切片，调用方之后不能再修改它们
+func (mt *MemTable) Put(key, value []byte) {
+	mt.list.put(Entry{Key: key, Value: value})
+}
+
+// Delete 为 key 写入删除标记
+func (mt *MemTable) Delete(key []byte) {
+	mt.list.put(Entry{Key: key, Deleted: true})
+}
+
+// Get 返回 key 的值，key 不存在或已被删除时返回 false
+func (mt *MemTable) Get(key []byte) ([]byte, bool) {
+	e, ok := mt.list.get(key)
+	return e.Value, ok && !e.Deleted
+}
+
+// lookup 返回内存表中 key 的记录（可能是删除标记）
+func (mt *MemTable) lookup(key []byte) (Entry, bool) {
+	return mt.list.get(key)
+}
+
+// apply 写入一条记录（WAL 重放时使用）
+func (mt *MemTable) apply(e Entry) {
+	mt.list.put(e)
+}
+
+// Size 返回内存表中不同键的个数（包括删除标记）
+func (mt *MemTable) Size() int {
+	return int(mt.list.size.Load())
+}
+
+// Entry 是一条 Key-Value 记录，Deleted 为 true 时它是一个删除标记（墓碑）；
+// Pointer 为 true 时 Value 不是值本身，而是值在值日志中的位置（见 valuePointer）
+type Entry struct {
+	Key     []byte
+	Value   []byte
+	Deleted bool
+	Pointer bool
+}
+
+// Entries 按键升序返回内存表中的所有记录（包括删除标记）
+func (mt *MemTable) Entries() []Entry {
+	entries := make([]Entry, 0, mt.Size())
+	mt.list.ascend(nil, func(e Entry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	return entries
+}
+
+// iter 返回从第一个 >= start 的键（start 为 nil 时从头）开始按顺序遍历的迭代器。
+// 遍历时不复制内存表，可以与写入并发进行（可能看到遍历开始之后写入的记录）
+func (mt *MemTable) iter(start []byte) iterator {
+	return &memIter{list: mt.list, start: start}
+}
+
+type memIter struct {
+	list  *skiplist
+	start []byte
+	node  *skipNode
+	cur   Entry
+}
+
+func (it *memIter) Next() bool {
+	if it.node == nil && it.list != nil {
+		it.node, it.list = it.list.seek(it.start, nil), nil
+	} else if it.node != nil {
+		it.node = it.node.next[0].Load()
+	}
+	if it.node == nil {
+		return false
+	}
+	it.cur = *it.node.entry.Load()
+	return true
+}
+
+func (it *memIter) Entry() Entry { return it.cur }
+func (it *memIter) Err() error   { return nil }
@@ -0,0 +1,118 @@
+package lsm
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// skiplist 是内存表使用的跳表：写入互斥，读取不加锁。
+//
+// 每个节点在第 0 层串成一条有序链表，并以 1/4 的概率逐层出现在更高的层中，
+// 查找从最高层开始向右、向下移动，期望 O(log n)。新节点先设置好自己的 next 指针，
+// 再用原子写把它接到前驱后面，所以并发的读者要么看不到它，要么看到一个完整的节点；
+// 覆盖已有的键只是原子地替换节点中的记录。节点从不删除（删除是写入删除标记）。
+type skiplist struct {
+	mu     sync.Mutex // 串行化写入
+	head   *skipNode
+	height atomic.Int32
+	size   atomic.Int64
+	rnd    *rand.Rand // 只在持有 mu 时使用
+	cmp    kv.Comparator
+}
+
+const (
+	skiplistMaxHeight = 12
+	skiplistBranching = 4
+)
+
+type skipNode struct {
+	key   []byte
+	entry atomic.Pointer[Entry]
+	next  []atomic.Pointer[skipNode]
+}
+
+func newSkiplist(cmp kv.Comparator) *skiplist {
+	sl := &skiplist{
+		head: &skipNode{next: make([]atomic.Pointer[skipNode], skiplistMaxHeight)},
+		rnd:  rand.New(rand.NewSource(1)),
+		cmp:  cmp,
+	}
+	sl.height.Store(1)
+	return sl
+}
+
+func (sl *skiplist) randomHeight() int {
+	h := 1
+	for h < skiplistMaxHeight && sl.rnd.Intn(skiplistBranching) == 0 {
+		h++
+	}
+	return h
+}
+
+// seek 返回第一个键 >= key 的节点（没有时返回 nil），key 为 nil 时返回第一个节点；
+// prev 不为 nil 时记录每一层上位于该节点之前的最后一个节点
+func (sl *skiplist) seek(key []byte, prev []*skipNode) *skipNode {
+	if key == nil && prev == nil {
+		return sl.head.next[0].Load()
+	}
+	x := sl.head
+	for level := int(sl.height.Load()) - 1; level >= 0; level-- {
+		for {
+			next := x.next[level].Load()
+			if next == nil || sl.cmp(next.key, key) >= 0 {
+				break
+			}
+			x = next
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+	}
+	return x.next[0].Load()
+}
+
+// put 插入或覆盖一条记录
+func (sl *skiplist) put(e Entry) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	var prev [skiplistMaxHeight]*skipNode
+	if x := sl.seek(e.Key, prev[:]); x != nil && sl.cmp(x.key, e.Key) == 0 {
+		x.entry.Store(&e)
+		return
+	}
+	h := sl.randomHeight()
+	if cur := int(sl.height.Load()); h > cur {
+		for level := cur; level < h; level++ {
+			prev[level] = sl.head
+		}
+		// 读者先看到更高的高度也没关系：head 在新的层上还没有后继，会直接向下走
+		sl.height.Store(int32(h))
+	}
+	n := &skipNode{key: e.Key, next: make([]atomic.Pointer[skipNode], h)}
+	n.entry.Store(&e)
+	for level := 0; level < h; level++ {
+		n.next[level].Store(prev[level].next[level].Load())
+		prev[level].next[level].Store(n)
+	}
+	sl.size.Add(1)
+}
+
+func (sl *skiplist) get(key []byte) (Entry, bool) {
+	if x := sl.seek(key, nil); x != nil && sl.cmp(x.key, key) == 0 {
+		return *x.entry.Load(), true
+	}
+	return Entry{}, false
+}
+
+// ascend 从第一个键 >= start 的节点（start 为 nil 时从头）开始按顺序把记录交给 fn，fn 返回 false 时停止
+func (sl *skiplist) ascend(start []byte, fn func(e Entry) bool) {
+	for x := sl.seek(start, nil); x != nil; x = x.next[0].Load() {
+		if !fn(*x.entry.Load()) {
+			return
+		}
+	}
+}
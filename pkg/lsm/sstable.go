@@ -0,0 +1,377 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync/atomic"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// SSTable 文件格式：
+//
+//	数据块 0 | 数据块 1 | ... | 布隆过滤器 | 索引块 | 页脚
+//
+// 数据块:   压缩类型(1) | 内容 | crc32(4)，内容是按键升序排列的若干条记录（编码见 appendEntry），
+//
+//	压缩类型为 1 时内容经过 compress 压缩
+//
+// 布隆过滤器: 位数组 | 哈希函数个数(1)，见 bloomFilter；关闭过滤器时长度为 0
+// 索引块:   最小键 | 最大键 | 块数(uvarint)，然后每个块一项：首键 | 偏移量(uvarint) | 长度(uvarint)，
+//
+//	其中每个键都编码为 长度(uvarint) | 字节
+//
+// 页脚:     索引偏移(8) | 索引长度(4) | 过滤器长度(4) | 条目数(8) | crc32(4) | magic(4)
+//
+// 索引是稀疏的：每个块只记录第一个键。打开文件时只读入页脚、过滤器和索引，
+// Get 先用索引块中的最小/最大键（fence pointer）和布隆过滤器排除不可能包含该键的表，
+// 再通过二分查找索引定位到唯一可能包含该键的块，只读取这一个块。
+// 页脚中的 crc32 覆盖过滤器、索引块和页脚中它之前的字段，过滤器紧挨在索引块之前，
+// 每个数据块另有自己的校验和。
+
+const (
+	footerSize = 32
+	tableMagic = 0x53535434 // "SST4"
+
+	blockRaw        = 0
+	blockCompressed = 1
+)
+
+var ErrCorruptTable = errors.New("lsm: corrupt sstable")
+
+type blockHandle struct {
+	firstKey []byte
+	offset   int64
+	length   int64
+}
+
+// TableInfo 描述一个 SSTable 文件
+type TableInfo struct {
+	File    string
+	Entries int
+	MinKey  []byte
+	MaxKey  []byte
+	Size    int64
+	Blocks  int
+	Level   int
+
+	FilterBytes int // 布隆过滤器的大小，0 表示没有过滤器
+}
+
+// tableWriter 按键升序接收条目，把它们切分成数据块写入文件
+type tableWriter struct {
+	path        string
+	file        *os.File
+	w           *bufio.Writer
+	blockSize   int
+	compression bool
+	bitsPerKey  int // <= 0 时不生成布隆过滤器
+
+	block    []byte
+	firstKey []byte
+	offset   int64
+	index    []blockHandle
+	entries  int
+	minKey   []byte
+	maxKey   []byte
+	hashes   []uint64 // 每个键的哈希，finish 时用来构造布隆过滤器
+}
+
+// newTableWriter 在 path 的临时文件上开始写入，finish 时才 rename 到 path
+func newTableWriter(path string, blockSize int, compression bool, bitsPerKey int) (*tableWriter, error) {
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{
+		path:        path,
+		file:        f,
+		w:           bufio.NewWriter(f),
+		blockSize:   blockSize,
+		compression: compression,
+		bitsPerKey:  bitsPerKey,
+	}, nil
+}
+
+func (tw *tableWriter) add(e Entry) error {
+	// 记录的键可能引用调用方（迭代器）的缓冲区，需要保留的键都复制一份
+	if tw.entries == 0 {
+		tw.minKey = kv.Clone(e.Key)
+	}
+	if len(tw.block) == 0 {
+		tw.firstKey = kv.Clone(e.Key)
+	}
+	tw.block = appendEntry(tw.block, e)
+	tw.maxKey = append(tw.maxKey[:0], e.Key...)
+	tw.entries++
+	if tw.bitsPerKey > 0 {
+		tw.hashes = append(tw.hashes, keyHash(e.Key))
+	}
+	if len(tw.block) >= tw.blockSize {
+		return tw.flushBlock()
+	}
+	return nil
+}
+
+func (tw *tableWriter) flushBlock() error {
+	if len(tw.block) == 0 {
+		return nil
+	}
+	kind, payload := byte(blockRaw), tw.block
+	if tw.compression {
+		if c := compress(tw.block); len(c) < len(tw.block) {
+			kind, payload = blockCompressed, c
+		}
+	}
+	sum := crc32.Update(crc32.ChecksumIEEE([]byte{kind}), crc32.IEEETable, payload)
+	tw.w.WriteByte(kind)
+	tw.w.Write(payload)
+	if err := binary.Write(tw.w, binary.LittleEndian, sum); err != nil {
+		return err
+	}
+	n := int64(1 + len(payload) + 4)
+	tw.index = append(tw.index, blockHandle{firstKey: tw.firstKey, offset: tw.offset, length: n})
+	tw.offset += n
+	tw.block = tw.block[:0]
+	return nil
+}
+
+// size 返回目前为止写入的字节数（含尚未落盘的当前块）
+func (tw *tableWriter) size() int64 { return tw.offset + int64(len(tw.block)) }
+
+// finish 写入过滤器、索引和页脚，fsync 后把临时文件 rename 为正式文件
+func (tw *tableWriter) finish() error {
+	if err := tw.flushBlock(); err != nil {
+		tw.abort()
+		return err
+	}
+	var filter []byte
+	if tw.bitsPerKey > 0 {
+		filter = newBloomFilter(tw.hashes, tw.bitsPerKey)
+	}
+	tw.w.Write(filter)
+	tw.offset += int64(len(filter))
+
+	index := appendKey(nil, tw.minKey)
+	index = appendKey(index, tw.maxKey)
+	index = binary.AppendUvarint(index, uint64(len(tw.index)))
+	for _, h := range tw.index {
+		index = appendKey(index, h.firstKey)
+		index = binary.AppendUvarint(index, uint64(h.offset))
+		index = binary.AppendUvarint(index, uint64(h.length))
+	}
+	footer := make([]byte, footerSize)
+	le := binary.LittleEndian
+	le.PutUint64(footer[0:], uint64(tw.offset))
+	le.PutUint32(footer[8:], uint32(len(index)))
+	le.PutUint32(footer[12:], uint32(len(filter)))
+	le.PutUint64(footer[16:], uint64(tw.entries))
+	le.PutUint32(footer[24:], tableChecksum(filter, index, footer))
+	le.PutUint32(footer[28:], tableMagic)
+
+	tw.w.Write(index)
+	tw.w.Write(footer)
+	err := tw.w.Flush()
+	if err == nil {
+		err = tw.file.Sync()
+	}
+	if err != nil {
+		tw.abort()
+		return err
+	}
+	if err := tw.file.Close(); err != nil {
+		os.Remove(tw.path + ".tmp")
+		return err
+	}
+	return os.Rename(tw.path+".tmp", tw.path)
+}
+
+// tableChecksum 计算覆盖过滤器、索引和页脚中校验和之前字段的 crc32
+func tableChecksum(filter, index, footer []byte) uint32 {
+	sum := crc32.ChecksumIEEE(filter)
+	sum = crc32.Update(sum, crc32.IEEETable, index)
+	return crc32.Update(sum, crc32.IEEETable, footer[:24])
+}
+
+// appendKey 把键编码为 长度(uvarint) | 字节
+func appendKey(b, key []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(key)))
+	return append(b, key...)
+}
+
+func (tw *tableWriter) abort() {
+	tw.file.Close()
+	os.Remove(tw.path + ".tmp")
+}
+
+// Table 是一个打开的 SSTable：页脚、布隆过滤器和稀疏索引常驻内存，数据块按需读取
+type Table struct {
+	path    string
+	num     uint64 // 文件编号，越大越新
+	file    *os.File
+	size    int64
+	index   []blockHandle
+	filter  bloomFilter
+	entries int
+	minKey  []byte
+	maxKey  []byte
+	cmp     kv.Comparator
+
+	blockReads *atomic.Int64
+	bytesRead  *atomic.Int64
+}
+
+// openTable 读取并校验页脚、过滤器和索引，cmp 必须与写表时使用的比较器一致
+func openTable(path string, num uint64, cmp kv.Comparator, s *stats) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := readTable(f, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.num, t.cmp = num, cmp
+	t.blockReads, t.bytesRead = &s.blockReads, &s.bytesRead
+	return t, nil
+}
+
+func readTable(f *os.File, path string) (*Table, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() < footerSize {
+		return nil, fmt.Errorf("%w: %s is too short", ErrCorruptTable, path)
+	}
+	footer := make([]byte, footerSize)
+	if _, err := f.ReadAt(footer, st.Size()-footerSize); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	if le.Uint32(footer[28:]) != tableMagic {
+		return nil, fmt.Errorf("%w: %s has bad magic", ErrCorruptTable, path)
+	}
+	indexOff, indexLen := int64(le.Uint64(footer[0:])), int64(le.Uint32(footer[8:]))
+	filterLen := int64(le.Uint32(footer[12:]))
+	if indexOff+indexLen+footerSize != st.Size() || filterLen > indexOff {
+		return nil, fmt.Errorf("%w: %s has bad index handle", ErrCorruptTable, path)
+	}
+	buf := make([]byte, filterLen+indexLen)
+	if _, err := f.ReadAt(buf, indexOff-filterLen); err != nil {
+		return nil, err
+	}
+	filter, index := buf[:filterLen], buf[filterLen:]
+	if tableChecksum(filter, index, footer) != le.Uint32(footer[24:]) {
+		return nil, fmt.Errorf("%w: %s checksum mismatch", ErrCorruptTable, path)
+	}
+
+	t := &Table{
+		path:    path,
+		file:    f,
+		size:    st.Size(),
+		entries: int(le.Uint64(footer[16:])),
+	}
+	if filterLen > 0 {
+		t.filter = bloomFilter(filter)
+	}
+	// 依次读出 uvarint 和键，任何一个解码失败都视为索引损坏
+	bad := false
+	uvarint := func() int64 {
+		v, n := binary.Uvarint(index)
+		if n <= 0 {
+			bad = true
+			return 0
+		}
+		index = index[n:]
+		return int64(v)
+	}
+	key := func() []byte {
+		n := uvarint()
+		if bad || n > int64(len(index)) {
+			bad = true
+			return nil
+		}
+		k := index[:n:n]
+		index = index[n:]
+		return k
+	}
+	t.minKey, t.maxKey = key(), key()
+	count := uvarint()
+	for i := int64(0); i < count && !bad; i++ {
+		h := blockHandle{firstKey: key()}
+		h.offset = uvarint()
+		h.length = uvarint()
+		t.index = append(t.index, h)
+	}
+	if bad {
+		return nil, fmt.Errorf("%w: %s has bad index", ErrCorruptTable, path)
+	}
+	return t, nil
+}
+
+// readBlock 读取、校验并（必要时）解压第 i 个数据块
+func (t *Table) readBlock(i int) ([]byte, error) {
+	h := t.index[i]
+	buf := make([]byte, h.length)
+	if _, err := t.file.ReadAt(buf, h.offset); err != nil {
+		return nil, err
+	}
+	t.blockReads.Add(1)
+	t.bytesRead.Add(h.length)
+	body, sum := buf[:len(buf)-4], binary.LittleEndian.Uint32(buf[len(buf)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: %s block %d checksum mismatch", ErrCorruptTable, t.path, i)
+	}
+	if body[0] == blockCompressed {
+		return decompress(body[1:])
+	}
+	return body[1:], nil
+}
+
+// get 在表中查找 key，最多读取一个数据块。找到的记录可能是删除标记
+func (t *Table) get(key []byte) (Entry, bool, error) {
+	// 最后一个首键 <= key 的块
+	i := sort.Search(len(t.index), func(i int) bool { return t.cmp(t.index[i].firstKey, key) > 0 }) - 1
+	if i < 0 {
+		return Entry{}, false, nil
+	}
+	b, err := t.readBlock(i)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	for len(b) > 0 {
+		var e Entry
+		if e, b, err = decodeEntry(b); err != nil {
+			return Entry{}, false, err
+		}
+		if c := t.cmp(e.Key, key); c == 0 {
+			return e, true, nil
+		} else if c > 0 {
+			break
+		}
+	}
+	return Entry{}, false, nil
+}
+
+// Info 返回表的元数据
+func (t *Table) Info() TableInfo {
+	return TableInfo{
+		File:    t.path,
+		Entries: t.entries,
+		MinKey:  t.minKey,
+		MaxKey:  t.maxKey,
+		Size:    t.size,
+		Blocks:  len(t.index),
+
+		FilterBytes: len(t.filter),
+	}
+}
+
+func (t *Table) Close() error { return t.file.Close() }
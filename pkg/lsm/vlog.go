@@ -0,0 +1,400 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+// 键值分离（WiscKey）：长度达到 Options.ValueThreshold 的值追加到值日志 <编号>.vlog，
+// LSM-tree 中只保存一个指向它的 valuePointer。合并只搬动键和指针，不再反复重写大的值，
+// 代价是读取多一次随机读，以及被覆盖或删除的值需要单独的垃圾回收。
+//
+// 值日志记录：crc32(4) | 长度(4) | 键长度(uvarint) | 键 | 值，CRC 覆盖长度之后的内容。
+// 值日志按段切分，当前段写满 Options.ValueLogFileSize 后换一个新段；
+// 每次打开都从一个新段开始写，旧段只读。
+//
+// 垃圾回收（GCValueLog）每次处理最旧的一个段：逐条检查记录是不是对应键的最新版本
+// （LSM 中该键的最新记录恰好指向这个位置），是的话把值重新追加到当前段并写入新的指针，
+// 然后把内存表刷盘让新指针持久化，最后删除整个段。
+
+const vlogHeaderSize = 8
+
+var errCorruptValueLog = errors.New("lsm: corrupt value log record")
+
+// valuePointer 是值日志中一条记录的位置
+type valuePointer struct {
+	file   uint64
+	offset int64
+	length int64 // 整条记录的长度（含头部和键）
+}
+
+func (p valuePointer) encode() []byte {
+	b := binary.AppendUvarint(nil, p.file)
+	b = binary.AppendUvarint(b, uint64(p.offset))
+	return binary.AppendUvarint(b, uint64(p.length))
+}
+
+func decodePointer(b []byte) (valuePointer, error) {
+	var p valuePointer
+	var vals [3]uint64
+	for i := range vals {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return p, errCorruptValueLog
+		}
+		vals[i], b = v, b[n:]
+	}
+	return valuePointer{file: vals[0], offset: int64(vals[1]), length: int64(vals[2])}, nil
+}
+
+// valueSize 返回指针指向的值的长度，klen 是键的长度
+func (p valuePointer) valueSize(klen int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return int(p.length) - vlogHeaderSize - binary.PutUvarint(buf[:], uint64(klen)) - klen
+}
+
+type vlogSegment struct {
+	num  uint64
+	path string
+	file *os.File
+	size atomic.Int64 // 当前段在写入时被 Stats 并发读取
+}
+
+// valueLog 管理所有值日志段。append 和切换当前段在持有 writeMu 时进行；
+// segments 的增删在持有 mu 时进行，读取（ReadAt）持有 mu 的读锁，可以并发。
+type valueLog struct {
+	segments map[uint64]*vlogSegment
+	active   *vlogSegment
+	maxSize  int64
+	sync     bool
+	buf      []byte
+}
+
+func vlogName(num uint64) string { return fmt.Sprintf("%06d.vlog", num) }
+
+// openSegment 以读写方式打开一个段，create 为 true 时创建新段
+func openSegment(path string, num uint64, create bool) (*vlogSegment, error) {
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	seg := &vlogSegment{num: num, path: path, file: f}
+	seg.size.Store(st.Size())
+	return seg, nil
+}
+
+// append 把一条值写入当前段，返回它的位置
+func (vl *valueLog) append(key, value []byte) (valuePointer, error) {
+	vl.buf = binary.AppendUvarint(append(vl.buf[:0], make([]byte, vlogHeaderSize)...), uint64(len(key)))
+	vl.buf = append(append(vl.buf, key...), value...)
+	binary.LittleEndian.PutUint32(vl.buf[4:], uint32(len(vl.buf)-vlogHeaderSize))
+	binary.LittleEndian.PutUint32(vl.buf[0:], crc32.ChecksumIEEE(vl.buf[4:]))
+
+	seg := vl.active
+	if _, err := seg.file.Write(vl.buf); err != nil {
+		return valuePointer{}, err
+	}
+	if vl.sync {
+		if err := seg.file.Sync(); err != nil {
+			return valuePointer{}, err
+		}
+	}
+	p := valuePointer{file: seg.num, offset: seg.size.Load(), length: int64(len(vl.buf))}
+	seg.size.Add(int64(len(vl.buf)))
+	return p, nil
+}
+
+// read 读取指针指向的值，并校验记录中的键
+func (vl *valueLog) read(key []byte, p valuePointer) ([]byte, error) {
+	seg, ok := vl.segments[p.file]
+	if !ok {
+		return nil, fmt.Errorf("%w: segment %d is gone", errCorruptValueLog, p.file)
+	}
+	if p.offset < 0 || p.length < vlogHeaderSize || p.offset+p.length > seg.size.Load() {
+		return nil, fmt.Errorf("%w: pointer %d+%d is outside segment %d", errCorruptValueLog, p.offset, p.length, p.file)
+	}
+	rec := make([]byte, p.length)
+	if _, err := seg.file.ReadAt(rec, p.offset); err != nil {
+		return nil, err
+	}
+	k, value, err := decodeVlogRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(k, key) {
+		return nil, fmt.Errorf("%w: key %x, want %x", errCorruptValueLog, k, key)
+	}
+	return value, nil
+}
+
+func decodeVlogRecord(rec []byte) ([]byte, []byte, error) {
+	if len(rec) < vlogHeaderSize || int(binary.LittleEndian.Uint32(rec[4:])) != len(rec)-vlogHeaderSize ||
+		crc32.ChecksumIEEE(rec[4:]) != binary.LittleEndian.Uint32(rec) {
+		return nil, nil, errCorruptValueLog
+	}
+	body := rec[vlogHeaderSize:]
+	klen, n := binary.Uvarint(body)
+	if n <= 0 || uint64(len(body)-n) < klen {
+		return nil, nil, errCorruptValueLog
+	}
+	return body[n : n+int(klen)], body[n+int(klen):], nil
+}
+
+// scanSegment 按顺序把段中的每条记录交给 fn，遇到撕裂的尾部时停止
+func scanSegment(seg *vlogSegment, fn func(key []byte, p valuePointer) error) error {
+	size := seg.size.Load()
+	r := bufio.NewReader(io.NewSectionReader(seg.file, 0, size))
+	var off int64
+	var hdr [vlogHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil
+		}
+		length := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		if off+vlogHeaderSize+length > size {
+			return nil
+		}
+		rec := make([]byte, vlogHeaderSize+length)
+		copy(rec, hdr[:])
+		if _, err := io.ReadFull(r, rec[vlogHeaderSize:]); err != nil {
+			return nil
+		}
+		key, _, err := decodeVlogRecord(rec)
+		if err != nil {
+			return nil
+		}
+		if err := fn(key, valuePointer{file: seg.num, offset: off, length: int64(len(rec))}); err != nil {
+			return err
+		}
+		off += int64(len(rec))
+	}
+}
+
+func (vl *valueLog) totalSize() int64 {
+	var n int64
+	for _, seg := range vl.segments {
+		n += seg.size.Load()
+	}
+	return n
+}
+
+func (vl *valueLog) close() error {
+	var err error
+	for _, seg := range vl.segments {
+		if seg == vl.active {
+			if serr := seg.file.Sync(); err == nil {
+				err = serr
+			}
+		}
+		if cerr := seg.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openValueLog 打开目录中已有的段（只读，空段直接删除），启用键值分离时再创建一个新的当前段。
+// 即使没有启用键值分离也要打开已有的段：之前写入的指针仍然指向它们。
+func (lsm *LSMTree) openValueLog(nums []uint64) error {
+	lsm.vlog = &valueLog{
+		segments: make(map[uint64]*vlogSegment),
+		maxSize:  lsm.opts.ValueLogFileSize,
+		sync:     lsm.opts.SyncWrites,
+	}
+	for _, num := range nums {
+		seg, err := openSegment(filepath.Join(lsm.dir, vlogName(num)), num, false)
+		if err != nil {
+			return err
+		}
+		if seg.size.Load() == 0 {
+			seg.file.Close()
+			os.Remove(seg.path)
+			continue
+		}
+		lsm.vlog.segments[num] = seg
+	}
+	if lsm.opts.ValueThreshold > 0 {
+		return lsm.newValueSegment()
+	}
+	return nil
+}
+
+// newValueSegment 创建一个新段作为当前段，调用方持有 writeMu 和 mu（或处于初始化阶段）
+func (lsm *LSMTree) newValueSegment() error {
+	num := lsm.allocFile()
+	seg, err := openSegment(filepath.Join(lsm.dir, vlogName(num)), num, true)
+	if err != nil {
+		return err
+	}
+	if old := lsm.vlog.active; old != nil {
+		if err := old.file.Sync(); err != nil {
+			seg.file.Close()
+			os.Remove(seg.path)
+			return err
+		}
+	}
+	lsm.vlog.segments[num] = seg
+	lsm.vlog.active = seg
+	return nil
+}
+
+// separateValue 在持有 writeMu 时把大的值写入值日志，返回替换成指针的记录
+func (lsm *LSMTree) separateValue(e Entry) (Entry, error) {
+	if lsm.opts.ValueThreshold <= 0 || e.Deleted || e.Pointer || len(e.Value) < lsm.opts.ValueThreshold {
+		return e, nil
+	}
+	if lsm.vlog.active.size.Load() >= lsm.vlog.maxSize {
+		lsm.mu.Lock()
+		err := lsm.newValueSegment()
+		lsm.mu.Unlock()
+		if err != nil {
+			return e, err
+		}
+	}
+	p, err := lsm.vlog.append(e.Key, e.Value)
+	if err != nil {
+		return e, err
+	}
+	lsm.stats.vlogWritten.Add(p.length)
+	return Entry{Key: e.Key, Value: p.encode(), Pointer: true}, nil
+}
+
+// resolve 在持有 mu（读锁即可）时把指针记录替换成值日志中的值
+func (lsm *LSMTree) resolve(e Entry) (Entry, error) {
+	if !e.Pointer {
+		return e, nil
+	}
+	p, err := decodePointer(e.Value)
+	if err != nil {
+		return e, err
+	}
+	value, err := lsm.vlog.read(e.Key, p)
+	if err != nil {
+		return e, err
+	}
+	return Entry{Key: e.Key, Value: value}, nil
+}
+
+// logicalSize 返回记录按值本身（而不是指针）计算的编码大小
+func logicalSize(e Entry) int {
+	if e.Pointer {
+		if p, err := decodePointer(e.Value); err == nil {
+			return encodedSize(len(e.Key), p.valueSize(len(e.Key)))
+		}
+	}
+	return entrySize(e)
+}
+
+// ValueLogGCStats 是一次值日志垃圾回收的结果
+type ValueLogGCStats struct {
+	Segment   string // 被回收的段
+	Records   int    // 段中的记录数
+	Relocated int    // 仍然有效、被搬到当前段的记录数
+	Reclaimed int64  // 释放的字节数（段大小减去搬走的数据）
+}
+
+// ErrNoValueLogGarbage 表示没有可以回收的段（只有当前段）
+var ErrNoValueLogGarbage = errors.New("lsm: no value log segment to collect")
+
+// GCValueLog 回收最旧的一个值日志段：仍然有效的值被搬到当前段（没有启用键值分离时写回 LSM-tree），
+// 然后删除整个段。可以和读写并发调用，同一时间只运行一次回收。
+func (lsm *LSMTree) GCValueLog() (ValueLogGCStats, error) {
+	lsm.gcMu.Lock()
+	defer lsm.gcMu.Unlock()
+
+	lsm.mu.RLock()
+	if lsm.closed {
+		lsm.mu.RUnlock()
+		return ValueLogGCStats{}, ErrClosed
+	}
+	var nums []uint64
+	for num, seg := range lsm.vlog.segments {
+		if seg != lsm.vlog.active {
+			nums = append(nums, num)
+		}
+	}
+	lsm.mu.RUnlock()
+	if len(nums) == 0 {
+		return ValueLogGCStats{}, ErrNoValueLogGarbage
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	lsm.mu.RLock()
+	seg := lsm.vlog.segments[nums[0]]
+	lsm.mu.RUnlock()
+
+	gs := ValueLogGCStats{Segment: filepath.Base(seg.path)}
+	var moved int64
+	err := scanSegment(seg, func(key []byte, p valuePointer) error {
+		gs.Records++
+		ok, err := lsm.relocate(key, p)
+		if ok {
+			gs.Relocated++
+			moved += p.length
+		}
+		return err
+	})
+	if err != nil {
+		return gs, err
+	}
+	// 新指针写进 SSTable（刷盘前会 fsync 当前值日志段）之后，旧段才能删除
+	if err := lsm.Flush(); err != nil {
+		return gs, err
+	}
+
+	lsm.mu.Lock()
+	delete(lsm.vlog.segments, seg.num)
+	lsm.stats.gcRuns++
+	lsm.stats.gcRelocated += int64(gs.Relocated)
+	lsm.mu.Unlock()
+	seg.file.Close()
+	if err := os.Remove(seg.path); err != nil {
+		return gs, err
+	}
+	gs.Reclaimed = seg.size.Load() - moved
+	return gs, nil
+}
+
+// relocate 在 p 仍是 key 的最新版本时，把值重新追加到当前段并写入新指针。
+// 检查和写入都在 writeMu 下进行，不会覆盖并发写入的新值。
+func (lsm *LSMTree) relocate(key []byte, p valuePointer) (bool, error) {
+	lsm.writeMu.Lock()
+	defer lsm.writeMu.Unlock()
+
+	lsm.mu.RLock()
+	e, ok, err := lsm.lookup(key)
+	var value []byte
+	live := false
+	if err == nil && ok && e.Pointer {
+		if cur, perr := decodePointer(e.Value); perr == nil && cur == p {
+			value, err = lsm.vlog.read(key, p)
+			live = err == nil
+		}
+	}
+	lsm.mu.RUnlock()
+	if err != nil || !live {
+		return false, err
+	}
+
+	if err := lsm.writeLocked(Entry{Key: key, Value: value}, false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,113 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// 内存表的预写日志：每条写入在进入内存表之前先追加到当前的 <编号>.log。
+// 记录格式：crc32(4) | 长度(4) | 记录（编码见 appendEntry），CRC 覆盖长度之后的内容。
+//
+// 每个内存表对应一个日志文件。内存表刷盘时先切换到新的日志文件，
+// 等 SSTable 已经 fsync 并 rename 到位之后才删除旧日志；
+// 打开时按编号顺序重放所有剩下的日志，重建崩溃前尚未刷盘的内存表。
+// 日志尾部被撕裂的记录（CRC 不匹配或长度不够）会被忽略。
+
+// walHeaderSize 是每条日志记录的头部（crc32 和长度）大小
+const walHeaderSize = 8
+
+type walWriter struct {
+	path string
+	file *os.File
+	sync bool
+	buf  []byte
+}
+
+func createWAL(path string, sync bool) (*walWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &walWriter{path: path, file: f, sync: sync}, nil
+}
+
+// append 把一条记录写入日志。每条记录都是一次 write 系统调用，
+// 进程崩溃不会丢失已返回的写入；开启 sync 时还能抵御断电
+func (w *walWriter) append(e Entry) error {
+	w.buf = appendEntry(append(w.buf[:0], make([]byte, walHeaderSize)...), e)
+	binary.LittleEndian.PutUint32(w.buf[4:], uint32(len(w.buf)-walHeaderSize))
+	binary.LittleEndian.PutUint32(w.buf[0:], crc32.ChecksumIEEE(w.buf[4:]))
+	if _, err := w.file.Write(w.buf); err != nil {
+		return err
+	}
+	if w.sync {
+		return w.file.Sync()
+	}
+	return nil
+}
+
+func (w *walWriter) close() error {
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+var errTornRecord = errors.New("lsm: torn wal record")
+
+// replayWAL 依次把日志中的记录交给 fn，返回重放的记录数；遇到撕裂的尾部时停止
+func replayWAL(path string, fn func(e Entry)) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(f)
+	n := 0
+	remaining := info.Size()
+	var hdr [8]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return n, nil
+		}
+		// 长度超出文件剩余部分的记录同样是撕裂的尾部（也避免按损坏的长度分配内存）
+		size := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		if remaining -= walHeaderSize; size > remaining {
+			return n, nil
+		}
+		remaining -= size
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return n, nil
+		}
+		if crc32.Update(crc32.ChecksumIEEE(hdr[4:]), crc32.IEEETable, body) != binary.LittleEndian.Uint32(hdr[:4]) {
+			return n, nil
+		}
+		e, rest, err := decodeEntry(body)
+		if err != nil || len(rest) != 0 {
+			return n, errTornRecord
+		}
+		fn(e)
+		n++
+	}
+}
+
+// syncDir fsync 目录，让其中文件的创建、rename 和删除持久化
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,366 @@
+package pager
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrPoolFull 表示缓冲池中所有的页都被固定（pinned）或尚未写入日志，无法淘汰
+var ErrPoolFull = errors.New("pager: all buffer pool frames are pinned or unlogged")
+
+// ErrFailed 表示撤销操作本身失败了，缓冲池中的页和日志处于未知状态，只能关闭后重新打开（用 WAL 恢复）
+var ErrFailed = errors.New("pager: buffer pool is unusable after a failed abort, reopen it")
+
+// Page 是缓冲池中的一个页帧
+type Page struct {
+	ID   PageID
+	Data [PageSize]byte
+
+	pins     int
+	dirty    bool          // 内容与数据文件中的不同
+	unlogged bool          // 自上次提交以来被修改过，还没有写入 WAL
+	lru      *list.Element // 未被固定时位于 LRU 链表中
+}
+
+// BufferPool 在内存中缓存最多 capacity 个页。
+// Fetch 返回的页处于固定状态，使用完后必须调用 Unpin；
+// 只有未被固定的页才会按 LRU 被淘汰，脏页在淘汰时写回磁盘。
+//
+// 通过 SetWAL 开启日志后，上层在每次操作结束时调用 Commit：本次修改过的页
+// 先写入 WAL，之后才允许被写回数据文件，从而保证崩溃后可以重做恢复。
+// 操作中途出错时调用 Abort 撤销本次的修改，否则下一次 Commit 会把改了一半的页一起写进日志。
+type BufferPool struct {
+	pager    *Pager
+	capacity int
+	frames   map[PageID]*Page
+	lru      *list.List // 队头是最近使用的未固定页
+
+	wal             *WAL
+	unlogged        []*Page // 自上次提交以来修改过的页
+	committed       meta    // 上次提交时的元数据，Abort 时恢复
+	checkpointBytes int64
+	failed          error // 不为 nil 时拒绝所有操作
+
+	stats PoolStats
+}
+
+// PoolStats 统计缓冲池的命中情况
+type PoolStats struct {
+	Hits       int64
+	Misses     int64
+	Evictions  int64
+	Writebacks int64 // 淘汰或刷盘时写回的脏页数
+}
+
+func NewBufferPool(p *Pager, capacity int) *BufferPool {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &BufferPool{
+		pager:    p,
+		capacity: capacity,
+		frames:   make(map[PageID]*Page),
+		lru:      list.New(),
+	}
+}
+
+// DefaultCheckpointBytes 是日志超过多大时在提交后自动做检查点
+const DefaultCheckpointBytes = 4 << 20
+
+// SetWAL 为缓冲池开启预写日志
+func (bp *BufferPool) SetWAL(w *WAL) {
+	bp.wal = w
+	bp.committed = bp.pager.meta
+	bp.checkpointBytes = DefaultCheckpointBytes
+}
+
+// SetCheckpointBytes 设置自动检查点的日志大小阈值
+func (bp *BufferPool) SetCheckpointBytes(n int64) { bp.checkpointBytes = n }
+
+// WAL 返回缓冲池使用的日志，没有开启时为 nil
+func (bp *BufferPool) WAL() *WAL { return bp.wal }
+
+// Pager 返回底层的 Pager
+func (bp *BufferPool) Pager() *Pager { return bp.pager }
+
+// Fetch 取得第 id 页并固定它
+func (bp *BufferPool) Fetch(id PageID) (*Page, error) {
+	if bp.failed != nil {
+		return nil, bp.failed
+	}
+	if pg, ok := bp.frames[id]; ok {
+		bp.stats.Hits++
+		bp.pin(pg)
+		return pg, nil
+	}
+	bp.stats.Misses++
+	pg, err := bp.newFrame(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := bp.pager.ReadPage(id, pg.Data[:]); err != nil {
+		delete(bp.frames, id)
+		return nil, err
+	}
+	return pg, nil
+}
+
+// NewPage 分配一个新页，返回固定状态的空白页。
+// 优先复用空闲链表中的页，否则在文件末尾追加。空闲链表的修改同样经过缓冲池，
+// 因此开启 WAL 时分配和回收也会随提交一起写入日志。
+func (bp *BufferPool) NewPage() (*Page, error) {
+	if bp.failed != nil {
+		return nil, bp.failed
+	}
+	p := bp.pager
+	var pg *Page
+	if p.freeHead != InvalidPage {
+		var err error
+		if pg, err = bp.Fetch(p.freeHead); err != nil {
+			return nil, err
+		}
+		p.freeHead = PageID(binary.LittleEndian.Uint32(pg.Data[:4]))
+		clear(pg.Data[:])
+	} else {
+		var err error
+		if pg, err = bp.newFrame(PageID(p.numPages)); err != nil {
+			return nil, err
+		}
+		p.numPages++
+	}
+	p.stats.Allocs++
+	bp.markDirty(pg)
+	return pg, nil
+}
+
+// Unpin 解除一次固定，dirty 表示调用方修改了页的内容
+func (bp *BufferPool) Unpin(pg *Page, dirty bool) {
+	if dirty {
+		bp.markDirty(pg)
+	}
+	pg.pins--
+	if pg.pins == 0 {
+		pg.lru = bp.lru.PushFront(pg)
+	}
+}
+
+func (bp *BufferPool) markDirty(pg *Page) {
+	pg.dirty = true
+	if bp.wal != nil && !pg.unlogged {
+		pg.unlogged = true
+		bp.unlogged = append(bp.unlogged, pg)
+	}
+}
+
+// FreePage 把一个页放回空闲链表，调用方不能再持有它
+func (bp *BufferPool) FreePage(id PageID) error {
+	pg, err := bp.Fetch(id)
+	if err != nil {
+		return err
+	}
+	clear(pg.Data[:])
+	binary.LittleEndian.PutUint32(pg.Data[:4], uint32(bp.pager.freeHead))
+	bp.Unpin(pg, true)
+	bp.pager.freeHead = id
+	bp.pager.stats.Frees++
+	return nil
+}
+
+// FreePages 遍历空闲链表，返回空闲页的数量
+func (bp *BufferPool) FreePages() (int, error) {
+	n := 0
+	for id := bp.pager.freeHead; id != InvalidPage; n++ {
+		pg, err := bp.Fetch(id)
+		if err != nil {
+			return n, err
+		}
+		id = PageID(binary.LittleEndian.Uint32(pg.Data[:4]))
+		bp.Unpin(pg, false)
+	}
+	return n, nil
+}
+
+// FlushAll 把所有脏页写回磁盘（不会淘汰它们）。开启 WAL 时尚未提交的页不会被写回。
+func (bp *BufferPool) FlushAll() error {
+	if bp.failed != nil {
+		return bp.failed
+	}
+	for _, pg := range bp.frames {
+		if err := bp.writeBack(pg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit 把自上次提交以来修改过的页和元数据写入 WAL，标志着一次原子操作的结束；
+// 日志超过阈值时顺带做一次检查点。没有开启 WAL 或者没有任何修改时什么也不做。
+func (bp *BufferPool) Commit() error {
+	if bp.failed != nil {
+		return bp.failed
+	}
+	if bp.wal == nil || (len(bp.unlogged) == 0 && bp.pager.meta == bp.committed) {
+		return nil
+	}
+	if err := bp.commit(); err != nil {
+		return err
+	}
+	if bp.wal.size >= bp.checkpointBytes {
+		return bp.Checkpoint()
+	}
+	return nil
+}
+
+func (bp *BufferPool) commit() error {
+	for _, pg := range bp.unlogged {
+		if err := bp.wal.appendPage(pg.ID, pg.Data[:]); err != nil {
+			return err
+		}
+	}
+	if err := bp.wal.commit(bp.pager.meta); err != nil {
+		return err
+	}
+	for _, pg := range bp.unlogged {
+		pg.unlogged = false
+	}
+	bp.unlogged = bp.unlogged[:0]
+	bp.committed = bp.pager.meta
+	return nil
+}
+
+// Abort 撤销自上次提交以来的所有修改，让缓冲池回到上次提交后的状态：
+// 元数据（页数、空闲链表、根页）恢复原值；修改过的页如果上次提交的版本还只在日志中，
+// 就从日志读回，否则丢弃页帧，下次 Fetch 时从数据文件读取（仍被固定的页帧就地读回）；
+// 日志中没提交的记录也被截掉。撤销失败时缓冲池进入失效状态，之后的操作都返回 ErrFailed。
+// 没有开启 WAL 时什么也不做。
+func (bp *BufferPool) Abort() error {
+	if bp.failed != nil {
+		return bp.failed
+	}
+	if bp.wal == nil {
+		return nil
+	}
+	if err := bp.wal.rollback(); err != nil {
+		bp.failed = fmt.Errorf("%w: truncate log: %v", ErrFailed, err)
+		return bp.failed
+	}
+	bp.pager.meta = bp.committed
+	for _, pg := range bp.unlogged {
+		pg.unlogged = false
+		ok, err := bp.wal.readPage(pg.ID, pg.Data[:])
+		if err != nil {
+			bp.failed = fmt.Errorf("%w: read page %d from log: %v", ErrFailed, pg.ID, err)
+			return bp.failed
+		}
+		switch {
+		case ok:
+			// 仍是脏页，但内容已是日志中提交过的版本
+		case pg.pins > 0:
+			if err := bp.pager.ReadPage(pg.ID, pg.Data[:]); err != nil {
+				bp.failed = fmt.Errorf("%w: reload page %d: %v", ErrFailed, pg.ID, err)
+				return bp.failed
+			}
+			pg.dirty = false
+		default:
+			if pg.lru != nil {
+				bp.lru.Remove(pg.lru)
+			}
+			delete(bp.frames, pg.ID)
+		}
+	}
+	bp.unlogged = bp.unlogged[:0]
+	return nil
+}
+
+// Checkpoint 提交未写入日志的修改，把所有脏页和元数据写回数据文件并 fsync，然后清空日志
+func (bp *BufferPool) Checkpoint() error {
+	if bp.failed != nil {
+		return bp.failed
+	}
+	if bp.wal != nil && len(bp.unlogged) > 0 {
+		if err := bp.commit(); err != nil {
+			return err
+		}
+	}
+	if err := bp.FlushAll(); err != nil {
+		return err
+	}
+	if err := bp.pager.Sync(); err != nil {
+		return err
+	}
+	if bp.wal == nil {
+		return nil
+	}
+	bp.wal.stats.Checkpoints++
+	return bp.wal.reset()
+}
+
+// Close 做最后一次检查点并关闭数据文件和日志。
+// 失效状态下不写回任何东西，重新打开时由 WAL 恢复到最后一次提交。
+func (bp *BufferPool) Close() error {
+	if bp.failed != nil {
+		bp.pager.file.Close()
+		if bp.wal != nil {
+			bp.wal.Close()
+		}
+		return bp.failed
+	}
+	err := bp.Checkpoint()
+	if cerr := bp.pager.Close(); err == nil {
+		err = cerr
+	}
+	if bp.wal != nil {
+		if cerr := bp.wal.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (bp *BufferPool) Stats() PoolStats { return bp.stats }
+
+func (bp *BufferPool) pin(pg *Page) {
+	if pg.pins == 0 && pg.lru != nil {
+		bp.lru.Remove(pg.lru)
+		pg.lru = nil
+	}
+	pg.pins++
+}
+
+// newFrame 为 id 准备一个固定状态的页帧，缓冲池满时先淘汰最久未使用的页。
+// 开启 WAL 时跳过还没写入日志的页（no-steal）。
+func (bp *BufferPool) newFrame(id PageID) (*Page, error) {
+	if len(bp.frames) >= bp.capacity {
+		victim := bp.lru.Back()
+		for victim != nil && victim.Value.(*Page).unlogged {
+			victim = victim.Prev()
+		}
+		if victim == nil {
+			return nil, ErrPoolFull
+		}
+		pg := victim.Value.(*Page)
+		if err := bp.writeBack(pg); err != nil {
+			return nil, err
+		}
+		bp.lru.Remove(victim)
+		delete(bp.frames, pg.ID)
+		bp.stats.Evictions++
+	}
+	pg := &Page{ID: id, pins: 1}
+	bp.frames[id] = pg
+	return pg, nil
+}
+
+func (bp *BufferPool) writeBack(pg *Page) error {
+	if !pg.dirty || pg.unlogged {
+		return nil
+	}
+	if err := bp.pager.WritePage(pg.ID, pg.Data[:]); err != nil {
+		return err
+	}
+	pg.dirty = false
+	bp.stats.Writebacks++
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package pager 把一个文件划分成固定大小的页，提供页的读写、分配与回收（空闲链表），
+// 以及带 LRU 淘汰和脏页回写的缓冲池。磁盘上的 B+tree、哈希索引等结构都建立在它之上。
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// PageSize 是每一页的字节数
+const PageSize = 4096
+
+// PageID 是页在文件中的编号，页 i 位于偏移量 i*PageSize 处。
+// 0 号页是元数据页，因此 0 也用来表示“空指针”。
+type PageID uint32
+
+// InvalidPage 表示不存在的页
+const InvalidPage PageID = 0
+
+const magic = "DDPG"
+
+// 元数据页布局：magic(4) | 页数(4) | 空闲链表头(4) | 根页(4)
+const (
+	metaNumPages = 4
+	metaFreeHead = 8
+	metaRoot     = 12
+)
+
+var ErrBadMagic = errors.New("pager: not a page file")
+
+// Pager 负责页在文件中的读写，页的分配与回收由 BufferPool 完成
+type Pager struct {
+	file *os.File
+	meta
+
+	stats Stats
+}
+
+// meta 是元数据页中保存的状态，开启 WAL 时它也随每次提交写入日志
+type meta struct {
+	numPages uint32 // 文件中的页数（含元数据页）
+	freeHead PageID // 空闲链表头，空闲页的前 4 个字节指向下一个空闲页
+	root     PageID // 上层数据结构的根页（例如 B+tree 的根节点）
+}
+
+// Stats 统计真实发生的页 I/O
+type Stats struct {
+	PageReads  int64
+	PageWrites int64
+	Allocs     int64
+	Frees      int64
+}
+
+// Open 打开（或创建）页文件
+func Open(path string) (*Pager, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pager{file: f, meta: meta{numPages: 1}}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		// 新文件：写入元数据页
+		if err := p.writeMeta(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return p, nil
+	}
+
+	var buf [PageSize]byte
+	if _, err := f.ReadAt(buf[:], 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if string(buf[:4]) != magic {
+		f.Close()
+		return nil, ErrBadMagic
+	}
+	p.numPages = binary.LittleEndian.Uint32(buf[metaNumPages:])
+	p.freeHead = PageID(binary.LittleEndian.Uint32(buf[metaFreeHead:]))
+	p.root = PageID(binary.LittleEndian.Uint32(buf[metaRoot:]))
+	return p, nil
+}
+
+func (p *Pager) writeMeta() error {
+	var buf [PageSize]byte
+	copy(buf[:], magic)
+	binary.LittleEndian.PutUint32(buf[metaNumPages:], p.numPages)
+	binary.LittleEndian.PutUint32(buf[metaFreeHead:], uint32(p.freeHead))
+	binary.LittleEndian.PutUint32(buf[metaRoot:], uint32(p.root))
+	_, err := p.file.WriteAt(buf[:], 0)
+	return err
+}
+
+// ReadPage 把第 id 页读入 buf
+func (p *Pager) ReadPage(id PageID, buf []byte) error {
+	if id == InvalidPage || uint32(id) >= p.numPages {
+		return fmt.Errorf("pager: read of invalid page %d", id)
+	}
+	p.stats.PageReads++
+	n, err := p.file.ReadAt(buf[:PageSize], int64(id)*PageSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	// 新分配但从未写入过的页，文件还没有长到这里
+	clear(buf[n:PageSize])
+	return nil
+}
+
+// WritePage 把 buf 写到第 id 页
+func (p *Pager) WritePage(id PageID, buf []byte) error {
+	if id == InvalidPage || uint32(id) >= p.numPages {
+		return fmt.Errorf("pager: write of invalid page %d", id)
+	}
+	p.stats.PageWrites++
+	_, err := p.file.WriteAt(buf[:PageSize], int64(id)*PageSize)
+	return err
+}
+
+// NumPages 返回文件中的页数（含元数据页）
+func (p *Pager) NumPages() int { return int(p.numPages) }
+
+// FreeHead 返回空闲链表的第一个页
+func (p *Pager) FreeHead() PageID { return p.freeHead }
+
+// Root 返回记录在元数据页中的根页
+func (p *Pager) Root() PageID { return p.root }
+
+// SetRoot 更新根页，在下一次 Sync 时写入元数据页（开启 WAL 时随下一次提交写入日志）
+func (p *Pager) SetRoot(id PageID) { p.root = id }
+
+func (p *Pager) Stats() Stats { return p.stats }
+
+// Sync 写回元数据页并 fsync
+func (p *Pager) Sync() error {
+	if err := p.writeMeta(); err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+func (p *Pager) Close() error {
+	if err := p.Sync(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
@@ -0,0 +1,250 @@
+package pager
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// WAL 是页级别的重做日志（redo log）。
+//
+// 一次提交把本次操作修改过的所有页的完整新内容（after-image）和元数据追加到日志，
+// 最后写一条提交记录并 fsync。缓冲池保证还没写进日志的脏页不会被写回数据文件
+// （no-steal），因此数据文件中要么是旧版本的页，要么是某次已提交的页，
+// 崩溃时即使一次分裂只写回了一半，重新打开时重放已提交的页即可把树恢复到
+// 最后一次提交后的状态；没有提交记录的尾部直接丢弃。
+//
+// 日志记录格式（小端序），每条记录末尾都有覆盖整条记录的 CRC32：
+//
+//	页记录:   'P' | 页号(4) | 页内容(PageSize) | crc(4)
+//	提交记录: 'C' | 页数(4) | 空闲链表头(4) | 根页(4) | crc(4)
+//
+// 检查点（Checkpoint）把所有脏页写回数据文件并 fsync 之后清空日志。
+type WAL struct {
+	file *os.File
+	w    *bufio.Writer
+	size int64
+	sync bool
+
+	// committed 是最后一条提交记录之后的日志大小，之后的内容属于还没提交的事务
+	committed int64
+	// pages 记录每个页最近一次已提交的页记录在日志中的偏移量，pending 是当前事务中的页记录。
+	// 缓冲池撤销一次操作时，还没写回数据文件的页从这里读回上次提交的版本。
+	pages   map[PageID]int64
+	pending map[PageID]int64
+
+	stats WALStats
+}
+
+// WALStats 统计日志的写入和恢复情况
+type WALStats struct {
+	Size        int64 // 当前日志大小
+	Written     int64 // 自打开以来写入日志的总字节数（检查点清空日志后继续累加）
+	PageRecords int64
+	Commits     int64
+	Syncs       int64
+	Checkpoints int64
+	Recovered   int64 // 打开时重放的已提交事务数
+	Discarded   int64 // 打开时丢弃的未提交/损坏尾部字节数
+}
+
+const (
+	recPage   = 'P'
+	recCommit = 'C'
+
+	pageRecordSize   = 1 + 4 + PageSize + 4
+	commitRecordSize = 1 + 12 + 4
+)
+
+// OpenWAL 打开 path 处的日志，把其中已提交的页重放到 p 中，然后清空日志
+func OpenWAL(p *Pager, path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	l := &WAL{
+		file:    f,
+		w:       bufio.NewWriterSize(f, 64*1024),
+		sync:    true,
+		pages:   make(map[PageID]int64),
+		pending: make(map[PageID]int64),
+	}
+	if err := l.recover(p); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetSync 设置每次提交后是否 fsync。关闭后进程崩溃仍然安全
+// （数据已在操作系统的页缓存中），但断电可能丢失最近的提交。
+func (l *WAL) SetSync(sync bool) { l.sync = sync }
+
+func (l *WAL) Stats() WALStats {
+	s := l.stats
+	s.Size = l.size
+	s.Written = s.PageRecords*pageRecordSize + s.Commits*commitRecordSize
+	return s
+}
+
+// recover 读取日志，把最后一条有效提交记录之前的所有页写回数据文件
+func (l *WAL) recover(p *Pager) error {
+	stat, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() == 0 {
+		return nil
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(l.file, 0, stat.Size()))
+	pending := make(map[PageID][]byte) // 当前事务中的页
+	committed := make(map[PageID][]byte)
+	var last *meta
+	var pos, valid int64
+	buf := make([]byte, pageRecordSize)
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		n := pageRecordSize
+		if kind == recCommit {
+			n = commitRecordSize
+		} else if kind != recPage {
+			break
+		}
+		buf[0] = kind
+		if _, err := io.ReadFull(r, buf[1:n]); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(buf[:n-4]) != binary.LittleEndian.Uint32(buf[n-4:]) {
+			break
+		}
+		pos += int64(n)
+
+		if kind == recPage {
+			id := PageID(binary.LittleEndian.Uint32(buf[1:]))
+			pending[id] = append([]byte(nil), buf[5:5+PageSize]...)
+			continue
+		}
+		for id, data := range pending {
+			committed[id] = data
+		}
+		clear(pending)
+		last = &meta{
+			numPages: binary.LittleEndian.Uint32(buf[1:]),
+			freeHead: PageID(binary.LittleEndian.Uint32(buf[5:])),
+			root:     PageID(binary.LittleEndian.Uint32(buf[9:])),
+		}
+		valid = pos
+		l.stats.Recovered++
+	}
+	l.stats.Discarded = stat.Size() - valid
+
+	if last != nil {
+		p.meta = *last
+		for id, data := range committed {
+			if err := p.WritePage(id, data); err != nil {
+				return err
+			}
+		}
+		if err := p.Sync(); err != nil {
+			return err
+		}
+	}
+	return l.reset()
+}
+
+// appendPage 把一页的新内容写入日志缓冲区
+func (l *WAL) appendPage(id PageID, data []byte) error {
+	var hdr [5]byte
+	hdr[0] = recPage
+	binary.LittleEndian.PutUint32(hdr[1:], uint32(id))
+	sum := crc32.Update(crc32.ChecksumIEEE(hdr[:]), crc32.IEEETable, data[:PageSize])
+	var tail [4]byte
+	binary.LittleEndian.PutUint32(tail[:], sum)
+	l.w.Write(hdr[:])
+	l.w.Write(data[:PageSize])
+	if _, err := l.w.Write(tail[:]); err != nil {
+		return err
+	}
+	l.pending[id] = l.size + int64(len(hdr))
+	l.size += pageRecordSize
+	l.stats.PageRecords++
+	return nil
+}
+
+// commit 写入提交记录，把缓冲区刷到文件并（可选地）fsync
+func (l *WAL) commit(m meta) error {
+	var rec [commitRecordSize]byte
+	rec[0] = recCommit
+	binary.LittleEndian.PutUint32(rec[1:], m.numPages)
+	binary.LittleEndian.PutUint32(rec[5:], uint32(m.freeHead))
+	binary.LittleEndian.PutUint32(rec[9:], uint32(m.root))
+	binary.LittleEndian.PutUint32(rec[13:], crc32.ChecksumIEEE(rec[:13]))
+	l.w.Write(rec[:])
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	l.size += commitRecordSize
+	l.stats.Commits++
+	if l.sync {
+		if err := l.file.Sync(); err != nil {
+			return err
+		}
+		l.stats.Syncs++
+	}
+	l.committed = l.size
+	for id, off := range l.pending {
+		l.pages[id] = off
+	}
+	clear(l.pending)
+	return nil
+}
+
+// rollback 丢弃最后一次提交之后写入的记录：清空缓冲区并把文件截断回最后一条提交记录之后，
+// 否则撤销的页记录会在下一次提交时被当成那次事务的一部分重放
+func (l *WAL) rollback() error {
+	l.w.Reset(l.file)
+	clear(l.pending)
+	if err := l.file.Truncate(l.committed); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(l.committed, io.SeekStart); err != nil {
+		return err
+	}
+	l.size = l.committed
+	return nil
+}
+
+// readPage 把 id 最近一次已提交的内容读入 buf，自上次检查点以来没有提交过该页时返回 false
+func (l *WAL) readPage(id PageID, buf []byte) (bool, error) {
+	off, ok := l.pages[id]
+	if !ok {
+		return false, nil
+	}
+	_, err := l.file.ReadAt(buf[:PageSize], off)
+	return true, err
+}
+
+// reset 清空日志，只能在数据文件已经 fsync 之后调用
+func (l *WAL) reset() error {
+	l.w.Reset(l.file)
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	l.size, l.committed = 0, 0
+	clear(l.pages)
+	clear(l.pending)
+	return l.file.Sync()
+}
+
+func (l *WAL) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,57 @@
+package rtree
+
+import "fmt"
+
+// CheckInvariants 检查树的结构：所有叶子在同一层；除根之外每个节点的条目数在 [min, max] 之间，
+// 内部的根至少有两个子节点；父节点中的矩形恰好是子节点的最小外接矩形；项数与 Len 一致
+func (t *Tree) CheckInvariants() error {
+	items, err := t.check(t.root, t.height-1, true)
+	if err != nil {
+		return err
+	}
+	if items != t.size {
+		return fmt.Errorf("rtree: tree holds %d items, Len says %d", items, t.size)
+	}
+	return nil
+}
+
+func (t *Tree) check(n *node, level int, isRoot bool) (int, error) {
+	if n.leaf != (level == 0) {
+		return 0, fmt.Errorf("rtree: node at level %d has leaf=%v", level, n.leaf)
+	}
+	if len(n.entries) > t.max {
+		return 0, fmt.Errorf("rtree: node at level %d has %d entries, max %d", level, len(n.entries), t.max)
+	}
+	if isRoot && !n.leaf && len(n.entries) < 2 {
+		return 0, fmt.Errorf("rtree: internal root has %d children", len(n.entries))
+	}
+	if !isRoot && len(n.entries) < t.min {
+		return 0, fmt.Errorf("rtree: node at level %d has %d entries, min %d", level, len(n.entries), t.min)
+	}
+	if n.leaf {
+		for _, e := range n.entries {
+			if e.child != nil {
+				return 0, fmt.Errorf("rtree: leaf entry %q has a child", e.id)
+			}
+			if e.rect.Min.X > e.rect.Max.X || e.rect.Min.Y > e.rect.Max.Y {
+				return 0, fmt.Errorf("rtree: item %q has inverted rect %v", e.id, e.rect)
+			}
+		}
+		return len(n.entries), nil
+	}
+	total := 0
+	for _, e := range n.entries {
+		if e.child == nil || len(e.child.entries) == 0 {
+			return 0, fmt.Errorf("rtree: empty child at level %d", level)
+		}
+		if got := e.child.mbr(); got != e.rect {
+			return 0, fmt.Errorf("rtree: entry rect %v at level %d, child MBR is %v", e.rect, level, got)
+		}
+		items, err := t.check(e.child, level-1, false)
+		if err != nil {
+			return 0, err
+		}
+		total += items
+	}
+	return total, nil
+}
@@ -0,0 +1,78 @@
+package rtree
+
+import (
+	"fmt"
+	"math"
+)
+
+// Point 是平面上的一个点
+type Point struct {
+	X, Y float64
+}
+
+// Rect 是坐标轴对齐的矩形（包含边界），Min 的两个坐标都不大于 Max。点是 Min == Max 的矩形
+type Rect struct {
+	Min, Max Point
+}
+
+// PointRect 返回只包含 p 的矩形
+func PointRect(p Point) Rect { return Rect{p, p} }
+
+// NewRect 返回以两个对角为顶点的矩形，两个点的顺序无关
+func NewRect(a, b Point) Rect {
+	return Rect{
+		Min: Point{math.Min(a.X, b.X), math.Min(a.Y, b.Y)},
+		Max: Point{math.Max(a.X, b.X), math.Max(a.Y, b.Y)},
+	}
+}
+
+func (r Rect) String() string {
+	return fmt.Sprintf("[(%g, %g), (%g, %g)]", r.Min.X, r.Min.Y, r.Max.X, r.Max.Y)
+}
+
+// Area 返回面积
+func (r Rect) Area() float64 { return (r.Max.X - r.Min.X) * (r.Max.Y - r.Min.Y) }
+
+// Margin 返回半周长，R*-tree 分裂时用它衡量矩形是否接近正方形
+func (r Rect) Margin() float64 { return (r.Max.X - r.Min.X) + (r.Max.Y - r.Min.Y) }
+
+// Center 返回中心点
+func (r Rect) Center() Point { return Point{(r.Min.X + r.Max.X) / 2, (r.Min.Y + r.Max.Y) / 2} }
+
+// Union 返回同时包含 r 和 s 的最小矩形
+func (r Rect) Union(s Rect) Rect {
+	return Rect{
+		Min: Point{math.Min(r.Min.X, s.Min.X), math.Min(r.Min.Y, s.Min.Y)},
+		Max: Point{math.Max(r.Max.X, s.Max.X), math.Max(r.Max.Y, s.Max.Y)},
+	}
+}
+
+// Intersects 报告 r 与 s 是否相交（边界接触也算）
+func (r Rect) Intersects(s Rect) bool {
+	return r.Min.X <= s.Max.X && s.Min.X <= r.Max.X && r.Min.Y <= s.Max.Y && s.Min.Y <= r.Max.Y
+}
+
+// Contains 报告 s 是否完全在 r 中
+func (r Rect) Contains(s Rect) bool {
+	return r.Min.X <= s.Min.X && s.Max.X <= r.Max.X && r.Min.Y <= s.Min.Y && s.Max.Y <= r.Max.Y
+}
+
+// Overlap 返回 r 与 s 相交部分的面积
+func (r Rect) Overlap(s Rect) float64 {
+	w := math.Min(r.Max.X, s.Max.X) - math.Max(r.Min.X, s.Min.X)
+	h := math.Min(r.Max.Y, s.Max.Y) - math.Max(r.Min.Y, s.Min.Y)
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+	return w * h
+}
+
+// Enlargement 返回把 r 扩大到包含 s 所增加的面积
+func (r Rect) Enlargement(s Rect) float64 { return r.Union(s).Area() - r.Area() }
+
+// Dist 返回 p 到 r 的最短欧氏距离，p 在 r 中时为 0
+func (r Rect) Dist(p Point) float64 {
+	dx := math.Max(0, math.Max(r.Min.X-p.X, p.X-r.Max.X))
+	dy := math.Max(0, math.Max(r.Min.Y-p.Y, p.Y-r.Max.Y))
+	return math.Hypot(dx, dy)
+}
@@ -0,0 +1,478 @@
+// Package rtree 实现内存中的 R-tree 空间索引：每个节点保存若干个矩形，内部节点的矩形是子树的最小外接矩形（MBR），
+// 查询时只进入与查询区域相交的子树。支持插入、删除、矩形范围查询和 k 近邻查询。
+//
+// 节点溢出时的分裂策略可选 Guttman 的二次分裂（Quadratic）或 R*-tree（RStar）。R*-tree 选择子树时
+// 在叶子上一层优先减少重叠，分裂时先选周长最小的轴再选重叠最小的切分，并且每层第一次溢出时先把离中心
+// 最远的 30% 条目重新插入而不是分裂，得到的节点更方正、重叠更少，查询访问的节点更少。
+package rtree
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// Item 是索引中的一项：一个矩形（点用 PointRect）和调用者给定的 ID
+type Item struct {
+	Rect Rect
+	ID   string
+}
+
+// SplitStrategy 是节点溢出时的处理方式
+type SplitStrategy int
+
+const (
+	RStar     SplitStrategy = iota // R*-tree：重叠最小的子树选择、按轴切分和强制重新插入
+	Quadratic                      // Guttman 的二次分裂
+)
+
+func (s SplitStrategy) String() string {
+	if s == Quadratic {
+		return "Quadratic"
+	}
+	return "R*"
+}
+
+// entry 是节点中的一项：叶子中是数据项，内部节点中是子节点及其 MBR
+type entry struct {
+	rect  Rect
+	child *node
+	id    string
+}
+
+type node struct {
+	leaf    bool
+	entries []entry
+}
+
+func (n *node) mbr() Rect {
+	r := n.entries[0].rect
+	for _, e := range n.entries[1:] {
+		r = r.Union(e.rect)
+	}
+	return r
+}
+
+// Tree 是一棵 R-tree。所有叶子在同一层，叶子的层号为 0，根的层号为 height-1
+type Tree struct {
+	root     *node
+	height   int
+	size     int
+	max, min int
+	strategy SplitStrategy
+
+	visits     int64
+	splits     int
+	reinserted int
+}
+
+// Stats 是树的统计信息
+type Stats struct {
+	Items      int
+	Height     int
+	Nodes      int
+	Leaves     int
+	AvgFill    float64 // 平均每个节点的条目数 / 最大条目数
+	NodeVisits int64   // 查询累计访问的节点数
+	Splits     int     // 节点分裂次数
+	Reinserted int     // R*-tree 强制重新插入的条目数
+}
+
+// New 创建每个节点最多 maxEntries 个条目的 R-tree（至少 4），除根之外每个节点至少 40% 满
+func New(maxEntries int, strategy SplitStrategy) *Tree {
+	if maxEntries < 4 {
+		maxEntries = 4
+	}
+	return &Tree{
+		root:     &node{leaf: true},
+		height:   1,
+		max:      maxEntries,
+		min:      max(2, maxEntries*4/10),
+		strategy: strategy,
+	}
+}
+
+// Len 返回项数
+func (t *Tree) Len() int { return t.size }
+
+// Height 返回树高（只有根叶子时为 1）
+func (t *Tree) Height() int { return t.height }
+
+// Bounds 返回所有项的最小外接矩形，树为空时返回零值
+func (t *Tree) Bounds() Rect {
+	if len(t.root.entries) == 0 {
+		return Rect{}
+	}
+	return t.root.mbr()
+}
+
+// Insert 插入一项。同一个矩形和 ID 可以插入多次，它们是不同的项
+func (t *Tree) Insert(item Item) {
+	t.insert(entry{rect: item.Rect, id: item.ID}, 0, make(map[int]bool))
+	t.size++
+}
+
+// insert 把 e 放进层号为 level 的某个节点（数据项的 level 是 0，重新插入的子树是它原来所在的层）。
+// reinserted 记录本次插入中已经做过强制重新插入的层，每层只做一次，避免无限循环
+func (t *Tree) insert(e entry, level int, reinserted map[int]bool) {
+	// 从根往下选择子树，记录路径以及每个节点在父节点中的下标
+	path := []*node{t.root}
+	var idx []int
+	for n := t.root; t.height-len(path) > level; {
+		i := t.chooseSubtree(n, e.rect, t.height-len(path))
+		n = n.entries[i].child
+		path = append(path, n)
+		idx = append(idx, i)
+	}
+	path[len(path)-1].entries = append(path[len(path)-1].entries, e)
+
+	// 自底向上处理溢出并更新父节点中的 MBR
+	for i := len(path) - 1; i >= 0; i-- {
+		n := path[i]
+		nodeLevel := t.height - 1 - i
+		if len(n.entries) > t.max {
+			if t.strategy == RStar && i > 0 && !reinserted[nodeLevel] {
+				reinserted[nodeLevel] = true
+				removed := t.pickReinsert(n)
+				for j := i; j > 0; j-- {
+					path[j-1].entries[idx[j-1]].rect = path[j].mbr()
+				}
+				// 由近到远重新插入（close reinsert）
+				for j := len(removed) - 1; j >= 0; j-- {
+					t.reinserted++
+					t.insert(removed[j], nodeLevel, reinserted)
+				}
+				return
+			}
+			nn := t.split(n)
+			if i == 0 {
+				t.root = &node{entries: []entry{{rect: n.mbr(), child: n}, {rect: nn.mbr(), child: nn}}}
+				t.height++
+				return
+			}
+			path[i-1].entries = append(path[i-1].entries, entry{rect: nn.mbr(), child: nn})
+		}
+		if i > 0 {
+			path[i-1].entries[idx[i-1]].rect = n.mbr()
+		}
+	}
+}
+
+// chooseSubtree 在 n（层号 level > 0）中选择放 r 的子节点：面积增加最少者，相同时面积最小者。
+// R*-tree 在子节点是叶子时改为重叠面积增加最少者优先
+func (t *Tree) chooseSubtree(n *node, r Rect, level int) int {
+	best := 0
+	bestOverlap, bestEnlarge, bestArea := math.Inf(1), math.Inf(1), math.Inf(1)
+	for i, e := range n.entries {
+		overlap := 0.0
+		if t.strategy == RStar && level == 1 {
+			grown := e.rect.Union(r)
+			for j, o := range n.entries {
+				if j != i {
+					overlap += grown.Overlap(o.rect) - e.rect.Overlap(o.rect)
+				}
+			}
+		}
+		enlarge, area := e.rect.Enlargement(r), e.rect.Area()
+		if overlap < bestOverlap || overlap == bestOverlap && (enlarge < bestEnlarge || enlarge == bestEnlarge && area < bestArea) {
+			best, bestOverlap, bestEnlarge, bestArea = i, overlap, enlarge, area
+		}
+	}
+	return best
+}
+
+// pickReinsert 从溢出的节点中取出中心离节点中心最远的 30% 条目，按距离从远到近返回
+func (t *Tree) pickReinsert(n *node) []entry {
+	c := n.mbr().Center()
+	dist := func(e entry) float64 {
+		p := e.rect.Center()
+		return math.Hypot(p.X-c.X, p.Y-c.Y)
+	}
+	sort.SliceStable(n.entries, func(i, j int) bool { return dist(n.entries[i]) > dist(n.entries[j]) })
+	p := max(1, len(n.entries)*3/10)
+	removed := append([]entry(nil), n.entries[:p]...)
+	n.entries = append(n.entries[:0], n.entries[p:]...)
+	return removed
+}
+
+// split 把溢出的节点 n 分成两个，n 保留一组，返回另一组组成的新节点
+func (t *Tree) split(n *node) *node {
+	t.splits++
+	var a, b []entry
+	if t.strategy == RStar {
+		a, b = t.splitRStar(n.entries)
+	} else {
+		a, b = t.splitQuadratic(n.entries)
+	}
+	n.entries = a
+	return &node{leaf: n.leaf, entries: b}
+}
+
+func mbrOf(entries []entry) Rect {
+	r := entries[0].rect
+	for _, e := range entries[1:] {
+		r = r.Union(e.rect)
+	}
+	return r
+}
+
+// splitRStar 对两个轴分别按下界、上界排序，考察所有满足最小填充的切分位置：
+// 先选所有切分的两组周长之和最小的轴，再在这个轴上选两组重叠最小（其次面积和最小）的切分
+func (t *Tree) splitRStar(entries []entry) ([]entry, []entry) {
+	sorts := func(axis int) [][]entry {
+		key := func(e entry, upper bool) float64 {
+			switch {
+			case axis == 0 && !upper:
+				return e.rect.Min.X
+			case axis == 0:
+				return e.rect.Max.X
+			case !upper:
+				return e.rect.Min.Y
+			}
+			return e.rect.Max.Y
+		}
+		var out [][]entry
+		for _, upper := range []bool{false, true} {
+			s := append([]entry(nil), entries...)
+			sort.SliceStable(s, func(i, j int) bool { return key(s[i], upper) < key(s[j], upper) })
+			out = append(out, s)
+		}
+		return out
+	}
+	bestAxis, bestMargin := 0, math.Inf(1)
+	for axis := 0; axis < 2; axis++ {
+		margin := 0.0
+		for _, s := range sorts(axis) {
+			for k := t.min; k <= len(s)-t.min; k++ {
+				margin += mbrOf(s[:k]).Margin() + mbrOf(s[k:]).Margin()
+			}
+		}
+		if margin < bestMargin {
+			bestAxis, bestMargin = axis, margin
+		}
+	}
+	var bestA, bestB []entry
+	bestOverlap, bestArea := math.Inf(1), math.Inf(1)
+	for _, s := range sorts(bestAxis) {
+		for k := t.min; k <= len(s)-t.min; k++ {
+			ra, rb := mbrOf(s[:k]), mbrOf(s[k:])
+			overlap, area := ra.Overlap(rb), ra.Area()+rb.Area()
+			if overlap < bestOverlap || overlap == bestOverlap && area < bestArea {
+				bestOverlap, bestArea = overlap, area
+				bestA, bestB = append([]entry(nil), s[:k]...), append([]entry(nil), s[k:]...)
+			}
+		}
+	}
+	return bestA, bestB
+}
+
+// splitQuadratic 是 Guttman 的二次分裂：选放在一起最浪费面积的两项作为两组的种子，
+// 然后每次把对两组偏好差别最大的项放进面积增加较少的一组，某组必须拿走剩下所有项才能满足最小填充时直接分给它
+func (t *Tree) splitQuadratic(entries []entry) ([]entry, []entry) {
+	s1, s2 := 0, 1
+	worst := math.Inf(-1)
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			d := entries[i].rect.Union(entries[j].rect).Area() - entries[i].rect.Area() - entries[j].rect.Area()
+			if d > worst {
+				worst, s1, s2 = d, i, j
+			}
+		}
+	}
+	a, b := []entry{entries[s1]}, []entry{entries[s2]}
+	ra, rb := entries[s1].rect, entries[s2].rect
+	var rest []entry
+	for i, e := range entries {
+		if i != s1 && i != s2 {
+			rest = append(rest, e)
+		}
+	}
+	for len(rest) > 0 {
+		if len(a)+len(rest) == t.min {
+			a = append(a, rest...)
+			break
+		}
+		if len(b)+len(rest) == t.min {
+			b = append(b, rest...)
+			break
+		}
+		pick, diff := 0, -1.0
+		for i, e := range rest {
+			if d := math.Abs(ra.Enlargement(e.rect) - rb.Enlargement(e.rect)); d > diff {
+				pick, diff = i, d
+			}
+		}
+		e := rest[pick]
+		rest = append(rest[:pick], rest[pick+1:]...)
+		da, db := ra.Enlargement(e.rect), rb.Enlargement(e.rect)
+		if da < db || da == db && (ra.Area() < rb.Area() || ra.Area() == rb.Area() && len(a) <= len(b)) {
+			a, ra = append(a, e), ra.Union(e.rect)
+		} else {
+			b, rb = append(b, e), rb.Union(e.rect)
+		}
+	}
+	return a, b
+}
+
+// Delete 删除矩形和 ID 都相同的一项，返回是否找到
+func (t *Tree) Delete(item Item) bool {
+	path, idx, i := t.findLeaf(t.root, item, nil, nil)
+	if i < 0 {
+		return false
+	}
+	leaf := path[len(path)-1]
+	leaf.entries = append(leaf.entries[:i], leaf.entries[i+1:]...)
+	t.size--
+	t.condense(path, idx)
+	return true
+}
+
+// findLeaf 找到包含 item 的叶子，返回从根到叶子的路径、每个节点在父节点中的下标和 item 在叶子中的下标
+func (t *Tree) findLeaf(n *node, item Item, path []*node, idx []int) ([]*node, []int, int) {
+	path = append(path, n)
+	for i, e := range n.entries {
+		if n.leaf {
+			if e.id == item.ID && e.rect == item.Rect {
+				return path, idx, i
+			}
+			continue
+		}
+		if e.rect.Contains(item.Rect) {
+			if p, x, j := t.findLeaf(e.child, item, path, append(idx, i)); j >= 0 {
+				return p, x, j
+			}
+		}
+	}
+	return nil, nil, -1
+}
+
+// condense 在删除之后自底向上处理：不足最小填充的节点从父节点中摘掉，它的条目稍后按原来的层重新插入；
+// 其余节点更新在父节点中的 MBR。最后根只剩一个子节点时让子节点成为新的根
+func (t *Tree) condense(path []*node, idx []int) {
+	type orphan struct {
+		e     entry
+		level int
+	}
+	var orphans []orphan
+	for i := len(path) - 1; i > 0; i-- {
+		n, parent := path[i], path[i-1]
+		if len(n.entries) < t.min {
+			parent.entries = append(parent.entries[:idx[i-1]], parent.entries[idx[i-1]+1:]...)
+			for _, e := range n.entries {
+				orphans = append(orphans, orphan{e, t.height - 1 - i})
+			}
+		} else {
+			parent.entries[idx[i-1]].rect = n.mbr()
+		}
+	}
+	for _, o := range orphans {
+		t.insert(o.e, o.level, make(map[int]bool))
+	}
+	for !t.root.leaf && len(t.root.entries) == 1 {
+		t.root = t.root.entries[0].child
+		t.height--
+	}
+	if !t.root.leaf && len(t.root.entries) == 0 {
+		t.root, t.height = &node{leaf: true}, 1
+	}
+}
+
+// Search 对每个与 r 相交的项调用 fn，fn 返回 false 时停止
+func (t *Tree) Search(r Rect, fn func(Item) bool) {
+	t.search(t.root, r, fn)
+}
+
+func (t *Tree) search(n *node, r Rect, fn func(Item) bool) bool {
+	t.visits++
+	for _, e := range n.entries {
+		if !e.rect.Intersects(r) {
+			continue
+		}
+		if n.leaf {
+			if !fn(Item{Rect: e.rect, ID: e.id}) {
+				return false
+			}
+		} else if !t.search(e.child, r, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Neighbor 是近邻查询的一个结果
+type Neighbor struct {
+	Item
+	Dist float64 // 到查询点的距离（矩形取最近的点）
+}
+
+// Nearest 返回离 p 最近的 k 项，按距离从近到远排列，距离相同时按 ID。
+// 最优优先搜索：所有待访问的节点和项放在按距离排序的优先队列中，每次取出最近的一个，
+// 节点到 p 的距离不大于它子树中任何一项的距离，所以取出的项一定是剩下的项中最近的
+func (t *Tree) Nearest(p Point, k int) []Neighbor {
+	var out []Neighbor
+	if k <= 0 || t.size == 0 {
+		return out
+	}
+	pq := &queue{{dist: t.root.mbr().Dist(p), node: t.root}}
+	for pq.Len() > 0 && len(out) < k {
+		c := heap.Pop(pq).(candidate)
+		if c.node == nil {
+			out = append(out, Neighbor{Item: Item{Rect: c.e.rect, ID: c.e.id}, Dist: c.dist})
+			continue
+		}
+		t.visits++
+		for _, e := range c.node.entries {
+			heap.Push(pq, candidate{dist: e.rect.Dist(p), node: e.child, e: e})
+		}
+	}
+	return out
+}
+
+type candidate struct {
+	dist float64
+	node *node // nil 表示数据项 e
+	e    entry
+}
+
+// queue 是按距离排序的最小堆；距离相同时节点先于数据项出队，数据项按 ID 排序，让结果是确定的
+type queue []candidate
+
+func (q queue) Len() int { return len(q) }
+func (q queue) Less(i, j int) bool {
+	if q[i].dist != q[j].dist {
+		return q[i].dist < q[j].dist
+	}
+	if (q[i].node == nil) != (q[j].node == nil) {
+		return q[i].node != nil
+	}
+	return q[i].node == nil && q[i].e.id < q[j].e.id
+}
+func (q queue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x any)   { *q = append(*q, x.(candidate)) }
+func (q *queue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// Stats 返回统计信息
+func (t *Tree) Stats() Stats {
+	st := Stats{Items: t.size, Height: t.height, NodeVisits: t.visits, Splits: t.splits, Reinserted: t.reinserted}
+	var entries int
+	var walk func(n *node)
+	walk = func(n *node) {
+		st.Nodes++
+		entries += len(n.entries)
+		if n.leaf {
+			st.Leaves++
+			return
+		}
+		for _, e := range n.entries {
+			walk(e.child)
+		}
+	}
+	walk(t.root)
+	st.AvgFill = float64(entries) / float64(st.Nodes*t.max)
+	return st
+}
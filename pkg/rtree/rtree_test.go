@@ -0,0 +1,144 @@
+package rtree
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// randomItemRect 返回整数坐标上的点或小矩形，坐标范围小，让相交、相同的矩形和相等的距离经常出现
+func randomItemRect(rng *rand.Rand) Rect {
+	p := Point{float64(rng.Intn(100)), float64(rng.Intn(100))}
+	if rng.Intn(2) == 0 {
+		return PointRect(p)
+	}
+	return NewRect(p, Point{p.X + float64(rng.Intn(8)), p.Y + float64(rng.Intn(8))})
+}
+
+// linearSearch 逐项检查 model，返回与 r 相交的项的 ID（排好序）
+func linearSearch(model map[string]Rect, r Rect) []string {
+	var ids []string
+	for id, rect := range model {
+		if rect.Intersects(r) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// linearNearest 逐项计算距离，返回按距离、距离相同时按 ID 排序的前 k 项
+func linearNearest(model map[string]Rect, p Point, k int) []Neighbor {
+	var all []Neighbor
+	for id, rect := range model {
+		all = append(all, Neighbor{Item{rect, id}, rect.Dist(p)})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Dist != all[j].Dist {
+			return all[i].Dist < all[j].Dist
+		}
+		return all[i].ID < all[j].ID
+	})
+	return all[:min(k, len(all))]
+}
+
+// TestMatchesLinearScan 对两种分裂策略随机插入和删除，每次操作后检查树的结构，
+// 并让 Search 和 Nearest 的结果与逐项扫描比较
+func TestMatchesLinearScan(t *testing.T) {
+	for _, strategy := range []SplitStrategy{RStar, Quadratic} {
+		for _, maxEntries := range []int{4, 9} {
+			t.Run(fmt.Sprintf("%v/%d", strategy, maxEntries), func(t *testing.T) {
+				testMatchesLinearScan(t, strategy, maxEntries)
+			})
+		}
+	}
+}
+
+func testMatchesLinearScan(t *testing.T, strategy SplitStrategy, maxEntries int) {
+	rng := rand.New(rand.NewSource(int64(maxEntries)))
+	tree := New(maxEntries, strategy)
+	model := make(map[string]Rect)
+	var ids []string // model 中的 ID，便于随机挑选
+	next := 0
+	for i := 0; i < 4000; i++ {
+		switch op := rng.Intn(10); {
+		case op < 5 || len(ids) < 20:
+			id := fmt.Sprint(next)
+			next++
+			r := randomItemRect(rng)
+			tree.Insert(Item{r, id})
+			model[id] = r
+			ids = append(ids, id)
+		case op < 8:
+			j := rng.Intn(len(ids))
+			id := ids[j]
+			// 矩形相同但 ID 不同的项不能被删掉
+			if tree.Delete(Item{model[id], id + "x"}) {
+				t.Fatalf("op %d: deleted %sx, which was never inserted", i, id)
+			}
+			if !tree.Delete(Item{model[id], id}) {
+				t.Fatalf("op %d: Delete(%s %v) = false", i, id, model[id])
+			}
+			delete(model, id)
+			ids[j] = ids[len(ids)-1]
+			ids = ids[:len(ids)-1]
+		case op < 9:
+			q := randomItemRect(rng)
+			q.Max.X += float64(rng.Intn(30))
+			q.Max.Y += float64(rng.Intn(30))
+			var got []string
+			tree.Search(q, func(it Item) bool {
+				if model[it.ID] != it.Rect {
+					t.Fatalf("op %d: Search returned %v, model has %v", i, it, model[it.ID])
+				}
+				got = append(got, it.ID)
+				return true
+			})
+			sort.Strings(got)
+			if want := linearSearch(model, q); !slices.Equal(got, want) {
+				t.Fatalf("op %d: Search(%v) = %v, want %v", i, q, got, want)
+			}
+			n := 0
+			tree.Search(q, func(Item) bool { n++; return false })
+			if n != min(1, len(got)) {
+				t.Fatalf("op %d: Search kept going after fn returned false (%d calls)", i, n)
+			}
+		default:
+			p := Point{rng.Float64()*120 - 10, rng.Float64()*120 - 10}
+			if rng.Intn(2) == 0 {
+				p = Point{math.Round(p.X), math.Round(p.Y)}
+			}
+			k := 1 + rng.Intn(15)
+			got := tree.Nearest(p, k)
+			if want := linearNearest(model, p, k); !slices.Equal(got, want) {
+				t.Fatalf("op %d: Nearest(%v, %d) =\n%v\nwant\n%v", i, p, k, got, want)
+			}
+			continue
+		}
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("op %d: %v", i, err)
+		}
+		if tree.Len() != len(model) {
+			t.Fatalf("op %d: Len = %d, want %d", i, tree.Len(), len(model))
+		}
+	}
+
+	// 全部删除后回到空的根叶子
+	for _, id := range ids {
+		if !tree.Delete(Item{model[id], id}) {
+			t.Fatalf("Delete(%s) = false while draining", id)
+		}
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("draining: %v", err)
+		}
+	}
+	if tree.Len() != 0 || tree.Height() != 1 || tree.Bounds() != (Rect{}) {
+		t.Fatalf("empty tree: Len %d, Height %d, Bounds %v", tree.Len(), tree.Height(), tree.Bounds())
+	}
+	if got := tree.Nearest(Point{}, 3); len(got) != 0 {
+		t.Fatalf("Nearest on an empty tree = %v", got)
+	}
+}
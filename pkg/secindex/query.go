@@ -0,0 +1,329 @@
+package secindex
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// Primary 是 Plan.Index 中表示主键的名字
+const Primary = "PRIMARY"
+
+// Query 是一个简单的查询：若干列上的等值条件，加上至多一列上的范围条件
+type Query struct {
+	Where   Row      // 等值条件：列 → 值
+	Range   string   // 可选：范围条件所在的列
+	From    any      // Range 列的下界（含），nil 表示不限
+	To      any      // Range 列的上界（不含），nil 表示不限
+	Columns []string // 要返回的列，nil 表示所有列
+	Index   string   // 指定使用的索引（Primary 表示主键），为空时由 Find 选择
+}
+
+// Plan 描述 Find 如何执行一个查询，相当于 EXPLAIN 的输出
+type Plan struct {
+	Index    string   // 使用的索引，Primary 是主键；FullScan 为 true 时是全表扫描
+	FullScan bool     // 没有可用的前缀，扫描整个主存储或索引
+	Prefix   []string // 变成扫描范围的等值条件列（索引键的最左前缀）
+	Range    bool     // 范围条件也变成了扫描范围（它是紧接着前缀的那一列）
+	Covering bool     // 索引项包含所有需要的列，不用回表读主存储
+	Filter   []string // 扫描之后逐项过滤的条件列
+}
+
+func (p Plan) String() string {
+	var b strings.Builder
+	switch {
+	case p.FullScan && p.Index == Primary:
+		b.WriteString("全表扫描")
+	case p.FullScan:
+		fmt.Fprintf(&b, "扫描整个索引 %s", p.Index)
+	default:
+		fmt.Fprintf(&b, "索引 %s", p.Index)
+		var parts []string
+		if len(p.Prefix) > 0 {
+			parts = append(parts, "前缀 ("+strings.Join(p.Prefix, ", ")+")")
+		}
+		if p.Range {
+			parts = append(parts, "范围")
+		}
+		b.WriteString(" " + strings.Join(parts, " + "))
+	}
+	if p.Index != Primary {
+		if p.Covering {
+			b.WriteString("，覆盖索引（不回表）")
+		} else {
+			b.WriteString("，回表")
+		}
+	}
+	if len(p.Filter) > 0 {
+		fmt.Fprintf(&b, "，过滤 %s", strings.Join(p.Filter, ", "))
+	}
+	return b.String()
+}
+
+// path 是一种访问路径：主存储或一个二级索引
+type path struct {
+	name  string
+	ix    *Index          // nil 表示主存储
+	key   []string        // 键由这些列依次编码而成
+	avail map[string]bool // 不回表就能得到的列
+}
+
+func (t *Table) paths() []path {
+	all := make(map[string]bool)
+	for c := range t.types {
+		all[c] = true
+	}
+	paths := []path{{name: Primary, key: t.schema.Key, avail: all}}
+	for _, ix := range t.indexes {
+		key := append(slices.Clone(ix.def.Columns), t.schema.Key...)
+		p := path{name: ix.def.Name, ix: ix, key: key, avail: make(map[string]bool)}
+		for _, c := range append(slices.Clone(key), ix.def.Include...) {
+			p.avail[c] = true
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// plan 为 q 选择访问路径：等值条件覆盖的最左前缀最长者优先，其次是能否用上范围条件，
+// 最后是能否覆盖查询。没有任何索引的前缀可用时全表扫描主存储
+func (t *Table) plan(q Query) (Plan, path, error) {
+	var best Plan
+	var bestPath path
+	bestScore := -1
+	for _, p := range t.paths() {
+		if q.Index != "" && q.Index != p.name {
+			continue
+		}
+		pl := Plan{Index: p.name, Covering: true}
+		for _, c := range p.key {
+			if _, ok := q.Where[c]; !ok {
+				break
+			}
+			pl.Prefix = append(pl.Prefix, c)
+		}
+		n := len(pl.Prefix)
+		pl.Range = q.Range != "" && n < len(p.key) && p.key[n] == q.Range
+		pl.FullScan = n == 0 && !pl.Range
+		for _, c := range t.needed(q) {
+			if !p.avail[c] {
+				pl.Covering = false
+			}
+		}
+		for c := range q.Where {
+			if !slices.Contains(pl.Prefix, c) {
+				pl.Filter = append(pl.Filter, c)
+			}
+		}
+		if q.Range != "" && !pl.Range {
+			pl.Filter = append(pl.Filter, q.Range)
+		}
+		slices.Sort(pl.Filter)
+
+		score := 4 * n
+		if pl.Range {
+			score += 2
+		}
+		if pl.Covering {
+			score++
+		}
+		if pl.FullScan && p.ix != nil && q.Index == "" {
+			continue // 不指定索引时，没有前缀可用的二级索引不如直接扫描主存储
+		}
+		if score > bestScore {
+			best, bestPath, bestScore = pl, p, score
+		}
+	}
+	if bestScore < 0 {
+		return Plan{}, path{}, fmt.Errorf("secindex: no index named %q", q.Index)
+	}
+	return best, bestPath, nil
+}
+
+// needed 返回查询需要读到的列：要返回的列和所有条件列
+func (t *Table) needed(q Query) []string {
+	cols := q.Columns
+	if cols == nil {
+		cols = t.columnNames()
+	}
+	cols = slices.Clone(cols)
+	for c := range q.Where {
+		cols = append(cols, c)
+	}
+	if q.Range != "" {
+		cols = append(cols, q.Range)
+	}
+	return cols
+}
+
+// Explain 返回 Find 执行 q 时会使用的计划
+func (t *Table) Explain(q Query) (Plan, error) {
+	if err := t.normalizeQuery(&q); err != nil {
+		return Plan{}, err
+	}
+	pl, _, err := t.plan(q)
+	return pl, err
+}
+
+// normalizeQuery 检查条件和返回列都存在、值的类型正确
+func (t *Table) normalizeQuery(q *Query) error {
+	where := make(Row, len(q.Where))
+	for c, v := range q.Where {
+		typ, ok := t.types[c]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrNoColumn, c)
+		}
+		v, err := normalize(typ, v)
+		if err != nil {
+			return fmt.Errorf("column %q: %w", c, err)
+		}
+		where[c] = v
+	}
+	q.Where = where
+	if q.Range != "" {
+		typ, ok := t.types[q.Range]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrNoColumn, q.Range)
+		}
+		for _, bound := range []*any{&q.From, &q.To} {
+			if *bound == nil {
+				continue
+			}
+			v, err := normalize(typ, *bound)
+			if err != nil {
+				return fmt.Errorf("column %q: %w", q.Range, err)
+			}
+			*bound = v
+		}
+	}
+	return t.checkColumns(q.Columns)
+}
+
+// Find 执行查询，对每个匹配的行调用 fn（只包含 q.Columns 中的列），fn 返回 false 时停止。
+// 结果按所用索引的键排序。返回实际使用的计划
+func (t *Table) Find(q Query, fn func(row Row) bool) (Plan, error) {
+	if err := t.normalizeQuery(&q); err != nil {
+		return Plan{}, err
+	}
+	pl, p, err := t.plan(q)
+	if err != nil {
+		return pl, err
+	}
+
+	// 等值前缀编码成扫描范围的公共前缀，范围条件再收窄它
+	prefix, err := t.appendValues(nil, pl.Prefix, q.Where)
+	if err != nil {
+		return pl, err
+	}
+	start, end := prefix, kv.PrefixEnd(prefix)
+	if len(prefix) == 0 {
+		start = nil
+	}
+	if pl.Range {
+		if q.From != nil {
+			start, _ = t.appendValues(kv.Clone(prefix), []string{q.Range}, Row{q.Range: q.From})
+		}
+		if q.To != nil {
+			end, _ = t.appendValues(kv.Clone(prefix), []string{q.Range}, Row{q.Range: q.To})
+		}
+	}
+
+	store := t.primary
+	if p.ix != nil {
+		store = p.ix.store
+	}
+	scanErr := store.Scan(start, end, func(key, value []byte) bool {
+		var row Row
+		if p.ix == nil {
+			t.stats.TableRows++
+			row, err = t.decodeRow(value)
+		} else {
+			t.stats.IndexEntries++
+			row, err = p.ix.decodeEntry(key, value)
+			// 先用索引项中已有的列过滤，被过滤掉的项不用回表
+			if err == nil && !t.match(q, row, true) {
+				return true
+			}
+			if err == nil && !pl.Covering {
+				row, err = t.lookup(row)
+			}
+		}
+		if err != nil {
+			return false
+		}
+		if row == nil || !t.match(q, row, false) {
+			return true
+		}
+		return fn(project(row, q.Columns))
+	})
+	if err == nil {
+		err = scanErr
+	}
+	return pl, err
+}
+
+// lookup 用索引项中的主键回表读取整行；索引项没有对应的行时返回 nil
+func (t *Table) lookup(entry Row) (Row, error) {
+	pk, err := t.primaryKey(entry)
+	if err != nil {
+		return nil, err
+	}
+	value, ok, err := t.primary.Get(pk)
+	if err != nil || !ok {
+		return nil, err
+	}
+	t.stats.TableRows++
+	return t.decodeRow(value)
+}
+
+// match 检查 row 是否满足所有条件；partial 为 true 时 row 只含部分列，缺少的列视为满足
+func (t *Table) match(q Query, row Row, partial bool) bool {
+	for c, want := range q.Where {
+		v, ok := row[c]
+		if !ok && partial {
+			continue
+		}
+		if !ok || compare(v, want) != 0 {
+			return false
+		}
+	}
+	if q.Range != "" {
+		v, ok := row[q.Range]
+		if !ok {
+			return partial
+		}
+		if (q.From != nil && compare(v, q.From) < 0) || (q.To != nil && compare(v, q.To) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare 比较两个同一类型的值
+func compare(a, b any) int {
+	if x, ok := a.(string); ok {
+		return strings.Compare(x, b.(string))
+	}
+	x, y := a.(int64), b.(int64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// project 返回只包含 cols 的行，cols 为 nil 时返回整行
+func project(row Row, cols []string) Row {
+	if cols == nil {
+		return row
+	}
+	out := make(Row, len(cols))
+	for _, c := range cols {
+		out[c] = row[c]
+	}
+	return out
+}
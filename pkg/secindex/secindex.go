@@ -0,0 +1,441 @@
+// Package secindex 在任意 kv.KV 引擎之上实现了一张带二级索引的表：
+// 主存储（primary）按主键保存整行，每个二级索引是另一个 kv.KV，
+// 其中的键是索引列按 kv 包中保持顺序的编码拼成的复合键，后面再接上主键。
+//
+// 复合键按 (列1, 列2, ...) 排序，所以只有“最左前缀”上的等值条件（以及紧接着的一列上的范围条件）
+// 能变成一次连续的范围扫描；跳过第一列的条件只能全表扫描。
+// 索引可以用 Include 额外存放几列的值（覆盖索引）：查询需要的列都在索引项中时不用回表读主存储。
+//
+// 表和索引分别写入不同的 kv.KV，一次 Insert 不是原子的：进程在中途崩溃会让索引与主存储不一致
+// （这正是 DDIA 第 7 章中事务要解决的问题）。CheckInvariants 可以发现这种不一致。
+package secindex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ddia-labs/pkg/kv"
+)
+
+var (
+	ErrNoColumn     = errors.New("secindex: no such column")
+	ErrType         = errors.New("secindex: value has the wrong type for column")
+	ErrMissingValue = errors.New("secindex: row is missing a column")
+)
+
+// Type 是列的类型
+type Type int
+
+const (
+	Int    Type = iota // int64，也接受 int
+	String             // string
+)
+
+func (t Type) String() string {
+	if t == Int {
+		return "INT"
+	}
+	return "TEXT"
+}
+
+// Column 是表中的一列
+type Column struct {
+	Name string
+	Type Type
+}
+
+// Schema 描述表的列和主键，Key 中的列按顺序组成复合主键
+type Schema struct {
+	Columns []Column
+	Key     []string
+}
+
+// Row 是一行数据（或查询返回的部分列），列名 → 值（int64 或 string）
+type Row map[string]any
+
+// IndexDef 定义一个二级索引
+type IndexDef struct {
+	Name    string
+	Columns []string // 索引键的列，按顺序组成复合键
+	Include []string // 覆盖列：值直接存放在索引项中，不参与排序
+}
+
+// Table 是一张表：一个主存储和若干二级索引
+type Table struct {
+	schema  Schema
+	types   map[string]Type
+	primary kv.KV
+	indexes []*Index
+	stats   Stats
+}
+
+// Index 是表上的一个二级索引
+type Index struct {
+	def   IndexDef
+	store kv.KV
+	table *Table
+}
+
+// Stats 统计查询读取了多少索引项、回表读了多少行
+type Stats struct {
+	IndexEntries int64 // 从二级索引中读取的索引项
+	TableRows    int64 // 从主存储读取的行（包括回表和全表扫描）
+}
+
+// NewTable 创建一张表，primary 是保存整行的主存储，通常是一个有序引擎
+func NewTable(schema Schema, primary kv.KV) (*Table, error) {
+	t := &Table{schema: schema, types: make(map[string]Type), primary: primary}
+	for _, c := range schema.Columns {
+		if _, dup := t.types[c.Name]; dup {
+			return nil, fmt.Errorf("secindex: duplicate column %q", c.Name)
+		}
+		t.types[c.Name] = c.Type
+	}
+	if len(schema.Key) == 0 {
+		return nil, errors.New("secindex: schema has no primary key")
+	}
+	if err := t.checkColumns(schema.Key); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Table) checkColumns(cols []string) error {
+	for _, c := range cols {
+		if _, ok := t.types[c]; !ok {
+			return fmt.Errorf("%w: %q", ErrNoColumn, c)
+		}
+	}
+	return nil
+}
+
+// Schema 返回表的结构
+func (t *Table) Schema() Schema { return t.schema }
+
+// Indexes 返回表上的二级索引
+func (t *Table) Indexes() []*Index { return t.indexes }
+
+// Stats 返回自创建以来的读取统计
+func (t *Table) Stats() Stats { return t.stats }
+
+// AddIndex 在表上创建一个二级索引，索引项写入 store，并为已有的行补建索引项
+func (t *Table) AddIndex(def IndexDef, store kv.KV) (*Index, error) {
+	if def.Name == "" || len(def.Columns) == 0 {
+		return nil, errors.New("secindex: index needs a name and at least one column")
+	}
+	if err := t.checkColumns(def.Columns); err != nil {
+		return nil, err
+	}
+	if err := t.checkColumns(def.Include); err != nil {
+		return nil, err
+	}
+	for _, ix := range t.indexes {
+		if ix.def.Name == def.Name {
+			return nil, fmt.Errorf("secindex: index %q already exists", def.Name)
+		}
+	}
+	ix := &Index{def: def, store: store, table: t}
+	var err error
+	scanErr := t.primary.Scan(nil, nil, func(_, value []byte) bool {
+		var row Row
+		if row, err = t.decodeRow(value); err == nil {
+			err = ix.put(row)
+		}
+		return err == nil
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.indexes = append(t.indexes, ix)
+	return ix, nil
+}
+
+// Name 返回索引名
+func (ix *Index) Name() string { return ix.def.Name }
+
+// Def 返回索引的定义
+func (ix *Index) Def() IndexDef { return ix.def }
+
+func (ix *Index) String() string {
+	s := fmt.Sprintf("%s(%s)", ix.def.Name, strings.Join(ix.def.Columns, ", "))
+	if len(ix.def.Include) > 0 {
+		s += fmt.Sprintf(" INCLUDE(%s)", strings.Join(ix.def.Include, ", "))
+	}
+	return s
+}
+
+// normalize 检查 v 是否符合列的类型，把 int 统一成 int64
+func normalize(typ Type, v any) (any, error) {
+	switch x := v.(type) {
+	case int:
+		if typ == Int {
+			return int64(x), nil
+		}
+	case int64:
+		if typ == Int {
+			return x, nil
+		}
+	case string:
+		if typ == String {
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %v is not %s", ErrType, v, typ)
+}
+
+// appendValues 把 row 中 cols 列的值依次按保持顺序的编码追加到 b 后面
+func (t *Table) appendValues(b []byte, cols []string, row Row) ([]byte, error) {
+	for _, c := range cols {
+		v, ok := row[c]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrMissingValue, c)
+		}
+		v, err := normalize(t.types[c], v)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", c, err)
+		}
+		if s, ok := v.(string); ok {
+			b = kv.AppendString(b, s)
+		} else {
+			b = kv.AppendInt64(b, v.(int64))
+		}
+	}
+	return b, nil
+}
+
+// decodeValues 从 b 中依次解码 cols 列的值写入 row，返回剩余的字节
+func (t *Table) decodeValues(b []byte, cols []string, row Row) ([]byte, error) {
+	for _, c := range cols {
+		var err error
+		if t.types[c] == String {
+			var s []byte
+			s, b, err = kv.DecodeBytes(b)
+			row[c] = string(s)
+		} else {
+			row[c], b, err = kv.DecodeInt64(b)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("secindex: decoding column %q: %w", c, err)
+		}
+	}
+	return b, nil
+}
+
+func (t *Table) columnNames() []string {
+	names := make([]string, len(t.schema.Columns))
+	for i, c := range t.schema.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// 主存储中的一行：键是主键列的编码，值是所有列按表结构顺序的编码
+func (t *Table) decodeRow(value []byte) (Row, error) {
+	row := make(Row, len(t.schema.Columns))
+	if _, err := t.decodeValues(value, t.columnNames(), row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// 索引项：键是索引列的编码后接主键列的编码（让同一索引值的多行各有一个索引项），
+// 值是 Include 列的编码
+func (ix *Index) entry(row Row) ([]byte, []byte, error) {
+	t := ix.table
+	key, err := t.appendValues(nil, ix.def.Columns, row)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key, err = t.appendValues(key, t.schema.Key, row); err != nil {
+		return nil, nil, err
+	}
+	value, err := t.appendValues([]byte{}, ix.def.Include, row)
+	return key, value, err
+}
+
+// decodeEntry 从索引项中还原出索引列、主键列和覆盖列
+func (ix *Index) decodeEntry(key, value []byte) (Row, error) {
+	t := ix.table
+	row := make(Row)
+	rest, err := t.decodeValues(key, ix.def.Columns, row)
+	if err == nil {
+		_, err = t.decodeValues(rest, t.schema.Key, row)
+	}
+	if err == nil {
+		_, err = t.decodeValues(value, ix.def.Include, row)
+	}
+	return row, err
+}
+
+func (ix *Index) put(row Row) error {
+	key, value, err := ix.entry(row)
+	if err != nil {
+		return err
+	}
+	return ix.store.Put(key, value)
+}
+
+func (ix *Index) delete(row Row) error {
+	key, _, err := ix.entry(row)
+	if err != nil {
+		return err
+	}
+	return ix.store.Delete(key)
+}
+
+// primaryKey 编码 row 的主键
+func (t *Table) primaryKey(row Row) ([]byte, error) {
+	return t.appendValues(nil, t.schema.Key, row)
+}
+
+// keyOf 把按主键列顺序给出的值编码成主键
+func (t *Table) keyOf(pk []any) ([]byte, error) {
+	if len(pk) != len(t.schema.Key) {
+		return nil, fmt.Errorf("secindex: primary key has %d columns, got %d values", len(t.schema.Key), len(pk))
+	}
+	row := make(Row, len(pk))
+	for i, c := range t.schema.Key {
+		row[c] = pk[i]
+	}
+	return t.primaryKey(row)
+}
+
+// Insert 写入一行（主键已存在时覆盖）：先删掉旧行的索引项，再写主存储和新的索引项
+func (t *Table) Insert(row Row) error {
+	for c := range row {
+		if _, ok := t.types[c]; !ok {
+			return fmt.Errorf("%w: %q", ErrNoColumn, c)
+		}
+	}
+	pk, err := t.primaryKey(row)
+	if err != nil {
+		return err
+	}
+	value, err := t.appendValues([]byte{}, t.columnNames(), row)
+	if err != nil {
+		return err
+	}
+	if err := t.deleteIndexEntries(pk); err != nil {
+		return err
+	}
+	if err := t.primary.Put(pk, value); err != nil {
+		return err
+	}
+	for _, ix := range t.indexes {
+		if err := ix.put(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIndexEntries 删除主键为 pk 的旧行（如果存在）的所有索引项
+func (t *Table) deleteIndexEntries(pk []byte) error {
+	old, ok, err := t.primary.Get(pk)
+	if err != nil || !ok {
+		return err
+	}
+	row, err := t.decodeRow(old)
+	if err != nil {
+		return err
+	}
+	for _, ix := range t.indexes {
+		if err := ix.delete(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get 按主键读取一行，pk 按主键列的顺序给出
+func (t *Table) Get(pk ...any) (Row, bool, error) {
+	key, err := t.keyOf(pk)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok, err := t.primary.Get(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	t.stats.TableRows++
+	row, err := t.decodeRow(value)
+	return row, err == nil, err
+}
+
+// Delete 按主键删除一行及其索引项
+func (t *Table) Delete(pk ...any) error {
+	key, err := t.keyOf(pk)
+	if err != nil {
+		return err
+	}
+	if err := t.deleteIndexEntries(key); err != nil {
+		return err
+	}
+	return t.primary.Delete(key)
+}
+
+// CheckInvariants 校验每个索引恰好包含主存储中每一行的索引项（键和覆盖列的值都一致），没有多余的项
+func (t *Table) CheckInvariants() error {
+	want := make([]map[string]string, len(t.indexes))
+	for i := range want {
+		want[i] = make(map[string]string)
+	}
+	var err error
+	scanErr := t.primary.Scan(nil, nil, func(key, value []byte) bool {
+		var row Row
+		if row, err = t.decodeRow(value); err != nil {
+			return false
+		}
+		var pk []byte
+		if pk, err = t.primaryKey(row); err != nil {
+			return false
+		}
+		if string(pk) != string(key) {
+			err = fmt.Errorf("row stored under key %x encodes primary key %x", key, pk)
+			return false
+		}
+		for i, ix := range t.indexes {
+			var k, v []byte
+			if k, v, err = ix.entry(row); err != nil {
+				return false
+			}
+			want[i][string(k)] = string(v)
+		}
+		return true
+	})
+	if err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, ix := range t.indexes {
+		n := 0
+		scanErr := ix.store.Scan(nil, nil, func(key, value []byte) bool {
+			v, ok := want[i][string(key)]
+			switch {
+			case !ok:
+				err = fmt.Errorf("index %s: stale entry %x", ix.def.Name, key)
+			case v != string(value):
+				err = fmt.Errorf("index %s: entry %x has included values %x, row has %x", ix.def.Name, key, value, v)
+			}
+			n++
+			return err == nil
+		})
+		if err == nil {
+			err = scanErr
+		}
+		if err != nil {
+			return err
+		}
+		if n != len(want[i]) {
+			return fmt.Errorf("index %s has %d entries, table has %d rows", ix.def.Name, n, len(want[i]))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package secindex
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/ddia-labs/pkg/btree"
+)
+
+var testSchema = Schema{
+	Columns: []Column{
+		{Name: "id", Type: Int},
+		{Name: "a", Type: String},
+		{Name: "b", Type: Int},
+		{Name: "c", Type: String},
+		{Name: "d", Type: Int},
+	},
+	Key: []string{"id"},
+}
+
+// newTestTable 创建一张表，带有复合索引 ab(a, b) INCLUDE(d) 和单列索引 c(c)
+func newTestTable(t *testing.T) *Table {
+	t.Helper()
+	tbl, err := NewTable(testSchema, btree.New(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tbl.AddIndex(IndexDef{Name: "ab", Columns: []string{"a", "b"}, Include: []string{"d"}}, btree.New(8)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tbl.AddIndex(IndexDef{Name: "c", Columns: []string{"c"}}, btree.New(8)); err != nil {
+		t.Fatal(err)
+	}
+	return tbl
+}
+
+// randomRow 的取值范围很小，等值条件能匹配到多行
+func randomRow(rng *rand.Rand, id int64) Row {
+	return Row{
+		"id": id,
+		"a":  string(rune('p' + rng.Intn(4))),
+		"b":  int64(rng.Intn(6)),
+		"c":  string(rune('x' + rng.Intn(3))),
+		"d":  int64(rng.Intn(100)),
+	}
+}
+
+// randomValue 返回列 c 上的一个随机值，偶尔超出数据的取值范围
+func randomValue(rng *rand.Rand, c string) any {
+	switch c {
+	case "id":
+		return int64(rng.Intn(220))
+	case "a":
+		return string(rune('o' + rng.Intn(6)))
+	case "b":
+		return int64(rng.Intn(8) - 1)
+	case "c":
+		return string(rune('w' + rng.Intn(5)))
+	}
+	return int64(rng.Intn(110))
+}
+
+// randomQuery 随机选择等值条件、范围条件、返回列和强制使用的索引
+func randomQuery(rng *rand.Rand) Query {
+	cols := []string{"id", "a", "b", "c", "d"}
+	q := Query{Where: Row{}}
+	for _, c := range cols {
+		if rng.Intn(3) == 0 {
+			q.Where[c] = randomValue(rng, c)
+		}
+	}
+	if rng.Intn(2) == 0 {
+		q.Range = cols[rng.Intn(len(cols))]
+		if rng.Intn(4) != 0 {
+			q.From = randomValue(rng, q.Range)
+		}
+		if rng.Intn(4) != 0 {
+			q.To = randomValue(rng, q.Range)
+		}
+	}
+	switch rng.Intn(3) {
+	case 0:
+		q.Columns = []string{"a", "b", "d", "id"}[:1+rng.Intn(4)]
+	case 1:
+		q.Columns = []string{cols[rng.Intn(len(cols))]}
+	}
+	if rng.Intn(4) == 0 {
+		q.Index = []string{Primary, "ab", "c"}[rng.Intn(3)]
+	}
+	return q
+}
+
+// bruteForce 逐行检查模型中的每一行，返回满足条件的行投影后的字符串形式（排好序，便于比较）
+func bruteForce(model map[int64]Row, q Query) []string {
+	var out []string
+	for _, row := range model {
+		ok := true
+		for c, v := range q.Where {
+			ok = ok && compare(row[c], v) == 0
+		}
+		if q.Range != "" {
+			v := row[q.Range]
+			ok = ok && (q.From == nil || compare(v, q.From) >= 0) && (q.To == nil || compare(v, q.To) < 0)
+		}
+		if ok {
+			out = append(out, fmt.Sprint(project(row, q.Columns)))
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// TestFindMatchesBruteForce 随机插入、覆盖和删除行（其中一个索引在已有数据之后才建立，靠 AddIndex 补建），
+// 每次修改后检查索引与主存储一致；随机查询的结果必须与逐行过滤相同，
+// 并且主键、前缀、前缀加范围、覆盖索引和全表扫描这几种计划都要被用到
+func TestFindMatchesBruteForce(t *testing.T) {
+	tbl, err := NewTable(testSchema, btree.New(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[int64]Row)
+	for id := int64(0); id < 100; id++ {
+		row := randomRow(rng, id)
+		if err := tbl.Insert(row); err != nil {
+			t.Fatal(err)
+		}
+		model[id] = row
+	}
+	if _, err := tbl.AddIndex(IndexDef{Name: "ab", Columns: []string{"a", "b"}, Include: []string{"d"}}, btree.New(8)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tbl.AddIndex(IndexDef{Name: "c", Columns: []string{"c"}}, btree.New(8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.CheckInvariants(); err != nil {
+		t.Fatalf("after AddIndex: %v", err)
+	}
+
+	plans := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		if i%3 == 0 {
+			id := int64(rng.Intn(200))
+			if rng.Intn(3) == 0 {
+				err = tbl.Delete(id)
+				delete(model, id)
+			} else {
+				row := randomRow(rng, id)
+				err = tbl.Insert(row)
+				model[id] = row
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tbl.CheckInvariants(); err != nil {
+				t.Fatalf("op %d: %v", i, err)
+			}
+			continue
+		}
+
+		q := randomQuery(rng)
+		var got []string
+		plan, err := tbl.Find(q, func(row Row) bool {
+			got = append(got, fmt.Sprint(row))
+			return true
+		})
+		if err != nil {
+			t.Fatalf("query %+v: %v", q, err)
+		}
+		slices.Sort(got)
+		if want := bruteForce(model, q); !slices.Equal(got, want) {
+			t.Fatalf("query %+v with plan %q:\n got %d rows %v\nwant %d rows %v", q, plan, len(got), got, len(want), want)
+		}
+		if explained, err := tbl.Explain(q); err != nil || !samePlan(explained, plan) {
+			t.Fatalf("Explain(%+v) = %+v, %v; Find used %+v", q, explained, err, plan)
+		}
+
+		switch {
+		case plan.FullScan:
+			plans["full scan"]++
+		case plan.Index == Primary:
+			plans["primary"]++
+		case plan.Range:
+			plans["prefix+range"]++
+		default:
+			plans["prefix"]++
+		}
+		if plan.Covering && plan.Index != Primary {
+			plans["covering"]++
+		}
+	}
+	for _, kind := range []string{"primary", "prefix", "prefix+range", "covering", "full scan"} {
+		if plans[kind] == 0 {
+			t.Errorf("no query used a %s plan: %v", kind, plans)
+		}
+	}
+}
+
+func samePlan(a, b Plan) bool {
+	return a.Index == b.Index && a.FullScan == b.FullScan && slices.Equal(a.Prefix, b.Prefix) &&
+		a.Range == b.Range && a.Covering == b.Covering && slices.Equal(a.Filter, b.Filter)
+}
+
+// TestExplainLeftmostPrefix 检查只有索引键的最左前缀上的等值条件（以及紧接着的一列上的范围条件）能用上索引
+func TestExplainLeftmostPrefix(t *testing.T) {
+	tbl := newTestTable(t)
+	tests := []struct {
+		name string
+		q    Query
+		want string
+	}{
+		{"both columns", Query{Where: Row{"a": "p", "b": 1}}, "索引 ab 前缀 (a, b)，回表"},
+		{"first column", Query{Where: Row{"a": "p"}, Columns: []string{"a", "b", "d"}}, "索引 ab 前缀 (a)，覆盖索引（不回表）"},
+		{"range on the next column", Query{Where: Row{"a": "p"}, Range: "b", From: 1, To: 3}, "索引 ab 前缀 (a) + 范围，回表"},
+		{"range on the first column", Query{Range: "a", From: "p"}, "索引 ab 范围，回表"},
+		{"skips the first column", Query{Where: Row{"b": 1}}, "全表扫描，过滤 b"},
+		{"range not next to the prefix", Query{Where: Row{"a": "p"}, Range: "d", To: 50}, "索引 ab 前缀 (a)，回表，过滤 d"},
+		{"primary key", Query{Where: Row{"id": 3, "d": 5}}, "索引 PRIMARY 前缀 (id)，过滤 d"},
+		{"secondary key ends with the primary key", Query{Where: Row{"id": 3, "c": "x"}}, "索引 c 前缀 (c, id)，回表"},
+		{"longer prefix wins", Query{Where: Row{"a": "p", "b": 2, "c": "x"}}, "索引 ab 前缀 (a, b)，回表，过滤 c"},
+		{"forced index without a prefix", Query{Where: Row{"b": 1}, Index: "ab"}, "扫描整个索引 ab，回表，过滤 b"},
+	}
+	for _, tt := range tests {
+		plan, err := tbl.Explain(tt.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := plan.String(); got != tt.want {
+			t.Errorf("%s: Explain = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := tbl.Explain(Query{Index: "nope"}); err == nil {
+		t.Error("Explain accepted an unknown index")
+	}
+}
+
+// TestOverwriteAndDelete 检查覆盖一行时旧值的索引项被删掉、删除一行时它的索引项也被删掉
+func TestOverwriteAndDelete(t *testing.T) {
+	tbl := newTestTable(t)
+	insert := func(row Row) {
+		t.Helper()
+		if err := tbl.Insert(row); err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func(q Query) int {
+		t.Helper()
+		n := 0
+		if _, err := tbl.Find(q, func(Row) bool { n++; return true }); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	insert(Row{"id": 1, "a": "p", "b": 1, "c": "x", "d": 10})
+	insert(Row{"id": 2, "a": "p", "b": 1, "c": "y", "d": 20})
+	insert(Row{"id": 1, "a": "q", "b": 1, "c": "y", "d": 30})
+	if n := count(Query{Where: Row{"a": "p"}}); n != 1 {
+		t.Errorf("a=p matches %d rows after overwriting row 1, want 1", n)
+	}
+	if n := count(Query{Where: Row{"c": "y"}}); n != 2 {
+		t.Errorf("c=y matches %d rows, want 2", n)
+	}
+	var d any
+	if _, err := tbl.Find(Query{Where: Row{"a": "q", "b": 1}, Columns: []string{"d"}}, func(row Row) bool {
+		d = row["d"]
+		return true
+	}); err != nil || d != int64(30) {
+		t.Errorf("covering read of d = %v, %v; want the overwritten value 30", d, err)
+	}
+
+	if err := tbl.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Delete(99); err != nil {
+		t.Fatalf("deleting a missing row: %v", err)
+	}
+	if err := tbl.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(Query{Where: Row{"c": "y"}}); n != 1 {
+		t.Errorf("c=y matches %d rows after deleting row 2, want 1", n)
+	}
+	if _, ok, err := tbl.Get(2); err != nil || ok {
+		t.Errorf("Get(2) after Delete = %v, %v", ok, err)
+	}
+}
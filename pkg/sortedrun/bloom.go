@@ -0,0 +1,57 @@
+package sortedrun
+
+// bloomFilter 是一个 run 的布隆过滤器：每个键用双重哈希（h1 + i*h2）置 k 位，
+// 查找时只要有一位为 0，键就一定不在这个 run 中，不用二分查找
+type bloomFilter struct {
+	bits []uint64
+	k    int
+}
+
+// keyHash 是 FNV-1a 再经过 splitmix64 的终结函数打散各位
+func keyHash(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+func newBloomFilter(keys [][]byte, bitsPerKey int) bloomFilter {
+	// k = bitsPerKey × ln2 时误判率最低
+	f := bloomFilter{bits: make([]uint64, (max(64, len(keys)*bitsPerKey)+63)/64), k: max(1, min(30, bitsPerKey*69/100))}
+	nbits := uint32(len(f.bits) * 64)
+	for _, key := range keys {
+		h := keyHash(key)
+		h1, h2 := uint32(h), uint32(h>>32)|1
+		for i := 0; i < f.k; i++ {
+			pos := h1 % nbits
+			f.bits[pos/64] |= 1 << (pos % 64)
+			h1 += h2
+		}
+	}
+	return f
+}
+
+// mayContain 返回 false 时键一定不在 run 中；没有过滤器时总是返回 true
+func (f bloomFilter) mayContain(key []byte) bool {
+	if len(f.bits) == 0 {
+		return true
+	}
+	nbits := uint32(len(f.bits) * 64)
+	h := keyHash(key)
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	for i := 0; i < f.k; i++ {
+		pos := h1 % nbits
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+		h1 += h2
+	}
+	return true
+}
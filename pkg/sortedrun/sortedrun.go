@@ -0,0 +1,340 @@
+// Package sortedrun 实现一个 LSM 风格的内存索引：写入先进入可修改的缓冲区，缓冲区满了之后
+// 排序冻结成一个不可变的有序数组（run），run 按大小合并，保持 run 的个数是 O(log n)。
+//
+// 与 B-tree 原地修改节点不同，run 一旦生成就不再修改：覆盖写和删除（墓碑）都写进缓冲区，
+// 读取时从新到旧查找，第一个找到的版本就是当前值。run 是紧凑的数组，没有节点指针和空闲空间，
+// 每个 run 带一个布隆过滤器，点查可以跳过大部分不含该键的 run。代价是合并时同一个条目被反复复制（写放大）。
+package sortedrun
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ddia-labs/pkg/btree"
+	"github.com/ddia-labs/pkg/kv"
+)
+
+// Options 控制缓冲区大小和合并，零值字段使用默认值
+type Options struct {
+	Comparator      kv.Comparator // 键的顺序，默认 kv.Bytewise
+	BufferSize      int           // 缓冲区中的条目数达到它时冻结成 run，默认 1024
+	MergeRatio      int           // 较新的 run 的大小达到较旧的 run 的 1/MergeRatio 时合并两者，默认 2
+	BloomBitsPerKey int           // 每个键的布隆过滤器位数，默认 10，小于 0 表示不用过滤器
+}
+
+func (o *Options) setDefaults() {
+	if o.Comparator == nil {
+		o.Comparator = kv.Bytewise
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = 1024
+	}
+	if o.MergeRatio <= 0 {
+		o.MergeRatio = 2
+	}
+	if o.BloomBitsPerKey == 0 {
+		o.BloomBitsPerKey = 10
+	}
+}
+
+// run 是一个不可变的有序数组，tombstone[i] 为 true 表示 keys[i] 在这个 run 中被删除
+type run struct {
+	keys      [][]byte
+	values    [][]byte
+	tombstone []bool
+	bloom     bloomFilter
+	compacted bool // 作为最旧的 run 合并出来，其中没有墓碑
+}
+
+func (r *run) len() int { return len(r.keys) }
+
+// search 返回第一个不小于 key 的位置
+func (r *run) search(cmp kv.Comparator, key []byte) int {
+	return sort.Search(len(r.keys), func(i int) bool { return cmp(r.keys[i], key) >= 0 })
+}
+
+// Index 是由缓冲区和若干个 run 组成的有序索引，实现了 kv.KV
+type Index struct {
+	opts   Options
+	buffer *btree.BTree // 值的第一个字节是类型：kindValue 或 kindTombstone
+	runs   []*run       // 从新到旧
+
+	flushes      int
+	merges       int
+	mergedCopies int64 // 合并时复制的条目数，反映写放大
+	runsProbed   int64 // 点查实际二分查找的 run 数
+	bloomSkips   int64 // 点查被布隆过滤器跳过的 run 数
+}
+
+var _ kv.KV = (*Index)(nil)
+
+const (
+	kindValue     = 1
+	kindTombstone = 0
+)
+
+// Stats 是索引的统计信息
+type Stats struct {
+	BufferEntries int   // 缓冲区中的条目数（含墓碑）
+	Runs          []int // 每个 run 的条目数，从新到旧
+	Flushes       int   // 缓冲区冻结成 run 的次数
+	Merges        int   // run 合并的次数
+	MergedCopies  int64 // 合并时复制的条目数
+	RunsProbed    int64 // Get 二分查找过的 run 数
+	BloomSkips    int64 // Get 被布隆过滤器跳过的 run 数
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("缓冲区 %d 条, run %v, 冻结 %d 次, 合并 %d 次（复制 %d 条）, 查找 run %d 次, 过滤器跳过 %d 次",
+		s.BufferEntries, s.Runs, s.Flushes, s.Merges, s.MergedCopies, s.RunsProbed, s.BloomSkips)
+}
+
+// New 创建一个空索引
+func New(opts Options) *Index {
+	opts.setDefaults()
+	return &Index{opts: opts, buffer: btree.NewWithComparator(32, opts.Comparator)}
+}
+
+// Get 依次查找缓冲区和从新到旧的各个 run，第一个找到的版本决定结果
+func (ix *Index) Get(key []byte) ([]byte, bool, error) {
+	if v, ok, _ := ix.buffer.Get(key); ok {
+		if v[0] == kindTombstone {
+			return nil, false, nil
+		}
+		return v[1:], true, nil
+	}
+	for _, r := range ix.runs {
+		if !r.bloom.mayContain(key) {
+			ix.bloomSkips++
+			continue
+		}
+		ix.runsProbed++
+		if i := r.search(ix.opts.Comparator, key); i < r.len() && ix.opts.Comparator(r.keys[i], key) == 0 {
+			if r.tombstone[i] {
+				return nil, false, nil
+			}
+			return r.values[i], true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Put 写入缓冲区（复制键和值）
+func (ix *Index) Put(key, value []byte) error {
+	return ix.write(key, append([]byte{kindValue}, value...))
+}
+
+// Delete 在缓冲区中写入墓碑，它遮住旧 run 中的值，直到合并进最旧的 run 时才被丢掉
+func (ix *Index) Delete(key []byte) error {
+	return ix.write(key, []byte{kindTombstone})
+}
+
+func (ix *Index) write(key, value []byte) error {
+	if err := ix.buffer.Put(key, value); err != nil {
+		return err
+	}
+	if ix.buffer.Len() >= ix.opts.BufferSize {
+		ix.Flush()
+	}
+	return nil
+}
+
+// Flush 把缓冲区排序冻结成最新的 run，然后按 MergeRatio 合并
+func (ix *Index) Flush() {
+	if ix.buffer.Len() == 0 {
+		return
+	}
+	r := &run{}
+	ix.buffer.Scan(nil, nil, func(key, value []byte) bool {
+		r.keys = append(r.keys, kv.Clone(key))
+		r.values = append(r.values, kv.Clone(value[1:]))
+		r.tombstone = append(r.tombstone, value[0] == kindTombstone)
+		return true
+	})
+	ix.seal(r)
+	ix.runs = append([]*run{r}, ix.runs...)
+	ix.buffer = btree.NewWithComparator(32, ix.opts.Comparator)
+	ix.flushes++
+	ix.maybeMerge()
+}
+
+// maybeMerge 在最新的 run 大到较旧的 run 的 1/MergeRatio 时把两者合并，重复到不再满足条件。
+// 这样 run 的大小大致按 MergeRatio 倍递增，n 个条目只有 O(log n) 个 run，每个条目被复制 O(log n) 次
+func (ix *Index) maybeMerge() {
+	for len(ix.runs) > 1 && ix.runs[0].len()*ix.opts.MergeRatio >= ix.runs[1].len() {
+		merged := ix.mergeRuns(ix.runs[0], ix.runs[1], len(ix.runs) == 2)
+		ix.runs = append([]*run{merged}, ix.runs[2:]...)
+	}
+}
+
+// Merge 把缓冲区和所有 run 合并成一个 run，丢掉所有墓碑
+func (ix *Index) Merge() {
+	ix.Flush()
+	for len(ix.runs) > 1 {
+		merged := ix.mergeRuns(ix.runs[0], ix.runs[1], len(ix.runs) == 2)
+		ix.runs = append([]*run{merged}, ix.runs[2:]...)
+	}
+	if len(ix.runs) == 1 {
+		// 唯一的 run 中可能还有它刚冻结时留下的墓碑
+		if r := ix.mergeRuns(&run{}, ix.runs[0], true); r.len() > 0 {
+			ix.runs[0] = r
+		} else {
+			ix.runs = nil
+		}
+	}
+}
+
+// mergeRuns 归并两个 run，同一个键保留较新的 newer 中的版本。
+// oldest 为 true 时结果是最旧的 run，它下面没有更旧的值需要遮住，墓碑可以丢掉
+func (ix *Index) mergeRuns(newer, older *run, oldest bool) *run {
+	ix.merges++
+	out := &run{compacted: oldest}
+	emit := func(r *run, i int) {
+		ix.mergedCopies++
+		if oldest && r.tombstone[i] {
+			return
+		}
+		out.keys = append(out.keys, r.keys[i])
+		out.values = append(out.values, r.values[i])
+		out.tombstone = append(out.tombstone, r.tombstone[i])
+	}
+	i, j := 0, 0
+	for i < newer.len() || j < older.len() {
+		switch {
+		case j == older.len():
+			emit(newer, i)
+			i++
+		case i == newer.len():
+			emit(older, j)
+			j++
+		default:
+			switch c := ix.opts.Comparator(newer.keys[i], older.keys[j]); {
+			case c < 0:
+				emit(newer, i)
+				i++
+			case c > 0:
+				emit(older, j)
+				j++
+			default:
+				emit(newer, i)
+				i++
+				j++
+			}
+		}
+	}
+	ix.seal(out)
+	return out
+}
+
+// seal 为 run 构造布隆过滤器
+func (ix *Index) seal(r *run) {
+	if ix.opts.BloomBitsPerKey > 0 {
+		r.bloom = newBloomFilter(r.keys, ix.opts.BloomBitsPerKey)
+	}
+}
+
+// Scan 按键的顺序遍历 [start, end) 内的键值（范围查询），nil 边界表示不限，fn 返回 false 时停止。
+// 缓冲区和每个 run 各有一个游标，每次取所有游标中最小的键，同一个键只取最新的版本，墓碑被跳过
+func (ix *Index) Scan(start, end []byte, fn func(key, value []byte) bool) error {
+	cmp := ix.opts.Comparator
+	// 缓冲区的范围先取出来作为一个临时 run，它是最新的
+	buf := &run{}
+	ix.buffer.Scan(start, end, func(key, value []byte) bool {
+		buf.keys = append(buf.keys, key)
+		buf.values = append(buf.values, value[1:])
+		buf.tombstone = append(buf.tombstone, value[0] == kindTombstone)
+		return true
+	})
+	runs := append([]*run{buf}, ix.runs...)
+	pos := make([]int, len(runs))
+	for i, r := range runs {
+		if start != nil {
+			pos[i] = r.search(cmp, start)
+		}
+	}
+	for {
+		best := -1
+		for i, r := range runs {
+			if pos[i] == r.len() || end != nil && cmp(r.keys[pos[i]], end) >= 0 {
+				continue
+			}
+			if best < 0 || cmp(r.keys[pos[i]], runs[best].keys[pos[best]]) < 0 {
+				best = i // 键相同时保留下标小（较新）的 run
+			}
+		}
+		if best < 0 {
+			return nil
+		}
+		r, key := runs[best], runs[best].keys[pos[best]]
+		live, value := !r.tombstone[pos[best]], r.values[pos[best]]
+		for i := range runs {
+			if pos[i] < runs[i].len() && cmp(runs[i].keys[pos[i]], key) == 0 {
+				pos[i]++
+			}
+		}
+		if live && !fn(key, value) {
+			return nil
+		}
+	}
+}
+
+// RangeScan 返回闭区间 [start, end] 内所有键的值，nil 边界表示不限（与 BTreeIndex.RangeScan 相同，
+// 注意上界与 Scan 不同，是包含在内的）
+func (ix *Index) RangeScan(start, end []byte) [][]byte {
+	var result [][]byte
+	ix.Scan(start, nil, func(key, value []byte) bool {
+		if end != nil && ix.opts.Comparator(key, end) > 0 {
+			return false
+		}
+		result = append(result, value)
+		return true
+	})
+	return result
+}
+
+// InOrderTraversal 按键的顺序返回所有值（与 BTreeIndex.InOrderTraversal 相同）
+func (ix *Index) InOrderTraversal() [][]byte {
+	var result [][]byte
+	ix.Scan(nil, nil, func(key, value []byte) bool {
+		result = append(result, value)
+		return true
+	})
+	return result
+}
+
+// Stats 返回统计信息
+func (ix *Index) Stats() Stats {
+	st := Stats{
+		BufferEntries: ix.buffer.Len(),
+		Flushes:       ix.flushes,
+		Merges:        ix.merges,
+		MergedCopies:  ix.mergedCopies,
+		RunsProbed:    ix.runsProbed,
+		BloomSkips:    ix.bloomSkips,
+	}
+	for _, r := range ix.runs {
+		st.Runs = append(st.Runs, r.len())
+	}
+	return st
+}
+
+// CheckInvariants 检查每个 run 严格有序、布隆过滤器包含 run 中的所有键、合并成最旧的 run 时丢掉了所有墓碑
+func (ix *Index) CheckInvariants() error {
+	for n, r := range ix.runs {
+		if len(r.values) != r.len() || len(r.tombstone) != r.len() {
+			return fmt.Errorf("sortedrun: run %d has mismatched arrays", n)
+		}
+		for i := range r.keys {
+			if r.compacted && r.tombstone[i] {
+				return fmt.Errorf("sortedrun: run %d was merged as the oldest run but keeps tombstone for %s", n, kv.FormatKey(r.keys[i]))
+			}
+			if i > 0 && ix.opts.Comparator(r.keys[i-1], r.keys[i]) >= 0 {
+				return fmt.Errorf("sortedrun: run %d keys %s and %s out of order", n, kv.FormatKey(r.keys[i-1]), kv.FormatKey(r.keys[i]))
+			}
+			if ix.opts.BloomBitsPerKey > 0 && !r.bloom.mayContain(r.keys[i]) {
+				return fmt.Errorf("sortedrun: run %d bloom filter misses key %s", n, kv.FormatKey(r.keys[i]))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package sortedrun
+
+import (
+	"bytes"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/ddia-labs/pkg/btreeindex"
+	"github.com/ddia-labs/pkg/kv"
+	"github.com/ddia-labs/pkg/kvcheck"
+)
+
+// TestModel 用 pkg/kvcheck 把随机序列与 map 模型比较。缓冲区只有两三个条目，几乎每次写入都会冻结一个 run 并触发合并，
+// 覆盖写和墓碑要遮住旧 run 中的版本；逆序比较器检查冻结、合并和多路归并都用的是 Options.Comparator 而不是字节序
+func TestModel(t *testing.T) {
+	reverse := func(a, b []byte) int { return -bytes.Compare(a, b) }
+	targets := []struct {
+		name string
+		cfg  kvcheck.Config
+		opts Options
+	}{
+		{"buffer2", kvcheck.Config{}, Options{BufferSize: 2}},
+		{"buffer3/ratio1", kvcheck.Config{}, Options{BufferSize: 3, MergeRatio: 1}},
+		{"buffer2/no-bloom", kvcheck.Config{}, Options{BufferSize: 2, BloomBitsPerKey: -1}},
+		{"buffer3/reverse", kvcheck.Config{Comparator: reverse}, Options{BufferSize: 3, Comparator: reverse}},
+	}
+	for _, tg := range targets {
+		opts := tg.opts
+		factory := func() (kv.KV, error) { return New(opts), nil }
+		if failure := kvcheck.CheckSeeds(tg.cfg, factory, 1, 20); failure != nil {
+			t.Errorf("%s: %s", tg.name, failure)
+		}
+	}
+}
+
+// TestRunSizes 检查合并后相邻的 run 满足 新 * MergeRatio < 旧，因此 run 的个数是 O(log n)
+func TestRunSizes(t *testing.T) {
+	const n = 10000
+	ix := New(Options{BufferSize: 16, MergeRatio: 2})
+	for i := 0; i < n; i++ {
+		if err := ix.Put(kv.IntKey(i*7919%n), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs := ix.Stats().Runs
+	for i := 0; i+1 < len(runs); i++ {
+		if runs[i]*2 >= runs[i+1] {
+			t.Fatalf("runs %v: run %d is not less than half of the older run", runs, i)
+		}
+	}
+	if len(runs) > 10 { // log2(10000/16) ≈ 9.3
+		t.Errorf("%d runs for %d entries: %v", len(runs), n, runs)
+	}
+}
+
+// TestMergeDropsTombstones 检查 Merge 之后只剩一个不含墓碑的 run，全部删除后一个 run 也不剩
+func TestMergeDropsTombstones(t *testing.T) {
+	ix := New(Options{BufferSize: 8})
+	for i := 0; i < 100; i++ {
+		ix.Put(kv.IntKey(i), []byte("v"))
+	}
+	for i := 0; i < 100; i += 2 {
+		ix.Delete(kv.IntKey(i))
+	}
+	ix.Merge()
+	if s := ix.Stats(); len(s.Runs) != 1 || s.Runs[0] != 50 || s.BufferEntries != 0 {
+		t.Fatalf("after Merge: %s", s)
+	}
+	for i := 1; i < 100; i += 2 {
+		ix.Delete(kv.IntKey(i))
+	}
+	ix.Merge()
+	if s := ix.Stats(); len(s.Runs) != 0 {
+		t.Fatalf("after deleting everything and merging: %s", s)
+	}
+}
+
+func values(t *testing.T, vs [][]byte) []int {
+	t.Helper()
+	var out []int
+	for _, v := range vs {
+		k, err := kv.KeyInt(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, k)
+	}
+	return out
+}
+
+// TestRangeScanClosed 检查 RangeScan 包含上界而 Scan 不包含（与 BTreeIndex 的同名测试用同一组键），
+// 墓碑遮住的键不出现；再用随机的写入、删除和边界与 BTreeIndex.RangeScan 逐一比较
+func TestRangeScanClosed(t *testing.T) {
+	ix := New(Options{BufferSize: 3})
+	for _, k := range []int{10, 20, 5, 15, 25, 30, 8, 12, 18} {
+		ix.Put(kv.IntKey(k), kv.IntKey(k))
+	}
+	ix.Delete(kv.IntKey(18)) // 墓碑留在缓冲区，18 在更旧的 run 中
+
+	if got, want := values(t, ix.RangeScan(kv.IntKey(10), kv.IntKey(25))), []int{10, 12, 15, 20, 25}; !slices.Equal(got, want) {
+		t.Errorf("RangeScan(10, 25) = %v, want %v", got, want)
+	}
+	if got, want := values(t, ix.RangeScan(kv.IntKey(26), nil)), []int{30}; !slices.Equal(got, want) {
+		t.Errorf("RangeScan(26, nil) = %v, want %v", got, want)
+	}
+	if got := ix.RangeScan(kv.IntKey(21), kv.IntKey(24)); len(got) != 0 {
+		t.Errorf("RangeScan(21, 24) = %v, want nothing", values(t, got))
+	}
+	var scanned [][]byte
+	ix.Scan(kv.IntKey(10), kv.IntKey(25), func(key, value []byte) bool {
+		scanned = append(scanned, value)
+		return true
+	})
+	if got, want := values(t, scanned), []int{10, 12, 15, 20}; !slices.Equal(got, want) {
+		t.Errorf("Scan(10, 25) = %v, want %v", got, want)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	ix = New(Options{BufferSize: 5})
+	bti := btreeindex.NewBTreeIndexWithOrder(4, kv.Bytewise)
+	bound := func() []byte {
+		if rng.Intn(8) == 0 {
+			return nil
+		}
+		return kv.IntKey(rng.Intn(220) - 10)
+	}
+	for i := 0; i < 2000; i++ {
+		k := kv.IntKey(rng.Intn(200))
+		if rng.Intn(3) == 0 {
+			ix.Delete(k)
+			bti.Delete(k)
+		} else {
+			v := kv.IntKey(rng.Intn(1000))
+			ix.Put(k, v)
+			bti.Put(k, v)
+		}
+		start, end := bound(), bound()
+		if got, want := ix.RangeScan(start, end), bti.RangeScan(start, end); !slices.EqualFunc(got, want, bytes.Equal) {
+			t.Fatalf("op %d: RangeScan(%s, %s) = %v, BTreeIndex returns %v",
+				i, kv.FormatKey(start), kv.FormatKey(end), values(t, got), values(t, want))
+		}
+	}
+}
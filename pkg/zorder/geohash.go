@@ -0,0 +1,73 @@
+package zorder
+
+import (
+	"errors"
+	"strings"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// ErrBadGeohash 表示 geohash 中有不属于 base32 字母表的字符
+var ErrBadGeohash = errors.New("zorder: invalid geohash")
+
+// Geohash 把经纬度编码成 precision 个字符的 geohash（每个字符 5 位，经度和纬度的位从经度开始交错），
+// 每多一个字符格子缩小为 1/32。前缀相同的点在同一个格子中，但相邻的两点可能因为格子边界而没有公共前缀
+func Geohash(lat, lon float64, precision int) string {
+	latLo, latHi, lonLo, lonHi := -90.0, 90.0, -180.0, 180.0
+	var b strings.Builder
+	even := true
+	for b.Len() < precision {
+		var c byte
+		for bit := 0; bit < 5; bit++ {
+			c <<= 1
+			if even {
+				if mid := (lonLo + lonHi) / 2; lon >= mid {
+					c |= 1
+					lonLo = mid
+				} else {
+					lonHi = mid
+				}
+			} else {
+				if mid := (latLo + latHi) / 2; lat >= mid {
+					c |= 1
+					latLo = mid
+				} else {
+					latHi = mid
+				}
+			}
+			even = !even
+		}
+		b.WriteByte(geohashAlphabet[c])
+	}
+	return b.String()
+}
+
+// DecodeGeohash 返回 geohash 对应的格子的纬度和经度范围
+func DecodeGeohash(hash string) (minLat, minLon, maxLat, maxLon float64, err error) {
+	minLat, maxLat, minLon, maxLon = -90, 90, -180, 180
+	even := true
+	for i := 0; i < len(hash); i++ {
+		c := strings.IndexByte(geohashAlphabet, hash[i])
+		if c < 0 {
+			return 0, 0, 0, 0, ErrBadGeohash
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := c>>bit&1 == 1
+			if even {
+				if mid := (minLon + maxLon) / 2; set {
+					minLon = mid
+				} else {
+					maxLon = mid
+				}
+			} else {
+				if mid := (minLat + maxLat) / 2; set {
+					minLat = mid
+				} else {
+					maxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return minLat, minLon, maxLat, maxLon, nil
+}
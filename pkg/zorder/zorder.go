@@ -0,0 +1,151 @@
+// Package zorder 把二维坐标编码成一维的键，让只支持一维范围扫描的有序索引（B-tree、LSM-tree）
+// 也能近似地回答矩形范围查询。
+//
+// Z 序（Morton 码）把两个坐标的二进制位交错排列：平面被递归地四等分，每个象限对应一段连续的码，
+// 所以一个查询矩形可以分解成若干段码的范围，每段是一次有序扫描。分解得越细范围越多（扫描次数多），
+// 越粗则范围覆盖了矩形之外的格子，扫描到的点要再按真实坐标过滤。Geohash 是同样的交错编码
+// 按经纬度划分后再用 base32 写成字符串，前缀相同的点落在同一个格子中。
+package zorder
+
+import (
+	"math"
+	"sort"
+)
+
+// Interleave 把 x 和 y 的位交错成 64 位的 Z 序码：x 占偶数位，y 占奇数位
+func Interleave(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+// Deinterleave 是 Interleave 的逆运算
+func Deinterleave(z uint64) (x, y uint32) {
+	return compact(z), compact(z >> 1)
+}
+
+// spread 把 v 的第 i 位移到第 2i 位
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// compact 是 spread 的逆运算，忽略奇数位
+func compact(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return uint32(x)
+}
+
+// Grid 把 [MinX, MaxX] × [MinY, MaxY] 划分成 2^Bits × 2^Bits 个格子，坐标先换算成格子号再交错
+type Grid struct {
+	MinX, MinY, MaxX, MaxY float64
+	Bits                   uint // 每个维度的位数，1 到 32
+}
+
+// Cell 返回坐标所在的格子，超出边界的坐标归到最近的边缘格子
+func (g Grid) Cell(x, y float64) (cx, cy uint32) {
+	return g.axis(x, g.MinX, g.MaxX), g.axis(y, g.MinY, g.MaxY)
+}
+
+func (g Grid) axis(v, lo, hi float64) uint32 {
+	cells := math.Ldexp(1, int(g.Bits))
+	c := math.Floor((v - lo) / (hi - lo) * cells)
+	return uint32(math.Max(0, math.Min(c, cells-1)))
+}
+
+// Key 返回坐标的 Z 序码
+func (g Grid) Key(x, y float64) uint64 {
+	return Interleave(g.Cell(x, y))
+}
+
+// Range 是一段 Z 序码 [Lo, Hi]（包含两端）
+type Range struct {
+	Lo, Hi uint64
+}
+
+// quad 是第 level 层的一个象限：边长 2^level 个格子，左下角的格子是 (x, y)
+type quad struct {
+	x, y  uint32
+	level uint
+}
+
+func (q quad) rng() Range {
+	lo := Interleave(q.x, q.y)
+	return Range{lo, lo + (uint64(1) << (2 * q.level)) - 1}
+}
+
+// Ranges 把查询矩形分解成至多 maxRanges 段 Z 序码（maxRanges 至少按 1 算），按 Lo 递增。
+// 从整个网格开始逐层四分：完全在矩形内的象限直接成为一段，与矩形部分相交的象限继续细分，
+// 细分会让段数超过 maxRanges 时停止，剩下部分相交的象限整个作为一段（包含矩形外的格子）。
+// 相邻的段合并成一段，所以返回的段数可能少于象限数
+func (g Grid) Ranges(minX, minY, maxX, maxY float64, maxRanges int) []Range {
+	x0, y0 := g.Cell(minX, minY)
+	x1, y1 := g.Cell(maxX, maxY)
+	inside := func(q quad) bool {
+		size := uint32(1)<<q.level - 1
+		return q.x >= x0 && q.x+size <= x1 && q.y >= y0 && q.y+size <= y1
+	}
+	disjoint := func(q quad) bool {
+		size := uint32(1)<<q.level - 1
+		return q.x > x1 || q.x+size < x0 || q.y > y1 || q.y+size < y0
+	}
+
+	var full []Range
+	partial := []quad{{0, 0, g.Bits}}
+	if inside(partial[0]) {
+		return []Range{partial[0].rng()}
+	}
+	for len(partial) > 0 && partial[0].level > 0 {
+		var next []quad
+		var nextFull []Range
+		for _, q := range partial {
+			half := uint32(1) << (q.level - 1)
+			for _, c := range []quad{
+				{q.x, q.y, q.level - 1}, {q.x + half, q.y, q.level - 1},
+				{q.x, q.y + half, q.level - 1}, {q.x + half, q.y + half, q.level - 1},
+			} {
+				switch {
+				case disjoint(c):
+				case inside(c):
+					nextFull = append(nextFull, c.rng())
+				default:
+					next = append(next, c)
+				}
+			}
+		}
+		candidate := append(append([]Range(nil), full...), nextFull...)
+		for _, q := range next {
+			candidate = append(candidate, q.rng())
+		}
+		if len(merge(candidate)) > max(1, maxRanges) {
+			break
+		}
+		full, partial = append(full, nextFull...), next
+	}
+	for _, q := range partial {
+		full = append(full, q.rng())
+	}
+	return merge(full)
+}
+
+// merge 排序并合并重叠或相邻的段。Bits = 32 时最后一段的 Hi 是 MaxUint64，Hi+1 会溢出成 0，所以单独判断
+func merge(ranges []Range) []Range {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Lo < ranges[j].Lo })
+	var out []Range
+	for _, r := range ranges {
+		if n := len(out); n > 0 && (out[n-1].Hi == math.MaxUint64 || r.Lo <= out[n-1].Hi+1) {
+			out[n-1].Hi = max(out[n-1].Hi, r.Hi)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package zorder
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestInterleaveRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	values := []uint32{0, 1, 2, 0x55555555, 0xAAAAAAAA, math.MaxUint32 - 1, math.MaxUint32}
+	for i := 0; i < 1000; i++ {
+		values = append(values, rng.Uint32())
+	}
+	for _, x := range values {
+		for _, y := range []uint32{values[rng.Intn(len(values))], x, 0, math.MaxUint32} {
+			z := Interleave(x, y)
+			if gx, gy := Deinterleave(z); gx != x || gy != y {
+				t.Fatalf("Deinterleave(Interleave(%#x, %#x)) = %#x, %#x", x, y, gx, gy)
+			}
+			if spread(x)&0xAAAAAAAAAAAAAAAA != 0 || z&0x5555555555555555 != spread(x) {
+				t.Fatalf("x = %#x is not on the even bits of %#x", x, z)
+			}
+		}
+	}
+	if z := Interleave(math.MaxUint32, math.MaxUint32); z != math.MaxUint64 {
+		t.Fatalf("Interleave(max, max) = %#x", z)
+	}
+	// 同一个 2×2 象限中的格子码连续：(0,0) (1,0) (0,1) (1,1)
+	for i, c := range [][2]uint32{{0, 0}, {1, 0}, {0, 1}, {1, 1}, {2, 0}} {
+		if z := Interleave(c[0], c[1]); z != uint64(i) {
+			t.Errorf("Interleave%v = %d, want %d", c, z, i)
+		}
+	}
+}
+
+// checkRanges 检查 ranges 按 Lo 递增、互不重叠也不相邻，段数不超过 max(1, maxRanges)，
+// 并且包含 [x0, x1] × [y0, y1] 中每个格子的码。exact 时还要求不包含矩形外的格子
+func checkRanges(t *testing.T, ranges []Range, maxRanges int, x0, y0, x1, y1 uint32, exact bool) {
+	t.Helper()
+	if len(ranges) == 0 || len(ranges) > max(1, maxRanges) {
+		t.Fatalf("%d ranges for maxRanges %d", len(ranges), maxRanges)
+	}
+	for i, r := range ranges {
+		if r.Lo > r.Hi || i > 0 && (ranges[i-1].Hi == math.MaxUint64 || r.Lo <= ranges[i-1].Hi+1) {
+			t.Fatalf("ranges out of order, overlapping or adjacent: %v", ranges)
+		}
+	}
+	covered := func(z uint64) bool {
+		i := sort.Search(len(ranges), func(i int) bool { return ranges[i].Hi >= z })
+		return i < len(ranges) && ranges[i].Lo <= z
+	}
+	cells := uint64(0)
+	for x := uint64(x0); x <= uint64(x1); x++ {
+		for y := uint64(y0); y <= uint64(y1); y++ {
+			if !covered(Interleave(uint32(x), uint32(y))) {
+				t.Fatalf("cell (%d, %d) is not covered by %v", x, y, ranges)
+			}
+			cells++
+		}
+	}
+	if exact {
+		var total uint64
+		for _, r := range ranges {
+			total += r.Hi - r.Lo + 1
+		}
+		if total != cells {
+			t.Fatalf("ranges cover %d codes, the rectangle has %d cells", total, cells)
+		}
+	}
+}
+
+// TestRangesCoverRect 在 16×16 的网格上用各种 maxRanges 分解随机矩形（包括超出网格边界的），
+// 每个格子都要被覆盖；maxRanges 足够大时恰好覆盖矩形
+func TestRangesCoverRect(t *testing.T) {
+	g := Grid{MaxX: 16, MaxY: 16, Bits: 4}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		minX, minY := rng.Float64()*20-2, rng.Float64()*20-2
+		maxX, maxY := minX+rng.Float64()*10, minY+rng.Float64()*10
+		x0, y0 := g.Cell(minX, minY)
+		x1, y1 := g.Cell(maxX, maxY)
+		for _, maxRanges := range []int{-1, 0, 1, 2, 3, 5, 8, 20, 1000} {
+			ranges := g.Ranges(minX, minY, maxX, maxY, maxRanges)
+			checkRanges(t, ranges, maxRanges, x0, y0, x1, y1, maxRanges == 1000)
+		}
+	}
+
+	if got := g.Ranges(-1, -1, 100, 100, 4); !slices.Equal(got, []Range{{0, 255}}) {
+		t.Errorf("whole grid: %v", got)
+	}
+}
+
+// TestRangesBits32 在每维 32 位的网格上分解包含最大格子的矩形：最后一段的 Hi 是 MaxUint64
+func TestRangesBits32(t *testing.T) {
+	g := Grid{MaxX: 1, MaxY: 1, Bits: 32}
+	cell := math.Ldexp(1, -32)
+	for _, maxRanges := range []int{1, 2, 3, 4, 9, 100} {
+		// 右上角 3×3 个格子
+		ranges := g.Ranges(1-2.5*cell, 1-2.5*cell, 1, 1, maxRanges)
+		checkRanges(t, ranges, maxRanges, math.MaxUint32-2, math.MaxUint32-2, math.MaxUint32, math.MaxUint32, maxRanges == 100)
+		if last := ranges[len(ranges)-1]; last.Hi != math.MaxUint64 {
+			t.Errorf("maxRanges %d: last range %v does not end at MaxUint64", maxRanges, last)
+		}
+	}
+	if got := g.Ranges(0, 0, 1, 1, 4); !slices.Equal(got, []Range{{0, math.MaxUint64}}) {
+		t.Errorf("whole grid: %v", got)
+	}
+}
+
+// TestMergeAtMaxUint64 检查以 MaxUint64 结尾的段能吞并后面与它重叠的段
+func TestMergeAtMaxUint64(t *testing.T) {
+	tests := []struct {
+		in, want []Range
+	}{
+		{[]Range{{0, math.MaxUint64}, {5, 10}}, []Range{{0, math.MaxUint64}}},
+		{[]Range{{10, math.MaxUint64}, {0, 9}, {20, 30}}, []Range{{0, math.MaxUint64}}},
+		{[]Range{{0, 3}, {5, math.MaxUint64}, {math.MaxUint64, math.MaxUint64}}, []Range{{0, 3}, {5, math.MaxUint64}}},
+		{[]Range{{4, 7}, {0, 3}, {9, 9}}, []Range{{0, 7}, {9, 9}}},
+	}
+	for _, tt := range tests {
+		if got := merge(append([]Range(nil), tt.in...)); !slices.Equal(got, tt.want) {
+			t.Errorf("merge(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}